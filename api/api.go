@@ -9,8 +9,12 @@ import (
 	"github.com/filecoin-project/go-fil-markets/storagemarket"
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/venus-market/client"
+	"github.com/filecoin-project/venus-market/dealfilter"
+	"github.com/filecoin-project/venus-market/fundmgr"
 	"github.com/filecoin-project/venus-market/imports"
 	"github.com/filecoin-project/venus-market/piece"
+	"github.com/filecoin-project/venus-market/quota"
+	"github.com/filecoin-project/venus-market/retrievaladapter"
 	"github.com/filecoin-project/venus-market/types"
 	"github.com/filecoin-project/venus-market/utils"
 	mTypes "github.com/filecoin-project/venus-messager/types"
@@ -29,30 +33,55 @@ var _ = xerrors.New("") // nolint
 
 type MarketFullNode interface {
 	ActorAddress(context.Context) (address.Address, error)                    //perm:read
+	ActorList(context.Context) ([]address.Address, error)                     //perm:read
 	ActorSectorSize(context.Context, address.Address) (abi.SectorSize, error) //perm:read
 
-	MarketImportDealData(ctx context.Context, propcid cid.Cid, path string) error                                                                                                          //perm:write
-	MarketListDeals(ctx context.Context) ([]types.MarketDeal, error)                                                                                                                       //perm:read
-	MarketListRetrievalDeals(ctx context.Context) ([]retrievalmarket.ProviderDealState, error)                                                                                             //perm:read
-	MarketGetDealUpdates(ctx context.Context) (<-chan storagemarket.MinerDeal, error)                                                                                                      //perm:read
-	MarketListIncompleteDeals(ctx context.Context) ([]storagemarket.MinerDeal, error)                                                                                                      //perm:read
-	MarketSetAsk(ctx context.Context, price vTypes.BigInt, verifiedPrice vTypes.BigInt, duration abi.ChainEpoch, minPieceSize abi.PaddedPieceSize, maxPieceSize abi.PaddedPieceSize) error //perm:admin
-	MarketGetAsk(ctx context.Context) (*storagemarket.SignedStorageAsk, error)                                                                                                             //perm:read
-	MarketSetRetrievalAsk(ctx context.Context, rask *retrievalmarket.Ask) error                                                                                                            //perm:admin
-	MarketGetRetrievalAsk(ctx context.Context) (*retrievalmarket.Ask, error)                                                                                                               //perm:read
-	MarketListDataTransfers(ctx context.Context) ([]types.DataTransferChannel, error)                                                                                                      //perm:write
-	MarketDataTransferUpdates(ctx context.Context) (<-chan types.DataTransferChannel, error)                                                                                               //perm:write
+	MarketImportDealData(ctx context.Context, propcid cid.Cid, path string) error                                                                                                                                 //perm:write
+	MarketListDeals(ctx context.Context) ([]types.MarketDeal, error)                                                                                                                                              //perm:read
+	MarketListRetrievalDeals(ctx context.Context) ([]retrievalmarket.ProviderDealState, error)                                                                                                                    //perm:read
+	MarketGetDealUpdates(ctx context.Context) (<-chan storagemarket.MinerDeal, error)                                                                                                                             //perm:read
+	MarketListIncompleteDeals(ctx context.Context) ([]storagemarket.MinerDeal, error)                                                                                                                             //perm:read
+	MarketSetAsk(ctx context.Context, mAddr address.Address, price vTypes.BigInt, verifiedPrice vTypes.BigInt, duration abi.ChainEpoch, minPieceSize abi.PaddedPieceSize, maxPieceSize abi.PaddedPieceSize) error //perm:admin
+	MarketGetAsk(ctx context.Context, mAddr address.Address) (*storagemarket.SignedStorageAsk, error)                                                                                                             //perm:read
+	MarketSetRetrievalAsk(ctx context.Context, mAddr address.Address, rask *retrievalmarket.Ask) error                                                                                                            //perm:admin
+	MarketGetRetrievalAsk(ctx context.Context, mAddr address.Address) (*retrievalmarket.Ask, error)                                                                                                               //perm:read
+	MarketListDataTransfers(ctx context.Context) ([]types.DataTransferChannel, error)                                                                                                                             //perm:write
+	MarketDataTransferUpdates(ctx context.Context) (<-chan types.DataTransferChannel, error)                                                                                                                      //perm:write
 	// MarketRestartDataTransfer attempts to restart a data transfer with the given transfer ID and other peer
 	MarketRestartDataTransfer(ctx context.Context, transferID datatransfer.TransferID, otherPeer peer.ID, isInitiator bool) error //perm:write
 	// MarketCancelDataTransfer cancels a data transfer with the given transfer ID and other peer
 	MarketCancelDataTransfer(ctx context.Context, transferID datatransfer.TransferID, otherPeer peer.ID, isInitiator bool) error //perm:write
 	MarketPendingDeals(ctx context.Context) (types.PendingDealInfo, error)                                                       //perm:write
 	MarketPublishPendingDeals(ctx context.Context) error                                                                         //perm:admin
+	// MarketRetrievalPricingRules returns the rules of the dynamic retrieval pricing
+	MarketRetrievalPricingRules(ctx context.Context) (*retrievaladapter.DynamicPricingRules, error) //perm:read
+	// MarketReloadRetrievalPricingRules reads the pricing file of the dynamic retrieval pricing again
+	MarketReloadRetrievalPricingRules(ctx context.Context) error //perm:admin
 
-	PiecesListPieces(ctx context.Context) ([]cid.Cid, error)                                 //perm:read
+	PiecesListPieces(ctx context.Context) ([]cid.Cid, error) //perm:read
+	// PiecesListCidInfos lists the payload cids of the legacy cid infos and the payload multihashes
+	// of the dagstore top level index. The index doesn't keep the codec of the payload cids, its
+	// multihashes are listed as CIDv1 with the raw codec: only their multihash identifies the payload.
 	PiecesListCidInfos(ctx context.Context) ([]cid.Cid, error)                               //perm:read
 	PiecesGetPieceInfo(ctx context.Context, pieceCid cid.Cid) (*piecestore.PieceInfo, error) //perm:read
 	PiecesGetCIDInfo(ctx context.Context, payloadCid cid.Cid) (*piecestore.CIDInfo, error)   //perm:read
+	PiecesStorageUsage(ctx context.Context) ([]piece.PieceStorageUsage, error)               //perm:read
+
+	// PiecesGC removes from the piece storages the pieces not needed by their deals anymore, nothing
+	// is removed if dryRun is set
+	PiecesGC(ctx context.Context, dryRun bool) ([]piece.PieceGCResult, error) //perm:admin
+	// PiecesListUnsealJobs lists the running unseal jobs and the last job of every other piece
+	PiecesListUnsealJobs(ctx context.Context) ([]types.UnsealJob, error) //perm:read
+	// PiecesIndexerHead returns the latest advertisement published for the indexers
+	PiecesIndexerHead(ctx context.Context) (cid.Cid, error) //perm:read
+	// PiecesIndexerAnnounce advertises the payload of the piece to the indexers, it returns the
+	// advertisement of a piece already announced
+	PiecesIndexerAnnounce(ctx context.Context, pieceCid cid.Cid) (cid.Cid, error) //perm:admin
+	// PiecesIndexerRemove advertises the removal of the payload of the piece to the indexers
+	PiecesIndexerRemove(ctx context.Context, pieceCid cid.Cid) (cid.Cid, error) //perm:admin
+	// PiecesDropCidInfos deletes the legacy cid infos once the dagstore top level index holds the
+	// payload of every shard, it returns the number of records deleted
+	PiecesDropCidInfos(ctx context.Context) (int, error) //perm:admin
 
 	DealsImportData(ctx context.Context, dealPropCid cid.Cid, file string) error //perm:admin
 	DealsList(ctx context.Context) ([]types.MarketDeal, error)                   //perm:admin
@@ -70,6 +99,23 @@ type MarketFullNode interface {
 	DealsSetConsiderVerifiedStorageDeals(context.Context, bool) error            //perm:admin
 	DealsConsiderUnverifiedStorageDeals(context.Context) (bool, error)           //perm:admin
 	DealsSetConsiderUnverifiedStorageDeals(context.Context, bool) error          //perm:admin
+
+	// DealsBatchImportData imports the data of many offline deals, parallel imports at a time. Once ctx is
+	// done the results so far are returned with the error, the rows never started are skipped
+	DealsBatchImportData(ctx context.Context, refs []types.ImportDataRef, parallel int) ([]types.ImportDataResult, error) //perm:admin
+	// DealsStorageDealRules returns the rules checked by storage deals
+	DealsStorageDealRules(ctx context.Context) (*dealfilter.StorageDealRules, error) //perm:read
+	// DealsReloadStorageDealRules reads the rule file of storage deals again
+	DealsReloadStorageDealRules(ctx context.Context) error //perm:admin
+	// DealsRetrievalDealRules returns the rules checked by retrieval deals
+	DealsRetrievalDealRules(ctx context.Context) (*retrievaladapter.RetrievalDealRules, error) //perm:read
+	// DealsReloadRetrievalDealRules reads the rule file of retrieval deals again
+	DealsReloadRetrievalDealRules(ctx context.Context) error //perm:admin
+	// DealsClientUsages lists the quota counters of the clients
+	DealsClientUsages(ctx context.Context) ([]quota.ClientUsage, error) //perm:admin
+	// DealsResetClientUsage clears the quota counters of a client, given by its address or its peer id
+	DealsResetClientUsage(ctx context.Context, client string) error //perm:admin
+
 	// SectorGetSealDelay gets the time that a newly-created sector
 	// waits for more deals before it starts sealing
 	SectorGetSealDelay(context.Context) (time.Duration, error) //perm:read
@@ -87,6 +133,11 @@ type MarketFullNode interface {
 	MarketReleaseFunds(ctx context.Context, addr address.Address, amt vTypes.BigInt) error                                    //perm:sign
 	MarketWithdraw(ctx context.Context, wallet, addr address.Address, amt vTypes.BigInt) (cid.Cid, error)                     //perm:sign
 
+	// MarketListFunds lists the addresses tracked by the fund manager with their balances, queued requests and message in flight
+	MarketListFunds(ctx context.Context) ([]fundmgr.FundedAddressInfo, error) //perm:read
+	// MarketFundHistory lists the messages sent by the fund manager for addr, or for every address if addr is undefined
+	MarketFundHistory(ctx context.Context, addr address.Address) ([]*fundmgr.FundOperation, error) //perm:read
+
 	NetAddrsListen(context.Context) (peer.AddrInfo, error) //perm:read
 	ID(context.Context) (peer.ID, error)                   //perm:read
 
@@ -132,13 +183,18 @@ type MarketFullNode interface {
 	// DagstoreGC runs garbage collection on the DAG store.
 	DagstoreGC(ctx context.Context) ([]types.DagstoreShardResult, error) //perm:admin
 
+	// DagstoreHealth lists the shards the health checker failed to repair so far.
+	DagstoreHealth(ctx context.Context) ([]types.DagstoreShardHealth, error) //perm:read
+
 	//todo validate miner identify
-	GetDeals(ctx context.Context, miner address.Address, pageIndex, pageSize int) ([]*piece.DealInfo, error)                                                          //perm:read
-	AssignUnPackedDeals(spec *piece.GetDealSpec) ([]*piece.DealInfoIncludePath, error)                                                                                //perm:write
-	GetUnPackedDeals(ctx context.Context, miner address.Address, spec *piece.GetDealSpec) ([]*piece.DealInfoIncludePath, error)                                       //perm:read
-	MarkDealsAsPacking(ctx context.Context, miner address.Address, deals []abi.DealID) error                                                                          //perm:write
-	UpdateDealOnPacking(ctx context.Context, miner address.Address, pieceCID cid.Cid, dealId abi.DealID, sectorid abi.SectorNumber, offset abi.PaddedPieceSize) error //perm:write
-	UpdateDealStatus(ctx context.Context, miner address.Address, dealId abi.DealID, status string) error                                                              //perm:write
+	GetDeals(ctx context.Context, miner address.Address, pageIndex, pageSize int) ([]*piece.DealInfo, error)                       //perm:read
+	AssignUnPackedDeals(ctx context.Context, miner address.Address, spec *piece.GetDealSpec) ([]*piece.DealInfoIncludePath, error) //perm:write
+	GetUnPackedDeals(ctx context.Context, miner address.Address, spec *piece.GetDealSpec) ([]*piece.DealInfoIncludePath, error)    //perm:read
+	// MarkDealsAsPacking and UpdateDealOnPacking confirm the deals leased by AssignUnPackedDeals with
+	// the id of their lease, the deals not leased with an empty id
+	MarkDealsAsPacking(ctx context.Context, miner address.Address, deals []abi.DealID, leaseID string) error                                                                          //perm:write
+	UpdateDealOnPacking(ctx context.Context, miner address.Address, pieceCID cid.Cid, dealId abi.DealID, sectorid abi.SectorNumber, offset abi.PaddedPieceSize, leaseID string) error //perm:write
+	UpdateDealStatus(ctx context.Context, miner address.Address, dealId abi.DealID, status string) error                                                                              //perm:write
 	//market event
 	ResponseMarketEvent(ctx context.Context, resp *types2.ResponseEvent) error                                            //perm:read
 	ListenMarketEvent(ctx context.Context, policy *marketevent.MarketRegisterPolicy) (<-chan *types2.RequestEvent, error) //perm:read
@@ -213,4 +269,9 @@ type MarketClientNode interface {
 	MarketReserveFunds(ctx context.Context, wallet address.Address, addr address.Address, amt vTypes.BigInt) (cid.Cid, error) //perm:write
 	MarketReleaseFunds(ctx context.Context, addr address.Address, amt vTypes.BigInt) error                                    //perm:write
 	MarketWithdraw(ctx context.Context, wallet, addr address.Address, amt vTypes.BigInt) (cid.Cid, error)                     //perm:write
+
+	// MarketListFunds lists the addresses tracked by the fund manager with their balances, queued requests and message in flight
+	MarketListFunds(ctx context.Context) ([]fundmgr.FundedAddressInfo, error) //perm:read
+	// MarketFundHistory lists the messages sent by the fund manager for addr, or for every address if addr is undefined
+	MarketFundHistory(ctx context.Context, addr address.Address) ([]*fundmgr.FundOperation, error) //perm:read
 }
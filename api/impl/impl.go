@@ -9,13 +9,21 @@ import (
 	datatransfer "github.com/filecoin-project/go-data-transfer"
 	"github.com/filecoin-project/go-fil-markets/piecestore"
 	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	"github.com/filecoin-project/go-fil-markets/shared"
 	"github.com/filecoin-project/go-fil-markets/storagemarket"
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/venus-market/api"
 	clients2 "github.com/filecoin-project/venus-market/api/clients"
 	"github.com/filecoin-project/venus-market/config"
+	dagstore2 "github.com/filecoin-project/venus-market/dagstore"
+	"github.com/filecoin-project/venus-market/dealfilter"
+	"github.com/filecoin-project/venus-market/indexprovider"
+	"github.com/filecoin-project/venus-market/models"
 	"github.com/filecoin-project/venus-market/network"
 	"github.com/filecoin-project/venus-market/piece"
+	"github.com/filecoin-project/venus-market/quota"
+	"github.com/filecoin-project/venus-market/retrievaladapter"
+	"github.com/filecoin-project/venus-market/sealer"
 	storageadapter2 "github.com/filecoin-project/venus-market/storageadapter"
 	"github.com/filecoin-project/venus-market/types"
 	mTypes "github.com/filecoin-project/venus-messager/types"
@@ -31,6 +39,8 @@ import (
 	"golang.org/x/xerrors"
 	"os"
 	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -41,17 +51,30 @@ type MarketNodeImpl struct {
 	FundAPI
 	MarketEventAPI
 	fx.In
-	Cfg               *config.MarketConfig
-	FullNode          apiface.FullNode
-	Host              host.Host
-	StorageProvider   storagemarket.StorageProvider
-	RetrievalProvider retrievalmarket.RetrievalProvider
-	DataTransfer      network.ProviderDataTransfer
-	DealPublisher     *storageadapter2.DealPublisher
-	PieceStore        piece.ExtendPieceStore
-	SectorAccessor    retrievalmarket.SectorAccessor
-	Messager          clients2.IMessager `optional:"true"`
-	DAGStore          *dagstore.DAGStore
+	Cfg                *config.MarketConfig
+	FullNode           apiface.FullNode
+	Host               host.Host
+	Miners             types.MinerAddresses
+	StorageProviders   storageadapter2.StorageProviders
+	RetrievalProviders retrievaladapter.RetrievalProviders
+	DataTransfer       network.ProviderDataTransfer
+	DealPublisher      *storageadapter2.DealPublisher
+	DealUploads        *storageadapter2.DealUploads
+	PieceStores        piece.PieceStores
+	CIDInfoDS          models.CIDInfoDS
+	PieceStorages      *piece.PieceStorageManager
+	PieceGC            *piece.PieceGC
+	SectorAccessors    sealer.SectorAccessors
+	UnsealManager      *sealer.UnsealManager
+	IndexProvider      *indexprovider.Provider
+	Messager           clients2.IMessager `optional:"true"`
+	DAGStore           *dagstore.DAGStore
+	DAGStoreWrapper    *dagstore2.Wrapper
+	DAGStoreHealth     *dagstore2.HealthChecker
+	DealRules          *dealfilter.StorageDealRuleFilter         `optional:"true"`
+	DynamicPricer      *retrievaladapter.DynamicPricer           `optional:"true"`
+	RetrievalRules     *retrievaladapter.RetrievalDealRuleFilter `optional:"true"`
+	Quotas             *quota.ClientQuotas
 
 	ConsiderOnlineStorageDealsConfigFunc        config.ConsiderOnlineStorageDealsConfigFunc
 	SetConsiderOnlineStorageDealsConfigFunc     config.SetConsiderOnlineStorageDealsConfigFunc
@@ -73,16 +96,20 @@ type MarketNodeImpl struct {
 	SetExpectedSealDurationFunc config.SetExpectedSealDurationFunc
 }
 
+// ActorAddress returns the first configured miner, use ActorList to get all of them
 func (m MarketNodeImpl) ActorAddress(ctx context.Context) (address.Address, error) {
-	return address.NewFromString(m.Cfg.MinerAddress)
+	return m.Miners[0], nil
+}
+
+func (m MarketNodeImpl) ActorList(ctx context.Context) ([]address.Address, error) {
+	return m.Miners, nil
 }
 
 func (m MarketNodeImpl) ActorSectorSize(ctx context.Context, addr address.Address) (abi.SectorSize, error) {
-	mAddr, err := address.NewFromString(m.Cfg.MinerAddress)
-	if err != nil {
-		return 0, err
+	if !m.Miners.Has(addr) {
+		return 0, xerrors.Errorf("get sector size of %s: %w", addr, types.ErrMinerNotFound)
 	}
-	minerInfo, err := m.FullNode.StateMinerInfo(ctx, mAddr, vTypes.EmptyTSK)
+	minerInfo, err := m.FullNode.StateMinerInfo(ctx, addr, vTypes.EmptyTSK)
 	if err != nil {
 		return 0, err
 	}
@@ -96,7 +123,11 @@ func (m MarketNodeImpl) MarketImportDealData(ctx context.Context, propCid cid.Ci
 	}
 	defer fi.Close() //nolint:errcheck
 
-	return m.StorageProvider.ImportDataForDeal(ctx, propCid, fi)
+	provider, err := m.storageProviderOfDeal(propCid)
+	if err != nil {
+		return err
+	}
+	return provider.ImportDataForDeal(ctx, propCid, fi)
 }
 
 func (m MarketNodeImpl) MarketListDeals(ctx context.Context) ([]types.MarketDeal, error) {
@@ -105,15 +136,17 @@ func (m MarketNodeImpl) MarketListDeals(ctx context.Context) ([]types.MarketDeal
 
 func (m MarketNodeImpl) MarketListRetrievalDeals(ctx context.Context) ([]retrievalmarket.ProviderDealState, error) {
 	var out []retrievalmarket.ProviderDealState
-	deals := m.RetrievalProvider.ListDeals()
+	for _, mAddr := range m.Miners {
+		deals := m.RetrievalProviders[mAddr].ListDeals()
 
-	for _, deal := range deals {
-		if deal.ChannelID != nil {
-			if deal.ChannelID.Initiator == "" || deal.ChannelID.Responder == "" {
-				deal.ChannelID = nil // don't try to push unparsable peer IDs over jsonrpc
+		for _, deal := range deals {
+			if deal.ChannelID != nil {
+				if deal.ChannelID.Initiator == "" || deal.ChannelID.Responder == "" {
+					deal.ChannelID = nil // don't try to push unparsable peer IDs over jsonrpc
+				}
 			}
+			out = append(out, deal)
 		}
-		out = append(out, deal)
 	}
 
 	return out, nil
@@ -121,44 +154,73 @@ func (m MarketNodeImpl) MarketListRetrievalDeals(ctx context.Context) ([]retriev
 
 func (m MarketNodeImpl) MarketGetDealUpdates(ctx context.Context) (<-chan storagemarket.MinerDeal, error) {
 	results := make(chan storagemarket.MinerDeal)
-	unsub := m.StorageProvider.SubscribeToEvents(func(evt storagemarket.ProviderEvent, deal storagemarket.MinerDeal) {
-		select {
-		case results <- deal:
-		case <-ctx.Done():
-		}
-	})
+	unsubs := make([]shared.Unsubscribe, 0, len(m.Miners))
+	for _, mAddr := range m.Miners {
+		unsubs = append(unsubs, m.StorageProviders[mAddr].SubscribeToEvents(func(evt storagemarket.ProviderEvent, deal storagemarket.MinerDeal) {
+			select {
+			case results <- deal:
+			case <-ctx.Done():
+			}
+		}))
+	}
 	go func() {
 		<-ctx.Done()
-		unsub()
+		for _, unsub := range unsubs {
+			unsub()
+		}
 		close(results)
 	}()
 	return results, nil
 }
 
 func (m MarketNodeImpl) MarketListIncompleteDeals(ctx context.Context) ([]storagemarket.MinerDeal, error) {
-	return m.StorageProvider.ListLocalDeals()
+	var out []storagemarket.MinerDeal
+	for _, mAddr := range m.Miners {
+		deals, err := m.StorageProviders[mAddr].ListLocalDeals()
+		if err != nil {
+			return nil, xerrors.Errorf("list deals of %s: %w", mAddr, err)
+		}
+		out = append(out, deals...)
+	}
+	return out, nil
 }
 
-func (m MarketNodeImpl) MarketSetAsk(ctx context.Context, price vTypes.BigInt, verifiedPrice vTypes.BigInt, duration abi.ChainEpoch, minPieceSize abi.PaddedPieceSize, maxPieceSize abi.PaddedPieceSize) error {
+func (m MarketNodeImpl) MarketSetAsk(ctx context.Context, mAddr address.Address, price vTypes.BigInt, verifiedPrice vTypes.BigInt, duration abi.ChainEpoch, minPieceSize abi.PaddedPieceSize, maxPieceSize abi.PaddedPieceSize) error {
 	options := []storagemarket.StorageAskOption{
 		storagemarket.MinPieceSize(minPieceSize),
 		storagemarket.MaxPieceSize(maxPieceSize),
 	}
 
-	return m.StorageProvider.SetAsk(price, verifiedPrice, duration, options...)
+	provider, err := m.StorageProviders.Get(mAddr)
+	if err != nil {
+		return err
+	}
+	return provider.SetAsk(price, verifiedPrice, duration, options...)
 }
 
-func (m MarketNodeImpl) MarketGetAsk(ctx context.Context) (*storagemarket.SignedStorageAsk, error) {
-	return m.StorageProvider.GetAsk(), nil
+func (m MarketNodeImpl) MarketGetAsk(ctx context.Context, mAddr address.Address) (*storagemarket.SignedStorageAsk, error) {
+	provider, err := m.StorageProviders.Get(mAddr)
+	if err != nil {
+		return nil, err
+	}
+	return provider.GetAsk(), nil
 }
 
-func (m MarketNodeImpl) MarketSetRetrievalAsk(ctx context.Context, rask *retrievalmarket.Ask) error {
-	m.RetrievalProvider.SetAsk(rask)
+func (m MarketNodeImpl) MarketSetRetrievalAsk(ctx context.Context, mAddr address.Address, rask *retrievalmarket.Ask) error {
+	provider, err := m.RetrievalProviders.Get(mAddr)
+	if err != nil {
+		return err
+	}
+	provider.SetAsk(rask)
 	return nil
 }
 
-func (m MarketNodeImpl) MarketGetRetrievalAsk(ctx context.Context) (*retrievalmarket.Ask, error) {
-	return m.RetrievalProvider.GetAsk(), nil
+func (m MarketNodeImpl) MarketGetRetrievalAsk(ctx context.Context, mAddr address.Address) (*retrievalmarket.Ask, error) {
+	provider, err := m.RetrievalProviders.Get(mAddr)
+	if err != nil {
+		return nil, err
+	}
+	return provider.GetAsk(), nil
 }
 
 func (m MarketNodeImpl) MarketListDataTransfers(ctx context.Context) ([]types.DataTransferChannel, error) {
@@ -219,30 +281,89 @@ func (m MarketNodeImpl) MarketPublishPendingDeals(ctx context.Context) error {
 	return nil
 }
 
+func (m MarketNodeImpl) PiecesStorageUsage(ctx context.Context) ([]piece.PieceStorageUsage, error) {
+	return m.PieceStorages.Usage(ctx)
+}
+
+func (m MarketNodeImpl) PiecesGC(ctx context.Context, dryRun bool) ([]piece.PieceGCResult, error) {
+	return m.PieceGC.Collect(ctx, dryRun)
+}
+
+func (m MarketNodeImpl) PiecesListUnsealJobs(ctx context.Context) ([]types.UnsealJob, error) {
+	return m.UnsealManager.Jobs()
+}
+
+func (m MarketNodeImpl) PiecesIndexerHead(ctx context.Context) (cid.Cid, error) {
+	return m.IndexProvider.Head()
+}
+
+func (m MarketNodeImpl) PiecesIndexerAnnounce(ctx context.Context, pieceCid cid.Cid) (cid.Cid, error) {
+	return m.IndexProvider.NotifyPut(ctx, pieceCid)
+}
+
+func (m MarketNodeImpl) PiecesIndexerRemove(ctx context.Context, pieceCid cid.Cid) (cid.Cid, error) {
+	return m.IndexProvider.NotifyRemove(ctx, pieceCid)
+}
+
 func (m MarketNodeImpl) PiecesListPieces(ctx context.Context) ([]cid.Cid, error) {
-	return m.PieceStore.ListPieceInfoKeys()
+	seen := make(map[cid.Cid]struct{})
+	var out []cid.Cid
+	for _, mAddr := range m.Miners {
+		pieces, err := m.PieceStores[mAddr].ListPieceInfoKeys()
+		if err != nil {
+			return nil, xerrors.Errorf("list pieces of %s: %w", mAddr, err)
+		}
+		for _, pieceCid := range pieces {
+			if _, ok := seen[pieceCid]; ok {
+				continue
+			}
+			seen[pieceCid] = struct{}{}
+			out = append(out, pieceCid)
+		}
+	}
+	return out, nil
 }
 
 func (m MarketNodeImpl) PiecesListCidInfos(ctx context.Context) ([]cid.Cid, error) {
-	return m.PieceStore.ListCidInfoKeys()
+	// cid infos are shared by the piece stores of all miners
+	return m.PieceStores[m.Miners[0]].ListCidInfoKeys()
 }
 
+// PiecesGetPieceInfo merges the deals of the piece made with every miner
 func (m MarketNodeImpl) PiecesGetPieceInfo(ctx context.Context, pieceCid cid.Cid) (*piecestore.PieceInfo, error) {
-	pi, err := m.PieceStore.GetPieceInfo(pieceCid)
-	if err != nil {
-		return nil, err
+	var pi *piecestore.PieceInfo
+	var lastErr error
+	for _, mAddr := range m.Miners {
+		minerPi, err := m.PieceStores[mAddr].GetPieceInfo(pieceCid)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if pi == nil {
+			pi = &minerPi
+			continue
+		}
+		pi.Deals = append(pi.Deals, minerPi.Deals...)
+	}
+	if pi == nil {
+		return nil, lastErr
 	}
-	return &pi, nil
+	return pi, nil
 }
 
 func (m MarketNodeImpl) PiecesGetCIDInfo(ctx context.Context, payloadCid cid.Cid) (*piecestore.CIDInfo, error) {
-	ci, err := m.PieceStore.GetCIDInfo(payloadCid)
+	ci, err := m.PieceStores[m.Miners[0]].GetCIDInfo(payloadCid)
 	if err != nil {
 		return nil, err
 	}
 
 	return &ci, nil
 }
+
+func (m MarketNodeImpl) PiecesDropCidInfos(ctx context.Context) (int, error) {
+	return m.DAGStoreWrapper.DropCidInfos(m.CIDInfoDS)
+}
+
 func (m MarketNodeImpl) DealsList(ctx context.Context) ([]types.MarketDeal, error) {
 	return m.listDeals(ctx)
 }
@@ -346,13 +467,8 @@ func (m MarketNodeImpl) listDeals(ctx context.Context) ([]types.MarketDeal, erro
 
 	var out []types.MarketDeal
 
-	addr, err := m.ActorAddress(ctx)
-	if err != nil {
-		return nil, err
-	}
-
 	for _, deal := range allDeals {
-		if deal.Proposal.Provider == addr {
+		if m.Miners.Has(deal.Proposal.Provider) {
 			out = append(out, deal)
 		}
 	}
@@ -461,19 +577,24 @@ func (m MarketNodeImpl) DagstoreInitializeAll(ctx context.Context, params types.
 				continue
 			}
 
-			pi, err := m.PieceStore.GetPieceInfo(pieceCid)
-			if err != nil {
-				log.Warnw("DagstoreInitializeAll: failed to get piece info; skipping", "piece_cid", pieceCid, "error", err)
-				continue
-			}
-
 			var isUnsealed bool
-			for _, d := range pi.Deals {
-				isUnsealed, err = m.SectorAccessor.IsUnsealed(ctx, d.SectorID, d.Offset.Unpadded(), d.Length.Unpadded())
+			for _, mAddr := range m.Miners {
+				var pi piecestore.PieceInfo
+				pi, err = m.PieceStores[mAddr].GetPieceInfo(pieceCid)
 				if err != nil {
-					log.Warnw("DagstoreInitializeAll: failed to get unsealed status; skipping deal", "deal_id", d.DealID, "error", err)
 					continue
 				}
+
+				for _, d := range pi.Deals {
+					isUnsealed, err = m.SectorAccessors[mAddr].IsUnsealed(ctx, d.SectorID, d.Offset.Unpadded(), d.Length.Unpadded())
+					if err != nil {
+						log.Warnw("DagstoreInitializeAll: failed to get unsealed status; skipping deal", "deal_id", d.DealID, "error", err)
+						continue
+					}
+					if isUnsealed {
+						break
+					}
+				}
 				if isUnsealed {
 					break
 				}
@@ -618,24 +739,48 @@ func (m MarketNodeImpl) DagstoreGC(ctx context.Context) ([]types.DagstoreShardRe
 	return ret, nil
 }
 
+func (m MarketNodeImpl) DagstoreHealth(ctx context.Context) ([]types.DagstoreShardHealth, error) {
+	return m.DAGStoreHealth.Report(), nil
+}
+
 func (m MarketNodeImpl) GetUnPackedDeals(ctx context.Context, miner address.Address, spec *piece.GetDealSpec) ([]*piece.DealInfoIncludePath, error) {
-	return m.PieceStore.GetUnPackedDeals(spec)
+	ps, err := m.PieceStores.Get(miner)
+	if err != nil {
+		return nil, err
+	}
+	return ps.GetUnPackedDeals(spec)
 }
 
-func (m MarketNodeImpl) AssignUnPackedDeals(spec *piece.GetDealSpec) ([]*piece.DealInfoIncludePath, error) {
-	return m.PieceStore.AssignUnPackedDeals(spec)
+func (m MarketNodeImpl) AssignUnPackedDeals(ctx context.Context, miner address.Address, spec *piece.GetDealSpec) ([]*piece.DealInfoIncludePath, error) {
+	ps, err := m.PieceStores.Get(miner)
+	if err != nil {
+		return nil, err
+	}
+	return ps.AssignUnPackedDeals(spec)
 }
 
-func (m MarketNodeImpl) MarkDealsAsPacking(ctx context.Context, miner address.Address, deals []abi.DealID) error {
-	return m.PieceStore.MarkDealsAsPacking(deals)
+func (m MarketNodeImpl) MarkDealsAsPacking(ctx context.Context, miner address.Address, deals []abi.DealID, leaseID string) error {
+	ps, err := m.PieceStores.Get(miner)
+	if err != nil {
+		return err
+	}
+	return ps.MarkDealsAsPacking(deals, leaseID)
 }
 
-func (m MarketNodeImpl) UpdateDealOnPacking(ctx context.Context, miner address.Address, pieceCID cid.Cid, dealId abi.DealID, sectorid abi.SectorNumber, offset abi.PaddedPieceSize) error {
-	return m.PieceStore.UpdateDealOnPacking(pieceCID, dealId, sectorid, offset)
+func (m MarketNodeImpl) UpdateDealOnPacking(ctx context.Context, miner address.Address, pieceCID cid.Cid, dealId abi.DealID, sectorid abi.SectorNumber, offset abi.PaddedPieceSize, leaseID string) error {
+	ps, err := m.PieceStores.Get(miner)
+	if err != nil {
+		return err
+	}
+	return ps.UpdateDealOnPacking(pieceCID, dealId, sectorid, offset, leaseID)
 }
 
 func (m MarketNodeImpl) UpdateDealStatus(ctx context.Context, miner address.Address, dealId abi.DealID, status string) error {
-	return m.PieceStore.UpdateDealStatus(dealId, status)
+	ps, err := m.PieceStores.Get(miner)
+	if err != nil {
+		return err
+	}
+	return ps.UpdateDealStatus(dealId, status)
 }
 
 func (m MarketNodeImpl) DealsImportData(ctx context.Context, dealPropCid cid.Cid, fname string) error {
@@ -645,9 +790,202 @@ func (m MarketNodeImpl) DealsImportData(ctx context.Context, dealPropCid cid.Cid
 	}
 	defer fi.Close() //nolint:errcheck
 
-	return m.StorageProvider.ImportDataForDeal(ctx, dealPropCid, fi)
+	provider, err := m.storageProviderOfDeal(dealPropCid)
+	if err != nil {
+		return err
+	}
+	return provider.ImportDataForDeal(ctx, dealPropCid, fi)
+}
+
+func (m MarketNodeImpl) DealsBatchImportData(ctx context.Context, refs []types.ImportDataRef, parallel int) ([]types.ImportDataResult, error) {
+	if parallel <= 0 {
+		parallel = 1
+	}
+
+	// offline deals waiting for data of each piece, for the refs given by piece
+	pieceDeals := make(map[cid.Cid][]cid.Cid)
+	for _, mAddr := range m.Miners {
+		deals, err := m.StorageProviders[mAddr].ListLocalDeals()
+		if err != nil {
+			return nil, xerrors.Errorf("list deals of %s: %w", mAddr, err)
+		}
+		for _, deal := range deals {
+			if deal.State == storagemarket.StorageDealWaitingForData && deal.Ref != nil && deal.Ref.TransferType == storagemarket.TTManual {
+				pieceDeals[deal.Proposal.PieceCID] = append(pieceDeals[deal.Proposal.PieceCID], deal.ProposalCid)
+			}
+		}
+	}
+
+	results := make([]types.ImportDataResult, len(refs))
+	throttle := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	started := 0
+loop:
+	for i, ref := range refs {
+		// select picks at random when both are ready
+		if ctx.Err() != nil {
+			break
+		}
+		select {
+		case throttle <- struct{}{}:
+		case <-ctx.Done():
+			break loop
+		}
+		started++
+		wg.Add(1)
+		go func(i int, ref types.ImportDataRef) {
+			defer func() {
+				<-throttle
+				wg.Done()
+			}()
+			results[i] = m.importDataRef(ctx, ref, pieceDeals)
+		}(i, ref)
+	}
+	// the imports already started write to results and the data files
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		// the rows never started can be imported again
+		for i := started; i < len(refs); i++ {
+			results[i] = types.ImportDataResult{ImportDataRef: refs[i], Status: types.ImportDataSkipped, Message: "cancelled"}
+		}
+		return results, err
+	}
+	return results, nil
+}
+
+func (m MarketNodeImpl) importDataRef(ctx context.Context, ref types.ImportDataRef, pieceDeals map[cid.Cid][]cid.Cid) types.ImportDataResult {
+	res := types.ImportDataResult{ImportDataRef: ref, Status: types.ImportDataFailed}
+
+	var proposals []cid.Cid
+	switch {
+	case ref.ProposalCid != nil:
+		proposals = []cid.Cid{*ref.ProposalCid}
+	case ref.PieceCid != nil:
+		proposals = pieceDeals[*ref.PieceCid]
+		if len(proposals) == 0 {
+			res.Status = types.ImportDataSkipped
+			res.Message = "no offline deal of the piece is waiting for data"
+			return res
+		}
+	default:
+		res.Message = "neither proposal cid nor piece cid given"
+		return res
+	}
+
+	var skipped, failed []string
+	for _, propCid := range proposals {
+		provider, err := m.storageProviderOfDeal(propCid)
+		if err != nil {
+			failed = append(failed, err.Error())
+			continue
+		}
+		deal, err := provider.GetLocalDeal(propCid)
+		if err != nil {
+			failed = append(failed, xerrors.Errorf("get deal %s: %w", propCid, err).Error())
+			continue
+		}
+		if deal.State != storagemarket.StorageDealWaitingForData {
+			skipped = append(skipped, fmt.Sprintf("deal %s in state %s", propCid, storagemarket.DealStates[deal.State]))
+			continue
+		}
+
+		if err := m.importDealData(ctx, provider, propCid, ref.File); err != nil {
+			failed = append(failed, xerrors.Errorf("deal %s: %w", propCid, err).Error())
+			continue
+		}
+		res.Deals = append(res.Deals, propCid)
+	}
+
+	res.Message = strings.Join(append(failed, skipped...), "; ")
+	switch {
+	case len(failed) > 0:
+		res.Status = types.ImportDataFailed
+	case len(res.Deals) > 0:
+		res.Status = types.ImportDataImported
+	default:
+		res.Status = types.ImportDataSkipped
+	}
+	return res
+}
+
+func (m MarketNodeImpl) importDealData(ctx context.Context, provider storagemarket.StorageProvider, propCid cid.Cid, fname string) error {
+	fi, err := os.Open(fname)
+	if err != nil {
+		return xerrors.Errorf("failed to open given file: %w", err)
+	}
+	defer fi.Close() //nolint:errcheck
+
+	return provider.ImportDataForDeal(ctx, propCid, fi)
+}
+
+func (m MarketNodeImpl) DealsStorageDealRules(ctx context.Context) (*dealfilter.StorageDealRules, error) {
+	if m.DealRules == nil {
+		return nil, xerrors.Errorf("no storage deal rules configured")
+	}
+	rules := m.DealRules.Rules()
+	return &rules, nil
+}
+
+func (m MarketNodeImpl) DealsReloadStorageDealRules(ctx context.Context) error {
+	if m.DealRules == nil {
+		return xerrors.Errorf("no storage deal rules configured")
+	}
+	return m.DealRules.Reload()
+}
+
+func (m MarketNodeImpl) MarketRetrievalPricingRules(ctx context.Context) (*retrievaladapter.DynamicPricingRules, error) {
+	if m.DynamicPricer == nil {
+		return nil, xerrors.Errorf("no dynamic retrieval pricing configured")
+	}
+	rules := m.DynamicPricer.Rules()
+	return &rules, nil
+}
+
+func (m MarketNodeImpl) MarketReloadRetrievalPricingRules(ctx context.Context) error {
+	if m.DynamicPricer == nil {
+		return xerrors.Errorf("no dynamic retrieval pricing configured")
+	}
+	return m.DynamicPricer.Reload()
+}
+
+func (m MarketNodeImpl) DealsRetrievalDealRules(ctx context.Context) (*retrievaladapter.RetrievalDealRules, error) {
+	if m.RetrievalRules == nil {
+		return nil, xerrors.Errorf("no retrieval deal rules configured")
+	}
+	rules := m.RetrievalRules.Rules()
+	return &rules, nil
+}
+
+func (m MarketNodeImpl) DealsReloadRetrievalDealRules(ctx context.Context) error {
+	if m.RetrievalRules == nil {
+		return xerrors.Errorf("no retrieval deal rules configured")
+	}
+	return m.RetrievalRules.Reload()
+}
+
+func (m MarketNodeImpl) DealsClientUsages(ctx context.Context) ([]quota.ClientUsage, error) {
+	return m.Quotas.Usages()
+}
+
+func (m MarketNodeImpl) DealsResetClientUsage(ctx context.Context, client string) error {
+	return m.Quotas.Reset(client)
+}
+
+// storageProviderOfDeal finds the storage provider which has received the proposal
+func (m MarketNodeImpl) storageProviderOfDeal(propCid cid.Cid) (storagemarket.StorageProvider, error) {
+	for _, mAddr := range m.Miners {
+		provider := m.StorageProviders[mAddr]
+		if _, err := provider.GetLocalDeal(propCid); err == nil {
+			return provider, nil
+		}
+	}
+	return nil, xerrors.Errorf("deal %s not found in any miner", propCid)
 }
 
 func (m MarketNodeImpl) GetDeals(ctx context.Context, miner address.Address, pageIndex, pageSize int) ([]*piece.DealInfo, error) {
-	return m.PieceStore.GetDeals(pageIndex, pageSize)
+	ps, err := m.PieceStores.Get(miner)
+	if err != nil {
+		return nil, err
+	}
+	return ps.GetDeals(pageIndex, pageSize)
 }
@@ -0,0 +1,163 @@
+package impl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+
+	storageadapter2 "github.com/filecoin-project/venus-market/storageadapter"
+	"github.com/filecoin-project/venus-market/types"
+)
+
+type importTestProvider struct {
+	storagemarket.StorageProvider
+
+	lk       sync.Mutex
+	deals    map[cid.Cid]storagemarket.MinerDeal
+	imported map[cid.Cid]string
+	failOn   map[cid.Cid]error
+}
+
+func (p *importTestProvider) ListLocalDeals() ([]storagemarket.MinerDeal, error) {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	deals := make([]storagemarket.MinerDeal, 0, len(p.deals))
+	for _, deal := range p.deals {
+		deals = append(deals, deal)
+	}
+	return deals, nil
+}
+
+func (p *importTestProvider) GetLocalDeal(propCid cid.Cid) (storagemarket.MinerDeal, error) {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	deal, ok := p.deals[propCid]
+	if !ok {
+		return storagemarket.MinerDeal{}, fmt.Errorf("deal %s not found", propCid)
+	}
+	return deal, nil
+}
+
+func (p *importTestProvider) ImportDataForDeal(_ context.Context, propCid cid.Cid, data io.Reader) error {
+	buf, err := ioutil.ReadAll(data)
+	if err != nil {
+		return err
+	}
+
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	if err := p.failOn[propCid]; err != nil {
+		return err
+	}
+	deal := p.deals[propCid]
+	deal.State = storagemarket.StorageDealVerifyData
+	p.deals[propCid] = deal
+	p.imported[propCid] = string(buf)
+	return nil
+}
+
+func testImportCid(t *testing.T, codec uint64, data string) cid.Cid {
+	c, err := cid.Prefix{Version: 1, Codec: codec, MhType: multihash.SHA2_256, MhLength: -1}.Sum([]byte(data))
+	require.NoError(t, err)
+	return c
+}
+
+func TestDealsBatchImportData(t *testing.T) {
+	ctx := context.Background()
+	maddr, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+
+	pieceCid := testImportCid(t, cid.FilCommitmentUnsealed, "piece")
+	otherPiece := testImportCid(t, cid.FilCommitmentUnsealed, "other piece")
+	newDeal := func(name string, piece cid.Cid, state storagemarket.StorageDealStatus) storagemarket.MinerDeal {
+		deal := storagemarket.MinerDeal{
+			ProposalCid: testImportCid(t, cid.DagCBOR, name),
+			Ref:         &storagemarket.DataRef{TransferType: storagemarket.TTManual},
+			State:       state,
+		}
+		deal.Proposal.PieceCID = piece
+		return deal
+	}
+	waiting1 := newDeal("waiting 1", pieceCid, storagemarket.StorageDealWaitingForData)
+	waiting2 := newDeal("waiting 2", pieceCid, storagemarket.StorageDealWaitingForData)
+	sealing := newDeal("sealing", pieceCid, storagemarket.StorageDealSealing)
+	failing := newDeal("failing", otherPiece, storagemarket.StorageDealWaitingForData)
+
+	provider := &importTestProvider{
+		deals:    make(map[cid.Cid]storagemarket.MinerDeal),
+		imported: make(map[cid.Cid]string),
+		failOn:   map[cid.Cid]error{failing.ProposalCid: fmt.Errorf("bad data")},
+	}
+	for _, deal := range []storagemarket.MinerDeal{waiting1, waiting2, sealing, failing} {
+		provider.deals[deal.ProposalCid] = deal
+	}
+	m := MarketNodeImpl{
+		Miners:           types.MinerAddresses{maddr},
+		StorageProviders: storageadapter2.StorageProviders{maddr: provider},
+	}
+
+	file := filepath.Join(t.TempDir(), "data")
+	require.NoError(t, ioutil.WriteFile(file, []byte("piece data"), 0644))
+	unknown := testImportCid(t, cid.DagCBOR, "unknown")
+	unknownPiece := testImportCid(t, cid.FilCommitmentUnsealed, "unknown piece")
+	refs := []types.ImportDataRef{
+		// every deal of the piece waiting for data
+		{PieceCid: &pieceCid, File: file},
+		// the deal went past waiting for data with the import by piece
+		{ProposalCid: &waiting1.ProposalCid, File: file},
+		{ProposalCid: &sealing.ProposalCid, File: file},
+		{PieceCid: &unknownPiece, File: file},
+		{ProposalCid: &unknown, File: file},
+		{ProposalCid: &failing.ProposalCid, File: file},
+		{File: file},
+	}
+
+	results, err := m.DealsBatchImportData(ctx, refs, 1)
+	require.NoError(t, err)
+	require.Len(t, results, len(refs))
+
+	require.Equal(t, types.ImportDataImported, results[0].Status)
+	require.ElementsMatch(t, []cid.Cid{waiting1.ProposalCid, waiting2.ProposalCid}, results[0].Deals)
+	require.Equal(t, map[cid.Cid]string{waiting1.ProposalCid: "piece data", waiting2.ProposalCid: "piece data"}, provider.imported)
+
+	for i, status := range []types.ImportDataStatus{
+		types.ImportDataSkipped,
+		types.ImportDataSkipped,
+		types.ImportDataSkipped,
+		types.ImportDataFailed,
+		types.ImportDataFailed,
+		types.ImportDataFailed,
+	} {
+		res := results[i+1]
+		require.Equal(t, refs[i+1], res.ImportDataRef)
+		require.Equal(t, status, res.Status, "row %d: %s", i+2, res.Message)
+		require.Empty(t, res.Deals)
+		require.NotEmpty(t, res.Message)
+	}
+	require.Len(t, provider.imported, 2)
+
+	// the batch is aborted once the context is done, the rows never started are skipped
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	cancelled := []types.ImportDataRef{{ProposalCid: &failing.ProposalCid, File: file}, {ProposalCid: &unknown, File: file}}
+	results, err = m.DealsBatchImportData(cctx, cancelled, 1)
+	require.ErrorIs(t, err, context.Canceled)
+	require.Len(t, results, len(cancelled))
+	for i, res := range results {
+		require.Equal(t, cancelled[i], res.ImportDataRef)
+		require.Equal(t, types.ImportDataSkipped, res.Status)
+		require.Equal(t, "cancelled", res.Message)
+	}
+}
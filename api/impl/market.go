@@ -57,3 +57,11 @@ func (a *FundAPI) MarketReleaseFunds(ctx context.Context, addr address.Address,
 func (a *FundAPI) MarketWithdraw(ctx context.Context, wallet, addr address.Address, amt types.BigInt) (cid.Cid, error) {
 	return a.FMgr.Withdraw(ctx, wallet, addr, amt)
 }
+
+func (a *FundAPI) MarketListFunds(ctx context.Context) ([]fundmgr.FundedAddressInfo, error) {
+	return a.FMgr.ListFundedAddresses(ctx)
+}
+
+func (a *FundAPI) MarketFundHistory(ctx context.Context, addr address.Address) ([]*fundmgr.FundOperation, error) {
+	return a.FMgr.History(addr)
+}
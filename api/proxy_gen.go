@@ -11,8 +11,12 @@ import (
 	"github.com/filecoin-project/go-fil-markets/storagemarket"
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/venus-market/client"
+	"github.com/filecoin-project/venus-market/dealfilter"
+	"github.com/filecoin-project/venus-market/fundmgr"
 	"github.com/filecoin-project/venus-market/imports"
 	"github.com/filecoin-project/venus-market/piece"
+	"github.com/filecoin-project/venus-market/quota"
+	"github.com/filecoin-project/venus-market/retrievaladapter"
 	"github.com/filecoin-project/venus-market/types"
 	"github.com/filecoin-project/venus-market/utils"
 	mTypes "github.com/filecoin-project/venus-messager/types"
@@ -86,8 +90,12 @@ type MarketClientNodeStruct struct {
 
 		MarketAddBalance func(p0 context.Context, p1 address.Address, p2 address.Address, p3 vTypes.BigInt) (cid.Cid, error) `perm:"write"`
 
+		MarketFundHistory func(p0 context.Context, p1 address.Address) ([]*fundmgr.FundOperation, error) `perm:"read"`
+
 		MarketGetReserved func(p0 context.Context, p1 address.Address) (vTypes.BigInt, error) `perm:"read"`
 
+		MarketListFunds func(p0 context.Context) ([]fundmgr.FundedAddressInfo, error) `perm:"read"`
+
 		MarketReleaseFunds func(p0 context.Context, p1 address.Address, p2 vTypes.BigInt) error `perm:"write"`
 
 		MarketReserveFunds func(p0 context.Context, p1 address.Address, p2 address.Address, p3 vTypes.BigInt) (cid.Cid, error) `perm:"write"`
@@ -103,12 +111,16 @@ type MarketFullNodeStruct struct {
 	Internal struct {
 		ActorAddress func(p0 context.Context) (address.Address, error) `perm:"read"`
 
+		ActorList func(p0 context.Context) ([]address.Address, error) `perm:"read"`
+
 		ActorSectorSize func(p0 context.Context, p1 address.Address) (abi.SectorSize, error) `perm:"read"`
 
-		AssignUnPackedDeals func(p0 *piece.GetDealSpec) ([]*piece.DealInfoIncludePath, error) `perm:"write"`
+		AssignUnPackedDeals func(p0 context.Context, p1 address.Address, p2 *piece.GetDealSpec) ([]*piece.DealInfoIncludePath, error) `perm:"write"`
 
 		DagstoreGC func(p0 context.Context) ([]types.DagstoreShardResult, error) `perm:"admin"`
 
+		DagstoreHealth func(p0 context.Context) ([]types.DagstoreShardHealth, error) `perm:"read"`
+
 		DagstoreInitializeAll func(p0 context.Context, p1 types.DagstoreInitializeAllParams) (<-chan types.DagstoreInitializeAllEvent, error) `perm:"write"`
 
 		DagstoreInitializeShard func(p0 context.Context, p1 string) error `perm:"write"`
@@ -117,6 +129,10 @@ type MarketFullNodeStruct struct {
 
 		DagstoreRecoverShard func(p0 context.Context, p1 string) error `perm:"write"`
 
+		DealsBatchImportData func(p0 context.Context, p1 []types.ImportDataRef, p2 int) ([]types.ImportDataResult, error) `perm:"admin"`
+
+		DealsClientUsages func(p0 context.Context) ([]quota.ClientUsage, error) `perm:"admin"`
+
 		DealsConsiderOfflineRetrievalDeals func(p0 context.Context) (bool, error) `perm:"admin"`
 
 		DealsConsiderOfflineStorageDeals func(p0 context.Context) (bool, error) `perm:"admin"`
@@ -135,6 +151,14 @@ type MarketFullNodeStruct struct {
 
 		DealsPieceCidBlocklist func(p0 context.Context) ([]cid.Cid, error) `perm:"admin"`
 
+		DealsReloadRetrievalDealRules func(p0 context.Context) error `perm:"admin"`
+
+		DealsReloadStorageDealRules func(p0 context.Context) error `perm:"admin"`
+
+		DealsResetClientUsage func(p0 context.Context, p1 string) error `perm:"admin"`
+
+		DealsRetrievalDealRules func(p0 context.Context) (*retrievaladapter.RetrievalDealRules, error) `perm:"read"`
+
 		DealsSetConsiderOfflineRetrievalDeals func(p0 context.Context, p1 bool) error `perm:"admin"`
 
 		DealsSetConsiderOfflineStorageDeals func(p0 context.Context, p1 bool) error `perm:"admin"`
@@ -149,6 +173,8 @@ type MarketFullNodeStruct struct {
 
 		DealsSetPieceCidBlocklist func(p0 context.Context, p1 []cid.Cid) error `perm:"admin"`
 
+		DealsStorageDealRules func(p0 context.Context) (*dealfilter.StorageDealRules, error) `perm:"read"`
+
 		GetDeals func(p0 context.Context, p1 address.Address, p2 int, p3 int) ([]*piece.DealInfo, error) `perm:"read"`
 
 		GetUnPackedDeals func(p0 context.Context, p1 address.Address, p2 *piece.GetDealSpec) ([]*piece.DealInfoIncludePath, error) `perm:"read"`
@@ -157,7 +183,7 @@ type MarketFullNodeStruct struct {
 
 		ListenMarketEvent func(p0 context.Context, p1 *marketevent.MarketRegisterPolicy) (<-chan *types2.RequestEvent, error) `perm:"read"`
 
-		MarkDealsAsPacking func(p0 context.Context, p1 address.Address, p2 []abi.DealID) error `perm:"write"`
+		MarkDealsAsPacking func(p0 context.Context, p1 address.Address, p2 []abi.DealID, p3 string) error `perm:"write"`
 
 		MarketAddBalance func(p0 context.Context, p1 address.Address, p2 address.Address, p3 vTypes.BigInt) (cid.Cid, error) `perm:"sign"`
 
@@ -165,13 +191,15 @@ type MarketFullNodeStruct struct {
 
 		MarketDataTransferUpdates func(p0 context.Context) (<-chan types.DataTransferChannel, error) `perm:"write"`
 
-		MarketGetAsk func(p0 context.Context) (*storagemarket.SignedStorageAsk, error) `perm:"read"`
+		MarketFundHistory func(p0 context.Context, p1 address.Address) ([]*fundmgr.FundOperation, error) `perm:"read"`
+
+		MarketGetAsk func(p0 context.Context, p1 address.Address) (*storagemarket.SignedStorageAsk, error) `perm:"read"`
 
 		MarketGetDealUpdates func(p0 context.Context) (<-chan storagemarket.MinerDeal, error) `perm:"read"`
 
 		MarketGetReserved func(p0 context.Context, p1 address.Address) (vTypes.BigInt, error) `perm:"sign"`
 
-		MarketGetRetrievalAsk func(p0 context.Context) (*retrievalmarket.Ask, error) `perm:"read"`
+		MarketGetRetrievalAsk func(p0 context.Context, p1 address.Address) (*retrievalmarket.Ask, error) `perm:"read"`
 
 		MarketImportDealData func(p0 context.Context, p1 cid.Cid, p2 string) error `perm:"write"`
 
@@ -179,6 +207,8 @@ type MarketFullNodeStruct struct {
 
 		MarketListDeals func(p0 context.Context) ([]types.MarketDeal, error) `perm:"read"`
 
+		MarketListFunds func(p0 context.Context) ([]fundmgr.FundedAddressInfo, error) `perm:"read"`
+
 		MarketListIncompleteDeals func(p0 context.Context) ([]storagemarket.MinerDeal, error) `perm:"read"`
 
 		MarketListRetrievalDeals func(p0 context.Context) ([]retrievalmarket.ProviderDealState, error) `perm:"read"`
@@ -189,13 +219,17 @@ type MarketFullNodeStruct struct {
 
 		MarketReleaseFunds func(p0 context.Context, p1 address.Address, p2 vTypes.BigInt) error `perm:"sign"`
 
+		MarketReloadRetrievalPricingRules func(p0 context.Context) error `perm:"admin"`
+
 		MarketReserveFunds func(p0 context.Context, p1 address.Address, p2 address.Address, p3 vTypes.BigInt) (cid.Cid, error) `perm:"sign"`
 
 		MarketRestartDataTransfer func(p0 context.Context, p1 datatransfer.TransferID, p2 peer.ID, p3 bool) error `perm:"write"`
 
-		MarketSetAsk func(p0 context.Context, p1 vTypes.BigInt, p2 vTypes.BigInt, p3 abi.ChainEpoch, p4 abi.PaddedPieceSize, p5 abi.PaddedPieceSize) error `perm:"admin"`
+		MarketRetrievalPricingRules func(p0 context.Context) (*retrievaladapter.DynamicPricingRules, error) `perm:"read"`
+
+		MarketSetAsk func(p0 context.Context, p1 address.Address, p2 vTypes.BigInt, p3 vTypes.BigInt, p4 abi.ChainEpoch, p5 abi.PaddedPieceSize, p6 abi.PaddedPieceSize) error `perm:"admin"`
 
-		MarketSetRetrievalAsk func(p0 context.Context, p1 *retrievalmarket.Ask) error `perm:"admin"`
+		MarketSetRetrievalAsk func(p0 context.Context, p1 address.Address, p2 *retrievalmarket.Ask) error `perm:"admin"`
 
 		MarketWithdraw func(p0 context.Context, p1 address.Address, p2 address.Address, p3 vTypes.BigInt) (cid.Cid, error) `perm:"sign"`
 
@@ -207,21 +241,35 @@ type MarketFullNodeStruct struct {
 
 		NetAddrsListen func(p0 context.Context) (peer.AddrInfo, error) `perm:"read"`
 
+		PiecesDropCidInfos func(p0 context.Context) (int, error) `perm:"admin"`
+
+		PiecesGC func(p0 context.Context, p1 bool) ([]piece.PieceGCResult, error) `perm:"admin"`
+
 		PiecesGetCIDInfo func(p0 context.Context, p1 cid.Cid) (*piecestore.CIDInfo, error) `perm:"read"`
 
 		PiecesGetPieceInfo func(p0 context.Context, p1 cid.Cid) (*piecestore.PieceInfo, error) `perm:"read"`
 
+		PiecesIndexerAnnounce func(p0 context.Context, p1 cid.Cid) (cid.Cid, error) `perm:"admin"`
+
+		PiecesIndexerHead func(p0 context.Context) (cid.Cid, error) `perm:"read"`
+
+		PiecesIndexerRemove func(p0 context.Context, p1 cid.Cid) (cid.Cid, error) `perm:"admin"`
+
 		PiecesListCidInfos func(p0 context.Context) ([]cid.Cid, error) `perm:"read"`
 
 		PiecesListPieces func(p0 context.Context) ([]cid.Cid, error) `perm:"read"`
 
+		PiecesListUnsealJobs func(p0 context.Context) ([]types.UnsealJob, error) `perm:"read"`
+
+		PiecesStorageUsage func(p0 context.Context) ([]piece.PieceStorageUsage, error) `perm:"read"`
+
 		ResponseMarketEvent func(p0 context.Context, p1 *types2.ResponseEvent) error `perm:"read"`
 
 		SectorGetSealDelay func(p0 context.Context) (time.Duration, error) `perm:"read"`
 
 		SectorSetExpectedSealDuration func(p0 context.Context, p1 time.Duration) error `perm:"write"`
 
-		UpdateDealOnPacking func(p0 context.Context, p1 address.Address, p2 cid.Cid, p3 abi.DealID, p4 abi.SectorNumber, p5 abi.PaddedPieceSize) error `perm:"write"`
+		UpdateDealOnPacking func(p0 context.Context, p1 address.Address, p2 cid.Cid, p3 abi.DealID, p4 abi.SectorNumber, p5 abi.PaddedPieceSize, p6 string) error `perm:"write"`
 
 		UpdateDealStatus func(p0 context.Context, p1 address.Address, p2 abi.DealID, p3 string) error `perm:"write"`
 	}
@@ -462,6 +510,14 @@ func (s *MarketClientNodeStub) MarketAddBalance(p0 context.Context, p1 address.A
 	return *new(cid.Cid), xerrors.New("method not supported")
 }
 
+func (s *MarketClientNodeStruct) MarketFundHistory(p0 context.Context, p1 address.Address) ([]*fundmgr.FundOperation, error) {
+	return s.Internal.MarketFundHistory(p0, p1)
+}
+
+func (s *MarketClientNodeStub) MarketFundHistory(p0 context.Context, p1 address.Address) ([]*fundmgr.FundOperation, error) {
+	return *new([]*fundmgr.FundOperation), xerrors.New("method not supported")
+}
+
 func (s *MarketClientNodeStruct) MarketGetReserved(p0 context.Context, p1 address.Address) (vTypes.BigInt, error) {
 	return s.Internal.MarketGetReserved(p0, p1)
 }
@@ -470,6 +526,14 @@ func (s *MarketClientNodeStub) MarketGetReserved(p0 context.Context, p1 address.
 	return *new(vTypes.BigInt), xerrors.New("method not supported")
 }
 
+func (s *MarketClientNodeStruct) MarketListFunds(p0 context.Context) ([]fundmgr.FundedAddressInfo, error) {
+	return s.Internal.MarketListFunds(p0)
+}
+
+func (s *MarketClientNodeStub) MarketListFunds(p0 context.Context) ([]fundmgr.FundedAddressInfo, error) {
+	return *new([]fundmgr.FundedAddressInfo), xerrors.New("method not supported")
+}
+
 func (s *MarketClientNodeStruct) MarketReleaseFunds(p0 context.Context, p1 address.Address, p2 vTypes.BigInt) error {
 	return s.Internal.MarketReleaseFunds(p0, p1, p2)
 }
@@ -502,6 +566,14 @@ func (s *MarketFullNodeStub) ActorAddress(p0 context.Context) (address.Address,
 	return *new(address.Address), xerrors.New("method not supported")
 }
 
+func (s *MarketFullNodeStruct) ActorList(p0 context.Context) ([]address.Address, error) {
+	return s.Internal.ActorList(p0)
+}
+
+func (s *MarketFullNodeStub) ActorList(p0 context.Context) ([]address.Address, error) {
+	return *new([]address.Address), xerrors.New("method not supported")
+}
+
 func (s *MarketFullNodeStruct) ActorSectorSize(p0 context.Context, p1 address.Address) (abi.SectorSize, error) {
 	return s.Internal.ActorSectorSize(p0, p1)
 }
@@ -510,11 +582,11 @@ func (s *MarketFullNodeStub) ActorSectorSize(p0 context.Context, p1 address.Addr
 	return *new(abi.SectorSize), xerrors.New("method not supported")
 }
 
-func (s *MarketFullNodeStruct) AssignUnPackedDeals(p0 *piece.GetDealSpec) ([]*piece.DealInfoIncludePath, error) {
-	return s.Internal.AssignUnPackedDeals(p0)
+func (s *MarketFullNodeStruct) AssignUnPackedDeals(p0 context.Context, p1 address.Address, p2 *piece.GetDealSpec) ([]*piece.DealInfoIncludePath, error) {
+	return s.Internal.AssignUnPackedDeals(p0, p1, p2)
 }
 
-func (s *MarketFullNodeStub) AssignUnPackedDeals(p0 *piece.GetDealSpec) ([]*piece.DealInfoIncludePath, error) {
+func (s *MarketFullNodeStub) AssignUnPackedDeals(p0 context.Context, p1 address.Address, p2 *piece.GetDealSpec) ([]*piece.DealInfoIncludePath, error) {
 	return *new([]*piece.DealInfoIncludePath), xerrors.New("method not supported")
 }
 
@@ -526,6 +598,14 @@ func (s *MarketFullNodeStub) DagstoreGC(p0 context.Context) ([]types.DagstoreSha
 	return *new([]types.DagstoreShardResult), xerrors.New("method not supported")
 }
 
+func (s *MarketFullNodeStruct) DagstoreHealth(p0 context.Context) ([]types.DagstoreShardHealth, error) {
+	return s.Internal.DagstoreHealth(p0)
+}
+
+func (s *MarketFullNodeStub) DagstoreHealth(p0 context.Context) ([]types.DagstoreShardHealth, error) {
+	return *new([]types.DagstoreShardHealth), xerrors.New("method not supported")
+}
+
 func (s *MarketFullNodeStruct) DagstoreInitializeAll(p0 context.Context, p1 types.DagstoreInitializeAllParams) (<-chan types.DagstoreInitializeAllEvent, error) {
 	return s.Internal.DagstoreInitializeAll(p0, p1)
 }
@@ -558,6 +638,22 @@ func (s *MarketFullNodeStub) DagstoreRecoverShard(p0 context.Context, p1 string)
 	return xerrors.New("method not supported")
 }
 
+func (s *MarketFullNodeStruct) DealsBatchImportData(p0 context.Context, p1 []types.ImportDataRef, p2 int) ([]types.ImportDataResult, error) {
+	return s.Internal.DealsBatchImportData(p0, p1, p2)
+}
+
+func (s *MarketFullNodeStub) DealsBatchImportData(p0 context.Context, p1 []types.ImportDataRef, p2 int) ([]types.ImportDataResult, error) {
+	return *new([]types.ImportDataResult), xerrors.New("method not supported")
+}
+
+func (s *MarketFullNodeStruct) DealsClientUsages(p0 context.Context) ([]quota.ClientUsage, error) {
+	return s.Internal.DealsClientUsages(p0)
+}
+
+func (s *MarketFullNodeStub) DealsClientUsages(p0 context.Context) ([]quota.ClientUsage, error) {
+	return *new([]quota.ClientUsage), xerrors.New("method not supported")
+}
+
 func (s *MarketFullNodeStruct) DealsConsiderOfflineRetrievalDeals(p0 context.Context) (bool, error) {
 	return s.Internal.DealsConsiderOfflineRetrievalDeals(p0)
 }
@@ -630,6 +726,38 @@ func (s *MarketFullNodeStub) DealsPieceCidBlocklist(p0 context.Context) ([]cid.C
 	return *new([]cid.Cid), xerrors.New("method not supported")
 }
 
+func (s *MarketFullNodeStruct) DealsReloadRetrievalDealRules(p0 context.Context) error {
+	return s.Internal.DealsReloadRetrievalDealRules(p0)
+}
+
+func (s *MarketFullNodeStub) DealsReloadRetrievalDealRules(p0 context.Context) error {
+	return xerrors.New("method not supported")
+}
+
+func (s *MarketFullNodeStruct) DealsReloadStorageDealRules(p0 context.Context) error {
+	return s.Internal.DealsReloadStorageDealRules(p0)
+}
+
+func (s *MarketFullNodeStub) DealsReloadStorageDealRules(p0 context.Context) error {
+	return xerrors.New("method not supported")
+}
+
+func (s *MarketFullNodeStruct) DealsResetClientUsage(p0 context.Context, p1 string) error {
+	return s.Internal.DealsResetClientUsage(p0, p1)
+}
+
+func (s *MarketFullNodeStub) DealsResetClientUsage(p0 context.Context, p1 string) error {
+	return xerrors.New("method not supported")
+}
+
+func (s *MarketFullNodeStruct) DealsRetrievalDealRules(p0 context.Context) (*retrievaladapter.RetrievalDealRules, error) {
+	return s.Internal.DealsRetrievalDealRules(p0)
+}
+
+func (s *MarketFullNodeStub) DealsRetrievalDealRules(p0 context.Context) (*retrievaladapter.RetrievalDealRules, error) {
+	return nil, xerrors.New("method not supported")
+}
+
 func (s *MarketFullNodeStruct) DealsSetConsiderOfflineRetrievalDeals(p0 context.Context, p1 bool) error {
 	return s.Internal.DealsSetConsiderOfflineRetrievalDeals(p0, p1)
 }
@@ -686,6 +814,14 @@ func (s *MarketFullNodeStub) DealsSetPieceCidBlocklist(p0 context.Context, p1 []
 	return xerrors.New("method not supported")
 }
 
+func (s *MarketFullNodeStruct) DealsStorageDealRules(p0 context.Context) (*dealfilter.StorageDealRules, error) {
+	return s.Internal.DealsStorageDealRules(p0)
+}
+
+func (s *MarketFullNodeStub) DealsStorageDealRules(p0 context.Context) (*dealfilter.StorageDealRules, error) {
+	return nil, xerrors.New("method not supported")
+}
+
 func (s *MarketFullNodeStruct) GetDeals(p0 context.Context, p1 address.Address, p2 int, p3 int) ([]*piece.DealInfo, error) {
 	return s.Internal.GetDeals(p0, p1, p2, p3)
 }
@@ -718,11 +854,11 @@ func (s *MarketFullNodeStub) ListenMarketEvent(p0 context.Context, p1 *marketeve
 	return nil, xerrors.New("method not supported")
 }
 
-func (s *MarketFullNodeStruct) MarkDealsAsPacking(p0 context.Context, p1 address.Address, p2 []abi.DealID) error {
-	return s.Internal.MarkDealsAsPacking(p0, p1, p2)
+func (s *MarketFullNodeStruct) MarkDealsAsPacking(p0 context.Context, p1 address.Address, p2 []abi.DealID, p3 string) error {
+	return s.Internal.MarkDealsAsPacking(p0, p1, p2, p3)
 }
 
-func (s *MarketFullNodeStub) MarkDealsAsPacking(p0 context.Context, p1 address.Address, p2 []abi.DealID) error {
+func (s *MarketFullNodeStub) MarkDealsAsPacking(p0 context.Context, p1 address.Address, p2 []abi.DealID, p3 string) error {
 	return xerrors.New("method not supported")
 }
 
@@ -750,11 +886,19 @@ func (s *MarketFullNodeStub) MarketDataTransferUpdates(p0 context.Context) (<-ch
 	return nil, xerrors.New("method not supported")
 }
 
-func (s *MarketFullNodeStruct) MarketGetAsk(p0 context.Context) (*storagemarket.SignedStorageAsk, error) {
-	return s.Internal.MarketGetAsk(p0)
+func (s *MarketFullNodeStruct) MarketFundHistory(p0 context.Context, p1 address.Address) ([]*fundmgr.FundOperation, error) {
+	return s.Internal.MarketFundHistory(p0, p1)
+}
+
+func (s *MarketFullNodeStub) MarketFundHistory(p0 context.Context, p1 address.Address) ([]*fundmgr.FundOperation, error) {
+	return *new([]*fundmgr.FundOperation), xerrors.New("method not supported")
+}
+
+func (s *MarketFullNodeStruct) MarketGetAsk(p0 context.Context, p1 address.Address) (*storagemarket.SignedStorageAsk, error) {
+	return s.Internal.MarketGetAsk(p0, p1)
 }
 
-func (s *MarketFullNodeStub) MarketGetAsk(p0 context.Context) (*storagemarket.SignedStorageAsk, error) {
+func (s *MarketFullNodeStub) MarketGetAsk(p0 context.Context, p1 address.Address) (*storagemarket.SignedStorageAsk, error) {
 	return nil, xerrors.New("method not supported")
 }
 
@@ -774,11 +918,11 @@ func (s *MarketFullNodeStub) MarketGetReserved(p0 context.Context, p1 address.Ad
 	return *new(vTypes.BigInt), xerrors.New("method not supported")
 }
 
-func (s *MarketFullNodeStruct) MarketGetRetrievalAsk(p0 context.Context) (*retrievalmarket.Ask, error) {
-	return s.Internal.MarketGetRetrievalAsk(p0)
+func (s *MarketFullNodeStruct) MarketGetRetrievalAsk(p0 context.Context, p1 address.Address) (*retrievalmarket.Ask, error) {
+	return s.Internal.MarketGetRetrievalAsk(p0, p1)
 }
 
-func (s *MarketFullNodeStub) MarketGetRetrievalAsk(p0 context.Context) (*retrievalmarket.Ask, error) {
+func (s *MarketFullNodeStub) MarketGetRetrievalAsk(p0 context.Context, p1 address.Address) (*retrievalmarket.Ask, error) {
 	return nil, xerrors.New("method not supported")
 }
 
@@ -806,6 +950,14 @@ func (s *MarketFullNodeStub) MarketListDeals(p0 context.Context) ([]types.Market
 	return *new([]types.MarketDeal), xerrors.New("method not supported")
 }
 
+func (s *MarketFullNodeStruct) MarketListFunds(p0 context.Context) ([]fundmgr.FundedAddressInfo, error) {
+	return s.Internal.MarketListFunds(p0)
+}
+
+func (s *MarketFullNodeStub) MarketListFunds(p0 context.Context) ([]fundmgr.FundedAddressInfo, error) {
+	return *new([]fundmgr.FundedAddressInfo), xerrors.New("method not supported")
+}
+
 func (s *MarketFullNodeStruct) MarketListIncompleteDeals(p0 context.Context) ([]storagemarket.MinerDeal, error) {
 	return s.Internal.MarketListIncompleteDeals(p0)
 }
@@ -846,6 +998,14 @@ func (s *MarketFullNodeStub) MarketReleaseFunds(p0 context.Context, p1 address.A
 	return xerrors.New("method not supported")
 }
 
+func (s *MarketFullNodeStruct) MarketReloadRetrievalPricingRules(p0 context.Context) error {
+	return s.Internal.MarketReloadRetrievalPricingRules(p0)
+}
+
+func (s *MarketFullNodeStub) MarketReloadRetrievalPricingRules(p0 context.Context) error {
+	return xerrors.New("method not supported")
+}
+
 func (s *MarketFullNodeStruct) MarketReserveFunds(p0 context.Context, p1 address.Address, p2 address.Address, p3 vTypes.BigInt) (cid.Cid, error) {
 	return s.Internal.MarketReserveFunds(p0, p1, p2, p3)
 }
@@ -862,19 +1022,27 @@ func (s *MarketFullNodeStub) MarketRestartDataTransfer(p0 context.Context, p1 da
 	return xerrors.New("method not supported")
 }
 
-func (s *MarketFullNodeStruct) MarketSetAsk(p0 context.Context, p1 vTypes.BigInt, p2 vTypes.BigInt, p3 abi.ChainEpoch, p4 abi.PaddedPieceSize, p5 abi.PaddedPieceSize) error {
-	return s.Internal.MarketSetAsk(p0, p1, p2, p3, p4, p5)
+func (s *MarketFullNodeStruct) MarketRetrievalPricingRules(p0 context.Context) (*retrievaladapter.DynamicPricingRules, error) {
+	return s.Internal.MarketRetrievalPricingRules(p0)
+}
+
+func (s *MarketFullNodeStub) MarketRetrievalPricingRules(p0 context.Context) (*retrievaladapter.DynamicPricingRules, error) {
+	return nil, xerrors.New("method not supported")
 }
 
-func (s *MarketFullNodeStub) MarketSetAsk(p0 context.Context, p1 vTypes.BigInt, p2 vTypes.BigInt, p3 abi.ChainEpoch, p4 abi.PaddedPieceSize, p5 abi.PaddedPieceSize) error {
+func (s *MarketFullNodeStruct) MarketSetAsk(p0 context.Context, p1 address.Address, p2 vTypes.BigInt, p3 vTypes.BigInt, p4 abi.ChainEpoch, p5 abi.PaddedPieceSize, p6 abi.PaddedPieceSize) error {
+	return s.Internal.MarketSetAsk(p0, p1, p2, p3, p4, p5, p6)
+}
+
+func (s *MarketFullNodeStub) MarketSetAsk(p0 context.Context, p1 address.Address, p2 vTypes.BigInt, p3 vTypes.BigInt, p4 abi.ChainEpoch, p5 abi.PaddedPieceSize, p6 abi.PaddedPieceSize) error {
 	return xerrors.New("method not supported")
 }
 
-func (s *MarketFullNodeStruct) MarketSetRetrievalAsk(p0 context.Context, p1 *retrievalmarket.Ask) error {
-	return s.Internal.MarketSetRetrievalAsk(p0, p1)
+func (s *MarketFullNodeStruct) MarketSetRetrievalAsk(p0 context.Context, p1 address.Address, p2 *retrievalmarket.Ask) error {
+	return s.Internal.MarketSetRetrievalAsk(p0, p1, p2)
 }
 
-func (s *MarketFullNodeStub) MarketSetRetrievalAsk(p0 context.Context, p1 *retrievalmarket.Ask) error {
+func (s *MarketFullNodeStub) MarketSetRetrievalAsk(p0 context.Context, p1 address.Address, p2 *retrievalmarket.Ask) error {
 	return xerrors.New("method not supported")
 }
 
@@ -918,6 +1086,22 @@ func (s *MarketFullNodeStub) NetAddrsListen(p0 context.Context) (peer.AddrInfo,
 	return *new(peer.AddrInfo), xerrors.New("method not supported")
 }
 
+func (s *MarketFullNodeStruct) PiecesDropCidInfos(p0 context.Context) (int, error) {
+	return s.Internal.PiecesDropCidInfos(p0)
+}
+
+func (s *MarketFullNodeStub) PiecesDropCidInfos(p0 context.Context) (int, error) {
+	return 0, xerrors.New("method not supported")
+}
+
+func (s *MarketFullNodeStruct) PiecesGC(p0 context.Context, p1 bool) ([]piece.PieceGCResult, error) {
+	return s.Internal.PiecesGC(p0, p1)
+}
+
+func (s *MarketFullNodeStub) PiecesGC(p0 context.Context, p1 bool) ([]piece.PieceGCResult, error) {
+	return *new([]piece.PieceGCResult), xerrors.New("method not supported")
+}
+
 func (s *MarketFullNodeStruct) PiecesGetCIDInfo(p0 context.Context, p1 cid.Cid) (*piecestore.CIDInfo, error) {
 	return s.Internal.PiecesGetCIDInfo(p0, p1)
 }
@@ -934,6 +1118,30 @@ func (s *MarketFullNodeStub) PiecesGetPieceInfo(p0 context.Context, p1 cid.Cid)
 	return nil, xerrors.New("method not supported")
 }
 
+func (s *MarketFullNodeStruct) PiecesIndexerAnnounce(p0 context.Context, p1 cid.Cid) (cid.Cid, error) {
+	return s.Internal.PiecesIndexerAnnounce(p0, p1)
+}
+
+func (s *MarketFullNodeStub) PiecesIndexerAnnounce(p0 context.Context, p1 cid.Cid) (cid.Cid, error) {
+	return *new(cid.Cid), xerrors.New("method not supported")
+}
+
+func (s *MarketFullNodeStruct) PiecesIndexerHead(p0 context.Context) (cid.Cid, error) {
+	return s.Internal.PiecesIndexerHead(p0)
+}
+
+func (s *MarketFullNodeStub) PiecesIndexerHead(p0 context.Context) (cid.Cid, error) {
+	return *new(cid.Cid), xerrors.New("method not supported")
+}
+
+func (s *MarketFullNodeStruct) PiecesIndexerRemove(p0 context.Context, p1 cid.Cid) (cid.Cid, error) {
+	return s.Internal.PiecesIndexerRemove(p0, p1)
+}
+
+func (s *MarketFullNodeStub) PiecesIndexerRemove(p0 context.Context, p1 cid.Cid) (cid.Cid, error) {
+	return *new(cid.Cid), xerrors.New("method not supported")
+}
+
 func (s *MarketFullNodeStruct) PiecesListCidInfos(p0 context.Context) ([]cid.Cid, error) {
 	return s.Internal.PiecesListCidInfos(p0)
 }
@@ -950,6 +1158,22 @@ func (s *MarketFullNodeStub) PiecesListPieces(p0 context.Context) ([]cid.Cid, er
 	return *new([]cid.Cid), xerrors.New("method not supported")
 }
 
+func (s *MarketFullNodeStruct) PiecesListUnsealJobs(p0 context.Context) ([]types.UnsealJob, error) {
+	return s.Internal.PiecesListUnsealJobs(p0)
+}
+
+func (s *MarketFullNodeStub) PiecesListUnsealJobs(p0 context.Context) ([]types.UnsealJob, error) {
+	return *new([]types.UnsealJob), xerrors.New("method not supported")
+}
+
+func (s *MarketFullNodeStruct) PiecesStorageUsage(p0 context.Context) ([]piece.PieceStorageUsage, error) {
+	return s.Internal.PiecesStorageUsage(p0)
+}
+
+func (s *MarketFullNodeStub) PiecesStorageUsage(p0 context.Context) ([]piece.PieceStorageUsage, error) {
+	return *new([]piece.PieceStorageUsage), xerrors.New("method not supported")
+}
+
 func (s *MarketFullNodeStruct) ResponseMarketEvent(p0 context.Context, p1 *types2.ResponseEvent) error {
 	return s.Internal.ResponseMarketEvent(p0, p1)
 }
@@ -974,11 +1198,11 @@ func (s *MarketFullNodeStub) SectorSetExpectedSealDuration(p0 context.Context, p
 	return xerrors.New("method not supported")
 }
 
-func (s *MarketFullNodeStruct) UpdateDealOnPacking(p0 context.Context, p1 address.Address, p2 cid.Cid, p3 abi.DealID, p4 abi.SectorNumber, p5 abi.PaddedPieceSize) error {
-	return s.Internal.UpdateDealOnPacking(p0, p1, p2, p3, p4, p5)
+func (s *MarketFullNodeStruct) UpdateDealOnPacking(p0 context.Context, p1 address.Address, p2 cid.Cid, p3 abi.DealID, p4 abi.SectorNumber, p5 abi.PaddedPieceSize, p6 string) error {
+	return s.Internal.UpdateDealOnPacking(p0, p1, p2, p3, p4, p5, p6)
 }
 
-func (s *MarketFullNodeStub) UpdateDealOnPacking(p0 context.Context, p1 address.Address, p2 cid.Cid, p3 abi.DealID, p4 abi.SectorNumber, p5 abi.PaddedPieceSize) error {
+func (s *MarketFullNodeStub) UpdateDealOnPacking(p0 context.Context, p1 address.Address, p2 cid.Cid, p3 abi.DealID, p4 abi.SectorNumber, p5 abi.PaddedPieceSize, p6 string) error {
 	return xerrors.New("method not supported")
 }
 
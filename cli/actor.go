@@ -17,16 +17,39 @@ var ActorCmd = &cli.Command{
 	Name:  "actor",
 	Usage: "manipulate the miner actor",
 	Subcommands: []*cli.Command{
+		actorListCmd,
 		actorSetAddrsCmd,
 		actorSetPeeridCmd,
 		actorInfoCmd,
 	},
 }
 
+var actorListCmd = &cli.Command{
+	Name:  "list",
+	Usage: "list the miners served by the market",
+	Action: func(cctx *cli.Context) error {
+		nodeAPI, closer, err := NewMarketNode(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		miners, err := nodeAPI.ActorList(ReqContext(cctx))
+		if err != nil {
+			return err
+		}
+		for _, mAddr := range miners {
+			fmt.Println(mAddr.String())
+		}
+		return nil
+	},
+}
+
 var actorSetAddrsCmd = &cli.Command{
 	Name:  "set-addrs",
 	Usage: "set addresses that your miner can be publicly dialed on",
 	Flags: []cli.Flag{
+		MinerFlag,
 		&cli.Int64Flag{
 			Name:  "gas-limit",
 			Usage: "set gas limit",
@@ -79,7 +102,7 @@ var actorSetAddrsCmd = &cli.Command{
 			addrs = append(addrs, maddrNop2p.Bytes())
 		}
 
-		maddr, err := nodeAPI.ActorAddress(ctx)
+		maddr, err := GetMinerAddress(ctx, cctx, nodeAPI)
 		if err != nil {
 			return err
 		}
@@ -118,6 +141,7 @@ var actorSetPeeridCmd = &cli.Command{
 	Name:  "set-peer-id",
 	Usage: "set the peer id of your miner",
 	Flags: []cli.Flag{
+		MinerFlag,
 		&cli.Int64Flag{
 			Name:  "gas-limit",
 			Usage: "set gas limit",
@@ -144,7 +168,7 @@ var actorSetPeeridCmd = &cli.Command{
 			return fmt.Errorf("failed to parse input as a peerId: %w", err)
 		}
 
-		maddr, err := nodeAPI.ActorAddress(ctx)
+		maddr, err := GetMinerAddress(ctx, cctx, nodeAPI)
 		if err != nil {
 			return err
 		}
@@ -182,7 +206,9 @@ var actorSetPeeridCmd = &cli.Command{
 var actorInfoCmd = &cli.Command{
 	Name:  "info",
 	Usage: "query info of your miner",
-	Flags: []cli.Flag{},
+	Flags: []cli.Flag{
+		MinerFlag,
+	},
 	Action: func(cctx *cli.Context) error {
 		nodeAPI, closer, err := NewMarketNode(cctx)
 		if err != nil {
@@ -198,7 +224,7 @@ var actorInfoCmd = &cli.Command{
 
 		ctx := ReqContext(cctx)
 
-		maddr, err := nodeAPI.ActorAddress(ctx)
+		maddr, err := GetMinerAddress(ctx, cctx, nodeAPI)
 		if err != nil {
 			return err
 		}
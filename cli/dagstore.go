@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/filecoin-project/venus-market/types"
 	"os"
+	"time"
 
 	"github.com/fatih/color"
 	"github.com/urfave/cli/v2"
@@ -20,6 +21,7 @@ var DagstoreCmd = &cli.Command{
 		dagstoreRecoverShardCmd,
 		dagstoreInitializeAllCmd,
 		dagstoreGcCmd,
+		dagstoreHealthCmd,
 	},
 }
 
@@ -259,3 +261,59 @@ var dagstoreGcCmd = &cli.Command{
 		return nil
 	},
 }
+
+var dagstoreHealthCmd = &cli.Command{
+	Name:  "health",
+	Usage: "List the shards which stay broken after the automatic repairs",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:        "color",
+			Usage:       "use color in display output",
+			DefaultText: "depends on output being a TTY",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		if cctx.IsSet("color") {
+			color.NoColor = !cctx.Bool("color")
+		}
+
+		marketsApi, closer, err := NewMarketNode(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+		ctx := ReqContext(cctx)
+
+		shards, err := marketsApi.DagstoreHealth(ctx)
+		if err != nil {
+			return err
+		}
+
+		if len(shards) == 0 {
+			_, _ = fmt.Fprintln(os.Stdout, "all shards are healthy")
+			return nil
+		}
+
+		tw := tablewriter.New(
+			tablewriter.Col("Key"),
+			tablewriter.Col("State"),
+			tablewriter.Col("Attempts"),
+			tablewriter.Col("Since"),
+			tablewriter.Col("NextAttempt"),
+			tablewriter.NewLineCol("LastError"),
+		)
+
+		for _, s := range shards {
+			tw.Write(map[string]interface{}{
+				"Key":         s.Key,
+				"State":       color.New(color.FgRed).Sprint(s.State),
+				"Attempts":    s.Attempts,
+				"Since":       s.FirstFailure.Format(time.Stamp),
+				"NextAttempt": s.NextAttempt.Format(time.Stamp),
+				"LastError":   s.LastError,
+			})
+		}
+
+		return tw.Flush(os.Stdout)
+	},
+}
@@ -0,0 +1,131 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/venus/pkg/types"
+)
+
+var FundsCmd = &cli.Command{
+	Name:  "funds",
+	Usage: "Inspect the market funds managed by the fund manager",
+	Subcommands: []*cli.Command{
+		fundsListCmd,
+		fundsHistoryCmd,
+	},
+}
+
+var fundsListCmd = &cli.Command{
+	Name:  "list",
+	Usage: "List the addresses with their balances, queued requests and message in flight",
+	Action: func(cctx *cli.Context) error {
+		api, closer, err := NewMarketNode(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		infos, err := api.MarketListFunds(DaemonContext(cctx))
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+		_, _ = fmt.Fprintf(w, "Address\tEscrow\tLocked\tReserved\tAvailable\tIn Flight\tQueued\n")
+		for _, info := range infos {
+			inFlight := "-"
+			if info.MsgCid != nil {
+				inFlight = info.MsgCid.String()
+			}
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%d\n", info.Addr, types.FIL(info.Escrow), types.FIL(info.Locked),
+				types.FIL(info.Reserved), types.FIL(info.Available), inFlight, len(info.Queued))
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		var queued bool
+		for _, info := range infos {
+			if len(info.Queued) > 0 {
+				queued = true
+				break
+			}
+		}
+		if !queued {
+			return nil
+		}
+
+		fmt.Println("\nQueued requests:")
+		w = tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+		_, _ = fmt.Fprintf(w, "Address\tKind\tWallet\tAmount\n")
+		for _, info := range infos {
+			for _, req := range info.Queued {
+				wallet := "-"
+				if req.Wallet != address.Undef {
+					wallet = req.Wallet.String()
+				}
+				_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", info.Addr, req.Kind, wallet, types.FIL(req.Amount))
+			}
+		}
+		return w.Flush()
+	},
+}
+
+var fundsHistoryCmd = &cli.Command{
+	Name:  "history",
+	Usage: "List the AddBalance and WithdrawBalance messages sent by the fund manager",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "address",
+			Usage: "only list the messages of this address",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		addr := address.Undef
+		if cctx.IsSet("address") {
+			var err error
+			addr, err = address.NewFromString(cctx.String("address"))
+			if err != nil {
+				return err
+			}
+		}
+
+		api, closer, err := NewMarketNode(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		ops, err := api.MarketFundHistory(DaemonContext(cctx), addr)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+		_, _ = fmt.Fprintf(w, "Sent\tAddress\tKind\tWallet\tAmount\tMessage\tStatus\n")
+		for _, op := range ops {
+			sent, amount := "-", "-"
+			if !op.Sent.IsZero() {
+				sent = op.Sent.Format(time.RFC3339)
+			}
+			if !op.Amount.Nil() {
+				amount = types.FIL(op.Amount).String()
+			}
+			status := "pending"
+			switch {
+			case op.Error != "":
+				status = "failed: " + op.Error
+			case !op.Done.IsZero():
+				status = "done at " + op.Done.Format(time.RFC3339)
+			}
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", sent, op.Addr, op.Kind, op.Wallet, amount, op.MsgCid, status)
+		}
+		return w.Flush()
+	},
+}
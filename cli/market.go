@@ -2,19 +2,28 @@ package cli
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/filecoin-project/venus/pkg/constants"
 	"io"
+	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
+	"strings"
 	"text/tabwriter"
 	"time"
 
+	"github.com/BurntSushi/toml"
 	tm "github.com/buger/goterm"
 	"github.com/docker/go-units"
 	"github.com/ipfs/go-cid"
@@ -27,6 +36,9 @@ import (
 	"github.com/filecoin-project/go-fil-markets/storagemarket"
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/venus/pkg/types"
+
+	"github.com/filecoin-project/venus-market/storageadapter"
+	types2 "github.com/filecoin-project/venus-market/types"
 )
 
 var storageDealSelectionCmd = &cli.Command{
@@ -160,6 +172,7 @@ var setAskCmd = &cli.Command{
 	Name:  "set-ask",
 	Usage: "Configure the miner's ask",
 	Flags: []cli.Flag{
+		MinerFlag,
 		&cli.StringFlag{
 			Name:     "price",
 			Usage:    "Set the price of the ask for unverified deals (specified as FIL / GiB / Epoch) to `PRICE`.",
@@ -222,7 +235,7 @@ var setAskCmd = &cli.Command{
 			return xerrors.Errorf("cannot parse max-piece-size to quantity of bytes: %w", err)
 		}
 
-		maddr, err := api.ActorAddress(ctx)
+		maddr, err := GetMinerAddress(ctx, cctx, api)
 		if err != nil {
 			return err
 		}
@@ -242,14 +255,16 @@ var setAskCmd = &cli.Command{
 			return xerrors.Errorf("max piece size (w/bit-padding) %s cannot exceed miner sector size %s", types.SizeStr(types.NewInt(uint64(max))), types.SizeStr(types.NewInt(uint64(smax))))
 		}
 
-		return api.MarketSetAsk(ctx, types.BigInt(pri), types.BigInt(vpri), abi.ChainEpoch(qty), abi.PaddedPieceSize(min), abi.PaddedPieceSize(max))
+		return api.MarketSetAsk(ctx, maddr, types.BigInt(pri), types.BigInt(vpri), abi.ChainEpoch(qty), abi.PaddedPieceSize(min), abi.PaddedPieceSize(max))
 	},
 }
 
 var getAskCmd = &cli.Command{
 	Name:  "get-ask",
 	Usage: "Print the miner's ask",
-	Flags: []cli.Flag{},
+	Flags: []cli.Flag{
+		MinerFlag,
+	},
 	Action: func(cctx *cli.Context) error {
 		ctx := DaemonContext(cctx)
 
@@ -265,7 +280,12 @@ var getAskCmd = &cli.Command{
 		}
 		defer closer()
 
-		sask, err := smapi.MarketGetAsk(ctx)
+		maddr, err := GetMinerAddress(ctx, cctx, smapi)
+		if err != nil {
+			return err
+		}
+
+		sask, err := smapi.MarketGetAsk(ctx, maddr)
 		if err != nil {
 			return err
 		}
@@ -304,6 +324,7 @@ var StorageDealsCmd = &cli.Command{
 	Usage: "Manage storage deals and related configuration",
 	Subcommands: []*cli.Command{
 		dealsImportDataCmd,
+		dealsBatchImportDataCmd,
 		dealsListCmd,
 		storageDealSelectionCmd,
 		setAskCmd,
@@ -313,6 +334,8 @@ var StorageDealsCmd = &cli.Command{
 		resetBlocklistCmd,
 		setSealDurationCmd,
 		dealsPendingPublish,
+		dealRulesCmd,
+		dealQuotaCmd,
 	},
 }
 
@@ -320,6 +343,17 @@ var dealsImportDataCmd = &cli.Command{
 	Name:      "import-data",
 	Usage:     "Manually import data for a deal",
 	ArgsUsage: "<proposal CID> <file>",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "upload",
+			Usage: "upload the local file to the market by chunks instead of reading it on the market host, an interrupted upload resumes where it stopped",
+		},
+		&cli.StringFlag{
+			Name:  "chunk-size",
+			Usage: "size of the uploaded chunks",
+			Value: "64MiB",
+		},
+	},
 	Action: func(cctx *cli.Context) error {
 		api, closer, err := NewMarketNode(cctx)
 		if err != nil {
@@ -340,11 +374,408 @@ var dealsImportDataCmd = &cli.Command{
 
 		fpath := cctx.Args().Get(1)
 
+		if cctx.Bool("upload") {
+			chunkSize, err := units.RAMInBytes(cctx.String("chunk-size"))
+			if err != nil {
+				return xerrors.Errorf("parse chunk size: %w", err)
+			}
+			if chunkSize <= 0 {
+				return xerrors.Errorf("chunk size must be positive")
+			}
+			return uploadDealData(ctx, cctx, propCid, fpath, chunkSize)
+		}
+
 		return api.DealsImportData(ctx, propCid, fpath)
 
 	},
 }
 
+func uploadDealData(ctx context.Context, cctx *cli.Context, propCid cid.Cid, fpath string, chunkSize int64) error {
+	endpoint, header, err := NewMarketHTTPEndpoint(cctx, storageadapter.DealUploadPath+propCid.String())
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(fpath)
+	if err != nil {
+		return xerrors.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close() //nolint:errcheck
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	size := fi.Size()
+	if size == 0 {
+		return xerrors.Errorf("file %s is empty", fpath)
+	}
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return xerrors.Errorf("hash file: %w", err)
+	}
+	checksum := hex.EncodeToString(hasher.Sum(nil))
+
+	status, err := dealUploadRequest(ctx, http.MethodGet, endpoint, header, nil)
+	if err != nil {
+		return err
+	}
+	if status.Size != 0 && status.Size != size {
+		fmt.Printf("discard the upload in progress of a %d bytes file\n", status.Size)
+		if _, err := dealUploadRequest(ctx, http.MethodDelete, endpoint, header, nil); err != nil {
+			return err
+		}
+		status.Received = 0
+	}
+	if status.Received > 0 {
+		fmt.Printf("resume upload from %s\n", units.BytesSize(float64(status.Received)))
+	}
+
+	buf := make([]byte, chunkSize)
+	for offset := status.Received; offset < size; {
+		chunk := buf[:chunkSize]
+		if size-offset < chunkSize {
+			chunk = buf[:size-offset]
+		}
+		if _, err := f.ReadAt(chunk, offset); err != nil {
+			return xerrors.Errorf("read file at %d: %w", offset, err)
+		}
+		chunkSum := sha256.Sum256(chunk)
+
+		chunkHeader := header.Clone()
+		chunkHeader.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+int64(len(chunk))-1, size))
+		chunkHeader.Set(storageadapter.ChunkSha256Header, hex.EncodeToString(chunkSum[:]))
+		status, err = dealUploadRequest(ctx, http.MethodPut, endpoint, chunkHeader, bytes.NewReader(chunk))
+		if err != nil && (status == nil || status.Received == offset) {
+			return err
+		}
+		// the market may have more or less than expected, go on from what it has
+		offset = status.Received
+		fmt.Printf("\ruploaded %s / %s", units.BytesSize(float64(offset)), units.BytesSize(float64(size)))
+	}
+	fmt.Println()
+
+	if _, err := dealUploadRequest(ctx, http.MethodPost, endpoint+"?sha256="+checksum, header, nil); err != nil {
+		return xerrors.Errorf("import uploaded data: %w", err)
+	}
+	fmt.Println("data imported")
+	return nil
+}
+
+// dealUploadRequest sends a request to the deal upload endpoint, the status of the upload is returned
+// along with the error when the market replies it
+func dealUploadRequest(ctx context.Context, method, endpoint string, header http.Header, body io.Reader) (*storageadapter.DealUploadStatus, error) {
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = header
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	if resp.Header.Get("Content-Type") != "application/json" {
+		if resp.StatusCode != http.StatusOK {
+			msg, _ := ioutil.ReadAll(resp.Body)
+			return nil, xerrors.Errorf("%s %s: %s", method, resp.Status, strings.TrimSpace(string(msg)))
+		}
+		return nil, nil
+	}
+
+	var status storageadapter.DealUploadStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, xerrors.Errorf("decode upload status: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &status, xerrors.Errorf("%s %s: %s", method, resp.Status, resp.Header.Get("X-Upload-Error"))
+	}
+	return &status, nil
+}
+
+var dealsBatchImportDataCmd = &cli.Command{
+	Name:  "batch-import-data",
+	Usage: "Import data for many offline deals listed in a manifest",
+	Description: `The manifest is either a csv file of <proposal or piece CID>,<file> rows, or a json array of
+{"ProposalCid": "<CID>", "PieceCid": "<CID>", "File": "<file>"} objects. A piece CID imports the file
+for every offline deal of the piece waiting for data. Files are read by the market, relative paths
+are resolved against the directory of the manifest.`,
+	ArgsUsage: "<manifest>",
+	Flags: []cli.Flag{
+		&cli.IntFlag{
+			Name:  "parallel",
+			Usage: "number of deals imported at the same time",
+			Value: 4,
+		},
+		&cli.StringFlag{
+			Name:  "report",
+			Usage: "file to write the result of each row to, json if it ends with .json, csv otherwise (default: <manifest>.report.csv)",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		api, closer, err := NewMarketNode(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		ctx := DaemonContext(cctx)
+
+		if cctx.Args().Len() != 1 {
+			return fmt.Errorf("must specify the manifest")
+		}
+		manifest := cctx.Args().First()
+
+		refs, err := loadImportManifest(manifest)
+		if err != nil {
+			return xerrors.Errorf("load manifest: %w", err)
+		}
+
+		// the results of a cancelled batch come with the error, the report tells which rows are left
+		results, importErr := api.DealsBatchImportData(ctx, refs, cctx.Int("parallel"))
+		if importErr != nil && len(results) == 0 {
+			return importErr
+		}
+
+		report := cctx.String("report")
+		if len(report) == 0 {
+			report = manifest + ".report.csv"
+		}
+		if err := writeImportReport(report, results); err != nil {
+			return xerrors.Errorf("write report: %w", err)
+		}
+
+		count := make(map[types2.ImportDataStatus]int)
+		for _, res := range results {
+			count[res.Status]++
+		}
+		fmt.Printf("%d imported, %d skipped, %d failed, report written to %s\n",
+			count[types2.ImportDataImported], count[types2.ImportDataSkipped], count[types2.ImportDataFailed], report)
+		return importErr
+	},
+}
+
+type importManifestEntry struct {
+	ProposalCid string
+	PieceCid    string
+	File        string
+}
+
+func loadImportManifest(manifest string) ([]types2.ImportDataRef, error) {
+	data, err := ioutil.ReadFile(manifest)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := filepath.Abs(filepath.Dir(manifest))
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []importManifestEntry
+	if strings.EqualFold(filepath.Ext(manifest), ".json") {
+		if err := json.Unmarshal(data, &rows); err != nil {
+			return nil, err
+		}
+	} else {
+		records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		for i, record := range records {
+			if len(record) != 2 {
+				return nil, xerrors.Errorf("row %d: expect 2 columns, got %d", i+1, len(record))
+			}
+			c, err := cid.Decode(strings.TrimSpace(record[0]))
+			if err != nil {
+				if i == 0 {
+					// header
+					continue
+				}
+				return nil, xerrors.Errorf("row %d: %w", i+1, err)
+			}
+			entry := importManifestEntry{File: strings.TrimSpace(record[1])}
+			if c.Prefix().Codec == cid.FilCommitmentUnsealed {
+				entry.PieceCid = c.String()
+			} else {
+				entry.ProposalCid = c.String()
+			}
+			rows = append(rows, entry)
+		}
+	}
+
+	refs := make([]types2.ImportDataRef, 0, len(rows))
+	for i, row := range rows {
+		var ref types2.ImportDataRef
+		if len(row.ProposalCid) > 0 {
+			c, err := cid.Decode(row.ProposalCid)
+			if err != nil {
+				return nil, xerrors.Errorf("entry %d: invalid proposal cid: %w", i+1, err)
+			}
+			ref.ProposalCid = &c
+		}
+		if len(row.PieceCid) > 0 {
+			c, err := cid.Decode(row.PieceCid)
+			if err != nil {
+				return nil, xerrors.Errorf("entry %d: invalid piece cid: %w", i+1, err)
+			}
+			ref.PieceCid = &c
+		}
+		if ref.ProposalCid == nil && ref.PieceCid == nil {
+			return nil, xerrors.Errorf("entry %d: neither proposal cid nor piece cid given", i+1)
+		}
+		if len(row.File) == 0 {
+			return nil, xerrors.Errorf("entry %d: no file given", i+1)
+		}
+		ref.File = row.File
+		if !filepath.IsAbs(ref.File) {
+			ref.File = filepath.Join(dir, ref.File)
+		}
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
+
+func writeImportReport(report string, results []types2.ImportDataResult) error {
+	f, err := os.Create(report)
+	if err != nil {
+		return err
+	}
+	defer f.Close() //nolint:errcheck
+
+	if strings.EqualFold(filepath.Ext(report), ".json") {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			return err
+		}
+		return f.Close()
+	}
+
+	w := csv.NewWriter(f)
+	if err := w.Write([]string{"proposal_cid", "piece_cid", "file", "status", "deals", "message"}); err != nil {
+		return err
+	}
+	for _, res := range results {
+		var propCid, pieceCid string
+		if res.ProposalCid != nil {
+			propCid = res.ProposalCid.String()
+		}
+		if res.PieceCid != nil {
+			pieceCid = res.PieceCid.String()
+		}
+		deals := make([]string, 0, len(res.Deals))
+		for _, deal := range res.Deals {
+			deals = append(deals, deal.String())
+		}
+		if err := w.Write([]string{propCid, pieceCid, res.File, string(res.Status), strings.Join(deals, " "), res.Message}); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+var dealRulesCmd = &cli.Command{
+	Name:  "rules",
+	Usage: "Manage the rules checked by storage deals",
+	Subcommands: []*cli.Command{
+		dealRulesShowCmd,
+		dealRulesReloadCmd,
+	},
+}
+
+var dealRulesShowCmd = &cli.Command{
+	Name:  "show",
+	Usage: "Show the rules in use",
+	Action: func(cctx *cli.Context) error {
+		api, closer, err := NewMarketNode(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		rules, err := api.DealsStorageDealRules(DaemonContext(cctx))
+		if err != nil {
+			return err
+		}
+		return toml.NewEncoder(os.Stdout).Encode(rules)
+	},
+}
+
+var dealRulesReloadCmd = &cli.Command{
+	Name:  "reload",
+	Usage: "Read the rule file again",
+	Action: func(cctx *cli.Context) error {
+		api, closer, err := NewMarketNode(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		return api.DealsReloadStorageDealRules(DaemonContext(cctx))
+	},
+}
+
+var dealQuotaCmd = &cli.Command{
+	Name:  "quota",
+	Usage: "Manage the quota counters of storage deal clients",
+	Subcommands: []*cli.Command{
+		dealQuotaListCmd,
+		dealQuotaResetCmd,
+	},
+}
+
+var dealQuotaListCmd = &cli.Command{
+	Name:  "list",
+	Usage: "List the quota counters of the clients",
+	Action: func(cctx *cli.Context) error {
+		api, closer, err := NewMarketNode(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		usages, err := api.DealsClientUsages(DaemonContext(cctx))
+		if err != nil {
+			return err
+		}
+		sort.Slice(usages, func(i, j int) bool {
+			return usages[i].Client < usages[j].Client
+		})
+
+		w := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+		_, _ = fmt.Fprintf(w, "Client\tAccepted\tIn Flight\tProposals\tWindow Start\n")
+		for _, usage := range usages {
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\n", usage.Client, units.BytesSize(float64(usage.AcceptedBytes)),
+				usage.DealsInFlight, usage.Proposals, usage.WindowStart.Format(time.RFC3339))
+		}
+		return w.Flush()
+	},
+}
+
+var dealQuotaResetCmd = &cli.Command{
+	Name:      "reset",
+	Usage:     "Clear the quota counters of a client",
+	ArgsUsage: "<client address or peer id>",
+	Action: func(cctx *cli.Context) error {
+		if cctx.Args().Len() != 1 {
+			return xerrors.Errorf("must specify the client")
+		}
+		api, closer, err := NewMarketNode(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		return api.DealsResetClientUsage(DaemonContext(cctx), cctx.Args().First())
+	},
+}
+
 var dealsListCmd = &cli.Command{
 	Name:  "list",
 	Usage: "List all deals for this miner",
@@ -845,10 +1276,31 @@ var dealsPendingPublish = &cli.Command{
 
 				_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n", proposalNd.Cid(), deal.Proposal.Client, units.BytesSize(float64(deal.Proposal.PieceSize)))
 			}
-			return w.Flush()
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		} else {
+			fmt.Println("No deals queued to be published")
 		}
 
-		fmt.Println("No deals queued to be published")
+		if len(pending.Decisions) > 0 {
+			fmt.Println()
+			w := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+			_, _ = fmt.Fprintf(w, "Latest publish decisions:\n")
+			_, _ = fmt.Fprintf(w, "Time\tHeight\tBase Fee\tDeals\tAction\tReason\n")
+			for _, decision := range pending.Decisions {
+				height, baseFee, action := "-", "-", "publish"
+				if decision.Height > 0 {
+					height, baseFee = fmt.Sprint(decision.Height), types.FIL(decision.BaseFee).String()
+				}
+				if !decision.Publish {
+					action = "hold"
+				}
+				_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n", decision.Time.Format(time.RFC3339), height, baseFee,
+					decision.Deals, action, decision.Reason)
+			}
+			return w.Flush()
+		}
 		return nil
 	},
 }
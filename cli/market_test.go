@@ -0,0 +1,116 @@
+package cli
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+
+	types2 "github.com/filecoin-project/venus-market/types"
+)
+
+const (
+	testProposalCid = "bafy2bzacea3wsdh6y3a36tb3skempjoxqpuyompjbmfeyf34fi3uy6uue42v4"
+	testPieceCid    = "baga6ea4seaqjtovkwk4myyzj56eztkh5pzsk5upksan6f5outesy62bsvl4dsha"
+)
+
+func TestLoadImportManifest(t *testing.T) {
+	dir := t.TempDir()
+	propCid, err := cid.Decode(testProposalCid)
+	require.NoError(t, err)
+	pieceCid, err := cid.Decode(testPieceCid)
+	require.NoError(t, err)
+	expect := []types2.ImportDataRef{
+		{ProposalCid: &propCid, File: filepath.Join(dir, "deal.car")},
+		{PieceCid: &pieceCid, File: "/data/piece.car"},
+	}
+
+	write := func(name, content string) string {
+		manifest := filepath.Join(dir, name)
+		require.NoError(t, ioutil.WriteFile(manifest, []byte(content), 0644))
+		return manifest
+	}
+
+	t.Run("csv", func(t *testing.T) {
+		manifest := write("manifest.csv", "cid,file\n"+
+			testProposalCid+", deal.car\n"+
+			testPieceCid+",/data/piece.car\n")
+		refs, err := loadImportManifest(manifest)
+		require.NoError(t, err)
+		require.Equal(t, expect, refs)
+	})
+
+	t.Run("json", func(t *testing.T) {
+		manifest := write("manifest.json", `[
+			{"ProposalCid": "`+testProposalCid+`", "File": "deal.car"},
+			{"PieceCid": "`+testPieceCid+`", "File": "/data/piece.car"}
+		]`)
+		refs, err := loadImportManifest(manifest)
+		require.NoError(t, err)
+		require.Equal(t, expect, refs)
+	})
+
+	for name, content := range map[string]string{
+		"bad.csv":      testProposalCid + ",deal.car\nnot a cid,piece.car\n",
+		"columns.csv":  testProposalCid + ",deal.car,extra\n",
+		"nofile.csv":   testProposalCid + ",\n",
+		"nocid.json":   `[{"File": "deal.car"}]`,
+		"badcid.json":  `[{"PieceCid": "not a cid", "File": "deal.car"}]`,
+		"invalid.json": `{"File": "deal.car"}`,
+	} {
+		_, err := loadImportManifest(write(name, content))
+		require.Error(t, err, name)
+	}
+}
+
+func TestWriteImportReport(t *testing.T) {
+	dir := t.TempDir()
+	propCid, err := cid.Decode(testProposalCid)
+	require.NoError(t, err)
+	pieceCid, err := cid.Decode(testPieceCid)
+	require.NoError(t, err)
+	results := []types2.ImportDataResult{
+		{
+			ImportDataRef: types2.ImportDataRef{PieceCid: &pieceCid, File: "/data/piece.car"},
+			Deals:         []cid.Cid{propCid, propCid},
+			Status:        types2.ImportDataImported,
+		},
+		{
+			ImportDataRef: types2.ImportDataRef{ProposalCid: &propCid, File: "/data/deal.car"},
+			Status:        types2.ImportDataSkipped,
+			Message:       "deal " + testProposalCid + " in state StorageDealSealing",
+		},
+	}
+
+	t.Run("csv", func(t *testing.T) {
+		report := filepath.Join(dir, "report.csv")
+		require.NoError(t, writeImportReport(report, results))
+
+		f, err := os.Open(report)
+		require.NoError(t, err)
+		defer f.Close() //nolint:errcheck
+		rows, err := csv.NewReader(f).ReadAll()
+		require.NoError(t, err)
+		require.Equal(t, [][]string{
+			{"proposal_cid", "piece_cid", "file", "status", "deals", "message"},
+			{"", testPieceCid, "/data/piece.car", "imported", testProposalCid + " " + testProposalCid, ""},
+			{testProposalCid, "", "/data/deal.car", "skipped", "", "deal " + testProposalCid + " in state StorageDealSealing"},
+		}, rows)
+	})
+
+	t.Run("json", func(t *testing.T) {
+		report := filepath.Join(dir, "report.json")
+		require.NoError(t, writeImportReport(report, results))
+
+		data, err := ioutil.ReadFile(report)
+		require.NoError(t, err)
+		var decoded []types2.ImportDataResult
+		require.NoError(t, json.Unmarshal(data, &decoded))
+		require.Equal(t, results, decoded)
+	})
+}
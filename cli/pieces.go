@@ -4,9 +4,12 @@ import (
 	"fmt"
 	"os"
 	"text/tabwriter"
+	"time"
 
+	"github.com/docker/go-units"
 	"github.com/ipfs/go-cid"
 	"github.com/urfave/cli/v2"
+	"golang.org/x/xerrors"
 )
 
 var PiecesCmd = &cli.Command{
@@ -18,6 +21,11 @@ var PiecesCmd = &cli.Command{
 		piecesListCidInfosCmd,
 		piecesInfoCmd,
 		piecesCidInfoCmd,
+		piecesStorageUsageCmd,
+		piecesGCCmd,
+		piecesUnsealJobsCmd,
+		piecesIndexerCmd,
+		piecesDropCidInfosCmd,
 	},
 }
 
@@ -46,7 +54,7 @@ var piecesListPiecesCmd = &cli.Command{
 
 var piecesListCidInfosCmd = &cli.Command{
 	Name:  "list-cids",
-	Usage: "list registered payload CIDs",
+	Usage: "list registered payload CIDs, the payloads of the top level index are listed with the raw codec",
 	Action: func(cctx *cli.Context) error {
 		nodeApi, closer, err := NewMarketNode(cctx)
 		if err != nil {
@@ -137,3 +145,235 @@ var piecesCidInfoCmd = &cli.Command{
 		return w.Flush()
 	},
 }
+
+var piecesStorageUsageCmd = &cli.Command{
+	Name:  "storage-usage",
+	Usage: "show the space used and left in each piece storage",
+	Action: func(cctx *cli.Context) error {
+		nodeApi, closer, err := NewMarketNode(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+		ctx := ReqContext(cctx)
+
+		usages, err := nodeApi.PiecesStorageUsage(ctx)
+		if err != nil {
+			return err
+		}
+
+		sizeStr := func(size int64) string {
+			if size < 0 {
+				return "unlimited"
+			}
+			return units.BytesSize(float64(size))
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 4, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "Name\tPath\tWeight\tReadOnly\tUsed\tAvailable\tMaxCapacity\n")
+		for _, usage := range usages {
+			maxCapacity := "-"
+			if usage.MaxCapacity > 0 {
+				maxCapacity = sizeStr(usage.MaxCapacity)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%d\t%t\t%s\t%s\t%s\n", usage.Name, usage.Path, usage.Weight, usage.ReadOnly,
+				sizeStr(usage.Used), sizeStr(usage.Available), maxCapacity)
+		}
+		return w.Flush()
+	},
+}
+
+var piecesGCCmd = &cli.Command{
+	Name:  "gc",
+	Usage: "remove from the piece storages the pieces not needed by their deals anymore",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "only list the pieces which would be removed",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		nodeApi, closer, err := NewMarketNode(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+		ctx := ReqContext(cctx)
+
+		results, err := nodeApi.PiecesGC(ctx, cctx.Bool("dry-run"))
+		if err != nil {
+			return err
+		}
+
+		var freed int64
+		w := tabwriter.NewWriter(os.Stdout, 4, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "Piece\tStorage\tSize\tSaved\tReason\tStatus\n")
+		for _, res := range results {
+			status := "to remove"
+			switch {
+			case res.Error != "":
+				status = "failed: " + res.Error
+			case res.Removed:
+				status = "removed"
+				freed += res.Size
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", res.Piece, res.Storage, units.BytesSize(float64(res.Size)),
+				res.ModTime.Format(time.RFC3339), res.Reason, status)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		fmt.Printf("%d pieces, %s freed\n", len(results), units.BytesSize(float64(freed)))
+		return nil
+	},
+}
+
+var piecesUnsealJobsCmd = &cli.Command{
+	Name:  "unseal-jobs",
+	Usage: "list the running unseal jobs and the last job of every other piece",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "state",
+			Usage: "only list the jobs in this state: queued, unsealing, done or failed",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		nodeApi, closer, err := NewMarketNode(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+		ctx := ReqContext(cctx)
+
+		jobs, err := nodeApi.PiecesListUnsealJobs(ctx)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 4, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "Piece\tMiner\tSector\tSize\tState\tWaiters\tCreated\tUpdated\tError\n")
+		for _, job := range jobs {
+			if cctx.IsSet("state") && string(job.State) != cctx.String("state") {
+				continue
+			}
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%d\t%s\t%s\t%s\n", job.PieceCid, job.Miner, job.Sector.Number,
+				units.BytesSize(float64(job.Size)), job.State, job.Waiters, job.CreatedAt.Format(time.RFC3339),
+				job.UpdatedAt.Format(time.RFC3339), job.Error)
+		}
+		return w.Flush()
+	},
+}
+
+var piecesDropCidInfosCmd = &cli.Command{
+	Name:  "drop-cid-infos",
+	Usage: "delete the legacy cid infos once the dagstore top level index answers the payload lookups",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "really-do-it",
+			Usage: "the cid infos can't be restored",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		if !cctx.Bool("really-do-it") {
+			return xerrors.Errorf("pass --really-do-it to delete the cid infos")
+		}
+		nodeApi, closer, err := NewMarketNode(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+		ctx := ReqContext(cctx)
+
+		dropped, err := nodeApi.PiecesDropCidInfos(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d cid infos deleted\n", dropped)
+		return nil
+	},
+}
+
+var piecesIndexerCmd = &cli.Command{
+	Name:  "indexer",
+	Usage: "manage the advertisements of the pieces to the indexers",
+	Subcommands: []*cli.Command{
+		piecesIndexerHeadCmd,
+		piecesIndexerAnnounceCmd,
+		piecesIndexerRemoveCmd,
+	},
+}
+
+var piecesIndexerHeadCmd = &cli.Command{
+	Name:  "head",
+	Usage: "print the latest advertisement",
+	Action: func(cctx *cli.Context) error {
+		nodeApi, closer, err := NewMarketNode(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+		ctx := ReqContext(cctx)
+
+		head, err := nodeApi.PiecesIndexerHead(ctx)
+		if err != nil {
+			return err
+		}
+		if !head.Defined() {
+			fmt.Println("no advertisement published yet")
+			return nil
+		}
+		fmt.Println(head)
+		return nil
+	},
+}
+
+var piecesIndexerAnnounceCmd = &cli.Command{
+	Name:      "announce",
+	Usage:     "advertise the payload of a piece to the indexers",
+	ArgsUsage: "<piece cid>",
+	Action: func(cctx *cli.Context) error {
+		return indexerNotify(cctx, false)
+	},
+}
+
+var piecesIndexerRemoveCmd = &cli.Command{
+	Name:      "remove",
+	Usage:     "advertise the removal of the payload of a piece to the indexers",
+	ArgsUsage: "<piece cid>",
+	Action: func(cctx *cli.Context) error {
+		return indexerNotify(cctx, true)
+	},
+}
+
+func indexerNotify(cctx *cli.Context, remove bool) error {
+	if !cctx.Args().Present() {
+		return ShowHelp(cctx, fmt.Errorf("must specify piece cid"))
+	}
+
+	nodeApi, closer, err := NewMarketNode(cctx)
+	if err != nil {
+		return err
+	}
+	defer closer()
+	ctx := ReqContext(cctx)
+
+	pieceCid, err := cid.Decode(cctx.Args().First())
+	if err != nil {
+		return err
+	}
+
+	notify := nodeApi.PiecesIndexerAnnounce
+	if remove {
+		notify = nodeApi.PiecesIndexerRemove
+	}
+	ad, err := notify(ctx, pieceCid)
+	if err != nil {
+		return err
+	}
+	if !ad.Defined() {
+		fmt.Println("piece is not announced")
+		return nil
+	}
+	fmt.Println("advertisement:", ad)
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 	"text/tabwriter"
 
+	"github.com/BurntSushi/toml"
 	"github.com/docker/go-units"
 	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
 	"github.com/filecoin-project/go-state-types/abi"
@@ -21,6 +22,8 @@ var RetrievalDealsCmd = &cli.Command{
 		retrievalDealsListCmd,
 		retrievalSetAskCmd,
 		retrievalGetAskCmd,
+		retrievalPricingCmd,
+		retrievalRulesCmd,
 	},
 }
 
@@ -163,6 +166,7 @@ var retrievalSetAskCmd = &cli.Command{
 	Name:  "set-ask",
 	Usage: "Configure the provider's retrieval ask",
 	Flags: []cli.Flag{
+		MinerFlag,
 		&cli.StringFlag{
 			Name:  "price",
 			Usage: "Set the price of the ask for retrievals (FIL/GiB)",
@@ -191,7 +195,12 @@ var retrievalSetAskCmd = &cli.Command{
 		}
 		defer closer()
 
-		ask, err := api.MarketGetRetrievalAsk(ctx)
+		maddr, err := GetMinerAddress(ctx, cctx, api)
+		if err != nil {
+			return err
+		}
+
+		ask, err := api.MarketGetRetrievalAsk(ctx, maddr)
 		if err != nil {
 			return err
 		}
@@ -228,14 +237,16 @@ var retrievalSetAskCmd = &cli.Command{
 			ask.PaymentIntervalIncrease = uint64(v)
 		}
 
-		return api.MarketSetRetrievalAsk(ctx, ask)
+		return api.MarketSetRetrievalAsk(ctx, maddr, ask)
 	},
 }
 
 var retrievalGetAskCmd = &cli.Command{
 	Name:  "get-ask",
 	Usage: "Get the provider's current retrieval ask",
-	Flags: []cli.Flag{},
+	Flags: []cli.Flag{
+		MinerFlag,
+	},
 	Action: func(cctx *cli.Context) error {
 		ctx := DaemonContext(cctx)
 
@@ -245,7 +256,12 @@ var retrievalGetAskCmd = &cli.Command{
 		}
 		defer closer()
 
-		ask, err := api.MarketGetRetrievalAsk(ctx)
+		maddr, err := GetMinerAddress(ctx, cctx, api)
+		if err != nil {
+			return err
+		}
+
+		ask, err := api.MarketGetRetrievalAsk(ctx, maddr)
 		if err != nil {
 			return err
 		}
@@ -267,3 +283,85 @@ var retrievalGetAskCmd = &cli.Command{
 
 	},
 }
+
+var retrievalPricingCmd = &cli.Command{
+	Name:  "pricing",
+	Usage: "Manage the rules of the dynamic retrieval pricing",
+	Subcommands: []*cli.Command{
+		retrievalPricingShowCmd,
+		retrievalPricingReloadCmd,
+	},
+}
+
+var retrievalPricingShowCmd = &cli.Command{
+	Name:  "show",
+	Usage: "Show the pricing rules in use",
+	Action: func(cctx *cli.Context) error {
+		api, closer, err := NewMarketNode(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		rules, err := api.MarketRetrievalPricingRules(DaemonContext(cctx))
+		if err != nil {
+			return err
+		}
+		return toml.NewEncoder(os.Stdout).Encode(rules)
+	},
+}
+
+var retrievalPricingReloadCmd = &cli.Command{
+	Name:  "reload",
+	Usage: "Read the pricing file again",
+	Action: func(cctx *cli.Context) error {
+		api, closer, err := NewMarketNode(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		return api.MarketReloadRetrievalPricingRules(DaemonContext(cctx))
+	},
+}
+
+var retrievalRulesCmd = &cli.Command{
+	Name:  "rules",
+	Usage: "Manage the rules checked by retrieval deals",
+	Subcommands: []*cli.Command{
+		retrievalRulesShowCmd,
+		retrievalRulesReloadCmd,
+	},
+}
+
+var retrievalRulesShowCmd = &cli.Command{
+	Name:  "show",
+	Usage: "Show the rules in use",
+	Action: func(cctx *cli.Context) error {
+		api, closer, err := NewMarketNode(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		rules, err := api.DealsRetrievalDealRules(DaemonContext(cctx))
+		if err != nil {
+			return err
+		}
+		return toml.NewEncoder(os.Stdout).Encode(rules)
+	},
+}
+
+var retrievalRulesReloadCmd = &cli.Command{
+	Name:  "reload",
+	Usage: "Read the rule file again",
+	Action: func(cctx *cli.Context) error {
+		api, closer, err := NewMarketNode(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		return api.DealsReloadRetrievalDealRules(DaemonContext(cctx))
+	},
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/docker/go-units"
 	"github.com/fatih/color"
+	"github.com/filecoin-project/go-address"
 	datatransfer "github.com/filecoin-project/go-data-transfer"
 	"github.com/filecoin-project/go-jsonrpc"
 	"github.com/filecoin-project/venus-market/api"
@@ -19,6 +20,8 @@ import (
 	"github.com/urfave/cli/v2"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"path"
@@ -56,6 +59,42 @@ func NewMarketNode(cctx *cli.Context) (api.MarketFullNode, jsonrpc.ClientCloser,
 	return impl, closer, nil
 }
 
+// NewMarketHTTPEndpoint returns the url of the http endpoint at path on the rpc server of the market,
+// along with the auth header
+func NewMarketHTTPEndpoint(cctx *cli.Context, endpoint string) (string, http.Header, error) {
+	homePath, err := homedir.Expand(cctx.String("repo"))
+	if err != nil {
+		return "", nil, err
+	}
+	apiUrl, err := ioutil.ReadFile(path.Join(homePath, "api"))
+	if err != nil {
+		return "", nil, err
+	}
+
+	token, err := ioutil.ReadFile(path.Join(homePath, "token"))
+	if err != nil {
+		return "", nil, err
+	}
+	apiInfo := apiinfo.NewAPIInfo(string(apiUrl), string(token))
+	addr, err := apiInfo.DialArgs("v0")
+	if err != nil {
+		return "", nil, err
+	}
+
+	u, err := url.Parse(addr)
+	if err != nil {
+		return "", nil, err
+	}
+	switch u.Scheme {
+	case "ws":
+		u.Scheme = "http"
+	case "wss":
+		u.Scheme = "https"
+	}
+	u.Path = endpoint
+	return u.String(), apiInfo.AuthHeader(), nil
+}
+
 func NewMarketClientNode(cctx *cli.Context) (api.MarketClientNode, jsonrpc.ClientCloser, error) {
 	homePath, err := homedir.Expand(cctx.String("repo"))
 	if err != nil {
@@ -106,6 +145,19 @@ func NewFullNode(cctx *cli.Context) (apiface.FullNode, jsonrpc.ClientCloser, err
 	return impl, closer, nil
 }
 
+var MinerFlag = &cli.StringFlag{
+	Name:  "miner",
+	Usage: "miner actor to operate on, default to the first miner served by the market",
+}
+
+// GetMinerAddress returns the miner of the `miner` flag if provided, or the first miner of the market if not.
+func GetMinerAddress(ctx context.Context, cctx *cli.Context, nodeAPI api.MarketFullNode) (address.Address, error) {
+	if cctx.IsSet("miner") {
+		return address.NewFromString(cctx.String("miner"))
+	}
+	return nodeAPI.ActorAddress(ctx)
+}
+
 func WithCategory(cat string, cmd *cli.Command) *cli.Command {
 	cmd.Category = strings.ToUpper(cat)
 	return cmd
@@ -10,6 +10,7 @@ import (
 	cli2 "github.com/filecoin-project/venus-market/cli"
 	"github.com/filecoin-project/venus-market/config"
 	"github.com/filecoin-project/venus-market/fundmgr"
+	"github.com/filecoin-project/venus-market/indexprovider"
 	"github.com/filecoin-project/venus-market/journal"
 	"github.com/filecoin-project/venus-market/metrics"
 	"github.com/filecoin-project/venus-market/models"
@@ -30,6 +31,7 @@ import (
 	"go.uber.org/fx"
 	"golang.org/x/xerrors"
 	"log"
+	"net/http"
 	"os"
 )
 
@@ -71,14 +73,14 @@ var (
 		Usage: "auth token for connect signer service",
 	}
 
-	MinerFlag = &cli.StringFlag{
+	MinerFlag = &cli.StringSliceFlag{
 		Name:  "miner",
-		Usage: "miner address",
+		Usage: "miner address, can be repeated to serve several miners",
 	}
 
 	PieceStorageFlag = &cli.StringFlag{
 		Name:  "piecestorage",
-		Usage: "config storage for piece",
+		Usage: "config storage for piece, fs:<dir> or s3:<bucket>[/<prefix>] with the endpoint in PieceStorageS3",
 	}
 
 	TransferPathFlag = &cli.StringFlag{
@@ -119,6 +121,7 @@ func main() {
 			cli2.NetCmd,
 			cli2.DataTransfersCmd,
 			cli2.DagstoreCmd,
+			cli2.FundsCmd,
 		},
 	}
 
@@ -195,6 +198,7 @@ func daemon(cctx *cli.Context) error {
 		// Markets
 		storageadapter.StorageProviderOpts(cfg),
 		retrievaladapter.RetrievalProviderOpts(cfg),
+		indexprovider.IndexProviderOpts,
 
 		func(s *builder.Settings) error {
 			s.Invokes[ExtractApiKey] = builder.InvokeOption{
@@ -209,7 +213,12 @@ func daemon(cctx *cli.Context) error {
 	}
 	finishCh := utils.MonitorShutdown(shutdownChan)
 
-	return rpc.ServeRPC(ctx, cfg, &cfg.API, api.MarketFullNode(resAPI), finishCh, 1000, "")
+	return rpc.ServeRPC(ctx, cfg, &cfg.API, api.MarketFullNode(resAPI), map[string]http.Handler{
+		storageadapter.DealUploadPath: resAPI.DealUploads,
+	}, map[string]http.Handler{
+		// the indexers pull the advertisements without a token
+		indexprovider.IndexProviderPath: resAPI.IndexProvider,
+	}, finishCh, 1000, "")
 }
 
 func flagData(cctx *cli.Context, cfg *config.MarketConfig) error {
@@ -238,11 +247,15 @@ func flagData(cctx *cli.Context, cfg *config.MarketConfig) error {
 	}
 
 	if cctx.IsSet("miner") {
-		addr, err := address.NewFromString(cctx.String("miner"))
-		if err != nil {
-			return err
+		cfg.MinerAddress = ""
+		cfg.Miners = nil
+		for _, miner := range cctx.StringSlice("miner") {
+			addr, err := address.NewFromString(miner)
+			if err != nil {
+				return err
+			}
+			cfg.Miners = append(cfg.Miners, addr.String())
 		}
-		cfg.MinerAddress = addr.String()
 	}
 
 	if cctx.IsSet("piecestorage") {
@@ -63,13 +63,23 @@ const (
 	// RetrievalPricingExternal configures the node to use the external retrieval pricing script
 	// configured by the user.
 	RetrievalPricingExternalMode = "external"
+	// RetrievalPricingDynamic configures the node to price retrievals by the rules of a file.
+	RetrievalPricingDynamicMode = "dynamic"
 )
 
 type RetrievalPricing struct {
-	Strategy string // possible values: "default", "external"
+	Strategy string // possible values: "default", "external", "dynamic"
 
 	Default  *RetrievalPricingDefault
 	External *RetrievalPricingExternal
+	Dynamic  *RetrievalPricingDynamic
+}
+
+type RetrievalPricingDynamic struct {
+	// Path of the toml file of the pricing rules, see retrievaladapter.DynamicPricingRules.
+	// The file can be reloaded while the market is running.
+	// This parameter is ONLY applicable if the retrieval pricing policy strategy has been configured to "dynamic".
+	Path string
 }
 
 type RetrievalPricingExternal struct {
@@ -131,10 +141,112 @@ type DAGStoreConfig struct {
 	// representation, e.g. 1m, 5m, 1h.
 	// Default value: 1 minute.
 	GCInterval Duration
+
+	// Health checks the indexes of a sample of the shards and repairs the errored ones
+	Health DAGStoreHealthConfig
+}
+
+// DAGStoreHealthConfig configures the health checker of the dagstore, an errored shard is
+// recovered again after MinBackoff, doubled on every failed attempt up to MaxBackoff
+type DAGStoreHealthConfig struct {
+	// interval between two checks of a sample of the available shards, 0 to disable the checks
+	CheckInterval Duration
+	// number of shards checked on every check
+	SampleSize int
+	MinBackoff Duration
+	MaxBackoff Duration
 }
 
 type PieceStorageString string
 
+// S3PieceStorage is the object store used when PieceStorage is "s3:<bucket>[/<prefix>]"
+type S3PieceStorage struct {
+	// Endpoint of the s3 compatible service, e.g. https://s3.us-east-1.amazonaws.com or http://127.0.0.1:9000
+	Endpoint  string
+	Region    string
+	AccessKey string
+	SecretKey string
+	// Token is the session token of temporary credentials, leave it empty for long-term credentials
+	Token string
+	// PartSize is the size of each part of a multipart upload, pieces larger than it are uploaded
+	// in parts, at least 5MiB, default to 64MiB
+	PartSize int64
+}
+
+// PieceStorageConfig is one of the locations pieces are saved in
+type PieceStorageConfig struct {
+	// Name identifies the storage in the api and cli, default to Path
+	Name string
+	// Path is fs:<dir> or s3:<bucket>[/<prefix>]
+	Path PieceStorageString
+	// S3 is the object store holding the bucket of a s3 Path
+	S3 S3PieceStorage
+	// new pieces go to the writable storage with room for them and the highest Weight, the one with the most
+	// space left among those of the same Weight, 0 is taken as 1
+	Weight uint64
+	// ReadOnly storages still serve their pieces but don't take new ones
+	ReadOnly bool
+	// MaxCapacity limits the space taken by pieces, e.g. 10TiB, empty for no limit other than the free space
+	MaxCapacity string
+}
+
+// ClientQuota limits what a single client takes from the provider, counted both by client address
+// and by peer id
+type ClientQuota struct {
+	// padded size of the deals accepted from a client and not failed, e.g. 100TiB, empty for no limit
+	MaxAcceptedBytes string
+	// deals accepted from a client and not active yet, 0 for no limit
+	MaxDealsInFlight uint64
+	// proposals a client can make in ProposalWindow, 0 for no limit
+	MaxProposals   uint64
+	ProposalWindow Duration
+}
+
+// EscrowKeeperConfig keeps the available market escrow of every miner above LowWater, the
+// escrow is topped up to Target from Wallet at once instead of by every deal
+type EscrowKeeperConfig struct {
+	// the wallet sending the top ups, the keeper is disabled if empty
+	Wallet string
+	// a miner is topped up when its escrow, less the locked and reserved funds, is below LowWater
+	LowWater types.FIL
+	// available escrow of a miner after a top up
+	Target types.FIL
+	// interval between two checks of the escrow
+	CheckInterval Duration
+}
+
+// PieceGCConfig removes from the piece storages the pieces the sealer doesn't need anymore, a
+// piece is kept while any of its deals waits for sealing
+type PieceGCConfig struct {
+	// interval between two automatic collections, 0 to only collect by `pieces gc`
+	Interval Duration
+	// pieces of sealed deals are kept this long after they were saved, to serve retrievals
+	Retention Duration
+	// pieces of sealed deals are removed before Retention, oldest first, while a storage
+	// uses more than this percentage of its capacity, 0 to disable
+	HighWater uint64
+	// keep the pieces of sealed fast retrieval deals until the deals end
+	KeepFastRetrieval bool
+}
+
+// UnsealConfig controls the unsealing of pieces for retrievals
+type UnsealConfig struct {
+	// unseal jobs running at a time, the others are queued, 0 for no limit
+	MaxConcurrent uint64
+	// a job fails if the piece isn't unsealed within Timeout, queued time included, 0 for no limit
+	Timeout Duration
+}
+
+// IndexProviderConfig publishes the payload multihashes of the pieces with an active deal in a
+// chain of signed advertisements indexers pull over http
+type IndexProviderConfig struct {
+	Enable bool
+	// retrieval addresses put in the advertisements, the announced libp2p addresses if empty
+	RetrievalAddresses []string
+	// multihashes in an entries chunk of an advertisement
+	EntriesChunkSize int
+}
+
 // StorageMiner is a miner config
 type MarketConfig struct {
 	Home `toml:"-"`
@@ -145,14 +257,28 @@ type MarketConfig struct {
 	Messager Messager
 	Signer   Signer
 
-	PieceStorage  PieceStorageString
-	TransferPath  string
+	// PieceStorage and PieceStorageS3 configure a single piece storage, they are
+	// ignored when PieceStorages is set
+	PieceStorage   PieceStorageString
+	PieceStorageS3 S3PieceStorage
+	PieceStorages  []PieceStorageConfig
+	TransferPath   string
 
 	Journal       Journal
 	AddressConfig AddressConfig
 	DAGStore      DAGStoreConfig
-
+	ClientQuota   ClientQuota
+	EscrowKeeper  EscrowKeeperConfig
+	PieceGC       PieceGCConfig
+	Unseal        UnsealConfig
+	IndexProvider IndexProviderConfig
+
+	// MinerAddress is the miner served by a single-miner market, it is still
+	// honoured for config files written before Miners was introduced
 	MinerAddress string
+	// Miners lists every miner actor this market serves deals for, each one
+	// gets its own storage provider, retrieval provider and deal records
+	Miners []string
 	// When enabled, the miner can accept online deals
 	ConsiderOnlineStorageDeals bool
 	// When enabled, the miner can accept offline deals
@@ -179,6 +305,13 @@ type MarketConfig struct {
 	// The maximum number of deals to include in a single PublishStorageDeals
 	// message
 	MaxDealsPerPublishMsg uint64
+	// Publish the queue before PublishMsgPeriod ends when a queued deal
+	// starts within this margin, 0 to disable
+	PublishStartMargin Duration
+	// Hold the queue after PublishMsgPeriod ends while the base fee is above
+	// this value, as long as no queued deal is within PublishStartMargin.
+	// A queue of MaxDealsPerPublishMsg deals is published anyway, 0 to disable
+	PublishMaxBaseFee types.FIL
 	// The maximum collateral that the provider will put up against a deal,
 	// as a multiplier of the minimum collateral bound
 	MaxProviderCollateralMultiplier uint64
@@ -192,6 +325,10 @@ type MarketConfig struct {
 	// A command used for fine-grained evaluation of retrieval deals
 	// see https://docs.filecoin.io/mine/lotus/miner-configuration/#using-filters-for-fine-grained-storage-and-retrieval-deal-acceptance for more details
 	RetrievalFilter string
+	// Path of a toml file of rules checked by storage deals, see dealfilter.StorageDealRules
+	StorageDealRules string
+	// Path of a toml file of rules checked by retrieval deals, see retrievaladapter.RetrievalDealRules
+	RetrievalDealRules string
 
 	RetrievalPricing *RetrievalPricing
 
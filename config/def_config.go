@@ -12,8 +12,8 @@ const (
 )
 
 var DefaultMarketConfig = &MarketConfig{
-	Home:         Home{"~/.venusmarket"},
-	MinerAddress: "maddr",
+	Home:   Home{"~/.venusmarket"},
+	Miners: []string{},
 	Common: Common{
 		API: API{
 			ListenAddress: "/ip4/127.0.0.1/tcp/41235",
@@ -44,6 +44,36 @@ var DefaultMarketConfig = &MarketConfig{
 		MaxConcurrentIndex:         5,
 		MaxConcurrencyStorageCalls: 100,
 		GCInterval:                 Duration(1 * time.Minute),
+		Health: DAGStoreHealthConfig{
+			CheckInterval: Duration(time.Hour),
+			SampleSize:    10,
+			MinBackoff:    Duration(time.Minute),
+			MaxBackoff:    Duration(6 * time.Hour),
+		},
+	},
+	PieceStorageS3: S3PieceStorage{
+		Region:   "us-east-1",
+		PartSize: 64 << 20,
+	},
+	ClientQuota: ClientQuota{
+		ProposalWindow: Duration(time.Hour),
+	},
+	EscrowKeeper: EscrowKeeperConfig{
+		LowWater:      types.FIL(types.NewInt(0)),
+		Target:        types.FIL(types.NewInt(0)),
+		CheckInterval: Duration(10 * time.Minute),
+	},
+	PieceGC: PieceGCConfig{
+		Interval:          Duration(6 * time.Hour),
+		Retention:         Duration(7 * 24 * time.Hour),
+		HighWater:         90,
+		KeepFastRetrieval: true,
+	},
+	Unseal: UnsealConfig{
+		Timeout: Duration(6 * time.Hour),
+	},
+	IndexProvider: IndexProviderConfig{
+		EntriesChunkSize: 16384,
 	},
 	Journal:                        Journal{Path: "journal"},
 	PieceStorage:                   "fs:/mnt/piece",
@@ -59,6 +89,7 @@ var DefaultMarketConfig = &MarketConfig{
 	MaxDealStartDelay:    Duration(time.Hour * 24 * 14),
 	ExpectedSealDuration: Duration(time.Hour * 24),
 	PublishMsgPeriod:     Duration(time.Hour),
+	PublishStartMargin:   Duration(time.Hour * 24),
 
 	MaxDealsPerPublishMsg:           8,
 	MaxProviderCollateralMultiplier: 2,
@@ -73,10 +104,14 @@ var DefaultMarketConfig = &MarketConfig{
 		External: &RetrievalPricingExternal{
 			Path: "",
 		},
+		Dynamic: &RetrievalPricingDynamic{
+			Path: "",
+		},
 	},
 
 	MaxPublishDealsFee:     types.FIL(types.NewInt(0)),
 	MaxMarketBalanceAddFee: types.FIL(types.NewInt(0)),
+	PublishMaxBaseFee:      types.FIL(types.NewInt(0)),
 }
 
 var DefaultMarketClientConfig = &MarketClientConfig{
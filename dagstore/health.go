@@ -0,0 +1,372 @@
+package dagstore
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/dagstore"
+	"github.com/filecoin-project/dagstore/shard"
+	"github.com/filecoin-project/go-fil-markets/stores"
+
+	"github.com/filecoin-project/venus-market/config"
+	"github.com/filecoin-project/venus-market/journal"
+	"github.com/filecoin-project/venus-market/piece"
+	"github.com/filecoin-project/venus-market/types"
+)
+
+// ShardFailureEvt is recorded in the journal when a shard fails or the health checker fails to
+// repair it
+type ShardFailureEvt struct {
+	Key      string
+	Error    string
+	Attempts int
+}
+
+// ShardRecoveredEvt is recorded in the journal when the health checker repaired a shard
+type ShardRecoveredEvt struct {
+	Key      string
+	Attempts int
+	Broken   time.Duration
+}
+
+type shardHealth struct {
+	lastErr      string
+	attempts     int
+	firstFailure time.Time
+	nextAttempt  time.Time
+}
+
+// HealthChecker takes over the shard failures of the DAG store from RecoverImmediately: it
+// recovers the errored shards with an exponential backoff and verifies the index of a sample of
+// the available shards against their CAR data at every check interval.
+type HealthChecker struct {
+	w          *Wrapper
+	interval   time.Duration
+	sampleSize int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	journal          journal.Journal
+	failureEvtType   journal.EventType
+	recoveredEvtType journal.EventType
+
+	now func() time.Time
+
+	lk sync.Mutex
+	// shards waiting for a repair
+	broken map[shard.Key]*shardHealth
+}
+
+// NewHealthChecker attaches a health checker to the wrapper, it runs once the wrapper starts
+func NewHealthChecker(cfg config.DAGStoreHealthConfig, w *Wrapper, j journal.Journal) *HealthChecker {
+	minBackoff, maxBackoff := time.Duration(cfg.MinBackoff), time.Duration(cfg.MaxBackoff)
+	if minBackoff <= 0 {
+		minBackoff = time.Minute
+	}
+	if maxBackoff < minBackoff {
+		maxBackoff = minBackoff
+	}
+	h := &HealthChecker{
+		w:                w,
+		interval:         time.Duration(cfg.CheckInterval),
+		sampleSize:       cfg.SampleSize,
+		minBackoff:       minBackoff,
+		maxBackoff:       maxBackoff,
+		journal:          j,
+		failureEvtType:   j.RegisterEventType("markets/dagstore", "shard_failure"),
+		recoveredEvtType: j.RegisterEventType("markets/dagstore", "shard_recovered"),
+		now:              time.Now,
+		broken:           make(map[shard.Key]*shardHealth),
+	}
+	w.health = h
+	return h
+}
+
+func (h *HealthChecker) run(ctx context.Context, failureCh <-chan dagstore.ShardResult) {
+	defer h.w.backgroundWg.Done()
+
+	if h.interval > 0 && h.sampleSize > 0 {
+		h.w.backgroundWg.Add(1)
+		go h.checkLoop(ctx)
+	}
+
+	ticker := time.NewTicker(h.minBackoff)
+	defer ticker.Stop()
+
+	for ctx.Err() == nil {
+		select {
+		case res := <-failureCh:
+			h.fail(res.Key, res.Error)
+			h.repairDue(ctx)
+		case <-ticker.C:
+			h.repairDue(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (h *HealthChecker) checkLoop(ctx context.Context) {
+	defer h.w.backgroundWg.Done()
+
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	for ctx.Err() == nil {
+		select {
+		case <-ticker.C:
+			h.Check(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Check verifies the index of a random sample of the available shards and starts tracking the
+// errored shards it doesn't know yet, eg. the ones which failed before a restart
+func (h *HealthChecker) Check(ctx context.Context) {
+	var available []shard.Key
+	for key, info := range h.w.dagst.AllShardsInfo() {
+		switch info.ShardState {
+		case dagstore.ShardStateAvailable:
+			available = append(available, key)
+		case dagstore.ShardStateErrored:
+			h.lk.Lock()
+			_, ok := h.broken[key]
+			h.lk.Unlock()
+			if !ok {
+				h.fail(key, info.Error)
+			}
+		}
+	}
+
+	rand.Shuffle(len(available), func(i, j int) {
+		available[i], available[j] = available[j], available[i]
+	})
+	if len(available) > h.sampleSize {
+		available = available[:h.sampleSize]
+	}
+	for _, key := range available {
+		if ctx.Err() != nil {
+			return
+		}
+		// don't unseal a sector only to check the shard
+		pieceCid, err := cid.Decode(key.String())
+		if err != nil {
+			continue
+		}
+		if unsealed, err := h.w.minerAPI.IsUnsealed(ctx, pieceCid); err != nil || !unsealed {
+			continue
+		}
+		if err := h.verify(ctx, key); err != nil {
+			h.fail(key, err)
+			continue
+		}
+		log.Debugw("shard index verified", "shard_key", key)
+	}
+}
+
+// verify checks every block of the CAR data of the shard can be found through its index
+func (h *HealthChecker) verify(ctx context.Context, key shard.Key) error {
+	resch := make(chan dagstore.ShardResult, 1)
+	if err := h.w.dagst.AcquireShard(ctx, key, resch, dagstore.AcquireOpts{}); err != nil {
+		return xerrors.Errorf("failed to schedule acquire shard: %w", err)
+	}
+	var res dagstore.ShardResult
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case res = <-resch:
+		if res.Error != nil {
+			return xerrors.Errorf("failed to acquire shard: %w", res.Error)
+		}
+	}
+	defer res.Accessor.Close() //nolint:errcheck
+
+	bs, err := res.Accessor.Blockstore()
+	if err != nil {
+		return xerrors.Errorf("failed to get blockstore of shard: %w", err)
+	}
+	keys, err := bs.AllKeysChan(ctx)
+	if err != nil {
+		return xerrors.Errorf("failed to read CAR data of shard: %w", err)
+	}
+	var missing []cid.Cid
+	for c := range keys {
+		has, err := bs.Has(c)
+		if err != nil {
+			return xerrors.Errorf("failed to look up block %s in index: %w", c, err)
+		}
+		if !has {
+			missing = append(missing, c)
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if len(missing) > 0 {
+		return xerrors.Errorf("index misses %d blocks of the CAR data, first %s", len(missing), missing[0])
+	}
+	return nil
+}
+
+// fail records a failure of the shard, a new broken shard is repaired right away
+func (h *HealthChecker) fail(key shard.Key, err error) {
+	h.lk.Lock()
+	sh, ok := h.broken[key]
+	if !ok {
+		now := h.now()
+		sh = &shardHealth{firstFailure: now, nextAttempt: now}
+		h.broken[key] = sh
+	}
+	if err != nil {
+		sh.lastErr = err.Error()
+	}
+	evt := ShardFailureEvt{Key: key.String(), Error: sh.lastErr, Attempts: sh.attempts}
+	h.lk.Unlock()
+
+	log.Warnw("shard failed", "shard_key", key, "error", evt.Error, "attempts", evt.Attempts)
+	h.journal.RecordEvent(h.failureEvtType, func() interface{} {
+		return evt
+	})
+}
+
+// repairDue repairs the broken shards whose backoff elapsed
+func (h *HealthChecker) repairDue(ctx context.Context) {
+	now := h.now()
+	var due []shard.Key
+	h.lk.Lock()
+	for key, sh := range h.broken {
+		if !sh.nextAttempt.After(now) {
+			due = append(due, key)
+		}
+	}
+	h.lk.Unlock()
+
+	for _, key := range due {
+		if ctx.Err() != nil {
+			return
+		}
+		err := h.repair(ctx, key)
+
+		h.lk.Lock()
+		sh, ok := h.broken[key]
+		if !ok {
+			h.lk.Unlock()
+			continue
+		}
+		sh.attempts++
+		if err != nil {
+			sh.lastErr = err.Error()
+			sh.nextAttempt = h.now().Add(h.backoff(sh.attempts))
+			evt, next := ShardFailureEvt{Key: key.String(), Error: sh.lastErr, Attempts: sh.attempts}, sh.nextAttempt
+			h.lk.Unlock()
+
+			log.Warnw("failed to repair shard", "shard_key", key, "error", err, "attempts", evt.Attempts, "next_attempt", next)
+			h.journal.RecordEvent(h.failureEvtType, func() interface{} {
+				return evt
+			})
+			continue
+		}
+		delete(h.broken, key)
+		evt := ShardRecoveredEvt{Key: key.String(), Attempts: sh.attempts, Broken: h.now().Sub(sh.firstFailure)}
+		h.lk.Unlock()
+
+		log.Infow("shard repaired", "shard_key", key, "attempts", evt.Attempts)
+		h.journal.RecordEvent(h.recoveredEvtType, func() interface{} {
+			return evt
+		})
+	}
+}
+
+// repair recovers an errored shard, an available shard failed the verification of its index so it
+// is destroyed and registered again to rebuild the index. An errored shard whose piece left the
+// piece storages is registered again too, on the lotus mount.
+func (h *HealthChecker) repair(ctx context.Context, key shard.Key) error {
+	info, err := h.w.dagst.GetShardInfo(key)
+	if xerrors.Is(err, dagstore.ErrShardUnknown) {
+		// destroyed meanwhile, nothing to repair
+		return nil
+	}
+	if err != nil {
+		return xerrors.Errorf("failed to get shard info: %w", err)
+	}
+
+	pieceCid, err := cid.Decode(key.String())
+	if err != nil {
+		return xerrors.Errorf("invalid piece CID: %w", err)
+	}
+
+	if info.ShardState == dagstore.ShardStateErrored && !h.pieceCollected(pieceCid) {
+		resch := make(chan dagstore.ShardResult, 1)
+		if err := h.w.dagst.RecoverShard(ctx, key, resch, dagstore.RecoverOpts{}); err != nil {
+			return xerrors.Errorf("failed to schedule recover shard: %w", err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case res := <-resch:
+			return res.Error
+		}
+	}
+
+	if err := h.w.DestroyShard(ctx, pieceCid); err != nil {
+		return err
+	}
+	// the shard of a collected piece is initialized by its next acquire, not to unseal it right away
+	return stores.RegisterShardSync(ctx, h.w, pieceCid, "", info.ShardState != dagstore.ShardStateErrored)
+}
+
+// pieceCollected reports whether the gc removed the piece from the piece storages, its shard has to
+// read the sectors from now on
+func (h *HealthChecker) pieceCollected(pieceCid cid.Cid) bool {
+	if h.w.pieceStorages == nil {
+		return false
+	}
+	_, err := h.w.pieceStorages.FindStorageForRead(pieceCid.String())
+	return xerrors.Is(err, piece.ErrPieceNotFound)
+}
+
+func (h *HealthChecker) backoff(attempts int) time.Duration {
+	backoff := h.minBackoff
+	for i := 1; i < attempts && backoff < h.maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > h.maxBackoff {
+		backoff = h.maxBackoff
+	}
+	return backoff
+}
+
+// Report lists the shards which stay broken, the longest broken first
+func (h *HealthChecker) Report() []types.DagstoreShardHealth {
+	h.lk.Lock()
+	out := make([]types.DagstoreShardHealth, 0, len(h.broken))
+	for key, sh := range h.broken {
+		out = append(out, types.DagstoreShardHealth{
+			Key:          key.String(),
+			LastError:    sh.lastErr,
+			Attempts:     sh.attempts,
+			FirstFailure: sh.firstFailure,
+			NextAttempt:  sh.nextAttempt,
+		})
+	}
+	h.lk.Unlock()
+
+	for i := range out {
+		info, err := h.w.dagst.GetShardInfo(shard.KeyFromString(out[i].Key))
+		if err == nil {
+			out[i].State = info.ShardState.String()
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].FirstFailure.Before(out[j].FirstFailure)
+	})
+	return out
+}
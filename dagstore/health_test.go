@@ -0,0 +1,224 @@
+package dagstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	blocksutil "github.com/ipfs/go-ipfs-blocksutil"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/dagstore"
+	"github.com/filecoin-project/dagstore/mount"
+	"github.com/filecoin-project/dagstore/shard"
+
+	"github.com/filecoin-project/venus-market/config"
+	"github.com/filecoin-project/venus-market/journal"
+	"github.com/filecoin-project/venus-market/piece"
+)
+
+func TestHealthChecker(t *testing.T) {
+	ctx := context.Background()
+	bgen := blocksutil.NewBlockGenerator()
+	errored, available := shard.KeyFromCID(bgen.Next().Cid()), shard.KeyFromCID(bgen.Next().Cid())
+
+	dagst, w, err := NewDAGStore(&config.DAGStoreConfig{
+		RootDir:    t.TempDir(),
+		GCInterval: config.Duration(1 * time.Millisecond),
+	}, unsealedMinerAPI{}, nil, nil)
+	require.NoError(t, err)
+	defer dagst.Close() //nolint:errcheck
+
+	mock := &healthDagStore{
+		t: t,
+		shards: map[shard.Key]dagstore.ShardInfo{
+			errored:   {ShardState: dagstore.ShardStateErrored, Error: xerrors.New("boom")},
+			available: {ShardState: dagstore.ShardStateAvailable},
+		},
+		recoverErrs: []error{xerrors.New("still broken"), nil},
+	}
+	w.dagst = mock
+
+	j := &recordJournal{}
+	h := NewHealthChecker(config.DAGStoreHealthConfig{
+		SampleSize: 10,
+		MinBackoff: config.Duration(time.Minute),
+		MaxBackoff: config.Duration(3 * time.Minute),
+	}, w, j)
+	now := time.Now()
+	h.now = func() time.Time { return now }
+
+	require.Equal(t, time.Minute, h.backoff(1))
+	require.Equal(t, 2*time.Minute, h.backoff(2))
+	require.Equal(t, 3*time.Minute, h.backoff(3))
+	require.Equal(t, 3*time.Minute, h.backoff(10))
+
+	// the errored shard is tracked, the index of the available one is fine
+	h.Check(ctx)
+	report := h.Report()
+	require.Len(t, report, 1)
+	require.Equal(t, errored.String(), report[0].Key)
+	require.Equal(t, "ShardStateErrored", report[0].State)
+	require.Equal(t, "boom", report[0].LastError)
+	require.Equal(t, 0, report[0].Attempts)
+
+	// a failed recovery waits for the backoff
+	h.repairDue(ctx)
+	h.repairDue(ctx)
+	require.Equal(t, 1, mock.recovers)
+	report = h.Report()
+	require.Len(t, report, 1)
+	require.Equal(t, "still broken", report[0].LastError)
+	require.Equal(t, 1, report[0].Attempts)
+	require.Equal(t, now.Add(time.Minute), report[0].NextAttempt)
+
+	now = now.Add(time.Minute)
+	h.repairDue(ctx)
+	require.Equal(t, 2, mock.recovers)
+	require.Empty(t, h.Report())
+	require.Len(t, j.events, 3)
+	require.Equal(t, ShardRecoveredEvt{Key: errored.String(), Attempts: 2, Broken: time.Minute}, j.events[2])
+
+	// an available shard failing the check is registered again
+	mock.acquireErrs = map[shard.Key]error{available: xerrors.New("bad index")}
+	h.Check(ctx)
+	report = h.Report()
+	require.Len(t, report, 1)
+	require.Equal(t, available.String(), report[0].Key)
+	h.repairDue(ctx)
+	require.Equal(t, []shard.Key{available}, mock.destroyed)
+	require.Equal(t, []shard.Key{available}, mock.registered)
+	require.Empty(t, h.Report())
+}
+
+// TestHealthCheckerCollectedPiece verifies that the shard of a piece removed from the piece
+// storages is moved to the lotus mount instead of being recovered on the piece storage mount
+func TestHealthCheckerCollectedPiece(t *testing.T) {
+	ctx := context.Background()
+	bgen := blocksutil.NewBlockGenerator()
+	pieceCid := bgen.Next().Cid()
+	key := shard.KeyFromCID(pieceCid)
+
+	storages, err := piece.NewPieceStorageManager(ctx, &config.MarketConfig{
+		PieceStorages: []config.PieceStorageConfig{{Path: config.PieceStorageString("fs:" + t.TempDir())}},
+	})
+	require.NoError(t, err)
+	dagst, w, err := NewDAGStore(&config.DAGStoreConfig{
+		RootDir:    t.TempDir(),
+		GCInterval: config.Duration(1 * time.Millisecond),
+	}, unsealedMinerAPI{}, storages, nil)
+	require.NoError(t, err)
+	defer dagst.Close() //nolint:errcheck
+
+	mock := &healthDagStore{
+		t: t,
+		shards: map[shard.Key]dagstore.ShardInfo{
+			key: {ShardState: dagstore.ShardStateErrored, Error: xerrors.New("piece not found")},
+		},
+	}
+	w.dagst = mock
+
+	h := NewHealthChecker(config.DAGStoreHealthConfig{
+		SampleSize: 10,
+		MinBackoff: config.Duration(time.Minute),
+		MaxBackoff: config.Duration(time.Minute),
+	}, w, &recordJournal{})
+	h.Check(ctx)
+	require.Len(t, h.Report(), 1)
+	h.repairDue(ctx)
+
+	require.Equal(t, 0, mock.recovers)
+	require.Equal(t, []shard.Key{key}, mock.destroyed)
+	require.Equal(t, []shard.Key{key}, mock.registered)
+	require.IsType(t, &LotusMount{}, mock.mounts[0])
+	require.Empty(t, h.Report())
+}
+
+type healthDagStore struct {
+	dagstore.Interface
+	t *testing.T
+
+	shards      map[shard.Key]dagstore.ShardInfo
+	recoverErrs []error
+	acquireErrs map[shard.Key]error
+
+	recovers   int
+	destroyed  []shard.Key
+	registered []shard.Key
+	mounts     []mount.Mount
+}
+
+func (m *healthDagStore) AllShardsInfo() dagstore.AllShardsInfo {
+	out := make(dagstore.AllShardsInfo, len(m.shards))
+	for k, info := range m.shards {
+		out[k] = info
+	}
+	return out
+}
+
+func (m *healthDagStore) GetShardInfo(k shard.Key) (dagstore.ShardInfo, error) {
+	info, ok := m.shards[k]
+	if !ok {
+		return dagstore.ShardInfo{}, dagstore.ErrShardUnknown
+	}
+	return info, nil
+}
+
+func (m *healthDagStore) AcquireShard(ctx context.Context, key shard.Key, out chan dagstore.ShardResult, _ dagstore.AcquireOpts) error {
+	if err := m.acquireErrs[key]; err != nil {
+		out <- dagstore.ShardResult{Key: key, Error: err}
+		return nil
+	}
+	out <- dagstore.ShardResult{Key: key, Accessor: getShardAccessor(m.t)}
+	return nil
+}
+
+func (m *healthDagStore) RecoverShard(ctx context.Context, key shard.Key, out chan dagstore.ShardResult, _ dagstore.RecoverOpts) error {
+	m.recovers++
+	err := m.recoverErrs[0]
+	m.recoverErrs = m.recoverErrs[1:]
+	if err == nil {
+		m.shards[key] = dagstore.ShardInfo{ShardState: dagstore.ShardStateAvailable}
+	}
+	out <- dagstore.ShardResult{Key: key, Error: err}
+	return nil
+}
+
+func (m *healthDagStore) DestroyShard(ctx context.Context, key shard.Key, out chan dagstore.ShardResult, _ dagstore.DestroyOpts) error {
+	m.destroyed = append(m.destroyed, key)
+	delete(m.shards, key)
+	out <- dagstore.ShardResult{Key: key}
+	return nil
+}
+
+func (m *healthDagStore) RegisterShard(ctx context.Context, key shard.Key, mnt mount.Mount, out chan dagstore.ShardResult, opts dagstore.RegisterOpts) error {
+	m.registered = append(m.registered, key)
+	m.mounts = append(m.mounts, mnt)
+	m.shards[key] = dagstore.ShardInfo{ShardState: dagstore.ShardStateAvailable}
+	out <- dagstore.ShardResult{Key: key}
+	return nil
+}
+
+type unsealedMinerAPI struct {
+	mockLotusMount
+}
+
+func (m unsealedMinerAPI) IsUnsealed(ctx context.Context, pieceCid cid.Cid) (bool, error) {
+	return true, nil
+}
+
+type recordJournal struct {
+	events []interface{}
+}
+
+func (j *recordJournal) RegisterEventType(system, event string) journal.EventType {
+	return journal.EventType{System: system, Event: event}
+}
+
+func (j *recordJournal) RecordEvent(_ journal.EventType, supplier func() interface{}) {
+	j.events = append(j.events, supplier())
+}
+
+func (j *recordJournal) Close() error { return nil }
@@ -182,3 +182,83 @@ func (m *minerAPI) GetUnpaddedCARSize(ctx context.Context, pieceCid cid.Cid) (ui
 
 	return uint64(len), nil
 }
+
+// multiMinerAPI serves pieces of several miners, every request is tried against
+// the MinerAPI of each miner until one of them has the piece.
+type multiMinerAPI []MinerAPI
+
+var _ MinerAPI = (multiMinerAPI)(nil)
+
+// NewMultiMinerAPI combines the MinerAPI of each miner served by the market.
+func NewMultiMinerAPI(apis ...MinerAPI) MinerAPI {
+	if len(apis) == 1 {
+		return apis[0]
+	}
+	return multiMinerAPI(apis)
+}
+
+func (m multiMinerAPI) Start(ctx context.Context) error {
+	for _, api := range m {
+		if err := api.Start(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m multiMinerAPI) IsUnsealed(ctx context.Context, pieceCid cid.Cid) (bool, error) {
+	found := false
+	lastErr := xerrors.Errorf("no miner found for piece %s", pieceCid)
+	for _, api := range m {
+		isUnsealed, err := api.IsUnsealed(ctx, pieceCid)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if isUnsealed {
+			return true, nil
+		}
+		found = true
+	}
+
+	if found {
+		return false, nil
+	}
+	return false, lastErr
+}
+
+// FetchUnsealedPiece prefers a miner which has an unsealed copy of the piece, unsealing is expensive
+func (m multiMinerAPI) FetchUnsealedPiece(ctx context.Context, pieceCid cid.Cid) (io.ReadCloser, error) {
+	var unsealed, others []MinerAPI
+	for _, api := range m {
+		if isUnsealed, err := api.IsUnsealed(ctx, pieceCid); err == nil && isUnsealed {
+			unsealed = append(unsealed, api)
+			continue
+		}
+		others = append(others, api)
+	}
+
+	lastErr := xerrors.Errorf("no miner found for piece %s", pieceCid)
+	for _, api := range append(unsealed, others...) {
+		reader, err := api.FetchUnsealedPiece(ctx, pieceCid)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return reader, nil
+	}
+	return nil, lastErr
+}
+
+func (m multiMinerAPI) GetUnpaddedCARSize(ctx context.Context, pieceCid cid.Cid) (uint64, error) {
+	lastErr := xerrors.Errorf("no miner found for piece %s", pieceCid)
+	for _, api := range m {
+		size, err := api.GetUnpaddedCARSize(ctx, pieceCid)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return size, nil
+	}
+	return 0, lastErr
+}
@@ -181,6 +181,51 @@ func TestThrottle(t *testing.T) {
 
 }
 
+func TestMultiMinerAPI(t *testing.T) {
+	ctx := context.Background()
+	cid1, err := cid.Parse("bafkqaaa")
+	require.NoError(t, err)
+
+	rpn := &mockRPN{
+		sectors: map[abi.SectorNumber]string{
+			unsealedSectorID: "unsealed",
+			sealedSectorID:   "sealed",
+		},
+	}
+
+	// the piece is only sealed in the first miner and unsealed in the second one
+	ps1 := getPieceStore(t)
+	ps2 := getPieceStore(t)
+	ps3 := getPieceStore(t)
+	require.NoError(t, ps1.AddDealForPiece(cid1, piecestore.DealInfo{SectorID: sealedSectorID, Length: 10}))
+	require.NoError(t, ps2.AddDealForPiece(cid1, piecestore.DealInfo{SectorID: unsealedSectorID, Length: 10}))
+
+	api := NewMultiMinerAPI(NewMinerAPI(ps3, rpn, 100), NewMinerAPI(ps1, rpn, 100), NewMinerAPI(ps2, rpn, 100))
+	require.NoError(t, api.Start(ctx))
+
+	uns, err := api.IsUnsealed(ctx, cid1)
+	require.NoError(t, err)
+	require.True(t, uns)
+
+	r, err := api.FetchUnsealedPiece(ctx, cid1)
+	require.NoError(t, err)
+	bz, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, "unsealed", string(bz))
+
+	size, err := api.GetUnpaddedCARSize(ctx, cid1)
+	require.NoError(t, err)
+	require.EqualValues(t, 10, size)
+
+	// no miner has the piece
+	cid2, err := cid.Parse("bafkqaalb")
+	require.NoError(t, err)
+	_, err = api.IsUnsealed(ctx, cid2)
+	require.Error(t, err)
+	_, err = api.FetchUnsealedPiece(ctx, cid2)
+	require.Error(t, err)
+}
+
 func getPieceStore(t *testing.T) piecestore.PieceStore {
 	ps, err := piecestoreimpl.NewPieceStore(ds_sync.MutexWrap(ds.NewMapDatastore()))
 	require.NoError(t, err)
@@ -0,0 +1,247 @@
+package dagstore
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/dagstore/mount"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/venus-market/piece"
+)
+
+const pieceStorageScheme = "piecestorage"
+
+var _ mount.Mount = (*PieceStorageMount)(nil)
+
+// pieceStorageMountTemplate returns a templated PieceStorageMount the mount registry clones to
+// reinstantiate the mounts of the shards after a restart.
+func pieceStorageMountTemplate(api MinerAPI, storages *piece.PieceStorageManager) *PieceStorageMount {
+	return &PieceStorageMount{API: api, Storages: storages}
+}
+
+// PieceStorageMount is a DAGStore mount implementation that reads deal data straight from the
+// piece storages, without going through the sector accessor, and supports random access. A piece
+// removed from the piece storages is fetched unsealed through the miner api like the lotus mount.
+type PieceStorageMount struct {
+	API      MinerAPI
+	Storages *piece.PieceStorageManager
+	PieceCid cid.Cid
+}
+
+func NewPieceStorageMount(pieceCid cid.Cid, api MinerAPI, storages *piece.PieceStorageManager) (*PieceStorageMount, error) {
+	return &PieceStorageMount{
+		PieceCid: pieceCid,
+		API:      api,
+		Storages: storages,
+	}, nil
+}
+
+func (p *PieceStorageMount) Serialize() *url.URL {
+	return &url.URL{
+		Host: p.PieceCid.String(),
+	}
+}
+
+func (p *PieceStorageMount) Deserialize(u *url.URL) error {
+	pieceCid, err := cid.Decode(u.Host)
+	if err != nil {
+		return xerrors.Errorf("failed to parse PieceCid from host '%s': %w", u.Host, err)
+	}
+	p.PieceCid = pieceCid
+	return nil
+}
+
+func (p *PieceStorageMount) Fetch(ctx context.Context) (mount.Reader, error) {
+	loc, err := p.findStorage()
+	if xerrors.Is(err, piece.ErrPieceNotFound) {
+		return p.fetchUnsealed(ctx)
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("failed to find piece %s in piece storages: %w", p.PieceCid, err)
+	}
+	size, err := p.API.GetUnpaddedCARSize(ctx, p.PieceCid)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to fetch piece size for piece %s: %w", p.PieceCid, err)
+	}
+	return &pieceReader{
+		ctx:     ctx,
+		storage: loc,
+		name:    p.PieceCid.String(),
+		size:    int64(size),
+	}, nil
+}
+
+func (p *PieceStorageMount) Info() mount.Info {
+	return mount.Info{
+		Kind:             mount.KindLocal,
+		AccessSequential: true,
+		AccessSeek:       true,
+		AccessRandom:     true,
+	}
+}
+
+func (p *PieceStorageMount) Close() error {
+	return nil
+}
+
+func (p *PieceStorageMount) Stat(ctx context.Context) (mount.Stat, error) {
+	size, err := p.API.GetUnpaddedCARSize(ctx, p.PieceCid)
+	if err != nil {
+		return mount.Stat{}, xerrors.Errorf("failed to fetch piece size for piece %s: %w", p.PieceCid, err)
+	}
+	ready := true
+	_, err = p.findStorage()
+	if xerrors.Is(err, piece.ErrPieceNotFound) {
+		if ready, err = p.API.IsUnsealed(ctx, p.PieceCid); err != nil {
+			return mount.Stat{}, xerrors.Errorf("failed to verify if we have the unsealed piece %s: %w", p.PieceCid, err)
+		}
+	} else if err != nil {
+		return mount.Stat{}, xerrors.Errorf("failed to find piece %s in piece storages: %w", p.PieceCid, err)
+	}
+
+	return mount.Stat{
+		Exists: true,
+		Size:   int64(size),
+		Ready:  ready,
+	}, nil
+}
+
+// fetchUnsealed copies the unsealed piece to a temporary file, the readers of the mount expect
+// random access which the stream of the miner api doesn't offer. The file is removed on Close.
+func (p *PieceStorageMount) fetchUnsealed(ctx context.Context) (mount.Reader, error) {
+	r, err := p.API.FetchUnsealedPiece(ctx, p.PieceCid)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to fetch unsealed piece %s: %w", p.PieceCid, err)
+	}
+	defer r.Close() //nolint:errcheck
+
+	f, err := ioutil.TempFile("", "piece-mount-*")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return nil, xerrors.Errorf("failed to copy unsealed piece %s: %w", p.PieceCid, err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return nil, err
+	}
+	return &tempFileReader{File: f}, nil
+}
+
+// tempFileReader removes its file once closed
+type tempFileReader struct {
+	*os.File
+}
+
+func (r *tempFileReader) Close() error {
+	err := r.File.Close()
+	if rerr := os.Remove(r.Name()); rerr != nil && err == nil {
+		err = rerr
+	}
+	return err
+}
+
+func (p *PieceStorageMount) findStorage() (*piece.PieceStorageLocation, error) {
+	if p.Storages == nil {
+		return nil, piece.ErrPieceNotFound
+	}
+	return p.Storages.FindStorageForRead(p.PieceCid.String())
+}
+
+// pieceReader reads a piece from a piece storage, every seek or random read opens a new read at
+// the offset
+type pieceReader struct {
+	ctx     context.Context
+	storage piece.IPieceStorage
+	name    string
+	size    int64
+
+	offset int64
+	// sequential read from offset, opened by the first Read
+	r io.ReadCloser
+}
+
+var _ mount.Reader = (*pieceReader)(nil)
+
+func (r *pieceReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.r == nil {
+		rd, err := r.storage.ReadOffset(r.ctx, r.name, abi.UnpaddedPieceSize(r.offset), abi.UnpaddedPieceSize(r.size-r.offset))
+		if err != nil {
+			return 0, xerrors.Errorf("read piece %s at %d: %w", r.name, r.offset, err)
+		}
+		r.r = rd
+	}
+	n, err := r.r.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+func (r *pieceReader) ReadAt(p []byte, off int64) (int, error) {
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	size := int64(len(p))
+	if off+size > r.size {
+		size = r.size - off
+	}
+	rd, err := r.storage.ReadOffset(r.ctx, r.name, abi.UnpaddedPieceSize(off), abi.UnpaddedPieceSize(size))
+	if err != nil {
+		return 0, xerrors.Errorf("read piece %s at %d: %w", r.name, off, err)
+	}
+	defer rd.Close() //nolint:errcheck
+
+	n, err := io.ReadFull(rd, p[:size])
+	if err == io.ErrUnexpectedEOF || (err == nil && n < len(p)) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (r *pieceReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, xerrors.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, xerrors.Errorf("negative offset %d", offset)
+	}
+	if offset != r.offset {
+		if err := r.closeReader(); err != nil {
+			return 0, err
+		}
+		r.offset = offset
+	}
+	return r.offset, nil
+}
+
+func (r *pieceReader) Close() error {
+	return r.closeReader()
+}
+
+func (r *pieceReader) closeReader() error {
+	if r.r == nil {
+		return nil
+	}
+	err := r.r.Close()
+	r.r = nil
+	return err
+}
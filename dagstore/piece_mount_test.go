@@ -0,0 +1,104 @@
+package dagstore
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	blocksutil "github.com/ipfs/go-ipfs-blocksutil"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/dagstore/mount"
+
+	"github.com/filecoin-project/venus-market/config"
+	mock_dagstore2 "github.com/filecoin-project/venus-market/dagstore/mocks"
+	"github.com/filecoin-project/venus-market/piece"
+)
+
+func TestPieceStorageMount(t *testing.T) {
+	ctx := context.Background()
+	bgen := blocksutil.NewBlockGenerator()
+	pieceCid := bgen.Next().Cid()
+	missingCid := bgen.Next().Cid()
+
+	dir := t.TempDir()
+	data := []byte("0123456789abcdef")
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, pieceCid.String()), data, 0644))
+	storages, err := piece.NewPieceStorageManager(ctx, &config.MarketConfig{
+		PieceStorages: []config.PieceStorageConfig{{Path: config.PieceStorageString("fs:" + dir)}},
+	})
+	require.NoError(t, err)
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	api := mock_dagstore2.NewMockLotusAccessor(mockCtrl)
+	api.EXPECT().GetUnpaddedCARSize(gomock.Any(), gomock.Any()).Return(uint64(len(data)), nil).AnyTimes()
+
+	// the registry brings the mount back from its url
+	registry := mount.NewRegistry()
+	require.NoError(t, registry.Register(pieceStorageScheme, pieceStorageMountTemplate(api, storages)))
+	u, err := url.Parse(pieceStorageScheme + "://" + pieceCid.String())
+	require.NoError(t, err)
+	mnt, err := registry.Instantiate(u)
+	require.NoError(t, err)
+	require.Equal(t, mount.KindLocal, mnt.Info().Kind)
+
+	stat, err := mnt.Stat(ctx)
+	require.NoError(t, err)
+	require.True(t, stat.Ready)
+	require.EqualValues(t, len(data), stat.Size)
+
+	rd, err := mnt.Fetch(ctx)
+	require.NoError(t, err)
+	bz, err := ioutil.ReadAll(rd)
+	require.NoError(t, err)
+	require.Equal(t, data, bz)
+
+	buf := make([]byte, 4)
+	n, err := rd.ReadAt(buf, 10)
+	require.NoError(t, err)
+	require.Equal(t, "abcd", string(buf[:n]))
+	n, err = rd.ReadAt(buf, 14)
+	require.Equal(t, io.EOF, err)
+	require.Equal(t, "ef", string(buf[:n]))
+
+	off, err := rd.Seek(-6, io.SeekEnd)
+	require.NoError(t, err)
+	require.EqualValues(t, 10, off)
+	bz, err = ioutil.ReadAll(rd)
+	require.NoError(t, err)
+	require.Equal(t, "abcdef", string(bz))
+	require.NoError(t, rd.Close())
+
+	// a piece missing from the piece storages is read unsealed through the miner api
+	missing, err := NewPieceStorageMount(missingCid, api, storages)
+	require.NoError(t, err)
+	api.EXPECT().IsUnsealed(gomock.Any(), missingCid).Return(false, nil)
+	stat, err = missing.Stat(ctx)
+	require.NoError(t, err)
+	require.False(t, stat.Ready)
+	api.EXPECT().FetchUnsealedPiece(gomock.Any(), missingCid).Return(nil, xerrors.New("no unsealed copy"))
+	_, err = missing.Fetch(ctx)
+	require.Error(t, err)
+
+	api.EXPECT().IsUnsealed(gomock.Any(), missingCid).Return(true, nil)
+	stat, err = missing.Stat(ctx)
+	require.NoError(t, err)
+	require.True(t, stat.Ready)
+	api.EXPECT().FetchUnsealedPiece(gomock.Any(), missingCid).Return(ioutil.NopCloser(bytes.NewReader(data)), nil)
+	rd, err = missing.Fetch(ctx)
+	require.NoError(t, err)
+	n, err = rd.ReadAt(buf, 10)
+	require.NoError(t, err)
+	require.Equal(t, "abcd", string(buf[:n]))
+	bz, err = ioutil.ReadAll(rd)
+	require.NoError(t, err)
+	require.Equal(t, data, bz)
+	require.NoError(t, rd.Close())
+}
@@ -14,10 +14,13 @@ import (
 	levelds "github.com/ipfs/go-ds-leveldb"
 	measure "github.com/ipfs/go-ds-measure"
 	logging "github.com/ipfs/go-log/v2"
+	"github.com/multiformats/go-multihash"
 	ldbopts "github.com/syndtr/goleveldb/leveldb/opt"
 	"golang.org/x/xerrors"
 
 	"github.com/filecoin-project/venus-market/config"
+	"github.com/filecoin-project/venus-market/models"
+	"github.com/filecoin-project/venus-market/piece"
 
 	"github.com/filecoin-project/go-statemachine/fsm"
 
@@ -34,6 +37,11 @@ import (
 const (
 	maxRecoverAttempts = 1
 	shardRegMarker     = ".shard-registration-complete"
+	mountMigrateMarker = ".piece-storage-mount-migration-complete"
+	topIndexMarker     = ".top-level-index-complete"
+
+	// topIndexRetryInterval is how often the queued pieces are indexed again
+	topIndexRetryInterval = time.Minute
 )
 
 var log = logging.Logger("dagstore")
@@ -43,22 +51,33 @@ type Wrapper struct {
 	cancel       context.CancelFunc
 	backgroundWg sync.WaitGroup
 
-	cfg        *config.DAGStoreConfig
-	dagst      dagstore.Interface
-	minerAPI   MinerAPI
-	failureCh  chan dagstore.ShardResult
-	traceCh    chan dagstore.Trace
-	gcInterval time.Duration
+	cfg      *config.DAGStoreConfig
+	dagst    dagstore.Interface
+	minerAPI MinerAPI
+	// nil if the shards are only read through the miner api
+	pieceStorages *piece.PieceStorageManager
+	indices       index.FullIndexRepo
+	failureCh     chan dagstore.ShardResult
+	traceCh       chan dagstore.Trace
+	gcInterval    time.Duration
+	// recovers the failed shards instead of RecoverImmediately if set
+	health *HealthChecker
+	// the payload of the shards registered is added to topIndex if set
+	topIndex *piece.TopLevelIndex
+	indexCh  chan shard.Key
 }
 
 var _ stores.DAGStoreWrapper = (*Wrapper)(nil)
 
-func NewDAGStore(cfg *config.DAGStoreConfig, minerApi MinerAPI) (*dagstore.DAGStore, *Wrapper, error) {
+func NewDAGStore(cfg *config.DAGStoreConfig, minerApi MinerAPI, pieceStorages *piece.PieceStorageManager, topIndex *piece.TopLevelIndex) (*dagstore.DAGStore, *Wrapper, error) {
 	// construct the DAG Store.
 	registry := mount.NewRegistry()
 	if err := registry.Register(lotusScheme, mountTemplate(minerApi)); err != nil {
 		return nil, nil, xerrors.Errorf("failed to create registry: %w", err)
 	}
+	if err := registry.Register(pieceStorageScheme, pieceStorageMountTemplate(minerApi, pieceStorages)); err != nil {
+		return nil, nil, xerrors.Errorf("failed to create registry: %w", err)
+	}
 
 	// The dagstore will write Shard failures to the `failureCh` here.
 	failureCh := make(chan dagstore.ShardResult, 1)
@@ -102,12 +121,16 @@ func NewDAGStore(cfg *config.DAGStoreConfig, minerApi MinerAPI) (*dagstore.DAGSt
 	}
 
 	w := &Wrapper{
-		cfg:        cfg,
-		dagst:      dagst,
-		minerAPI:   minerApi,
-		failureCh:  failureCh,
-		traceCh:    traceCh,
-		gcInterval: time.Duration(cfg.GCInterval),
+		cfg:           cfg,
+		dagst:         dagst,
+		minerAPI:      minerApi,
+		pieceStorages: pieceStorages,
+		indices:       irepo,
+		failureCh:     failureCh,
+		traceCh:       traceCh,
+		gcInterval:    time.Duration(cfg.GCInterval),
+		topIndex:      topIndex,
+		indexCh:       make(chan shard.Key, 256),
 	}
 
 	return dagst, w, nil
@@ -148,12 +171,35 @@ func (w *Wrapper) Start(ctx context.Context) error {
 	go w.traceLoop()
 
 	// Run a go-routine for shard recovery
-	if dss, ok := w.dagst.(*dagstore.DAGStore); ok {
+	if w.health != nil {
+		w.backgroundWg.Add(1)
+		go w.health.run(w.ctx, w.failureCh)
+	} else if dss, ok := w.dagst.(*dagstore.DAGStore); ok {
 		w.backgroundWg.Add(1)
 		go dagstore.RecoverImmediately(w.ctx, dss, w.failureCh, maxRecoverAttempts, w.backgroundWg.Done)
 	}
 
-	return w.dagst.Start(ctx)
+	if err := w.dagst.Start(ctx); err != nil {
+		return err
+	}
+
+	// Run a go-routine to add the payload of the registered shards to the top level index
+	if w.topIndex != nil {
+		w.backgroundWg.Add(1)
+		go w.indexLoop()
+	}
+
+	// Move the shards registered before the piece storage mount onto it
+	if w.pieceStorages != nil {
+		w.backgroundWg.Add(1)
+		go func() {
+			defer w.backgroundWg.Done()
+			if _, err := w.MigrateMounts(w.ctx); err != nil {
+				log.Errorf("failed to migrate shards to the piece storage mount: %s", err)
+			}
+		}()
+	}
+	return nil
 }
 
 func (w *Wrapper) traceLoop() {
@@ -168,12 +214,181 @@ func (w *Wrapper) traceLoop() {
 				"op-type", tr.Op.String(),
 				"after", tr.After.String())
 
+			if tr.After.ShardState == dagstore.ShardStateAvailable {
+				w.queueIndex(tr.Key)
+			}
+
 		case <-w.ctx.Done():
 			return
 		}
 	}
 }
 
+// queueIndex records the shard in the queue of the top level index, then wakes up the index loop.
+// The queue is persisted, a shard not handed to the loop is indexed on the next retry.
+func (w *Wrapper) queueIndex(key shard.Key) {
+	if w.topIndex == nil {
+		return
+	}
+	pieceCid, err := cid.Decode(key.String())
+	if err != nil {
+		log.Warnw("skip shard with invalid piece CID", "shard-key", key.String(), "error", err)
+		return
+	}
+	has, err := w.topIndex.HasPiece(pieceCid)
+	if err != nil {
+		log.Warnw("failed to check the top level index", "shard-key", key.String(), "error", err)
+	}
+	if has {
+		return
+	}
+	if err := w.topIndex.QueuePiece(pieceCid); err != nil {
+		log.Errorw("failed to queue shard for the top level index", "shard-key", key.String(), "error", err)
+		return
+	}
+
+	select {
+	case w.indexCh <- key:
+	default:
+		log.Debugw("top level index queue is full, retry later", "shard-key", key.String())
+	}
+}
+
+// indexLoop adds the shards registered before the top level index existed, then the shards
+// queued
+func (w *Wrapper) indexLoop() {
+	defer w.backgroundWg.Done()
+
+	if err := w.fillTopIndex(w.ctx); err != nil {
+		log.Errorf("failed to fill the top level index: %s", err)
+	}
+	w.indexQueued(w.ctx)
+
+	ticker := time.NewTicker(topIndexRetryInterval)
+	defer ticker.Stop()
+
+	for w.ctx.Err() == nil {
+		select {
+		case key := <-w.indexCh:
+			if err := w.indexShard(w.ctx, key); err != nil {
+				log.Warnw("failed to add shard to the top level index", "shard-key", key.String(), "error", err)
+			}
+
+		case <-ticker.C:
+			if err := w.fillTopIndex(w.ctx); err != nil {
+				log.Errorf("failed to fill the top level index: %s", err)
+			}
+			w.indexQueued(w.ctx)
+
+		case <-w.ctx.Done():
+			return
+		}
+	}
+}
+
+// indexQueued adds the pieces of the queue to the top level index, the pieces failing stay queued
+func (w *Wrapper) indexQueued(ctx context.Context) {
+	pieces, err := w.topIndex.QueuedPieces()
+	if err != nil {
+		log.Errorf("failed to list the pieces queued for the top level index: %s", err)
+		return
+	}
+	for _, pieceCid := range pieces {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := w.indexShard(ctx, shard.KeyFromCID(pieceCid)); err != nil {
+			log.Warnw("failed to add shard to the top level index", "pieceCID", pieceCid, "error", err)
+		}
+	}
+}
+
+// fillTopIndex adds every registered shard with an index to the top level index, the shards not
+// initialized yet are added once they become available. The index is marked filled once it holds
+// all the shards.
+func (w *Wrapper) fillTopIndex(ctx context.Context) error {
+	filled, err := w.TopIndexFilled()
+	if err != nil {
+		return err
+	}
+	if filled {
+		return nil
+	}
+
+	for key := range w.dagst.AllShardsInfo() {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := w.indexShard(ctx, key); err != nil {
+			log.Warnw("failed to add shard to the top level index", "shard-key", key.String(), "error", err)
+		}
+	}
+
+	// shards registered meanwhile are checked too
+	var missing int
+	for key := range w.dagst.AllShardsInfo() {
+		pieceCid, err := cid.Decode(key.String())
+		if err != nil {
+			return xerrors.Errorf("invalid piece CID of shard %s: %w", key, err)
+		}
+		has, err := w.topIndex.HasPiece(pieceCid)
+		if err != nil {
+			return err
+		}
+		if !has {
+			missing++
+		}
+	}
+	if missing > 0 {
+		return xerrors.Errorf("%d shards are not in the top level index yet", missing)
+	}
+	log.Info("top level index filled")
+	return w.markComplete(topIndexMarker)
+}
+
+func (w *Wrapper) indexShard(ctx context.Context, key shard.Key) error {
+	pieceCid, err := cid.Decode(key.String())
+	if err != nil {
+		return xerrors.Errorf("invalid piece CID: %w", err)
+	}
+	has, err := w.topIndex.HasPiece(pieceCid)
+	if err != nil || has {
+		return err
+	}
+	// a shard is never acquired only to be indexed, that would unseal the sectors of lazy shards
+	mhs, err := w.indexedMultihashes(pieceCid)
+	if xerrors.Is(err, errShardNotIndexed) {
+		log.Debugw("shard not indexed yet, add it to the top level index once available", "shard-key", key.String())
+		return w.topIndex.QueuePiece(pieceCid)
+	}
+	if err != nil {
+		return err
+	}
+	return w.topIndex.AddPiece(pieceCid, mhs)
+}
+
+// TopIndexFilled reports whether every shard registered before the top level index existed was
+// added to it
+func (w *Wrapper) TopIndexFilled() (bool, error) {
+	if w.topIndex == nil {
+		return false, nil
+	}
+	return w.markerExists(topIndexMarker)
+}
+
+// DropCidInfos deletes the legacy cid infos once the top level index is filled, it returns the
+// number of records deleted
+func (w *Wrapper) DropCidInfos(cidInfoDS models.CIDInfoDS) (int, error) {
+	filled, err := w.TopIndexFilled()
+	if err != nil {
+		return 0, xerrors.Errorf("get top level index status: %w", err)
+	}
+	if !filled {
+		return 0, xerrors.Errorf("the dagstore top level index isn't filled yet, the cid infos are still needed")
+	}
+	return piece.DropCidInfos(cidInfoDS)
+}
+
 func (w *Wrapper) gcLoop() {
 	defer w.backgroundWg.Done()
 
@@ -248,11 +463,10 @@ func (w *Wrapper) LoadShard(ctx context.Context, pieceCid cid.Cid) (stores.Closa
 }
 
 func (w *Wrapper) RegisterShard(ctx context.Context, pieceCid cid.Cid, carPath string, eagerInit bool, resch chan dagstore.ShardResult) error {
-	// Create a lotus mount with the piece CID
 	key := shard.KeyFromCID(pieceCid)
-	mt, err := NewLotusMount(pieceCid, w.minerAPI)
+	mt, err := w.newMount(pieceCid)
 	if err != nil {
-		return xerrors.Errorf("failed to create lotus mount for piece CID %s: %w", pieceCid, err)
+		return err
 	}
 
 	// Register the shard
@@ -266,9 +480,128 @@ func (w *Wrapper) RegisterShard(ctx context.Context, pieceCid cid.Cid, carPath s
 	}
 	log.Debugf("successfully submitted Register Shard request for piece CID %s with eagerInit=%t", pieceCid, eagerInit)
 
+	// a lazy shard is added to the top level index once it is initialized by its first acquisition
+	w.queueIndex(key)
+
 	return nil
 }
 
+// newMount creates a piece storage mount if a piece storage has the piece, a lotus mount otherwise
+func (w *Wrapper) newMount(pieceCid cid.Cid) (mount.Mount, error) {
+	if w.pieceStorages != nil {
+		_, err := w.pieceStorages.FindStorageForRead(pieceCid.String())
+		if err == nil {
+			mt, err := NewPieceStorageMount(pieceCid, w.minerAPI, w.pieceStorages)
+			if err != nil {
+				return nil, xerrors.Errorf("failed to create piece storage mount for piece CID %s: %w", pieceCid, err)
+			}
+			return mt, nil
+		}
+		if !xerrors.Is(err, piece.ErrPieceNotFound) {
+			log.Warnw("failed to find piece in piece storages, fall back to lotus mount", "pieceCID", pieceCid, "error", err)
+		}
+	}
+
+	mt, err := NewLotusMount(pieceCid, w.minerAPI)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to create lotus mount for piece CID %s: %w", pieceCid, err)
+	}
+	return mt, nil
+}
+
+var errShardNotIndexed = xerrors.New("shard isn't indexed")
+
+// indexedMultihashes returns the multihashes of the blocks of the piece read from the full index of
+// its shard, errShardNotIndexed if the shard has no index that can be iterated
+func (w *Wrapper) indexedMultihashes(pieceCid cid.Cid) ([]multihash.Multihash, error) {
+	idx, err := w.indices.GetFullIndex(shard.KeyFromCID(pieceCid))
+	if err != nil {
+		return nil, xerrors.Errorf("%w: %s", errShardNotIndexed, err)
+	}
+	it, ok := idx.(iterableIndex)
+	if !ok {
+		return nil, xerrors.Errorf("%w: index of type %T can't be iterated", errShardNotIndexed, idx)
+	}
+	var mhs []multihash.Multihash
+	err = it.ForEach(func(mh multihash.Multihash, _ uint64) error {
+		mhs = append(mhs, mh)
+		return nil
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("%w: iterate index: %s", errShardNotIndexed, err)
+	}
+	return mhs, nil
+}
+
+// PayloadMultihashes returns the multihashes of the blocks of the piece, read from the index of its
+// shard or from the shard itself if the index can't be iterated. The shard is only read when the
+// piece is unsealed, a sector isn't unsealed for it.
+func (w *Wrapper) PayloadMultihashes(ctx context.Context, pieceCid cid.Cid) ([]multihash.Multihash, error) {
+	mhs, err := w.indexedMultihashes(pieceCid)
+	if err == nil {
+		return mhs, nil
+	}
+	unsealed, uerr := w.minerAPI.IsUnsealed(ctx, pieceCid)
+	if uerr != nil {
+		return nil, xerrors.Errorf("check piece %s unsealed: %w", pieceCid, uerr)
+	}
+	if !unsealed {
+		return nil, xerrors.Errorf("piece %s is sealed, read it once its shard is available: %w", pieceCid, err)
+	}
+	log.Infow("reading the shard for its payload", "pieceCID", pieceCid, "reason", err)
+
+	bs, err := w.LoadShard(ctx, pieceCid)
+	if err != nil {
+		return nil, err
+	}
+	defer bs.Close() //nolint:errcheck
+
+	keys, err := bs.AllKeysChan(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read blocks of piece CID %s: %w", pieceCid, err)
+	}
+	var mhs []multihash.Multihash
+	for c := range keys {
+		mhs = append(mhs, c.Hash())
+	}
+	return mhs, ctx.Err()
+}
+
+// iterableIndex is a CARv2 index which can list the multihashes it holds
+type iterableIndex interface {
+	ForEach(func(mh multihash.Multihash, offset uint64) error) error
+}
+
+// DestroyShard removes the shard of the piece and its index, it's a no-op for an unknown shard
+func (w *Wrapper) DestroyShard(ctx context.Context, pieceCid cid.Cid) error {
+	key := shard.KeyFromCID(pieceCid)
+	resch := make(chan dagstore.ShardResult, 1)
+	err := w.dagst.DestroyShard(ctx, key, resch, dagstore.DestroyOpts{})
+	if errors.Is(err, dagstore.ErrShardUnknown) {
+		return w.dropTopIndex(pieceCid)
+	}
+	if err != nil {
+		return xerrors.Errorf("failed to schedule destroy shard for piece CID %s: %w", pieceCid, err)
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case res := <-resch:
+		if res.Error != nil {
+			return xerrors.Errorf("failed to destroy shard for piece CID %s: %w", pieceCid, res.Error)
+		}
+	}
+	return w.dropTopIndex(pieceCid)
+}
+
+func (w *Wrapper) dropTopIndex(pieceCid cid.Cid) error {
+	if w.topIndex == nil {
+		return nil
+	}
+	return w.topIndex.DropPiece(pieceCid)
+}
+
 func (w *Wrapper) MigrateDeals(ctx context.Context, deals []storagemarket.MinerDeal) (bool, error) {
 	log := log.Named("migrator")
 
@@ -374,10 +707,63 @@ func (w *Wrapper) MigrateDeals(ctx context.Context, deals []storagemarket.MinerD
 	return true, nil
 }
 
+// MigrateMounts registers again, on the piece storage mount, the shards registered before it
+// existed whose piece is in the piece storages. It runs once, the shards are initialized again
+// lazily and a shard acquired meanwhile is registered again by LoadShard.
+func (w *Wrapper) MigrateMounts(ctx context.Context) (bool, error) {
+	log := log.Named("migrator")
+
+	isComplete, err := w.markerExists(mountMigrateMarker)
+	if err != nil {
+		return false, xerrors.Errorf("failed to get mount migration status: %w", err)
+	}
+	if isComplete {
+		log.Info("no mount migration necessary; already marked complete")
+		return false, nil
+	}
+
+	var migrated int
+	for key := range w.dagst.AllShardsInfo() {
+		pieceCid, err := cid.Decode(key.String())
+		if err != nil {
+			log.Warnw("skip shard with invalid piece CID", "shard_key", key, "error", err)
+			continue
+		}
+		if _, err := w.pieceStorages.FindStorageForRead(pieceCid.String()); err != nil {
+			continue
+		}
+
+		if err := w.DestroyShard(ctx, pieceCid); err != nil {
+			log.Warnw("failed to destroy shard, keeping its mount", "pieceCID", pieceCid, "error", err)
+			continue
+		}
+		if err := stores.RegisterShardSync(ctx, w, pieceCid, "", false); err != nil {
+			log.Warnw("failed to register shard on the piece storage mount", "pieceCID", pieceCid, "error", err)
+			continue
+		}
+		migrated++
+	}
+	log.Infow("migrated shards to the piece storage mount", "total", migrated)
+
+	if err := w.markComplete(mountMigrateMarker); err != nil {
+		return true, xerrors.Errorf("failed to mark mount migration as complete: %w", err)
+	}
+	return true, nil
+}
+
 // Check for the existence of a "marker" file indicating that the migration
 // has completed
 func (w *Wrapper) registrationComplete() (bool, error) {
-	path := filepath.Join(w.cfg.RootDir, shardRegMarker)
+	return w.markerExists(shardRegMarker)
+}
+
+// Create a "marker" file indicating that the migration has completed
+func (w *Wrapper) markRegistrationComplete() error {
+	return w.markComplete(shardRegMarker)
+}
+
+func (w *Wrapper) markerExists(marker string) (bool, error) {
+	path := filepath.Join(w.cfg.RootDir, marker)
 	_, err := os.Stat(path)
 	if os.IsNotExist(err) {
 		return false, nil
@@ -388,9 +774,8 @@ func (w *Wrapper) registrationComplete() (bool, error) {
 	return true, nil
 }
 
-// Create a "marker" file indicating that the migration has completed
-func (w *Wrapper) markRegistrationComplete() error {
-	path := filepath.Join(w.cfg.RootDir, shardRegMarker)
+func (w *Wrapper) markComplete(marker string) error {
+	path := filepath.Join(w.cfg.RootDir, marker)
 	file, err := os.Create(path)
 	if err != nil {
 		return err
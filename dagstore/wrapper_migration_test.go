@@ -94,7 +94,7 @@ func TestShardRegistration(t *testing.T) {
 	cfg.RootDir = t.TempDir()
 
 	mapi := NewMinerAPI(ps, sa, 10)
-	dagst, w, err := NewDAGStore(cfg, mapi)
+	dagst, w, err := NewDAGStore(cfg, mapi, nil, nil)
 	require.NoError(t, err)
 	require.NotNil(t, dagst)
 	require.NotNil(t, w)
@@ -31,7 +31,7 @@ func TestWrapperAcquireRecovery(t *testing.T) {
 	dagst, w, err := NewDAGStore(&config.DAGStoreConfig{
 		RootDir:    t.TempDir(),
 		GCInterval: config.Duration(1 * time.Millisecond),
-	}, mockLotusMount{})
+	}, mockLotusMount{}, nil, nil)
 	require.NoError(t, err)
 
 	defer dagst.Close() //nolint:errcheck
@@ -82,7 +82,7 @@ func TestWrapperBackground(t *testing.T) {
 	dagst, w, err := NewDAGStore(&config.DAGStoreConfig{
 		RootDir:    t.TempDir(),
 		GCInterval: config.Duration(1 * time.Millisecond),
-	}, mockLotusMount{})
+	}, mockLotusMount{}, nil, nil)
 	require.NoError(t, err)
 
 	defer dagst.Close() //nolint:errcheck
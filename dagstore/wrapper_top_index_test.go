@@ -0,0 +1,180 @@
+package dagstore
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	ds_sync "github.com/ipfs/go-datastore/sync"
+	blocksutil "github.com/ipfs/go-ipfs-blocksutil"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/dagstore"
+	"github.com/filecoin-project/dagstore/shard"
+
+	"github.com/filecoin-project/go-fil-markets/stores"
+
+	"github.com/filecoin-project/venus-market/config"
+	"github.com/filecoin-project/venus-market/piece"
+)
+
+const carFixture = "./fixtures/sample-rw-bs-v2.car"
+
+func TestWrapperTopIndex(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bgen := blocksutil.NewBlockGenerator()
+	piece1, piece2 := bgen.Next().Cid(), bgen.Next().Cid()
+
+	api := &carMinerAPI{broken: map[cid.Cid]bool{piece2: true}}
+	topIndex := piece.NewTopLevelIndex(ds_sync.MutexWrap(datastore.NewMapDatastore()))
+	dagst, w, err := NewDAGStore(&config.DAGStoreConfig{
+		RootDir:    t.TempDir(),
+		GCInterval: config.Duration(time.Hour),
+	}, api, nil, topIndex)
+	require.NoError(t, err)
+	defer dagst.Close() //nolint:errcheck
+
+	// the index loop isn't started, the test drives it
+	go func() {
+		for {
+			select {
+			case <-w.traceCh:
+			case <-w.failureCh:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	require.NoError(t, dagst.Start(ctx))
+
+	// the shards are registered lazily, like the migrated deals, and queued for the index
+	for _, pieceCid := range []cid.Cid{piece1, piece2} {
+		require.NoError(t, stores.RegisterShardSync(ctx, w, pieceCid, "", false))
+	}
+	for _, info := range dagst.AllShardsInfo() {
+		require.Equal(t, dagstore.ShardStateNew, info.ShardState)
+	}
+	queued, err := topIndex.QueuedPieces()
+	require.NoError(t, err)
+	require.ElementsMatch(t, []cid.Cid{piece1, piece2}, queued)
+
+	// a lazy shard isn't acquired to be indexed, nothing is unsealed for the top level index
+	cidInfoDS := ds_sync.MutexWrap(datastore.NewMapDatastore())
+	require.NoError(t, cidInfoDS.Put(datastore.NewKey(piece1.String()), []byte("{}")))
+
+	require.Error(t, w.fillTopIndex(ctx))
+	w.indexQueued(ctx)
+	require.Equal(t, int32(0), atomic.LoadInt32(&api.fetches))
+	has, err := topIndex.HasPiece(piece1)
+	require.NoError(t, err)
+	require.False(t, has)
+
+	// the shard is indexed once a retrieval initialized it, the broken one stays queued
+	bs, err := w.LoadShard(ctx, piece1)
+	require.NoError(t, err)
+	require.NoError(t, bs.Close())
+	_, err = w.LoadShard(ctx, piece2)
+	require.Error(t, err)
+	fetches := atomic.LoadInt32(&api.fetches)
+
+	require.Error(t, w.fillTopIndex(ctx))
+	w.indexQueued(ctx)
+	require.Equal(t, fetches, atomic.LoadInt32(&api.fetches))
+	has, err = topIndex.HasPiece(piece1)
+	require.NoError(t, err)
+	require.True(t, has)
+	has, err = topIndex.HasPiece(piece2)
+	require.NoError(t, err)
+	require.False(t, has)
+	queued, err = topIndex.QueuedPieces()
+	require.NoError(t, err)
+	require.Equal(t, []cid.Cid{piece2}, queued)
+	filled, err := w.TopIndexFilled()
+	require.NoError(t, err)
+	require.False(t, filled)
+	_, err = w.DropCidInfos(cidInfoDS)
+	require.Error(t, err)
+	has, err = cidInfoDS.Has(datastore.NewKey(piece1.String()))
+	require.NoError(t, err)
+	require.True(t, has)
+
+	// the piece of the broken shard is back
+	api.broken = nil
+	resch := make(chan dagstore.ShardResult, 1)
+	require.NoError(t, dagst.RecoverShard(ctx, shard.KeyFromCID(piece2), resch, dagstore.RecoverOpts{}))
+	require.NoError(t, (<-resch).Error)
+
+	require.NoError(t, w.fillTopIndex(ctx))
+	filled, err = w.TopIndexFilled()
+	require.NoError(t, err)
+	require.True(t, filled)
+	queued, err = topIndex.QueuedPieces()
+	require.NoError(t, err)
+	require.Empty(t, queued)
+	dropped, err := w.DropCidInfos(cidInfoDS)
+	require.NoError(t, err)
+	require.Equal(t, 1, dropped)
+
+	// both shards hold the payload of the fixture
+	mhs, err := w.PayloadMultihashes(ctx, piece1)
+	require.NoError(t, err)
+	require.NotEmpty(t, mhs)
+	pieces, err := topIndex.PiecesContainingMultihash(mhs[0])
+	require.NoError(t, err)
+	require.ElementsMatch(t, []cid.Cid{piece1, piece2}, pieces)
+
+	// destroying a shard drops its payload from the index
+	require.NoError(t, w.DestroyShard(ctx, piece1))
+	has, err = topIndex.HasPiece(piece1)
+	require.NoError(t, err)
+	require.False(t, has)
+	pieces, err = topIndex.PiecesContainingMultihash(mhs[0])
+	require.NoError(t, err)
+	require.Equal(t, []cid.Cid{piece2}, pieces)
+
+	// the payload of a sealed piece without index isn't read
+	piece3 := bgen.Next().Cid()
+	api.broken = map[cid.Cid]bool{piece3: true}
+	require.NoError(t, stores.RegisterShardSync(ctx, w, piece3, "", false))
+	fetches = atomic.LoadInt32(&api.fetches)
+	_, err = w.PayloadMultihashes(ctx, piece3)
+	require.Error(t, err)
+	require.Equal(t, fetches, atomic.LoadInt32(&api.fetches))
+}
+
+// carMinerAPI serves the car fixture as the unsealed copy of every piece which isn't broken
+type carMinerAPI struct {
+	broken  map[cid.Cid]bool
+	fetches int32
+}
+
+func (m *carMinerAPI) Start(context.Context) error {
+	return nil
+}
+
+func (m *carMinerAPI) FetchUnsealedPiece(_ context.Context, pieceCid cid.Cid) (io.ReadCloser, error) {
+	atomic.AddInt32(&m.fetches, 1)
+	if m.broken[pieceCid] {
+		return nil, xerrors.Errorf("piece %s is gone", pieceCid)
+	}
+	return os.Open(carFixture)
+}
+
+func (m *carMinerAPI) GetUnpaddedCARSize(_ context.Context, _ cid.Cid) (uint64, error) {
+	st, err := os.Stat(carFixture)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(st.Size()), nil
+}
+
+func (m *carMinerAPI) IsUnsealed(_ context.Context, pieceCid cid.Cid) (bool, error) {
+	return !m.broken[pieceCid], nil
+}
@@ -0,0 +1,409 @@
+package dealfilter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/docker/go-units"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/mitchellh/go-homedir"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/venus/pkg/types"
+
+	"github.com/filecoin-project/venus-market/models"
+)
+
+var log = logging.Logger("dealfilter")
+
+// StorageDealPolicy holds the conditions a storage deal must meet, empty or zero fields are not checked
+type StorageDealPolicy struct {
+	// bounds of the padded piece size, such as "1MiB"
+	MinPieceSize string
+	MaxPieceSize string
+	// lowest price per GiB per epoch of unverified and verified deals, such as "0.0000000005 FIL"
+	MinPricePerGiBEpoch         string
+	MinVerifiedPricePerGiBEpoch string
+	// bounds of the deal duration, in epochs
+	MinDuration abi.ChainEpoch
+	MaxDuration abi.ChainEpoch
+	// window of the start epoch, in epochs after the chain head
+	MinStartDelay abi.ChainEpoch
+	MaxStartDelay abi.ChainEpoch
+	// deals and padded piece bytes accepted from a client in a day (UTC)
+	DailyDeals uint64
+	DailyBytes string
+}
+
+// ClientStorageDealRule overrides the fields set in Policy for the deals of Clients
+type ClientStorageDealRule struct {
+	Clients []string
+	Policy  StorageDealPolicy
+}
+
+// StorageDealRules is the content of the rule file of the storage deal filter
+type StorageDealRules struct {
+	// only deals of these clients are accepted if set
+	AllowClients []string
+	DenyClients  []string
+
+	Policy StorageDealPolicy
+	Client []ClientStorageDealRule
+}
+
+type dealPolicy struct {
+	minPieceSize, maxPieceSize   abi.PaddedPieceSize
+	minPrice, minVerifiedPrice   abi.TokenAmount
+	minDuration, maxDuration     abi.ChainEpoch
+	minStartDelay, maxStartDelay abi.ChainEpoch
+	dailyDeals, dailyBytes       uint64
+}
+
+// compiledRules is StorageDealRules with the values parsed
+type compiledRules struct {
+	raw   StorageDealRules
+	allow map[address.Address]struct{}
+	deny  map[address.Address]struct{}
+
+	policy  dealPolicy
+	clients map[address.Address]dealPolicy
+}
+
+var usagePrefix = datastore.NewKey("/usage")
+
+// clientUsage is what a client had accepted in a day, it's kept in the datastore
+type clientUsage struct {
+	Day          string
+	Deals, Bytes uint64
+}
+
+// StorageDealRuleFilter checks storage deals against the rules of a toml file, the file can be
+// reloaded while the market is running
+type StorageDealRuleFilter struct {
+	path string
+	spn  storagemarket.StorageProviderNode
+	// deals accepted from each client today, kept across reloads and restarts
+	ds  datastore.Batching
+	now func() time.Time
+
+	lk    sync.Mutex
+	rules *compiledRules
+	// day of the usage each deal accepted by Filter and not settled yet was reserved on
+	reserved map[cid.Cid]string
+}
+
+func NewStorageDealRuleFilter(path string) func(spn storagemarket.StorageProviderNode, ds models.DealRuleDS) (*StorageDealRuleFilter, error) {
+	return func(spn storagemarket.StorageProviderNode, ds models.DealRuleDS) (*StorageDealRuleFilter, error) {
+		path, err := homedir.Expand(path)
+		if err != nil {
+			return nil, err
+		}
+		f := &StorageDealRuleFilter{
+			path: path,
+			spn:  spn,
+			ds:   ds,
+			now:  time.Now,
+
+			reserved: make(map[cid.Cid]string),
+		}
+		if err := f.Reload(); err != nil {
+			return nil, err
+		}
+		return f, nil
+	}
+}
+
+// Reload reads the rule file again, the current rules are kept if the file is invalid
+func (f *StorageDealRuleFilter) Reload() error {
+	data, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return xerrors.Errorf("read storage deal rules: %w", err)
+	}
+	var raw StorageDealRules
+	if _, err := toml.Decode(string(data), &raw); err != nil {
+		return xerrors.Errorf("decode storage deal rules %s: %w", f.path, err)
+	}
+	rules, err := compileRules(raw)
+	if err != nil {
+		return xerrors.Errorf("storage deal rules %s: %w", f.path, err)
+	}
+
+	f.lk.Lock()
+	f.rules = rules
+	f.lk.Unlock()
+	log.Infof("loaded storage deal rules from %s", f.path)
+	return nil
+}
+
+// Rules returns the rules in use
+func (f *StorageDealRuleFilter) Rules() StorageDealRules {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+	return f.rules.raw
+}
+
+// Filter is a config.StorageDealFilter. The deals it accepts are reserved on the daily quotas at
+// once, Settle gives the reservation back if another check rejects the deal.
+func (f *StorageDealRuleFilter) Filter(ctx context.Context, deal storagemarket.MinerDeal) (bool, string, error) {
+	_, head, err := f.spn.GetChainHead(ctx)
+	if err != nil {
+		return false, "failed to get chain head", err
+	}
+
+	f.lk.Lock()
+	defer f.lk.Unlock()
+
+	client := deal.Proposal.Client
+	if _, ok := f.rules.deny[client]; ok {
+		return false, fmt.Sprintf("client %s is denied", client), nil
+	}
+	if len(f.rules.allow) > 0 {
+		if _, ok := f.rules.allow[client]; !ok {
+			return false, fmt.Sprintf("client %s is not in the allowed clients", client), nil
+		}
+	}
+
+	policy, ok := f.rules.clients[client]
+	if !ok {
+		policy = f.rules.policy
+	}
+
+	proposal := deal.Proposal
+	if policy.minPieceSize > 0 && proposal.PieceSize < policy.minPieceSize {
+		return false, fmt.Sprintf("piece size %d is below the minimum %d", proposal.PieceSize, policy.minPieceSize), nil
+	}
+	if policy.maxPieceSize > 0 && proposal.PieceSize > policy.maxPieceSize {
+		return false, fmt.Sprintf("piece size %d is above the maximum %d", proposal.PieceSize, policy.maxPieceSize), nil
+	}
+
+	minPrice := policy.minPrice
+	if proposal.VerifiedDeal {
+		minPrice = policy.minVerifiedPrice
+	}
+	// price per epoch * GiB >= min price per GiB * piece size
+	if !minPrice.Nil() && big.Mul(proposal.StoragePricePerEpoch, big.NewInt(1<<30)).LessThan(big.Mul(minPrice, big.NewInt(int64(proposal.PieceSize)))) {
+		return false, fmt.Sprintf("price %s per epoch for %d bytes is below the minimum %s per GiB per epoch",
+			types.FIL(proposal.StoragePricePerEpoch), proposal.PieceSize, types.FIL(minPrice)), nil
+	}
+
+	duration := proposal.EndEpoch - proposal.StartEpoch
+	if policy.minDuration > 0 && duration < policy.minDuration {
+		return false, fmt.Sprintf("duration of %d epochs is below the minimum %d", duration, policy.minDuration), nil
+	}
+	if policy.maxDuration > 0 && duration > policy.maxDuration {
+		return false, fmt.Sprintf("duration of %d epochs is above the maximum %d", duration, policy.maxDuration), nil
+	}
+
+	if policy.minStartDelay > 0 && proposal.StartEpoch < head+policy.minStartDelay {
+		return false, fmt.Sprintf("start epoch %d is earlier than %d", proposal.StartEpoch, head+policy.minStartDelay), nil
+	}
+	if policy.maxStartDelay > 0 && proposal.StartEpoch > head+policy.maxStartDelay {
+		return false, fmt.Sprintf("start epoch %d is later than %d", proposal.StartEpoch, head+policy.maxStartDelay), nil
+	}
+
+	if policy.dailyDeals == 0 && policy.dailyBytes == 0 {
+		return true, "", nil
+	}
+	usage, err := f.usage(client)
+	if err != nil {
+		return false, "miner error", err
+	}
+	if policy.dailyDeals > 0 && usage.Deals+1 > policy.dailyDeals {
+		return false, fmt.Sprintf("client %s reached the daily quota of %d deals", client, policy.dailyDeals), nil
+	}
+	if policy.dailyBytes > 0 && usage.Bytes+uint64(proposal.PieceSize) > policy.dailyBytes {
+		return false, fmt.Sprintf("client %s reached the daily quota of %s", client, units.BytesSize(float64(policy.dailyBytes))), nil
+	}
+
+	// reserved under the lock, the deals of a client decided at the same time can't all pass
+	usage.Deals++
+	usage.Bytes += uint64(proposal.PieceSize)
+	if err := f.putUsage(client, usage); err != nil {
+		return false, "miner error", err
+	}
+	f.reserved[deal.ProposalCid] = usage.Day
+	return true, "", nil
+}
+
+// Settle ends the reservation Filter made for the deal, the reservation is given back if the deal
+// wasn't accepted in the end
+func (f *StorageDealRuleFilter) Settle(deal storagemarket.MinerDeal, accepted bool) error {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+
+	day, ok := f.reserved[deal.ProposalCid]
+	if !ok {
+		return nil
+	}
+	delete(f.reserved, deal.ProposalCid)
+	if accepted {
+		return nil
+	}
+
+	client := deal.Proposal.Client
+	usage, err := f.usage(client)
+	if err != nil {
+		return err
+	}
+	if usage.Day != day {
+		// reserved on a day already over
+		return nil
+	}
+	if usage.Deals > 0 {
+		usage.Deals--
+	}
+	if size := uint64(deal.Proposal.PieceSize); usage.Bytes > size {
+		usage.Bytes -= size
+	} else {
+		usage.Bytes = 0
+	}
+	return f.putUsage(client, usage)
+}
+
+func (f *StorageDealRuleFilter) putUsage(client address.Address, usage *clientUsage) error {
+	data, err := json.Marshal(usage)
+	if err != nil {
+		return err
+	}
+	return f.ds.Put(usagePrefix.ChildString(client.String()), data)
+}
+
+// usage returns what the client had accepted today
+func (f *StorageDealRuleFilter) usage(client address.Address) (*clientUsage, error) {
+	day := f.now().UTC().Format("2006-01-02")
+	data, err := f.ds.Get(usagePrefix.ChildString(client.String()))
+	if xerrors.Is(err, datastore.ErrNotFound) {
+		return &clientUsage{Day: day}, nil
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("get daily usage of client %s: %w", client, err)
+	}
+	var usage clientUsage
+	if err := json.Unmarshal(data, &usage); err != nil {
+		return nil, xerrors.Errorf("decode daily usage of client %s: %w", client, err)
+	}
+	if usage.Day != day {
+		return &clientUsage{Day: day}, nil
+	}
+	return &usage, nil
+}
+
+func compileRules(raw StorageDealRules) (*compiledRules, error) {
+	rules := &compiledRules{
+		raw:     raw,
+		allow:   make(map[address.Address]struct{}),
+		deny:    make(map[address.Address]struct{}),
+		clients: make(map[address.Address]dealPolicy),
+	}
+
+	var err error
+	for _, list := range []struct {
+		clients []string
+		set     map[address.Address]struct{}
+	}{{raw.AllowClients, rules.allow}, {raw.DenyClients, rules.deny}} {
+		for _, client := range list.clients {
+			addr, err := address.NewFromString(client)
+			if err != nil {
+				return nil, xerrors.Errorf("invalid client %s: %w", client, err)
+			}
+			list.set[addr] = struct{}{}
+		}
+	}
+
+	if rules.policy, err = compilePolicy(dealPolicy{}, raw.Policy); err != nil {
+		return nil, err
+	}
+	for _, rule := range raw.Client {
+		policy, err := compilePolicy(rules.policy, rule.Policy)
+		if err != nil {
+			return nil, xerrors.Errorf("rule of clients %v: %w", rule.Clients, err)
+		}
+		for _, client := range rule.Clients {
+			addr, err := address.NewFromString(client)
+			if err != nil {
+				return nil, xerrors.Errorf("invalid client %s: %w", client, err)
+			}
+			if _, ok := rules.clients[addr]; ok {
+				return nil, xerrors.Errorf("client %s is in several rules", client)
+			}
+			rules.clients[addr] = policy
+		}
+	}
+	return rules, nil
+}
+
+// compilePolicy parses the fields set in raw over base
+func compilePolicy(base dealPolicy, raw StorageDealPolicy) (dealPolicy, error) {
+	policy := base
+
+	for _, size := range []struct {
+		name string
+		raw  string
+		out  *abi.PaddedPieceSize
+	}{
+		{"MinPieceSize", raw.MinPieceSize, &policy.minPieceSize},
+		{"MaxPieceSize", raw.MaxPieceSize, &policy.maxPieceSize},
+	} {
+		if len(size.raw) == 0 {
+			continue
+		}
+		v, err := units.RAMInBytes(size.raw)
+		if err != nil {
+			return policy, xerrors.Errorf("invalid %s %s: %w", size.name, size.raw, err)
+		}
+		*size.out = abi.PaddedPieceSize(v)
+	}
+
+	for _, price := range []struct {
+		name string
+		raw  string
+		out  *abi.TokenAmount
+	}{
+		{"MinPricePerGiBEpoch", raw.MinPricePerGiBEpoch, &policy.minPrice},
+		{"MinVerifiedPricePerGiBEpoch", raw.MinVerifiedPricePerGiBEpoch, &policy.minVerifiedPrice},
+	} {
+		if len(price.raw) == 0 {
+			continue
+		}
+		v, err := types.ParseFIL(price.raw)
+		if err != nil {
+			return policy, xerrors.Errorf("invalid %s %s: %w", price.name, price.raw, err)
+		}
+		*price.out = abi.TokenAmount(v)
+	}
+
+	if raw.MinDuration > 0 {
+		policy.minDuration = raw.MinDuration
+	}
+	if raw.MaxDuration > 0 {
+		policy.maxDuration = raw.MaxDuration
+	}
+	if raw.MinStartDelay > 0 {
+		policy.minStartDelay = raw.MinStartDelay
+	}
+	if raw.MaxStartDelay > 0 {
+		policy.maxStartDelay = raw.MaxStartDelay
+	}
+	if raw.DailyDeals > 0 {
+		policy.dailyDeals = raw.DailyDeals
+	}
+	if len(raw.DailyBytes) > 0 {
+		v, err := units.RAMInBytes(raw.DailyBytes)
+		if err != nil {
+			return policy, xerrors.Errorf("invalid DailyBytes %s: %w", raw.DailyBytes, err)
+		}
+		policy.dailyBytes = uint64(v)
+	}
+	return policy, nil
+}
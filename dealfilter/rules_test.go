@@ -0,0 +1,190 @@
+package dealfilter
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-fil-markets/shared"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	ds_sync "github.com/ipfs/go-datastore/sync"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+type headNode struct {
+	storagemarket.StorageProviderNode
+	head abi.ChainEpoch
+}
+
+func (n *headNode) GetChainHead(context.Context) (shared.TipSetToken, abi.ChainEpoch, error) {
+	return nil, n.head, nil
+}
+
+const testRules = `
+DenyClients = ["f01003"]
+
+[Policy]
+  MinPieceSize = "1KiB"
+  MaxPieceSize = "1MiB"
+  MinPricePerGiBEpoch = "2048 attofil"
+  MinVerifiedPricePerGiBEpoch = "0"
+  MinDuration = 1000
+  MaxDuration = 2000
+  MinStartDelay = 10
+  MaxStartDelay = 100
+  DailyDeals = 2
+
+[[Client]]
+  Clients = ["f01002"]
+  [Client.Policy]
+    MaxPieceSize = "4MiB"
+    DailyBytes = "4MiB"
+`
+
+func TestStorageDealRuleFilter(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "rules.toml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(testRules), 0644))
+
+	ds := ds_sync.MutexWrap(datastore.NewMapDatastore())
+	filter, err := NewStorageDealRuleFilter(path)(&headNode{head: 100}, ds)
+	require.NoError(t, err)
+	now := time.Date(2021, 8, 1, 12, 0, 0, 0, time.UTC)
+	filter.now = func() time.Time { return now }
+
+	client1, _ := address.NewIDAddress(1001)
+	client2, _ := address.NewIDAddress(1002)
+	client3, _ := address.NewIDAddress(1003)
+	newDeal := func(client address.Address) storagemarket.MinerDeal {
+		deal := storagemarket.MinerDeal{}
+		deal.Proposal.Client = client
+		deal.Proposal.PieceSize = 1 << 20
+		// 2048 attofil per GiB per epoch
+		deal.Proposal.StoragePricePerEpoch = big.NewInt(2)
+		deal.Proposal.StartEpoch = 150
+		deal.Proposal.EndEpoch = 1650
+		return deal
+	}
+	check := func(deal storagemarket.MinerDeal, accept bool) {
+		ok, reason, err := filter.Filter(ctx, deal)
+		require.NoError(t, err)
+		require.Equal(t, accept, ok, reason)
+		if !accept {
+			require.NotEmpty(t, reason)
+			return
+		}
+		// accepted by the other checks too
+		require.NoError(t, filter.Settle(deal, true))
+	}
+
+	check(newDeal(client3), false)
+
+	deal := newDeal(client1)
+	deal.Proposal.PieceSize = 2 << 20
+	check(deal, false)
+	deal = newDeal(client1)
+	deal.Proposal.StoragePricePerEpoch = big.NewInt(1)
+	check(deal, false)
+	deal.Proposal.VerifiedDeal = true
+	check(deal, true)
+	deal = newDeal(client1)
+	deal.Proposal.EndEpoch = deal.Proposal.StartEpoch + 500
+	check(deal, false)
+	deal = newDeal(client1)
+	deal.Proposal.StartEpoch, deal.Proposal.EndEpoch = 300, 1800
+	check(deal, false)
+	// a deal rejected after the rules gives its reservation back
+	ok, _, err := filter.Filter(ctx, newDeal(client1))
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.NoError(t, filter.Settle(newDeal(client1), false))
+	check(newDeal(client1), true)
+	// daily quota of 2 deals
+	check(newDeal(client1), false)
+
+	// the quotas are kept across restarts
+	restarted, err := NewStorageDealRuleFilter(path)(&headNode{head: 100}, ds)
+	require.NoError(t, err)
+	restarted.now = filter.now
+	ok, _, err = restarted.Filter(ctx, newDeal(client1))
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	now = now.Add(24 * time.Hour)
+	check(newDeal(client1), true)
+
+	// the override of client2 allows larger pieces, up to 4MiB a day
+	deal = newDeal(client2)
+	deal.Proposal.PieceSize = 4 << 20
+	deal.Proposal.StoragePricePerEpoch = big.NewInt(8)
+	check(deal, true)
+	check(newDeal(client2), false)
+
+	// invalid rules are not loaded
+	require.NoError(t, ioutil.WriteFile(path, []byte(`DenyClients = ["not an address"]`), 0644))
+	require.Error(t, filter.Reload())
+	require.Equal(t, []string{"f01003"}, filter.Rules().DenyClients)
+
+	require.NoError(t, ioutil.WriteFile(path, []byte(`AllowClients = ["f01003"]`), 0644))
+	require.NoError(t, filter.Reload())
+	check(newDeal(client1), false)
+	check(newDeal(client3), true)
+}
+
+func TestStorageDealRuleFilterConcurrent(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "rules.toml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(testRules), 0644))
+	filter, err := NewStorageDealRuleFilter(path)(&headNode{head: 100}, ds_sync.MutexWrap(datastore.NewMapDatastore()))
+	require.NoError(t, err)
+
+	client, _ := address.NewIDAddress(1001)
+	var wg sync.WaitGroup
+	var lk sync.Mutex
+	var accepted []storagemarket.MinerDeal
+	for i := 0; i < 10; i++ {
+		deal := storagemarket.MinerDeal{}
+		deal.ProposalCid, err = cid.Prefix{Version: 1, Codec: cid.DagCBOR, MhType: multihash.SHA2_256, MhLength: -1}.Sum([]byte{byte(i)})
+		require.NoError(t, err)
+		deal.Proposal.Client = client
+		deal.Proposal.PieceSize = 1 << 20
+		deal.Proposal.StoragePricePerEpoch = big.NewInt(2)
+		deal.Proposal.StartEpoch = 150
+		deal.Proposal.EndEpoch = 1650
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, _, err := filter.Filter(ctx, deal)
+			require.NoError(t, err)
+			if ok {
+				lk.Lock()
+				accepted = append(accepted, deal)
+				lk.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	// the proposals decided at the same time share the daily quota of 2 deals
+	require.Len(t, accepted, 2)
+
+	// the quota left by a deal rejected later is available again
+	require.NoError(t, filter.Settle(accepted[0], false))
+	require.NoError(t, filter.Settle(accepted[1], true))
+	deal := accepted[0]
+	ok, reason, err := filter.Filter(ctx, deal)
+	require.NoError(t, err)
+	require.True(t, ok, reason)
+	ok, _, err = filter.Filter(ctx, accepted[1])
+	require.NoError(t, err)
+	require.False(t, ok)
+}
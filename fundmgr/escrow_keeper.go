@@ -0,0 +1,250 @@
+package fundmgr
+
+import (
+	"context"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/exitcode"
+	"github.com/filecoin-project/venus/app/client/apiface"
+	"github.com/filecoin-project/venus/app/submodule/apitypes"
+	"github.com/filecoin-project/venus/pkg/constants"
+	"github.com/filecoin-project/venus/pkg/types"
+	"github.com/filecoin-project/venus/pkg/types/specactors"
+	"github.com/filecoin-project/venus/pkg/types/specactors/builtin/market"
+	"github.com/ipfs/go-cid"
+	"go.uber.org/fx"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/venus-market/builder"
+	"github.com/filecoin-project/venus-market/config"
+	"github.com/filecoin-project/venus-market/journal"
+	types2 "github.com/filecoin-project/venus-market/types"
+)
+
+var RunEscrowKeeperKey builder.Invoke = builder.NextInvoke()
+
+// EscrowTopUpEvt is recorded when the escrow of a miner is topped up
+type EscrowTopUpEvt struct {
+	Miner     address.Address
+	Wallet    address.Address
+	Available abi.TokenAmount
+	Amount    abi.TokenAmount
+	MsgCid    cid.Cid
+}
+
+// EscrowShortfallEvt is recorded when the wallet can't bring the escrow of the miners to the target
+type EscrowShortfallEvt struct {
+	Wallet  address.Address
+	Balance abi.TokenAmount
+	Needed  abi.TokenAmount
+	Miners  []address.Address
+}
+
+// escrowKeeperAPI is the specific methods called by the EscrowKeeper
+type escrowKeeperAPI interface {
+	MpoolPushMessage(context.Context, *types.Message, *types.MessageSendSpec) (*types.SignedMessage, error)
+	StateMarketBalance(context.Context, address.Address, types.TipSetKey) (apitypes.MarketBalance, error)
+	StateSearchMsg(ctx context.Context, from types.TipSetKey, msg cid.Cid, limit abi.ChainEpoch, allowReplaced bool) (*apitypes.MsgLookup, error)
+	WalletBalance(context.Context, address.Address) (types.BigInt, error)
+}
+
+// EscrowKeeper checks the market escrow of the miners at intervals. A miner whose available
+// escrow, the escrow less the locked funds and the funds reserved by the FundManager, is below
+// the low-water mark is topped up to the target in one message, so that the deals find their
+// collateral available instead of sending a message each.
+type EscrowKeeper struct {
+	api      escrowKeeperAPI
+	fm       *FundManager
+	miners   []address.Address
+	wallet   address.Address
+	lowWater abi.TokenAmount
+	target   abi.TokenAmount
+	interval time.Duration
+	spec     *types.MessageSendSpec
+
+	journal          journal.Journal
+	topUpEvtType     journal.EventType
+	shortfallEvtType journal.EventType
+
+	// top up messages which are not on chain yet
+	pending map[address.Address]cid.Cid
+}
+
+func NewEscrowKeeper(cfg *config.MarketConfig) func(full apiface.FullNode, fm *FundManager, miners types2.MinerAddresses, j journal.Journal) (*EscrowKeeper, error) {
+	return func(full apiface.FullNode, fm *FundManager, miners types2.MinerAddresses, j journal.Journal) (*EscrowKeeper, error) {
+		wallet, err := address.NewFromString(cfg.EscrowKeeper.Wallet)
+		if err != nil {
+			return nil, xerrors.Errorf("invalid escrow keeper wallet %s: %w", cfg.EscrowKeeper.Wallet, err)
+		}
+		return newEscrowKeeper(full, fm, miners, wallet, cfg.EscrowKeeper, abi.TokenAmount(cfg.MaxMarketBalanceAddFee), j)
+	}
+}
+
+func newEscrowKeeper(api escrowKeeperAPI, fm *FundManager, miners []address.Address, wallet address.Address, cfg config.EscrowKeeperConfig, maxFee abi.TokenAmount, j journal.Journal) (*EscrowKeeper, error) {
+	lowWater, target := abi.TokenAmount(cfg.LowWater), abi.TokenAmount(cfg.Target)
+	if lowWater.Nil() || target.Nil() || target.IsZero() || target.LessThan(lowWater) {
+		return nil, xerrors.Errorf("escrow keeper target %s must be set and not below the low-water mark %s", cfg.Target, cfg.LowWater)
+	}
+	interval := time.Duration(cfg.CheckInterval)
+	if interval <= 0 {
+		interval = 10 * time.Minute
+	}
+	return &EscrowKeeper{
+		api:              api,
+		fm:               fm,
+		miners:           miners,
+		wallet:           wallet,
+		lowWater:         lowWater,
+		target:           target,
+		interval:         interval,
+		spec:             &types.MessageSendSpec{MaxFee: maxFee},
+		journal:          j,
+		topUpEvtType:     j.RegisterEventType("markets/escrow", "top_up"),
+		shortfallEvtType: j.RegisterEventType("markets/escrow", "shortfall"),
+		pending:          make(map[address.Address]cid.Cid),
+	}, nil
+}
+
+// RunEscrowKeeper checks the escrow until the market stops
+func RunEscrowKeeper(lc fx.Lifecycle, k *EscrowKeeper) {
+	ctx, cancel := context.WithCancel(context.Background())
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			go k.run(ctx)
+			return nil
+		},
+		OnStop: func(context.Context) error {
+			cancel()
+			return nil
+		},
+	})
+}
+
+func (k *EscrowKeeper) run(ctx context.Context) {
+	ticker := time.NewTicker(k.interval)
+	defer ticker.Stop()
+
+	for {
+		k.check(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+type escrowTopUp struct {
+	miner     address.Address
+	available abi.TokenAmount
+	amount    abi.TokenAmount
+}
+
+// check tops up the miners whose available escrow is below the low-water mark, a miner whose
+// previous top up is still on its way is skipped
+func (k *EscrowKeeper) check(ctx context.Context) {
+	var topUps []escrowTopUp
+	needed := big.Zero()
+	for _, miner := range k.miners {
+		if msgCid, ok := k.pending[miner]; ok {
+			lookup, err := k.api.StateSearchMsg(ctx, types.EmptyTSK, msgCid, constants.LookbackNoLimit, true)
+			if err == nil && lookup == nil {
+				continue
+			}
+			delete(k.pending, miner)
+			if err == nil && lookup.Receipt.ExitCode != exitcode.Ok {
+				err = xerrors.Errorf("exit code %s", lookup.Receipt.ExitCode)
+			}
+			if err != nil {
+				log.Warnf("escrow top up %s of miner %s failed: %s", msgCid, miner, err)
+			}
+		}
+
+		bal, err := k.api.StateMarketBalance(ctx, miner, types.EmptyTSK)
+		if err != nil {
+			log.Errorf("get market balance of miner %s: %s", miner, err)
+			continue
+		}
+		available := big.Sub(big.Sub(bal.Escrow, bal.Locked), k.fm.GetReserved(miner))
+		if available.GreaterThanEqual(k.lowWater) {
+			continue
+		}
+		topUp := escrowTopUp{miner: miner, available: available, amount: big.Sub(k.target, available)}
+		topUps = append(topUps, topUp)
+		needed = big.Add(needed, topUp.amount)
+	}
+	if len(topUps) == 0 {
+		return
+	}
+
+	balance, err := k.api.WalletBalance(ctx, k.wallet)
+	if err != nil {
+		log.Errorf("get balance of escrow wallet %s: %s", k.wallet, err)
+		return
+	}
+	if balance.LessThan(needed) {
+		miners := make([]address.Address, 0, len(topUps))
+		for _, topUp := range topUps {
+			miners = append(miners, topUp.miner)
+		}
+		log.Warnf("escrow wallet %s has %s, %s are needed to top up miners %v", k.wallet, types.FIL(balance), types.FIL(needed), miners)
+		evt := EscrowShortfallEvt{
+			Wallet:  k.wallet,
+			Balance: balance,
+			Needed:  needed,
+			Miners:  miners,
+		}
+		k.journal.RecordEvent(k.shortfallEvtType, func() interface{} {
+			return evt
+		})
+	}
+
+	// top up the miners the wallet can still cover
+	for _, topUp := range topUps {
+		if balance.LessThan(topUp.amount) {
+			continue
+		}
+		msgCid, err := k.addBalance(ctx, topUp.miner, topUp.amount)
+		if err != nil {
+			log.Errorf("top up escrow of miner %s: %s", topUp.miner, err)
+			continue
+		}
+		balance = big.Sub(balance, topUp.amount)
+		k.pending[topUp.miner] = msgCid
+
+		log.Infof("top up escrow of miner %s by %s in message %s", topUp.miner, types.FIL(topUp.amount), msgCid)
+		topUp := topUp
+		k.journal.RecordEvent(k.topUpEvtType, func() interface{} {
+			return EscrowTopUpEvt{
+				Miner:     topUp.miner,
+				Wallet:    k.wallet,
+				Available: topUp.available,
+				Amount:    topUp.amount,
+				MsgCid:    msgCid,
+			}
+		})
+	}
+}
+
+func (k *EscrowKeeper) addBalance(ctx context.Context, miner address.Address, amt abi.TokenAmount) (cid.Cid, error) {
+	params, err := specactors.SerializeParams(&miner)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	smsg, err := k.api.MpoolPushMessage(ctx, &types.Message{
+		To:     market.Address,
+		From:   k.wallet,
+		Value:  amt,
+		Method: market.Methods.AddBalance,
+		Params: params,
+	}, k.spec)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return smsg.Cid(), nil
+}
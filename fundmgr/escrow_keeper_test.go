@@ -0,0 +1,159 @@
+package fundmgr
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/venus/app/submodule/apitypes"
+	"github.com/filecoin-project/venus/pkg/types"
+	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	ds_sync "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/venus-market/config"
+	"github.com/filecoin-project/venus-market/journal"
+)
+
+func TestEscrowKeeper(t *testing.T) {
+	ctx := context.Background()
+	wallet := mustIDAddr(t, 100)
+	m1, m2 := mustIDAddr(t, 1001), mustIDAddr(t, 1002)
+
+	api := newMockEscrowAPI(big.NewInt(1000))
+	api.escrow[m1] = big.NewInt(10)
+	api.escrow[m2] = big.NewInt(500)
+	j := &recordJournal{}
+
+	fm := newFundManager(api, ds_sync.MutexWrap(ds.NewMapDatastore()))
+	cfg := config.EscrowKeeperConfig{
+		LowWater: types.FIL(big.NewInt(50)),
+		Target:   types.FIL(big.NewInt(200)),
+	}
+	k, err := newEscrowKeeper(api, fm, []address.Address{m1, m2}, wallet, cfg, big.Zero(), j)
+	require.NoError(t, err)
+
+	// m1 is topped up to the target
+	k.check(ctx)
+	require.Len(t, api.sent, 1)
+	require.Equal(t, m1, api.sent[0].miner)
+	require.Equal(t, big.NewInt(190), api.sent[0].amount)
+	require.Len(t, j.events, 1)
+	require.Equal(t, m1, j.events[0].(EscrowTopUpEvt).Miner)
+
+	// no new message while the top up is not on chain
+	k.check(ctx)
+	require.Len(t, api.sent, 1)
+
+	// the wallet only covers m1 once both are low
+	api.land(api.sent[0].msgCid)
+	api.escrow[m1] = big.NewInt(20)
+	api.escrow[m2] = big.NewInt(0)
+	api.balance = big.NewInt(190)
+	k.check(ctx)
+	require.Len(t, api.sent, 2)
+	require.Equal(t, m1, api.sent[1].miner)
+	require.Equal(t, big.NewInt(180), api.sent[1].amount)
+	require.Len(t, j.events, 3)
+	shortfall := j.events[1].(EscrowShortfallEvt)
+	require.Equal(t, big.NewInt(380), shortfall.Needed)
+	require.Equal(t, []address.Address{m1, m2}, shortfall.Miners)
+
+	// the target can't be below the low-water mark
+	cfg.Target = types.FIL(big.NewInt(10))
+	_, err = newEscrowKeeper(api, fm, []address.Address{m1, m2}, wallet, cfg, big.Zero(), j)
+	require.Error(t, err)
+}
+
+func mustIDAddr(t *testing.T, id uint64) address.Address {
+	addr, err := address.NewIDAddress(id)
+	require.NoError(t, err)
+	return addr
+}
+
+type sentTopUp struct {
+	miner  address.Address
+	amount abi.TokenAmount
+	msgCid cid.Cid
+}
+
+type mockEscrowAPI struct {
+	fundManagerAPI
+
+	lk      sync.Mutex
+	balance abi.TokenAmount
+	escrow  map[address.Address]abi.TokenAmount
+	sent    []sentTopUp
+	landed  map[cid.Cid]struct{}
+}
+
+func newMockEscrowAPI(balance abi.TokenAmount) *mockEscrowAPI {
+	return &mockEscrowAPI{
+		balance: balance,
+		escrow:  make(map[address.Address]abi.TokenAmount),
+		landed:  make(map[cid.Cid]struct{}),
+	}
+}
+
+func (m *mockEscrowAPI) MpoolPushMessage(ctx context.Context, msg *types.Message, spec *types.MessageSendSpec) (*types.SignedMessage, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	var miner address.Address
+	if err := miner.UnmarshalCBOR(bytes.NewReader(msg.Params)); err != nil {
+		return nil, err
+	}
+	smsg := &types.SignedMessage{Message: *msg}
+	m.sent = append(m.sent, sentTopUp{miner: miner, amount: msg.Value, msgCid: smsg.Cid()})
+	return smsg, nil
+}
+
+func (m *mockEscrowAPI) StateMarketBalance(ctx context.Context, addr address.Address, tsk types.TipSetKey) (apitypes.MarketBalance, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	return apitypes.MarketBalance{Escrow: m.escrow[addr], Locked: big.Zero()}, nil
+}
+
+func (m *mockEscrowAPI) StateSearchMsg(ctx context.Context, from types.TipSetKey, msg cid.Cid, limit abi.ChainEpoch, allowReplaced bool) (*apitypes.MsgLookup, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	if _, ok := m.landed[msg]; !ok {
+		return nil, nil
+	}
+	return &apitypes.MsgLookup{Message: msg}, nil
+}
+
+func (m *mockEscrowAPI) WalletBalance(ctx context.Context, addr address.Address) (types.BigInt, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	return m.balance, nil
+}
+
+func (m *mockEscrowAPI) land(msgCid cid.Cid) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	m.landed[msgCid] = struct{}{}
+}
+
+type recordJournal struct {
+	events []interface{}
+}
+
+func (j *recordJournal) RegisterEventType(system, event string) journal.EventType {
+	return journal.EventType{System: system, Event: event}
+}
+
+func (j *recordJournal) RecordEvent(_ journal.EventType, supplier func() interface{}) {
+	j.events = append(j.events, supplier())
+}
+
+func (j *recordJournal) Close() error { return nil }
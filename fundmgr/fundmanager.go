@@ -8,16 +8,21 @@ import (
 	"github.com/filecoin-project/venus/app/submodule/apitypes"
 	"github.com/filecoin-project/venus/pkg/constants"
 	"github.com/filecoin-project/venus/pkg/types/specactors"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/exitcode"
 	"github.com/filecoin-project/venus/pkg/types"
 	"github.com/filecoin-project/venus/pkg/types/specactors/builtin/market"
 	"github.com/ipfs/go-cid"
 	logging "github.com/ipfs/go-log/v2"
 	"go.uber.org/fx"
 	"golang.org/x/xerrors"
+
+	types2 "github.com/filecoin-project/venus-market/types"
 )
 
 var log = logging.Logger("market_adapter")
@@ -134,6 +139,39 @@ func (fm *FundManager) GetReserved(addr address.Address) abi.TokenAmount {
 	return fm.getFundedAddress(addr).getReserved()
 }
 
+// ListFundedAddresses returns the balances, the queued requests and the message in flight
+// of every address tracked by the FundManager
+func (fm *FundManager) ListFundedAddresses(ctx context.Context) ([]FundedAddressInfo, error) {
+	fm.lk.Lock()
+	fas := make([]*fundedAddress, 0, len(fm.fundedAddrs))
+	for _, fa := range fm.fundedAddrs {
+		fas = append(fas, fa)
+	}
+	fm.lk.Unlock()
+
+	infos := make([]FundedAddressInfo, 0, len(fas))
+	for _, fa := range fas {
+		info := fa.info()
+		bal, err := fm.api.StateMarketBalance(ctx, info.Addr, types.EmptyTSK)
+		if err != nil {
+			return nil, xerrors.Errorf("getting market balance of %s: %w", info.Addr, err)
+		}
+		info.Escrow = bal.Escrow
+		info.Locked = bal.Locked
+		info.Available = types.BigSub(types.BigSub(bal.Escrow, bal.Locked), info.Reserved)
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Addr.String() < infos[j].Addr.String()
+	})
+	return infos, nil
+}
+
+// History returns the messages sent for addr, or for every address if addr is undefined
+func (fm *FundManager) History(addr address.Address) ([]*FundOperation, error) {
+	return fm.str.listOperations(addr)
+}
+
 // FundedAddressState keeps track of the state of an address with funds in the
 // datastore
 type FundedAddressState struct {
@@ -145,6 +183,47 @@ type FundedAddressState struct {
 	MsgCid *cid.Cid
 }
 
+// Kinds of the fund requests and of the messages sent for them
+const (
+	FundReserve    = "reserve"
+	FundRelease    = "release"
+	FundWithdraw   = "withdraw"
+	FundAddBalance = "add-balance"
+)
+
+// FundRequestInfo is a request waiting in the queue of an address
+type FundRequestInfo struct {
+	Kind   string
+	Wallet address.Address
+	Amount abi.TokenAmount
+}
+
+// FundedAddressInfo is the state of an address tracked by the FundManager,
+// Available is the escrow less the locked and the reserved funds
+type FundedAddressInfo struct {
+	Addr      address.Address
+	Escrow    abi.TokenAmount
+	Locked    abi.TokenAmount
+	Reserved  abi.TokenAmount
+	Available abi.TokenAmount
+	// MsgCid is the message the address waits for before processing the queue
+	MsgCid *cid.Cid
+	Queued []FundRequestInfo
+}
+
+// FundOperation is an AddBalance or a WithdrawBalance message sent for an address
+type FundOperation struct {
+	Addr   address.Address
+	Kind   string
+	Wallet address.Address
+	Amount abi.TokenAmount
+	MsgCid cid.Cid
+	Sent   time.Time
+	// Done is zero while the message is not on chain
+	Done  time.Time
+	Error string
+}
+
 // fundedAddress keeps track of the state and request queues for a
 // particular address
 type fundedAddress struct {
@@ -195,6 +274,34 @@ func (a *fundedAddress) getReserved() abi.TokenAmount {
 	return a.state.AmtReserved
 }
 
+func (a *fundedAddress) info() FundedAddressInfo {
+	a.lk.RLock()
+	defer a.lk.RUnlock()
+
+	info := FundedAddressInfo{
+		Addr:     a.state.Addr,
+		Reserved: a.state.AmtReserved,
+		MsgCid:   a.state.MsgCid,
+	}
+	queues := []struct {
+		kind string
+		reqs []*fundRequest
+	}{
+		{FundReserve, a.reservations},
+		{FundRelease, a.releases},
+		{FundWithdraw, a.withdrawals},
+	}
+	for _, queue := range queues {
+		for _, req := range queue.reqs {
+			if req.Completed() {
+				continue
+			}
+			info.Queued = append(info.Queued, FundRequestInfo{Kind: queue.kind, Wallet: req.Wallet, Amount: req.Amount()})
+		}
+	}
+	return info
+}
+
 func (a *fundedAddress) reserve(ctx context.Context, wallet address.Address, amt abi.TokenAmount) (cid.Cid, error) {
 	return a.requestAndWait(ctx, wallet, amt, &a.reservations)
 }
@@ -327,6 +434,41 @@ func (a *fundedAddress) saveState() {
 	}
 }
 
+// Save a message sent for the address to the history
+func (a *fundedAddress) recordOperation(kind string, wallet address.Address, amt abi.TokenAmount, msgCid cid.Cid) {
+	err := a.str.saveOperation(&FundOperation{
+		Addr:   a.state.Addr,
+		Kind:   kind,
+		Wallet: wallet,
+		Amount: amt,
+		MsgCid: msgCid,
+		Sent:   types2.Clock.Now(),
+	})
+	if err != nil {
+		log.Errorf("saving %s message %s for addr %s: %v", kind, msgCid, a.state.Addr, err)
+	}
+}
+
+// Save the outcome of a message to the history
+func (a *fundedAddress) completeOperation(msgCid cid.Cid, msgErr error) {
+	op, err := a.str.getOperation(a.state.Addr, msgCid)
+	if err != nil {
+		log.Errorf("getting message %s for addr %s: %v", msgCid, a.state.Addr, err)
+		return
+	}
+	if op == nil {
+		// the message was sent before the history was kept
+		op = &FundOperation{Addr: a.state.Addr, MsgCid: msgCid}
+	}
+	op.Done = types2.Clock.Now()
+	if msgErr != nil {
+		op.Error = msgErr.Error()
+	}
+	if err := a.str.saveOperation(op); err != nil {
+		log.Errorf("saving message %s for addr %s: %v", msgCid, a.state.Addr, err)
+	}
+}
+
 // The result of processing the reservation / release queues
 type processResult struct {
 	// Requests that completed without adding funds
@@ -415,6 +557,7 @@ func (a *fundedAddress) processReservations(reservations []*fundRequest, release
 	if err != nil {
 		return res, err
 	}
+	a.recordOperation(FundAddBalance, toAdd[0].Wallet, amtToAdd, addFundsCid)
 
 	// Mark reservation requests as complete
 	res.added = toAdd
@@ -561,6 +704,7 @@ func (a *fundedAddress) processWithdrawals(withdrawals []*fundRequest) (msgCid c
 	if err != nil {
 		return cid.Undef, err
 	}
+	a.recordOperation(FundWithdraw, allowed[0].Wallet, allowedAmt, withdrawFundsCid)
 
 	// Mark allowed requests as complete
 	for _, req := range allowed {
@@ -583,6 +727,9 @@ func (a *fundedAddress) startWaitForResults(msgCid cid.Cid) {
 
 		a.lk.Lock()
 		a.debugf("complete wait")
+		if a.ctx.Err() == nil {
+			a.completeOperation(msgCid, err)
+		}
 		a.clearWaitState()
 		a.lk.Unlock()
 
@@ -720,6 +867,12 @@ func (env *fundManagerEnvironment) WithdrawFunds(
 }
 
 func (env *fundManagerEnvironment) WaitMsg(ctx context.Context, c cid.Cid) error {
-	_, err := env.api.StateWaitMsg(ctx, c, constants.MessageConfidence, constants.LookbackNoLimit, true)
-	return err
+	lookup, err := env.api.StateWaitMsg(ctx, c, constants.MessageConfidence, constants.LookbackNoLimit, true)
+	if err != nil {
+		return err
+	}
+	if lookup.Receipt.ExitCode != exitcode.Ok {
+		return xerrors.Errorf("message %s failed with exit code %s", c, lookup.Receipt.ExitCode)
+	}
+	return nil
 }
@@ -2,6 +2,9 @@ package fundmgr
 
 import (
 	"bytes"
+	"encoding/json"
+	"sort"
+
 	"github.com/filecoin-project/venus-market/models"
 
 	cborrpc "github.com/filecoin-project/go-cbor-util"
@@ -9,9 +12,13 @@ import (
 	dsq "github.com/ipfs/go-datastore/query"
 
 	"github.com/filecoin-project/go-address"
+	"github.com/ipfs/go-cid"
 )
 
-const dsKeyAddr = "Addr"
+const (
+	dsKeyAddr    = "Addr"
+	dsKeyHistory = "History"
+)
 
 type Store struct {
 	ds datastore.Batching
@@ -85,3 +92,65 @@ func (ps *Store) forEach(iter func(*FundedAddressState)) error {
 func dskeyForAddr(addr address.Address) datastore.Key {
 	return datastore.KeyWithNamespaces([]string{dsKeyAddr, addr.String()})
 }
+
+// saveOperation saves a fund operation to the history of its address
+func (ps *Store) saveOperation(op *FundOperation) error {
+	b, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+
+	return ps.ds.Put(dskeyForOperation(op.Addr, op.MsgCid), b)
+}
+
+// getOperation returns nil if the message isn't in the history of the address
+func (ps *Store) getOperation(addr address.Address, msgCid cid.Cid) (*FundOperation, error) {
+	data, err := ps.ds.Get(dskeyForOperation(addr, msgCid))
+	if err == datastore.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var op FundOperation
+	if err := json.Unmarshal(data, &op); err != nil {
+		return nil, err
+	}
+	return &op, nil
+}
+
+// listOperations returns the history of addr, or of every address if addr is undefined,
+// in the order the messages were sent
+func (ps *Store) listOperations(addr address.Address) ([]*FundOperation, error) {
+	prefix := datastore.NewKey(dsKeyHistory)
+	if addr != address.Undef {
+		prefix = prefix.ChildString(addr.String())
+	}
+	res, err := ps.ds.Query(dsq.Query{Prefix: prefix.String()})
+	if err != nil {
+		return nil, err
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		return nil, err
+	}
+
+	ops := make([]*FundOperation, 0, len(entries))
+	for _, entry := range entries {
+		var op FundOperation
+		if err := json.Unmarshal(entry.Value, &op); err != nil {
+			return nil, err
+		}
+		ops = append(ops, &op)
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		return ops[i].Sent.Before(ops[j].Sent)
+	})
+	return ops, nil
+}
+
+// The datastore key used to identify a fund operation
+func dskeyForOperation(addr address.Address, msgCid cid.Cid) datastore.Key {
+	return datastore.KeyWithNamespaces([]string{dsKeyHistory, addr.String(), msgCid.String()})
+}
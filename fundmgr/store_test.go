@@ -0,0 +1,53 @@
+package fundmgr
+
+import (
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	ds_sync "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoreOperations(t *testing.T) {
+	str := newStore(ds_sync.MutexWrap(ds.NewMapDatastore()))
+	addr1, addr2 := mustIDAddr(t, 1001), mustIDAddr(t, 1002)
+	msg1, err := cid.Decode("bafy2bzacedbz6qrgt6fkdl4fguas6ntdimi5uyxmn5sxkugdshxmdcfukjfk2")
+	require.NoError(t, err)
+	msg2, err := cid.Decode("bafy2bzacea3wsdh6y3a36tb3skempjoxqpuyompjbmfeyf34fi3uy6uue42v4")
+	require.NoError(t, err)
+	sent := time.Date(2021, 8, 1, 12, 0, 0, 0, time.UTC)
+
+	// the state of an address is kept apart from its history
+	require.NoError(t, str.save(&FundedAddressState{Addr: addr1, AmtReserved: big.NewInt(10)}))
+	require.NoError(t, str.saveOperation(&FundOperation{Addr: addr1, Kind: FundWithdraw, Amount: big.NewInt(5), MsgCid: msg2, Sent: sent.Add(time.Minute)}))
+	require.NoError(t, str.saveOperation(&FundOperation{Addr: addr1, Kind: FundAddBalance, Amount: big.NewInt(10), MsgCid: msg1, Sent: sent}))
+	require.NoError(t, str.saveOperation(&FundOperation{Addr: addr2, Kind: FundAddBalance, Amount: big.NewInt(20), MsgCid: msg1, Sent: sent.Add(time.Second)}))
+
+	var states int
+	require.NoError(t, str.forEach(func(*FundedAddressState) { states++ }))
+	require.Equal(t, 1, states)
+
+	ops, err := str.listOperations(addr1)
+	require.NoError(t, err)
+	require.Len(t, ops, 2)
+	require.Equal(t, msg1, ops[0].MsgCid)
+	require.Equal(t, FundAddBalance, ops[0].Kind)
+	require.Equal(t, big.NewInt(10), ops[0].Amount)
+	require.Equal(t, msg2, ops[1].MsgCid)
+
+	ops, err = str.listOperations(address.Undef)
+	require.NoError(t, err)
+	require.Len(t, ops, 3)
+	require.Equal(t, addr2, ops[1].Addr)
+
+	op, err := str.getOperation(addr2, msg1)
+	require.NoError(t, err)
+	require.True(t, op.Done.IsZero())
+	op, err = str.getOperation(addr2, msg2)
+	require.NoError(t, err)
+	require.Nil(t, op)
+}
@@ -0,0 +1,378 @@
+package indexprovider
+
+import (
+	"bytes"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/codec/dagjson"
+	"github.com/ipld/go-ipld-prime/fluent"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/record"
+	"github.com/multiformats/go-multihash"
+	"github.com/multiformats/go-varint"
+	"golang.org/x/xerrors"
+)
+
+// The advertisements follow the ingest schema of storetheindex:
+//
+//	type Advertisement struct {
+//		PreviousID optional Link_Advertisement
+//		Provider String
+//		Addresses List_String
+//		Signature Bytes
+//		Entries Link
+//		ContextID Bytes
+//		Metadata Bytes
+//		IsRm Bool
+//	}
+//
+//	type EntryChunk struct {
+//		Entries List_Bytes
+//		Next optional Link_EntryChunk
+//	}
+//
+// They are encoded in dag-json and linked by cidv1 dag-json sha256 links.
+const (
+	dagJSONCodec = 0x0129
+
+	// the signature is a libp2p signed envelope of the hash of the advertisement fields
+	adSignatureCodec  = "/indexer/ingest/adSignature"
+	adSignatureDomain = "indexer"
+
+	// metadata protocol of the pieces retrievable over graphsync with a filecoin retrieval deal
+	graphsyncFilecoinV1 = 0x0910
+)
+
+var linkPrefix = cid.Prefix{Version: 1, Codec: dagJSONCodec, MhType: multihash.SHA2_256, MhLength: -1}
+
+// NoEntries is the entries link of a removal advertisement
+var NoEntries = cid.MustParse("bafkreehdwdcefgh4dqkjv67uzcmw7oje")
+
+// Advertisement announces to the indexers the payload multihashes of a piece, or their removal.
+// Advertisements are chained by PreviousID, the head of the chain is the latest one.
+type Advertisement struct {
+	PreviousID *cid.Cid
+	// peer id of the market, its key signs the advertisement
+	Provider  string
+	Addresses []string
+	Signature []byte
+	// first chunk of the entries, NoEntries for a removal
+	Entries cid.Cid
+	// piece cid the entries belong to
+	ContextID []byte
+	// how the payload is retrieved
+	Metadata []byte
+	IsRm     bool
+}
+
+// EntryChunk holds a part of the multihashes of an advertisement, chunks are linked by Next
+type EntryChunk struct {
+	Entries []multihash.Multihash
+	Next    *cid.Cid
+}
+
+// PieceCid returns the piece the advertisement is for
+func (ad *Advertisement) PieceCid() (cid.Cid, error) {
+	_, c, err := cid.CidFromBytes(ad.ContextID)
+	return c, err
+}
+
+// ToNode returns the ipld node of the advertisement
+func (ad *Advertisement) ToNode() (ipld.Node, error) {
+	return fluent.BuildMap(basicnode.Prototype.Map, 8, func(ma fluent.MapAssembler) {
+		if ad.PreviousID != nil {
+			ma.AssembleEntry("PreviousID").AssignLink(cidlink.Link{Cid: *ad.PreviousID})
+		}
+		ma.AssembleEntry("Provider").AssignString(ad.Provider)
+		ma.AssembleEntry("Addresses").CreateList(int64(len(ad.Addresses)), func(la fluent.ListAssembler) {
+			for _, addr := range ad.Addresses {
+				la.AssembleValue().AssignString(addr)
+			}
+		})
+		ma.AssembleEntry("Signature").AssignBytes(ad.Signature)
+		ma.AssembleEntry("Entries").AssignLink(cidlink.Link{Cid: ad.Entries})
+		ma.AssembleEntry("ContextID").AssignBytes(ad.ContextID)
+		ma.AssembleEntry("Metadata").AssignBytes(ad.Metadata)
+		ma.AssembleEntry("IsRm").AssignBool(ad.IsRm)
+	})
+}
+
+// UnwrapAdvertisement reads an advertisement from its ipld node
+func UnwrapAdvertisement(n ipld.Node) (*Advertisement, error) {
+	var ad Advertisement
+	var err error
+	if ad.PreviousID, err = optionalLink(n, "PreviousID"); err != nil {
+		return nil, err
+	}
+	if ad.Provider, err = stringField(n, "Provider"); err != nil {
+		return nil, err
+	}
+	addrs, err := n.LookupByString("Addresses")
+	if err != nil {
+		return nil, xerrors.Errorf("field Addresses: %w", err)
+	}
+	for it := addrs.ListIterator(); it != nil && !it.Done(); {
+		_, v, err := it.Next()
+		if err != nil {
+			return nil, xerrors.Errorf("field Addresses: %w", err)
+		}
+		addr, err := v.AsString()
+		if err != nil {
+			return nil, xerrors.Errorf("field Addresses: %w", err)
+		}
+		ad.Addresses = append(ad.Addresses, addr)
+	}
+	if ad.Signature, err = bytesField(n, "Signature"); err != nil {
+		return nil, err
+	}
+	entries, err := optionalLink(n, "Entries")
+	if err != nil {
+		return nil, err
+	}
+	if entries == nil {
+		return nil, xerrors.New("field Entries is missing")
+	}
+	ad.Entries = *entries
+	if ad.ContextID, err = bytesField(n, "ContextID"); err != nil {
+		return nil, err
+	}
+	if ad.Metadata, err = bytesField(n, "Metadata"); err != nil {
+		return nil, err
+	}
+	isRm, err := n.LookupByString("IsRm")
+	if err != nil {
+		return nil, xerrors.Errorf("field IsRm: %w", err)
+	}
+	if ad.IsRm, err = isRm.AsBool(); err != nil {
+		return nil, xerrors.Errorf("field IsRm: %w", err)
+	}
+	return &ad, nil
+}
+
+// ToNode returns the ipld node of the entries chunk
+func (c *EntryChunk) ToNode() (ipld.Node, error) {
+	return fluent.BuildMap(basicnode.Prototype.Map, 2, func(ma fluent.MapAssembler) {
+		ma.AssembleEntry("Entries").CreateList(int64(len(c.Entries)), func(la fluent.ListAssembler) {
+			for _, mh := range c.Entries {
+				la.AssembleValue().AssignBytes(mh)
+			}
+		})
+		if c.Next != nil {
+			ma.AssembleEntry("Next").AssignLink(cidlink.Link{Cid: *c.Next})
+		}
+	})
+}
+
+// UnwrapEntryChunk reads an entries chunk from its ipld node
+func UnwrapEntryChunk(n ipld.Node) (*EntryChunk, error) {
+	var chunk EntryChunk
+	entries, err := n.LookupByString("Entries")
+	if err != nil {
+		return nil, xerrors.Errorf("field Entries: %w", err)
+	}
+	for it := entries.ListIterator(); it != nil && !it.Done(); {
+		_, v, err := it.Next()
+		if err != nil {
+			return nil, xerrors.Errorf("field Entries: %w", err)
+		}
+		data, err := v.AsBytes()
+		if err != nil {
+			return nil, xerrors.Errorf("field Entries: %w", err)
+		}
+		_, mh, err := multihash.MHFromBytes(data)
+		if err != nil {
+			return nil, xerrors.Errorf("field Entries: %w", err)
+		}
+		chunk.Entries = append(chunk.Entries, mh)
+	}
+	if chunk.Next, err = optionalLink(n, "Next"); err != nil {
+		return nil, err
+	}
+	return &chunk, nil
+}
+
+// Sign signs the advertisement with the key of the provider
+func (ad *Advertisement) Sign(key crypto.PrivKey) error {
+	payload, err := ad.signaturePayload()
+	if err != nil {
+		return err
+	}
+	envelope, err := record.Seal(&adSignatureRecord{payload: payload}, key)
+	if err != nil {
+		return xerrors.Errorf("seal signature: %w", err)
+	}
+	ad.Signature, err = envelope.Marshal()
+	return err
+}
+
+// Verify checks the advertisement is signed by the key of its provider
+func (ad *Advertisement) Verify() error {
+	var rec adSignatureRecord
+	envelope, err := record.ConsumeTypedEnvelope(ad.Signature, &rec)
+	if err != nil {
+		return xerrors.Errorf("verify signature: %w", err)
+	}
+	signer, err := peer.IDFromPublicKey(envelope.PublicKey)
+	if err != nil {
+		return xerrors.Errorf("get signer: %w", err)
+	}
+	if signer.String() != ad.Provider {
+		return xerrors.Errorf("advertisement of provider %s signed by %s", ad.Provider, signer)
+	}
+	payload, err := ad.signaturePayload()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(payload, rec.payload) {
+		return xerrors.Errorf("invalid signature of provider %s", ad.Provider)
+	}
+	return nil
+}
+
+// signaturePayload is the hash of previousID+entries+provider+addresses+metadata+isRm
+func (ad *Advertisement) signaturePayload() (multihash.Multihash, error) {
+	prev := cid.Undef.Bytes()
+	if ad.PreviousID != nil {
+		prev = ad.PreviousID.Bytes()
+	}
+	var buf bytes.Buffer
+	buf.Write(prev)
+	buf.Write(ad.Entries.Bytes())
+	buf.WriteString(ad.Provider)
+	for _, addr := range ad.Addresses {
+		buf.WriteString(addr)
+	}
+	buf.Write(ad.Metadata)
+	if ad.IsRm {
+		buf.WriteByte(1)
+	} else {
+		buf.WriteByte(0)
+	}
+	return multihash.Sum(buf.Bytes(), multihash.SHA2_256, -1)
+}
+
+// adSignatureRecord is the record sealed in the signature envelope
+type adSignatureRecord struct {
+	payload []byte
+}
+
+func (r *adSignatureRecord) Domain() string {
+	return adSignatureDomain
+}
+
+func (r *adSignatureRecord) Codec() []byte {
+	return []byte(adSignatureCodec)
+}
+
+func (r *adSignatureRecord) MarshalRecord() ([]byte, error) {
+	return r.payload, nil
+}
+
+func (r *adSignatureRecord) UnmarshalRecord(data []byte) error {
+	r.payload = data
+	return nil
+}
+
+// pieceMetadata tells that the payload of the piece is retrieved over graphsync with a filecoin
+// retrieval deal, the market keeps an unsealed copy of the pieces it announces
+func pieceMetadata(pieceCid cid.Cid) ([]byte, error) {
+	n, err := fluent.BuildMap(basicnode.Prototype.Map, 3, func(ma fluent.MapAssembler) {
+		ma.AssembleEntry("PieceCID").AssignLink(cidlink.Link{Cid: pieceCid})
+		ma.AssembleEntry("VerifiedDeal").AssignBool(false)
+		ma.AssembleEntry("FastRetrieval").AssignBool(true)
+	})
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(varint.ToUvarint(graphsyncFilecoinV1))
+	if err := dagcbor.Encode(n, buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// encode returns the dag-json of an advertisement or an entries chunk and its link
+func encode(n ipld.Node) (cid.Cid, []byte, error) {
+	var buf bytes.Buffer
+	if err := dagjson.Encode(n, &buf); err != nil {
+		return cid.Undef, nil, err
+	}
+	id, err := linkOf(buf.Bytes())
+	return id, buf.Bytes(), err
+}
+
+// decode reads the ipld node of an advertisement or an entries chunk
+func decode(data []byte) (ipld.Node, error) {
+	nb := basicnode.Prototype.Any.NewBuilder()
+	if err := dagjson.Decode(nb, bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	return nb.Build(), nil
+}
+
+func linkOf(data []byte) (cid.Cid, error) {
+	return linkPrefix.Sum(data)
+}
+
+func optionalLink(n ipld.Node, field string) (*cid.Cid, error) {
+	v, err := n.LookupByString(field)
+	if err != nil || v.IsNull() || v.IsAbsent() {
+		return nil, nil
+	}
+	l, err := v.AsLink()
+	if err != nil {
+		return nil, xerrors.Errorf("field %s: %w", field, err)
+	}
+	cl, ok := l.(cidlink.Link)
+	if !ok {
+		return nil, xerrors.Errorf("field %s: unsupported link %s", field, l)
+	}
+	return &cl.Cid, nil
+}
+
+func stringField(n ipld.Node, field string) (string, error) {
+	v, err := n.LookupByString(field)
+	if err != nil {
+		return "", xerrors.Errorf("field %s: %w", field, err)
+	}
+	s, err := v.AsString()
+	if err != nil {
+		return "", xerrors.Errorf("field %s: %w", field, err)
+	}
+	return s, nil
+}
+
+func bytesField(n ipld.Node, field string) ([]byte, error) {
+	v, err := n.LookupByString(field)
+	if err != nil {
+		return nil, xerrors.Errorf("field %s: %w", field, err)
+	}
+	b, err := v.AsBytes()
+	if err != nil {
+		return nil, xerrors.Errorf("field %s: %w", field, err)
+	}
+	return b, nil
+}
+
+// encodeAdvertisement returns the stored form of an advertisement and its link
+func encodeAdvertisement(ad *Advertisement) (cid.Cid, []byte, error) {
+	n, err := ad.ToNode()
+	if err != nil {
+		return cid.Undef, nil, err
+	}
+	return encode(n)
+}
+
+// encodeEntryChunk returns the stored form of an entries chunk and its link
+func encodeEntryChunk(chunk *EntryChunk) (cid.Cid, []byte, error) {
+	n, err := chunk.ToNode()
+	if err != nil {
+		return cid.Undef, nil, err
+	}
+	return encode(n)
+}
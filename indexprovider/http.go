@@ -0,0 +1,70 @@
+package indexprovider
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"golang.org/x/xerrors"
+)
+
+// IndexProviderPath is the prefix of the advertisement chain on the rpc server, served without a
+// token. GET <prefix>head returns the head of the chain, GET <prefix><cid> returns the dag-json of
+// an advertisement or an entries chunk, the block of the cid.
+const IndexProviderPath = "/index-provider/v0/"
+
+// HeadResponse is the body of the head endpoint, Head is nil before the first advertisement
+type HeadResponse struct {
+	Head *cid.Cid `json:",omitempty"`
+}
+
+func (p *Provider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	if !p.Enabled() {
+		http.Error(w, ErrDisabled.Error(), http.StatusNotFound)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, IndexProviderPath)
+	if name == "head" {
+		head, err := p.Head()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var resp HeadResponse
+		if head.Defined() {
+			resp.Head = &head
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(&resp); err != nil {
+			log.Warnf("write head: %s", err)
+		}
+		return
+	}
+
+	id, err := cid.Decode(name)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid cid: %s", err), http.StatusBadRequest)
+		return
+	}
+	data, err := p.Get(id)
+	if xerrors.Is(err, datastore.ErrNotFound) {
+		http.Error(w, fmt.Sprintf("%s not found", id), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(data); err != nil {
+		log.Warnf("write %s: %s", id, err)
+	}
+}
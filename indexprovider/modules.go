@@ -0,0 +1,52 @@
+package indexprovider
+
+import (
+	"context"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/host"
+	"go.uber.org/fx"
+
+	"github.com/filecoin-project/venus-market/builder"
+	"github.com/filecoin-project/venus-market/config"
+	"github.com/filecoin-project/venus-market/dagstore"
+	"github.com/filecoin-project/venus-market/metrics"
+	"github.com/filecoin-project/venus-market/models"
+	"github.com/filecoin-project/venus-market/piece"
+)
+
+var AnnounceActiveKey builder.Invoke = builder.NextInvoke()
+
+// NewProvider creates the index provider of the market, signing with the libp2p host key. It reads
+// the payload multihashes from the indexes of the dagstore.
+func NewProvider(mctx metrics.MetricsCtx, lc fx.Lifecycle, cfg *config.MarketConfig, ds models.IndexProviderDS, key crypto.PrivKey, h host.Host, dagStore *dagstore.Wrapper) (*Provider, error) {
+	addrs := func() []string {
+		if len(cfg.IndexProvider.RetrievalAddresses) > 0 {
+			return cfg.IndexProvider.RetrievalAddresses
+		}
+		var out []string
+		for _, addr := range h.Addrs() {
+			out = append(out, addr.String())
+		}
+		return out
+	}
+	return newProvider(metrics.LifecycleCtx(mctx, lc), cfg.IndexProvider, ds, key, addrs, dagStore)
+}
+
+func AnnounceActive(mctx metrics.MetricsCtx, lc fx.Lifecycle, p *Provider, pieceStores piece.PieceStores) {
+	if !p.Enabled() {
+		return
+	}
+	ctx := metrics.LifecycleCtx(mctx, lc)
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			go p.AnnounceActive(ctx, pieceStores)
+			return nil
+		},
+	})
+}
+
+var IndexProviderOpts = builder.Options(
+	builder.Override(new(*Provider), NewProvider),
+	builder.Override(AnnounceActiveKey, AnnounceActive),
+)
@@ -0,0 +1,307 @@
+package indexprovider
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multihash"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+
+	"github.com/filecoin-project/venus-market/config"
+	"github.com/filecoin-project/venus-market/piece"
+)
+
+var log = logging.Logger("index-provider")
+
+var ErrDisabled = xerrors.New("index provider is disabled")
+
+var (
+	headKey   = datastore.NewKey("/head")
+	blocksKey = datastore.NewKey("/blocks")
+	piecesKey = datastore.NewKey("/pieces")
+)
+
+// payloadIndex lists the payload multihashes of a piece
+type payloadIndex interface {
+	PayloadMultihashes(ctx context.Context, pieceCid cid.Cid) ([]multihash.Multihash, error)
+}
+
+// pieceAnnouncement is the last advertisement of a piece
+type pieceAnnouncement struct {
+	Ad      cid.Cid
+	Removed bool
+}
+
+// Provider keeps the advertisement chain of the market: an advertisement adds the payload
+// multihashes of a piece once a deal of the piece is active, another removes them once all its
+// deals ended. The advertisements and their entries are kept in the datastore and served to the
+// indexers by ServeHTTP.
+type Provider struct {
+	ctx       context.Context
+	enable    bool
+	chunkSize int
+	ds        datastore.Batching
+	key       crypto.PrivKey
+	self      peer.ID
+	addrs     func() []string
+	index     payloadIndex
+
+	lk sync.Mutex
+}
+
+func newProvider(ctx context.Context, cfg config.IndexProviderConfig, ds datastore.Batching, key crypto.PrivKey, addrs func() []string, index payloadIndex) (*Provider, error) {
+	self, err := peer.IDFromPrivateKey(key)
+	if err != nil {
+		return nil, xerrors.Errorf("get peer id of the provider key: %w", err)
+	}
+	chunkSize := cfg.EntriesChunkSize
+	if chunkSize <= 0 {
+		chunkSize = 16384
+	}
+	return &Provider{
+		ctx:       ctx,
+		enable:    cfg.Enable,
+		chunkSize: chunkSize,
+		ds:        ds,
+		key:       key,
+		self:      self,
+		addrs:     addrs,
+		index:     index,
+	}, nil
+}
+
+// Enabled reports whether the advertisements are published
+func (p *Provider) Enabled() bool {
+	return p != nil && p.enable
+}
+
+// Head returns the latest advertisement, cid.Undef if none was published yet
+func (p *Provider) Head() (cid.Cid, error) {
+	if !p.Enabled() {
+		return cid.Undef, ErrDisabled
+	}
+	data, err := p.ds.Get(headKey)
+	if xerrors.Is(err, datastore.ErrNotFound) {
+		return cid.Undef, nil
+	}
+	if err != nil {
+		return cid.Undef, err
+	}
+	return cid.Cast(data)
+}
+
+// Get returns the stored bytes of an advertisement or an entries chunk
+func (p *Provider) Get(id cid.Cid) ([]byte, error) {
+	if !p.Enabled() {
+		return nil, ErrDisabled
+	}
+	return p.ds.Get(blocksKey.ChildString(id.String()))
+}
+
+// GetAdvertisement returns an advertisement of the chain
+func (p *Provider) GetAdvertisement(id cid.Cid) (*Advertisement, error) {
+	data, err := p.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	n, err := decode(data)
+	if err != nil {
+		return nil, xerrors.Errorf("decode advertisement %s: %w", id, err)
+	}
+	return UnwrapAdvertisement(n)
+}
+
+// NotifyPut publishes an advertisement adding the payload multihashes of the piece, it returns the
+// advertisement of the piece if it is already announced
+func (p *Provider) NotifyPut(ctx context.Context, pieceCid cid.Cid) (cid.Cid, error) {
+	if !p.Enabled() {
+		return cid.Undef, ErrDisabled
+	}
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	last, err := p.announcement(pieceCid)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if last != nil && !last.Removed {
+		return last.Ad, nil
+	}
+
+	mhs, err := p.index.PayloadMultihashes(ctx, pieceCid)
+	if err != nil {
+		return cid.Undef, xerrors.Errorf("list payload multihashes of piece %s: %w", pieceCid, err)
+	}
+	if len(mhs) == 0 {
+		return cid.Undef, xerrors.Errorf("piece %s has no payload block", pieceCid)
+	}
+	entries, err := p.putEntries(mhs)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	id, err := p.publish(pieceCid, &Advertisement{Entries: entries})
+	if err != nil {
+		return cid.Undef, err
+	}
+	log.Infow("announced piece", "piece", pieceCid, "advertisement", id, "multihashes", len(mhs))
+	return id, nil
+}
+
+// NotifyRemove publishes an advertisement removing the payload multihashes of the piece, it's a
+// no-op for a piece which isn't announced
+func (p *Provider) NotifyRemove(ctx context.Context, pieceCid cid.Cid) (cid.Cid, error) {
+	if !p.Enabled() {
+		return cid.Undef, ErrDisabled
+	}
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	last, err := p.announcement(pieceCid)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if last == nil || last.Removed {
+		return cid.Undef, nil
+	}
+
+	id, err := p.publish(pieceCid, &Advertisement{Entries: NoEntries, IsRm: true})
+	if err != nil {
+		return cid.Undef, err
+	}
+	log.Infow("announced piece removal", "piece", pieceCid, "advertisement", id)
+	return id, nil
+}
+
+// OnDealEvent announces the piece of a deal once the deal is active
+func (p *Provider) OnDealEvent(event storagemarket.ProviderEvent, deal storagemarket.MinerDeal) {
+	if !p.Enabled() || event != storagemarket.ProviderEventDealActivated {
+		return
+	}
+	go func() {
+		if _, err := p.NotifyPut(p.ctx, deal.Proposal.PieceCID); err != nil {
+			log.Errorf("announce piece %s of deal %d: %s", deal.Proposal.PieceCID, deal.DealID, err)
+		}
+	}()
+}
+
+// AnnounceActive announces the pieces with an active deal which aren't announced yet, eg. the ones
+// activated before the index provider was enabled
+func (p *Provider) AnnounceActive(ctx context.Context, pieceStores piece.PieceStores) {
+	announced := make(map[cid.Cid]struct{})
+	for mAddr, ps := range pieceStores {
+		deals, err := ps.GetDealsByStatus(piece.Proving)
+		if err != nil {
+			log.Errorf("list active deals of miner %s: %s", mAddr, err)
+			continue
+		}
+		for _, deal := range deals {
+			pieceCid := deal.Proposal.PieceCID
+			if _, ok := announced[pieceCid]; ok {
+				continue
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			if _, err := p.NotifyPut(ctx, pieceCid); err != nil {
+				log.Errorf("announce piece %s of deal %d: %s", pieceCid, deal.DealID, err)
+				continue
+			}
+			announced[pieceCid] = struct{}{}
+		}
+	}
+}
+
+// putEntries stores the multihashes in chunks and returns the first one
+func (p *Provider) putEntries(mhs []multihash.Multihash) (cid.Cid, error) {
+	var next *cid.Cid
+	for end := len(mhs); end > 0; end -= p.chunkSize {
+		start := end - p.chunkSize
+		if start < 0 {
+			start = 0
+		}
+		id, data, err := encodeEntryChunk(&EntryChunk{Entries: mhs[start:end], Next: next})
+		if err != nil {
+			return cid.Undef, xerrors.Errorf("encode entries: %w", err)
+		}
+		if err := p.ds.Put(blocksKey.ChildString(id.String()), data); err != nil {
+			return cid.Undef, xerrors.Errorf("save entries: %w", err)
+		}
+		next = &id
+	}
+	return *next, nil
+}
+
+// publish signs the advertisement of the piece and makes it the head of the chain
+func (p *Provider) publish(pieceCid cid.Cid, ad *Advertisement) (cid.Cid, error) {
+	head, err := p.ds.Get(headKey)
+	switch {
+	case err == nil:
+		prev, err := cid.Cast(head)
+		if err != nil {
+			return cid.Undef, xerrors.Errorf("decode head: %w", err)
+		}
+		ad.PreviousID = &prev
+	case !xerrors.Is(err, datastore.ErrNotFound):
+		return cid.Undef, xerrors.Errorf("get head: %w", err)
+	}
+	ad.Provider = p.self.String()
+	ad.Addresses = p.addrs()
+	ad.ContextID = pieceCid.Bytes()
+	if ad.Metadata, err = pieceMetadata(pieceCid); err != nil {
+		return cid.Undef, xerrors.Errorf("encode metadata: %w", err)
+	}
+	if err := ad.Sign(p.key); err != nil {
+		return cid.Undef, xerrors.Errorf("sign advertisement: %w", err)
+	}
+
+	id, data, err := encodeAdvertisement(ad)
+	if err != nil {
+		return cid.Undef, xerrors.Errorf("encode advertisement: %w", err)
+	}
+	announcement, err := json.Marshal(&pieceAnnouncement{Ad: id, Removed: ad.IsRm})
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	batch, err := p.ds.Batch()
+	if err != nil {
+		return cid.Undef, err
+	}
+	if err := batch.Put(blocksKey.ChildString(id.String()), data); err != nil {
+		return cid.Undef, err
+	}
+	if err := batch.Put(piecesKey.ChildString(pieceCid.String()), announcement); err != nil {
+		return cid.Undef, err
+	}
+	if err := batch.Put(headKey, id.Bytes()); err != nil {
+		return cid.Undef, err
+	}
+	if err := batch.Commit(); err != nil {
+		return cid.Undef, xerrors.Errorf("save advertisement: %w", err)
+	}
+	return id, nil
+}
+
+func (p *Provider) announcement(pieceCid cid.Cid) (*pieceAnnouncement, error) {
+	data, err := p.ds.Get(piecesKey.ChildString(pieceCid.String()))
+	if xerrors.Is(err, datastore.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("get announcement of piece %s: %w", pieceCid, err)
+	}
+	var a pieceAnnouncement
+	if err := json.Unmarshal(data, &a); err != nil {
+		return nil, xerrors.Errorf("decode announcement of piece %s: %w", pieceCid, err)
+	}
+	return &a, nil
+}
@@ -0,0 +1,168 @@
+package indexprovider
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	ds_sync "github.com/ipfs/go-datastore/sync"
+	ipld "github.com/ipld/go-ipld-prime"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/multiformats/go-multihash"
+	"github.com/multiformats/go-varint"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/venus-market/config"
+)
+
+type mockPayloadIndex map[cid.Cid][]multihash.Multihash
+
+func (m mockPayloadIndex) PayloadMultihashes(ctx context.Context, pieceCid cid.Cid) ([]multihash.Multihash, error) {
+	return m[pieceCid], nil
+}
+
+// standInIndexer pulls the advertisement chain like an indexer and keeps the piece of every
+// multihash announced
+type standInIndexer struct {
+	t       *testing.T
+	url     string
+	last    cid.Cid
+	pieceOf map[string]cid.Cid
+}
+
+func (ix *standInIndexer) get(name string) []byte {
+	resp, err := http.Get(ix.url + IndexProviderPath + name)
+	require.NoError(ix.t, err)
+	defer resp.Body.Close() //nolint:errcheck
+	require.Equal(ix.t, http.StatusOK, resp.StatusCode)
+	data, err := ioutil.ReadAll(resp.Body)
+	require.NoError(ix.t, err)
+	if name != "head" {
+		// the content is addressed by its cid
+		id, err := linkOf(data)
+		require.NoError(ix.t, err)
+		require.Equal(ix.t, name, id.String())
+	}
+	return data
+}
+
+func (ix *standInIndexer) getNode(id cid.Cid) ipld.Node {
+	n, err := decode(ix.get(id.String()))
+	require.NoError(ix.t, err)
+	return n
+}
+
+func (ix *standInIndexer) sync() {
+	var head HeadResponse
+	require.NoError(ix.t, json.Unmarshal(ix.get("head"), &head))
+
+	// walk back to the last advertisement synced, then apply the new ones oldest first
+	var ads []*Advertisement
+	for next := head.Head; next != nil && !next.Equals(ix.last); {
+		ad, err := UnwrapAdvertisement(ix.getNode(*next))
+		require.NoError(ix.t, err)
+		require.NoError(ix.t, ad.Verify())
+		ads = append(ads, ad)
+		next = ad.PreviousID
+	}
+	for i := len(ads) - 1; i >= 0; i-- {
+		ad := ads[i]
+		pieceCid, err := ad.PieceCid()
+		require.NoError(ix.t, err)
+		if ad.IsRm {
+			for mh, c := range ix.pieceOf {
+				if c.Equals(pieceCid) {
+					delete(ix.pieceOf, mh)
+				}
+			}
+			continue
+		}
+		for next := &ad.Entries; next != nil; {
+			chunk, err := UnwrapEntryChunk(ix.getNode(*next))
+			require.NoError(ix.t, err)
+			for _, mh := range chunk.Entries {
+				ix.pieceOf[mh.B58String()] = pieceCid
+			}
+			next = chunk.Next
+		}
+	}
+	if head.Head != nil {
+		ix.last = *head.Head
+	}
+}
+
+func TestProvider(t *testing.T) {
+	ctx := context.Background()
+	key, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+
+	newCid := func(data string) cid.Cid {
+		mh, err := multihash.Sum([]byte(data), multihash.SHA2_256, -1)
+		require.NoError(t, err)
+		return cid.NewCidV1(cid.Raw, mh)
+	}
+	piece1, piece2 := newCid("piece1"), newCid("piece2")
+	index := mockPayloadIndex{
+		piece1: {newCid("a").Hash(), newCid("b").Hash(), newCid("c").Hash()},
+		piece2: {newCid("d").Hash()},
+	}
+
+	p, err := newProvider(ctx, config.IndexProviderConfig{Enable: true, EntriesChunkSize: 2}, ds_sync.MutexWrap(datastore.NewMapDatastore()),
+		key, func() []string { return []string{"/ip4/127.0.0.1/tcp/1234"} }, index)
+	require.NoError(t, err)
+	srv := httptest.NewServer(p)
+	defer srv.Close()
+	ix := &standInIndexer{t: t, url: srv.URL, pieceOf: make(map[string]cid.Cid)}
+
+	ix.sync()
+	require.Empty(t, ix.pieceOf)
+
+	ad1, err := p.NotifyPut(ctx, piece1)
+	require.NoError(t, err)
+	_, err = p.NotifyPut(ctx, piece2)
+	require.NoError(t, err)
+	// a piece is only announced once
+	again, err := p.NotifyPut(ctx, piece1)
+	require.NoError(t, err)
+	require.Equal(t, ad1, again)
+
+	ix.sync()
+	require.Len(t, ix.pieceOf, 4)
+	require.Equal(t, piece1, ix.pieceOf[newCid("c").Hash().B58String()])
+	require.Equal(t, piece2, ix.pieceOf[newCid("d").Hash().B58String()])
+
+	// the removal drops every multihash of the piece
+	rm, err := p.NotifyRemove(ctx, piece1)
+	require.NoError(t, err)
+	head, err := p.Head()
+	require.NoError(t, err)
+	require.Equal(t, rm, head)
+	ix.sync()
+	require.Len(t, ix.pieceOf, 1)
+	rm, err = p.NotifyRemove(ctx, piece1)
+	require.NoError(t, err)
+	require.False(t, rm.Defined())
+
+	// a tampered advertisement fails the verification
+	ad, err := p.GetAdvertisement(head)
+	require.NoError(t, err)
+	require.NoError(t, ad.Verify())
+	require.True(t, ad.IsRm)
+	require.Equal(t, NoEntries, ad.Entries)
+	protocol, _, err := varint.FromUvarint(ad.Metadata)
+	require.NoError(t, err)
+	require.Equal(t, uint64(graphsyncFilecoinV1), protocol)
+	ad.Addresses = []string{"/ip4/10.0.0.1/tcp/1"}
+	require.Error(t, ad.Verify())
+
+	disabled, err := newProvider(ctx, config.IndexProviderConfig{}, datastore.NewMapDatastore(), key, nil, index)
+	require.NoError(t, err)
+	_, err = disabled.NotifyPut(ctx, piece1)
+	require.ErrorIs(t, err, ErrDisabled)
+}
@@ -14,10 +14,10 @@ type PieceMetaDs datastore.Batching
 //  /metadata/storagemarket/cid-infos
 type CIDInfoDS datastore.Batching
 
-//  /metadata/storagemarket/pieces
+//  /metadata/storagemarket/pieces, pieces of each miner are under /metadata/storagemarket/pieces/<miner>
 type PieceInfoDS datastore.Batching
 
-// /metadata/retrievals/provider
+// /metadata/retrievals/provider, each miner has its own provider under /metadata/retrievals/provider/<miner>
 type RetrievalProviderDS datastore.Batching
 
 // /metadata/retrievals/provider/retrieval-ask
@@ -26,15 +26,33 @@ type RetrievalAskDS datastore.Batching //key = latest
 // /metadata/datatransfer/provider/transfers
 type DagTransferDS datastore.Batching
 
-// /metadata/deals/provider
+// /metadata/deals/provider, each miner has its own provider under /metadata/deals/provider/<miner>
 type ProviderDealDS datastore.Batching
 
 //   /metadata/deals/provider/storage-ask
-type StorageAskDS datastore.Batching //key = latest
+type StorageAskDS datastore.Batching //key = <miner>/latest
 
 // /metadata/paych/
 type PayChanDS datastore.Batching
 
+// /metadata/quota
+type QuotaDS datastore.Batching
+
+// /metadata/deal-rules
+type DealRuleDS datastore.Batching
+
+// /metadata/deals/publish
+type DealPublishDS datastore.Batching
+
+// /metadata/unseal-jobs
+type UnsealJobDS datastore.Batching
+
+// /metadata/index-provider
+type IndexProviderDS datastore.Batching
+
+// /metadata/dagstore/top-level-index
+type TopIndexDS datastore.Batching
+
 //*********************************client
 // /metadata/deals/client
 type ClientDatastore datastore.Batching
@@ -0,0 +1,95 @@
+package models
+
+import (
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/venus-market/types"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"github.com/ipfs/go-datastore/query"
+	logging "github.com/ipfs/go-log/v2"
+	"golang.org/x/xerrors"
+)
+
+var log = logging.Logger("models")
+
+// MinerNamespace returns the part of ds which belongs to mAddr, the market keeps
+// the records of every miner it serves under ds/<miner address>
+func MinerNamespace(ds datastore.Batching, mAddr address.Address) datastore.Batching {
+	return namespace.Wrap(ds, datastore.NewKey(mAddr.String()))
+}
+
+// minerNamespaceMigrated marks a datastore whose root records were moved, the migration runs once
+var minerNamespaceMigrated = datastore.NewKey("/_migrations/miner-namespace")
+
+// migrateToMinerNamespace moves records written by a single-miner market at the root of ds
+// into the namespace of the first configured miner. keys under the namespaces in skip are
+// left untouched, they belong to child datastores which are migrated on their own, and so are
+// the namespaces of miners, including the ones removed from the config since.
+func migrateToMinerNamespace(ds datastore.Batching, miners types.MinerAddresses, skip ...string) error {
+	if len(miners) == 0 {
+		return nil
+	}
+	done, err := ds.Has(minerNamespaceMigrated)
+	if err != nil {
+		return xerrors.Errorf("check miner namespace migration: %w", err)
+	}
+	if done {
+		return nil
+	}
+
+	keep := make(map[string]struct{}, len(miners)+len(skip)+1)
+	for _, mAddr := range miners {
+		keep[mAddr.String()] = struct{}{}
+	}
+	for _, ns := range skip {
+		keep[datastore.NewKey(ns).BaseNamespace()] = struct{}{}
+	}
+	keep[minerNamespaceMigrated.Namespaces()[0]] = struct{}{}
+
+	res, err := ds.Query(query.Query{KeysOnly: true})
+	if err != nil {
+		return xerrors.Errorf("query keys to migrate: %w", err)
+	}
+	var legacy []datastore.Key
+	for r := range res.Next() {
+		if r.Error != nil {
+			_ = res.Close()
+			return r.Error
+		}
+		key := datastore.NewKey(r.Key)
+		ns := key.Namespaces()[0]
+		if _, ok := keep[ns]; ok {
+			continue
+		}
+		if _, err := address.NewFromString(ns); err == nil {
+			continue
+		}
+		legacy = append(legacy, key)
+	}
+	_ = res.Close()
+
+	target := miners[0]
+	if len(legacy) > 0 {
+		log.Infof("move %d records into namespace of miner %s", len(legacy), target)
+	}
+	batch, err := ds.Batch()
+	if err != nil {
+		return err
+	}
+	for _, key := range legacy {
+		val, err := ds.Get(key)
+		if err != nil {
+			return xerrors.Errorf("get %s: %w", key, err)
+		}
+		if err := batch.Put(datastore.NewKey(target.String()).Child(key), val); err != nil {
+			return err
+		}
+		if err := batch.Delete(key); err != nil {
+			return err
+		}
+	}
+	if err := batch.Put(minerNamespaceMigrated, []byte(target.String())); err != nil {
+		return err
+	}
+	return batch.Commit()
+}
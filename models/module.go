@@ -6,6 +6,7 @@ import (
 	"github.com/filecoin-project/venus-market/builder"
 	"github.com/filecoin-project/venus-market/config"
 	"github.com/filecoin-project/venus-market/metrics"
+	"github.com/filecoin-project/venus-market/types"
 	"github.com/ipfs/go-datastore"
 	"github.com/ipfs/go-datastore/namespace"
 	badger "github.com/ipfs/go-ds-badger2"
@@ -27,6 +28,12 @@ const (
 	dealProvider      = "/deals/provider"
 	storageAsk        = "storage-ask"
 	paych             = "/paych/"
+	quota             = "/quota"
+	dealRule          = "/deal-rules"
+	dealPublish       = "/deals/publish"
+	unsealJob         = "/unseal-jobs"
+	indexProvider     = "/index-provider"
+	topIndex          = "/dagstore/top-level-index"
 
 	//client
 	client          = "/client"
@@ -79,12 +86,14 @@ func NewCidInfoDs(ds PieceMetaDs) CIDInfoDS {
 	return namespace.Wrap(ds, datastore.NewKey(cidinfo))
 }
 
-func NewPieceInfoDs(ds PieceMetaDs) PieceInfoDS {
-	return namespace.Wrap(ds, datastore.NewKey(pieceinfo))
+func NewPieceInfoDs(ds PieceMetaDs, miners types.MinerAddresses) (PieceInfoDS, error) {
+	pieceDs := namespace.Wrap(ds, datastore.NewKey(pieceinfo))
+	return pieceDs, migrateToMinerNamespace(pieceDs, miners)
 }
 
-func NewRetrievalProviderDS(ds MetadataDS) RetrievalProviderDS {
-	return namespace.Wrap(ds, datastore.NewKey(retrievalProvider))
+func NewRetrievalProviderDS(ds MetadataDS, miners types.MinerAddresses) (RetrievalProviderDS, error) {
+	providerDs := namespace.Wrap(ds, datastore.NewKey(retrievalProvider))
+	return providerDs, migrateToMinerNamespace(providerDs, miners)
 }
 
 func NewRetrievalAskDS(ds RetrievalProviderDS) RetrievalAskDS {
@@ -95,18 +104,44 @@ func NewDagTransferDS(ds MetadataDS) DagTransferDS {
 	return namespace.Wrap(ds, datastore.NewKey(transfer))
 }
 
-func NewProviderDealDS(ds MetadataDS) ProviderDealDS {
-	return namespace.Wrap(ds, datastore.NewKey(dealProvider))
+func NewProviderDealDS(ds MetadataDS, miners types.MinerAddresses) (ProviderDealDS, error) {
+	providerDs := namespace.Wrap(ds, datastore.NewKey(dealProvider))
+	return providerDs, migrateToMinerNamespace(providerDs, miners, storageAsk)
 }
 
-func NewStorageAskDS(ds ProviderDealDS) StorageAskDS {
-	return namespace.Wrap(ds, datastore.NewKey(storageAsk))
+func NewStorageAskDS(ds ProviderDealDS, miners types.MinerAddresses) (StorageAskDS, error) {
+	askDs := namespace.Wrap(ds, datastore.NewKey(storageAsk))
+	return askDs, migrateToMinerNamespace(askDs, miners)
 }
 
 func NewPayChanDS(ds MetadataDS) PayChanDS {
 	return namespace.Wrap(ds, datastore.NewKey(paych))
 }
 
+func NewQuotaDS(ds MetadataDS) QuotaDS {
+	return namespace.Wrap(ds, datastore.NewKey(quota))
+}
+
+func NewDealRuleDS(ds MetadataDS) DealRuleDS {
+	return namespace.Wrap(ds, datastore.NewKey(dealRule))
+}
+
+func NewDealPublishDS(ds MetadataDS) DealPublishDS {
+	return namespace.Wrap(ds, datastore.NewKey(dealPublish))
+}
+
+func NewUnsealJobDS(ds MetadataDS) UnsealJobDS {
+	return namespace.Wrap(ds, datastore.NewKey(unsealJob))
+}
+
+func NewIndexProviderDS(ds MetadataDS) IndexProviderDS {
+	return namespace.Wrap(ds, datastore.NewKey(indexProvider))
+}
+
+func NewTopIndexDS(ds MetadataDS) TopIndexDS {
+	return namespace.Wrap(ds, datastore.NewKey(topIndex))
+}
+
 // NewClientDatastore creates a datastore for the client to store its deals
 func NewClientDatastore(ds MetadataDS) ClientDatastore {
 	return namespace.Wrap(ds, datastore.NewKey(dealClient))
@@ -146,6 +181,12 @@ var DBOptions = func(server bool) builder.Option {
 			builder.Override(new(StagingBlockstore), NewStagingBlockStore),
 			builder.Override(new(PayChanDS), NewPayChanDS),
 			builder.Override(new(FundMgrDS), NewFundMgrDS),
+			builder.Override(new(QuotaDS), NewQuotaDS),
+			builder.Override(new(DealRuleDS), NewDealRuleDS),
+			builder.Override(new(DealPublishDS), NewDealPublishDS),
+			builder.Override(new(UnsealJobDS), NewUnsealJobDS),
+			builder.Override(new(IndexProviderDS), NewIndexProviderDS),
+			builder.Override(new(TopIndexDS), NewTopIndexDS),
 		)
 	} else {
 		return builder.Options(
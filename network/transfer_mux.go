@@ -0,0 +1,249 @@
+package network
+
+import (
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"golang.org/x/xerrors"
+)
+
+// keep the owner of a finished channel for a while, late events still need to be routed
+var channelOwnerTTL = time.Minute * 10
+
+// TransferMux shares one provider data transfer manager between the storage and retrieval
+// providers of every miner served by the market.
+//
+// go-data-transfer accepts only one validator per voucher type, so the mux registers a
+// dispatcher which asks the validator of each miner in turn. The miner which accepts a
+// channel owns it, later validations and events of that channel are only routed to it.
+type TransferMux struct {
+	dt ProviderDataTransfer
+
+	lk           sync.RWMutex
+	validators   map[datatransfer.TypeIdentifier][]minerHandler
+	revalidators map[datatransfer.TypeIdentifier][]minerHandler
+	configurers  map[datatransfer.TypeIdentifier][]minerHandler
+	resultTypes  map[datatransfer.TypeIdentifier]struct{}
+	owners       map[datatransfer.ChannelID]address.Address
+}
+
+type minerHandler struct {
+	miner   address.Address
+	handler interface{}
+}
+
+func NewTransferMux(dt ProviderDataTransfer) *TransferMux {
+	mux := &TransferMux{
+		dt:           dt,
+		validators:   make(map[datatransfer.TypeIdentifier][]minerHandler),
+		revalidators: make(map[datatransfer.TypeIdentifier][]minerHandler),
+		configurers:  make(map[datatransfer.TypeIdentifier][]minerHandler),
+		resultTypes:  make(map[datatransfer.TypeIdentifier]struct{}),
+		owners:       make(map[datatransfer.ChannelID]address.Address),
+	}
+	dt.SubscribeToEvents(mux.forgetFinishedChannel)
+	return mux
+}
+
+// ForMiner returns the data transfer manager to hand to the providers of mAddr
+func (mux *TransferMux) ForMiner(mAddr address.Address) ProviderDataTransfer {
+	return &minerDataTransfer{Manager: mux.dt, mux: mux, miner: mAddr}
+}
+
+func (mux *TransferMux) owner(chid datatransfer.ChannelID) (address.Address, bool) {
+	mux.lk.RLock()
+	defer mux.lk.RUnlock()
+	mAddr, ok := mux.owners[chid]
+	return mAddr, ok
+}
+
+func (mux *TransferMux) setOwner(chid datatransfer.ChannelID, mAddr address.Address) {
+	mux.lk.Lock()
+	defer mux.lk.Unlock()
+	mux.owners[chid] = mAddr
+}
+
+func (mux *TransferMux) forgetFinishedChannel(_ datatransfer.Event, state datatransfer.ChannelState) {
+	switch state.Status() {
+	case datatransfer.Completed, datatransfer.Failed, datatransfer.Cancelled:
+		chid := state.ChannelID()
+		time.AfterFunc(channelOwnerTTL, func() {
+			mux.lk.Lock()
+			delete(mux.owners, chid)
+			mux.lk.Unlock()
+		})
+	}
+}
+
+// handlersFor returns the handlers of the channel owner if it is known, otherwise all of them
+func (mux *TransferMux) handlersFor(all map[datatransfer.TypeIdentifier][]minerHandler, voucherType datatransfer.TypeIdentifier, chid datatransfer.ChannelID) []minerHandler {
+	mux.lk.RLock()
+	defer mux.lk.RUnlock()
+
+	handlers := all[voucherType]
+	mAddr, ok := mux.owners[chid]
+	if !ok {
+		return handlers
+	}
+	for _, h := range handlers {
+		if h.miner == mAddr {
+			return []minerHandler{h}
+		}
+	}
+	return handlers
+}
+
+// register adds a handler of mAddr for voucherType, first is called when the voucher type is new to the mux
+func (mux *TransferMux) register(all map[datatransfer.TypeIdentifier][]minerHandler, voucherType datatransfer.TypeIdentifier, mAddr address.Address, handler interface{}, first func() error) error {
+	mux.lk.Lock()
+	defer mux.lk.Unlock()
+
+	for _, h := range all[voucherType] {
+		if h.miner == mAddr {
+			return xerrors.Errorf("voucher type %s already registered for miner %s", voucherType, mAddr)
+		}
+	}
+	if len(all[voucherType]) == 0 {
+		if err := first(); err != nil {
+			return err
+		}
+	}
+	all[voucherType] = append(all[voucherType], minerHandler{miner: mAddr, handler: handler})
+	return nil
+}
+
+func (mux *TransferMux) validate(voucherType datatransfer.TypeIdentifier, chid datatransfer.ChannelID, validate func(datatransfer.RequestValidator) (datatransfer.VoucherResult, error)) (datatransfer.VoucherResult, error) {
+	var result datatransfer.VoucherResult
+	err := xerrors.Errorf("no validator for voucher type %s", voucherType)
+	for _, h := range mux.handlersFor(mux.validators, voucherType, chid) {
+		result, err = validate(h.handler.(datatransfer.RequestValidator))
+		// pause and resume are answers of a validator which accepted the request
+		if err == nil || err == datatransfer.ErrPause || err == datatransfer.ErrResume {
+			mux.setOwner(chid, h.miner)
+			return result, err
+		}
+	}
+	return result, err
+}
+
+type voucherValidator struct {
+	mux         *TransferMux
+	voucherType datatransfer.TypeIdentifier
+}
+
+var _ datatransfer.RequestValidator = (*voucherValidator)(nil)
+
+func (v *voucherValidator) ValidatePush(isRestart bool, chid datatransfer.ChannelID, sender peer.ID, voucher datatransfer.Voucher, baseCid cid.Cid, selector ipld.Node) (datatransfer.VoucherResult, error) {
+	return v.mux.validate(v.voucherType, chid, func(validator datatransfer.RequestValidator) (datatransfer.VoucherResult, error) {
+		return validator.ValidatePush(isRestart, chid, sender, voucher, baseCid, selector)
+	})
+}
+
+func (v *voucherValidator) ValidatePull(isRestart bool, chid datatransfer.ChannelID, receiver peer.ID, voucher datatransfer.Voucher, baseCid cid.Cid, selector ipld.Node) (datatransfer.VoucherResult, error) {
+	return v.mux.validate(v.voucherType, chid, func(validator datatransfer.RequestValidator) (datatransfer.VoucherResult, error) {
+		return validator.ValidatePull(isRestart, chid, receiver, voucher, baseCid, selector)
+	})
+}
+
+type voucherRevalidator struct {
+	mux         *TransferMux
+	voucherType datatransfer.TypeIdentifier
+}
+
+var _ datatransfer.Revalidator = (*voucherRevalidator)(nil)
+
+func (v *voucherRevalidator) Revalidate(chid datatransfer.ChannelID, voucher datatransfer.Voucher) (bool, datatransfer.VoucherResult, error) {
+	return v.mux.revalidateType(v.voucherType, chid, func(r datatransfer.Revalidator) (bool, datatransfer.VoucherResult, error) {
+		return r.Revalidate(chid, voucher)
+	})
+}
+
+func (v *voucherRevalidator) OnPullDataSent(chid datatransfer.ChannelID, additionalBytesSent uint64) (bool, datatransfer.VoucherResult, error) {
+	return v.mux.revalidateType(v.voucherType, chid, func(r datatransfer.Revalidator) (bool, datatransfer.VoucherResult, error) {
+		return r.OnPullDataSent(chid, additionalBytesSent)
+	})
+}
+
+func (v *voucherRevalidator) OnPushDataReceived(chid datatransfer.ChannelID, additionalBytesReceived uint64) (bool, datatransfer.VoucherResult, error) {
+	return v.mux.revalidateType(v.voucherType, chid, func(r datatransfer.Revalidator) (bool, datatransfer.VoucherResult, error) {
+		return r.OnPushDataReceived(chid, additionalBytesReceived)
+	})
+}
+
+func (v *voucherRevalidator) OnComplete(chid datatransfer.ChannelID) (bool, datatransfer.VoucherResult, error) {
+	return v.mux.revalidateType(v.voucherType, chid, func(r datatransfer.Revalidator) (bool, datatransfer.VoucherResult, error) {
+		return r.OnComplete(chid)
+	})
+}
+
+func (mux *TransferMux) revalidateType(voucherType datatransfer.TypeIdentifier, chid datatransfer.ChannelID, revalidate func(datatransfer.Revalidator) (bool, datatransfer.VoucherResult, error)) (bool, datatransfer.VoucherResult, error) {
+	for _, h := range mux.handlersFor(mux.revalidators, voucherType, chid) {
+		handled, result, err := revalidate(h.handler.(datatransfer.Revalidator))
+		if handled {
+			return handled, result, err
+		}
+	}
+	return false, nil, nil
+}
+
+// minerDataTransfer is the view of the shared data transfer manager given to the providers of one miner
+type minerDataTransfer struct {
+	datatransfer.Manager
+	mux   *TransferMux
+	miner address.Address
+}
+
+func (m *minerDataTransfer) RegisterVoucherType(voucherType datatransfer.Voucher, validator datatransfer.RequestValidator) error {
+	typ := voucherType.Type()
+	return m.mux.register(m.mux.validators, typ, m.miner, validator, func() error {
+		return m.Manager.RegisterVoucherType(voucherType, &voucherValidator{mux: m.mux, voucherType: typ})
+	})
+}
+
+func (m *minerDataTransfer) RegisterRevalidator(voucherType datatransfer.Voucher, revalidator datatransfer.Revalidator) error {
+	typ := voucherType.Type()
+	return m.mux.register(m.mux.revalidators, typ, m.miner, revalidator, func() error {
+		return m.Manager.RegisterRevalidator(voucherType, &voucherRevalidator{mux: m.mux, voucherType: typ})
+	})
+}
+
+func (m *minerDataTransfer) RegisterVoucherResultType(resultType datatransfer.VoucherResult) error {
+	m.mux.lk.Lock()
+	defer m.mux.lk.Unlock()
+
+	if _, ok := m.mux.resultTypes[resultType.Type()]; ok {
+		return nil
+	}
+	if err := m.Manager.RegisterVoucherResultType(resultType); err != nil {
+		return err
+	}
+	m.mux.resultTypes[resultType.Type()] = struct{}{}
+	return nil
+}
+
+func (m *minerDataTransfer) RegisterTransportConfigurer(voucherType datatransfer.Voucher, configurer datatransfer.TransportConfigurer) error {
+	typ := voucherType.Type()
+	return m.mux.register(m.mux.configurers, typ, m.miner, configurer, func() error {
+		return m.Manager.RegisterTransportConfigurer(voucherType, func(chid datatransfer.ChannelID, voucher datatransfer.Voucher, transport datatransfer.Transport) {
+			for _, h := range m.mux.handlersFor(m.mux.configurers, typ, chid) {
+				h.handler.(datatransfer.TransportConfigurer)(chid, voucher, transport)
+			}
+		})
+	})
+}
+
+// SubscribeToEvents only delivers events of channels owned by this miner, or channels
+// that no miner has claimed yet
+func (m *minerDataTransfer) SubscribeToEvents(subscriber datatransfer.Subscriber) datatransfer.Unsubscribe {
+	return m.Manager.SubscribeToEvents(func(event datatransfer.Event, channelState datatransfer.ChannelState) {
+		if owner, ok := m.mux.owner(channelState.ChannelID()); ok && owner != m.miner {
+			return
+		}
+		subscriber(event, channelState)
+	})
+}
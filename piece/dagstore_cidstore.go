@@ -0,0 +1,119 @@
+package piece
+
+import (
+	"github.com/filecoin-project/go-fil-markets/piecestore"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/multiformats/go-multihash"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/venus-market/models"
+)
+
+// dagStoreCidInfoStore answers the cid infos from the top level index of the dagstore merged with
+// the legacy cid infos, until those are dropped
+type dagStoreCidInfoStore struct {
+	index  *TopLevelIndex
+	legacy CIDStore
+}
+
+// NewDagStoreCidInfoStore returns a CIDStore looking up the payloads in the top level index
+func NewDagStoreCidInfoStore(ds models.CIDInfoDS, index *TopLevelIndex) (CIDStore, error) {
+	legacy, err := NewDsCidInfoStore(ds)
+	if err != nil {
+		return nil, err
+	}
+	return &dagStoreCidInfoStore{index: index, legacy: legacy}, nil
+}
+
+// AddPieceBlockLocations is a no-op, the dagstore queues the piece for the top level index when
+// its shard is registered
+func (ps *dagStoreCidInfoStore) AddPieceBlockLocations(pieceCID cid.Cid, blockLocations map[cid.Cid]piecestore.BlockLocation) error {
+	return nil
+}
+
+// ListCidInfoKeys returns the payload cids of the legacy cid infos, then the multihashes of the
+// top level index wrapped in CIDv1 with the raw codec. The index doesn't keep the codec of the
+// payload cids, so only the multihash of those keys identifies the payload.
+func (ps *dagStoreCidInfoStore) ListCidInfoKeys() ([]cid.Cid, error) {
+	legacy, err := ps.legacy.ListCidInfoKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]struct{}, len(legacy))
+	out := make([]cid.Cid, 0, len(legacy))
+	for _, c := range legacy {
+		seen[string(c.Hash())] = struct{}{}
+		out = append(out, c)
+	}
+	// the index only knows the multihashes, they are listed as raw cids
+	err = ps.index.ForEachMultihash(func(mh multihash.Multihash) error {
+		if _, ok := seen[string(mh)]; ok {
+			return nil
+		}
+		out = append(out, cid.NewCidV1(cid.Raw, mh))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetCIDInfo returns the pieces holding the payload. The block locations of the legacy cid infos are
+// kept, the offsets in the pieces only known by the index are left empty as the dagstore reads the
+// blocks from the index of the shard.
+func (ps *dagStoreCidInfoStore) GetCIDInfo(payloadCID cid.Cid) (piecestore.CIDInfo, error) {
+	pieces, err := ps.index.PiecesContainingMultihash(payloadCID.Hash())
+	if err != nil {
+		return piecestore.CIDInfo{}, err
+	}
+	// the legacy cid infos may know pieces the index doesn't hold yet
+	cidInfo, err := ps.legacy.GetCIDInfo(payloadCID)
+	if err != nil {
+		if !xerrors.Is(err, datastore.ErrNotFound) || len(pieces) == 0 {
+			return piecestore.CIDInfo{}, err
+		}
+		cidInfo = piecestore.CIDInfo{CID: payloadCID}
+	}
+
+	known := make(map[cid.Cid]struct{}, len(cidInfo.PieceBlockLocations))
+	for _, pbl := range cidInfo.PieceBlockLocations {
+		known[pbl.PieceCID] = struct{}{}
+	}
+	for _, pieceCid := range pieces {
+		if _, ok := known[pieceCid]; ok {
+			continue
+		}
+		cidInfo.PieceBlockLocations = append(cidInfo.PieceBlockLocations, piecestore.PieceBlockLocation{PieceCID: pieceCid})
+	}
+	return cidInfo, nil
+}
+
+// DropCidInfos deletes the legacy cid infos and returns the number of records deleted
+func DropCidInfos(ds models.CIDInfoDS) (int, error) {
+	res, err := ds.Query(query.Query{KeysOnly: true})
+	if err != nil {
+		return 0, xerrors.Errorf("query cid infos: %w", err)
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		return 0, xerrors.Errorf("list cid infos: %w", err)
+	}
+
+	batch, err := ds.Batch()
+	if err != nil {
+		return 0, err
+	}
+	for _, e := range entries {
+		if err := batch.Delete(datastore.NewKey(e.Key)); err != nil {
+			return 0, err
+		}
+	}
+	if err := batch.Commit(); err != nil {
+		return 0, xerrors.Errorf("drop cid infos: %w", err)
+	}
+	return len(entries), nil
+}
@@ -5,16 +5,38 @@ import (
 	"github.com/filecoin-project/go-state-types/abi"
 	"io"
 	"path"
+	"time"
 )
 
+// PieceFileInfo describes a piece saved in a piece storage
+type PieceFileInfo struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
 type IPieceStorage interface {
 	SaveTo(context.Context, string, io.Reader) (int64, error)
 	Read(context.Context, string) (io.ReadCloser, error)
 	ReadOffset(context.Context, string, abi.UnpaddedPieceSize, abi.UnpaddedPieceSize) (io.ReadCloser, error)
 	Has(string) (bool, error)
+	Remove(context.Context, string) error
+	// Usage returns the bytes taken by pieces and the free space of the storage, free is -1
+	// when the storage doesn't know it, e.g. an object store
+	Usage(context.Context) (used int64, free int64, err error)
+	// List returns the pieces saved in the storage
+	List(context.Context) ([]PieceFileInfo, error)
+}
+
+// PieceRenamer is implemented by the piece storages where a file shows up while it is written, a
+// piece written elsewhere is put under a temporary name and renamed once complete. An object of a
+// s3 store only shows up once its upload is complete.
+type PieceRenamer interface {
+	Rename(ctx context.Context, from, to string) error
 }
 
 var _ IPieceStorage = (*PieceStorage)(nil)
+var _ PieceRenamer = (*PieceStorage)(nil)
 
 type PieceStorage struct {
 	path string
@@ -35,3 +57,19 @@ func (p *PieceStorage) ReadOffset(ctx context.Context, s string, offset, size ab
 func (p *PieceStorage) Has(s string) (bool, error) {
 	return Has(path.Join(p.path, s))
 }
+
+func (p *PieceStorage) Remove(ctx context.Context, s string) error {
+	return Remove(path.Join(p.path, s))
+}
+
+func (p *PieceStorage) Rename(ctx context.Context, from, to string) error {
+	return Rename(path.Join(p.path, from), path.Join(p.path, to))
+}
+
+func (p *PieceStorage) Usage(ctx context.Context) (int64, int64, error) {
+	return Usage(p.path)
+}
+
+func (p *PieceStorage) List(ctx context.Context) ([]PieceFileInfo, error) {
+	return List(p.path)
+}
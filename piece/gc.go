@@ -0,0 +1,280 @@
+package piece
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"go.uber.org/fx"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/venus-market/builder"
+	"github.com/filecoin-project/venus-market/config"
+	"github.com/filecoin-project/venus-market/metrics"
+)
+
+var RunPieceGCKey builder.Invoke = builder.NextInvoke()
+
+// reasons a piece is collected for
+const (
+	GCDealsEnded = "deals ended"
+	GCRetention  = "retention passed"
+	GCHighWater  = "above high water"
+	GCExtraCopy  = "extra copy"
+)
+
+// PieceGCResult is a piece file removed, or to be removed by a dry run, from a piece storage
+type PieceGCResult struct {
+	Piece   string
+	Storage string
+	Size    int64
+	ModTime time.Time
+	Reason  string
+	Removed bool
+	Error   string
+}
+
+// PieceGC removes the piece files whose deals are sealed or ended. The copy a deal waiting for
+// sealing is read from is never removed, the pieces of sealed deals are kept for the retention
+// period unless a storage is above its high-water mark.
+type PieceGC struct {
+	cfg           config.PieceGCConfig
+	pieceStores   PieceStores
+	pieceStorages *PieceStorageManager
+	now           func() time.Time
+
+	// a single collection at a time
+	lk sync.Mutex
+}
+
+func NewPieceGC(cfg *config.MarketConfig, pieceStores PieceStores, pieceStorages *PieceStorageManager) *PieceGC {
+	return &PieceGC{
+		cfg:           cfg.PieceGC,
+		pieceStores:   pieceStores,
+		pieceStorages: pieceStorages,
+		now:           time.Now,
+	}
+}
+
+func RunPieceGC(mctx metrics.MetricsCtx, lc fx.Lifecycle, gc *PieceGC) {
+	ctx := metrics.LifecycleCtx(mctx, lc)
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			go gc.run(ctx)
+			return nil
+		},
+	})
+}
+
+func (gc *PieceGC) run(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(gc.cfg.Interval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		results, err := gc.Collect(ctx, false)
+		if err != nil {
+			log.Errorf("collect piece storages: %s", err)
+			continue
+		}
+		var removed int
+		var freed int64
+		for _, res := range results {
+			if res.Removed {
+				removed++
+				freed += res.Size
+			}
+		}
+		if removed > 0 {
+			log.Infow("piece storages collected", "pieces", removed, "freed", freed)
+		}
+	}
+}
+
+// Collect removes the pieces not needed anymore from the writable storages, nothing is removed
+// if dryRun is set
+func (gc *PieceGC) Collect(ctx context.Context, dryRun bool) ([]PieceGCResult, error) {
+	gc.lk.Lock()
+	defer gc.lk.Unlock()
+
+	usages, err := gc.pieceStorages.Usage(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := gc.now()
+	deals := make(map[cid.Cid][]*DealInfo)
+	// the storage a piece waiting for sealing is read from, the first one having it
+	readFrom := make(map[cid.Cid]string)
+
+	var results []PieceGCResult
+	for i, loc := range gc.pieceStorages.Locations() {
+		files, err := loc.List(ctx)
+		if err != nil {
+			return nil, xerrors.Errorf("list piece storage %s: %w", loc.Name, err)
+		}
+
+		var kept []PieceFileInfo
+		var collected int64
+		for _, f := range files {
+			pieceCID, err := cid.Decode(f.Name)
+			if err != nil {
+				continue
+			}
+			pieceDeals, ok := deals[pieceCID]
+			if !ok {
+				if pieceDeals, err = gc.pieceDeals(pieceCID); err != nil {
+					return nil, xerrors.Errorf("get deals of piece %s: %w", pieceCID, err)
+				}
+				deals[pieceCID] = pieceDeals
+			}
+			if len(pieceDeals) == 0 {
+				// not a piece of ours
+				continue
+			}
+			if _, ok := readFrom[pieceCID]; !ok {
+				readFrom[pieceCID] = loc.Name
+			}
+			if loc.ReadOnly {
+				continue
+			}
+
+			reason := gc.collectReason(now, f, pieceDeals, readFrom[pieceCID] == loc.Name)
+			if reason == "" {
+				if gc.removableAboveHighWater(pieceDeals) {
+					kept = append(kept, f)
+				}
+				continue
+			}
+			results = append(results, gc.remove(ctx, loc, f, reason, dryRun))
+			collected += f.Size
+		}
+
+		// the sealed pieces within their retention make room once the storage is above high water
+		excess := gc.excess(usages[i], collected)
+		sort.Slice(kept, func(i, j int) bool {
+			return kept[i].ModTime.Before(kept[j].ModTime)
+		})
+		for _, f := range kept {
+			if excess <= 0 {
+				break
+			}
+			results = append(results, gc.remove(ctx, loc, f, GCHighWater, dryRun))
+			excess -= f.Size
+		}
+	}
+	return results, nil
+}
+
+// collectReason returns why the piece file can be removed, or an empty string if it must be kept.
+// readFrom is set if the file is the copy the sealer reads the piece from.
+func (gc *PieceGC) collectReason(now time.Time, f PieceFileInfo, deals []*DealInfo, readFrom bool) string {
+	ended := true
+	for _, deal := range deals {
+		switch {
+		case isDealWaitingForSealing(deal.Status):
+			if readFrom {
+				return ""
+			}
+			return GCExtraCopy
+		case !IsDealEnded(deal.Status):
+			ended = false
+		}
+	}
+	if ended {
+		return GCDealsEnded
+	}
+	if gc.keepFastRetrieval(deals) {
+		return ""
+	}
+	if now.Sub(f.ModTime) >= time.Duration(gc.cfg.Retention) {
+		return GCRetention
+	}
+	return ""
+}
+
+// removableAboveHighWater reports whether a kept piece file can still be removed when the storage
+// is above its high-water mark
+func (gc *PieceGC) removableAboveHighWater(deals []*DealInfo) bool {
+	for _, deal := range deals {
+		if isDealWaitingForSealing(deal.Status) {
+			return false
+		}
+	}
+	return !gc.keepFastRetrieval(deals)
+}
+
+func (gc *PieceGC) keepFastRetrieval(deals []*DealInfo) bool {
+	if !gc.cfg.KeepFastRetrieval {
+		return false
+	}
+	for _, deal := range deals {
+		if deal.FastRetrieval && deal.Status == Proving {
+			return true
+		}
+	}
+	return false
+}
+
+// excess returns the bytes to remove to bring the storage back under its high-water mark, the
+// capacity is MaxCapacity or else the used and free space
+func (gc *PieceGC) excess(usage PieceStorageUsage, collected int64) int64 {
+	if gc.cfg.HighWater == 0 || usage.ReadOnly {
+		return 0
+	}
+	capacity := usage.MaxCapacity
+	if capacity <= 0 {
+		if usage.Free < 0 {
+			return 0
+		}
+		capacity = usage.Used + usage.Free
+	}
+	highWater := int64(float64(capacity) * float64(gc.cfg.HighWater) / 100)
+	return usage.Used - collected - highWater
+}
+
+func (gc *PieceGC) remove(ctx context.Context, loc *PieceStorageLocation, f PieceFileInfo, reason string, dryRun bool) PieceGCResult {
+	res := PieceGCResult{
+		Piece:   f.Name,
+		Storage: loc.Name,
+		Size:    f.Size,
+		ModTime: f.ModTime,
+		Reason:  reason,
+	}
+	if dryRun {
+		return res
+	}
+	if err := loc.Remove(ctx, f.Name); err != nil {
+		log.Errorf("remove piece %s from piece storage %s: %s", f.Name, loc.Name, err)
+		res.Error = err.Error()
+		return res
+	}
+	log.Infow("piece removed from piece storage", "piece", f.Name, "storage", loc.Name, "reason", reason)
+	res.Removed = true
+	return res
+}
+
+// pieceDeals returns the deals of the piece of every miner
+func (gc *PieceGC) pieceDeals(pieceCID cid.Cid) ([]*DealInfo, error) {
+	var out []*DealInfo
+	for _, ps := range gc.pieceStores {
+		deals, err := ps.GetPieceDeals(pieceCID)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, deals...)
+	}
+	return out, nil
+}
+
+// isDealWaitingForSealing reports whether the sealer still reads the piece of the deal
+func isDealWaitingForSealing(status string) bool {
+	return status == Undefine || status == Assigned || status == Packing
+}
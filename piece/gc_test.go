@@ -0,0 +1,147 @@
+package piece
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/venus/pkg/types/specactors/builtin/market"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	ds_sync "github.com/ipfs/go-datastore/sync"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/venus-market/config"
+)
+
+func TestPieceGC(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)
+	dirA, dirB := t.TempDir(), t.TempDir()
+	pieceStorages, err := newPieceStorageManager(ctx, []config.PieceStorageConfig{
+		{Name: "a", Path: config.PieceStorageString("fs:" + dirA), MaxCapacity: "4KiB"},
+		{Name: "b", Path: config.PieceStorageString("fs:" + dirB), MaxCapacity: "1MiB"},
+	})
+	require.NoError(t, err)
+	ps, err := NewDsPieceStore(ds_sync.MutexWrap(datastore.NewMapDatastore()), 2048, pieceStorages)
+	require.NoError(t, err)
+	mAddr, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+
+	pieces := make([]cid.Cid, 7)
+	for i := range pieces {
+		mh, err := multihash.Sum([]byte{byte(i)}, multihash.IDENTITY, -1)
+		require.NoError(t, err)
+		pieces[i] = cid.NewCidV1(cid.Raw, mh)
+	}
+	writePiece := func(dir string, name string, age time.Duration) {
+		file := filepath.Join(dir, name)
+		require.NoError(t, ioutil.WriteFile(file, make([]byte, 512), 0644))
+		require.NoError(t, os.Chtimes(file, now.Add(-age), now.Add(-age)))
+	}
+	addDeal := func(pieceCID cid.Cid, dealID abi.DealID, status string, fastRetrieval bool) {
+		proposal := market.ClientDealProposal{
+			Proposal: market.DealProposal{
+				PieceCID:             pieceCID,
+				PieceSize:            512,
+				StoragePricePerEpoch: abi.NewTokenAmount(1),
+				ProviderCollateral:   abi.NewTokenAmount(0),
+				ClientCollateral:     abi.NewTokenAmount(0),
+			},
+		}
+		err := ps.UpdateDealOnComplete(pieceCID, proposal, &storagemarket.DataRef{Root: pieceCID}, pieceCID, dealID, fastRetrieval)
+		require.NoError(t, err)
+		switch {
+		case IsDealEnded(status):
+			require.NoError(t, ps.UpdateDealOnChainStatus(dealID, status, 100))
+		case status != Undefine:
+			require.NoError(t, ps.UpdateDealStatus(dealID, status))
+		}
+	}
+
+	// waiting for sealing, the copy in a is read by the sealer
+	addDeal(pieces[0], 1, Undefine, false)
+	writePiece(dirA, pieces[0].String(), 72*time.Hour)
+	writePiece(dirB, pieces[0].String(), 72*time.Hour)
+	// sealed and past the retention
+	addDeal(pieces[1], 2, Proving, false)
+	writePiece(dirA, pieces[1].String(), 48*time.Hour)
+	// sealed fast retrieval deal
+	addDeal(pieces[2], 3, Proving, true)
+	writePiece(dirA, pieces[2].String(), 48*time.Hour)
+	// ended
+	addDeal(pieces[3], 4, Expired, false)
+	addDeal(pieces[3], 5, Slashed, false)
+	writePiece(dirA, pieces[3].String(), time.Hour)
+	// sealed within the retention
+	addDeal(pieces[4], 6, Proving, false)
+	writePiece(dirA, pieces[4].String(), time.Hour)
+	addDeal(pieces[5], 7, Proving, false)
+	writePiece(dirA, pieces[5].String(), 2*time.Hour)
+	// not a piece of a deal
+	writePiece(dirA, pieces[6].String(), 72*time.Hour)
+	writePiece(dirA, "notes", 72*time.Hour)
+
+	gc := NewPieceGC(&config.MarketConfig{PieceGC: config.PieceGCConfig{
+		Retention:         config.Duration(24 * time.Hour),
+		HighWater:         65,
+		KeepFastRetrieval: true,
+	}}, PieceStores{mAddr: struct {
+		PieceStore
+		CIDStore
+	}{ps, nil}}, pieceStorages)
+	gc.now = func() time.Time { return now }
+
+	collected := func(results []PieceGCResult) map[string]string {
+		out := make(map[string]string)
+		for _, res := range results {
+			out[res.Storage+"/"+res.Piece] = res.Reason
+		}
+		return out
+	}
+	// a is full, the oldest sealed piece within the retention makes room as well
+	expect := map[string]string{
+		"a/" + pieces[1].String(): GCRetention,
+		"a/" + pieces[3].String(): GCDealsEnded,
+		"a/" + pieces[5].String(): GCHighWater,
+		"b/" + pieces[0].String(): GCExtraCopy,
+	}
+
+	results, err := gc.Collect(ctx, true)
+	require.NoError(t, err)
+	require.Equal(t, expect, collected(results))
+	for _, res := range results {
+		require.False(t, res.Removed)
+	}
+	files, err := List("fs:" + dirA)
+	require.NoError(t, err)
+	require.Len(t, files, 8)
+
+	results, err = gc.Collect(ctx, false)
+	require.NoError(t, err)
+	require.Equal(t, expect, collected(results))
+	for _, res := range results {
+		require.True(t, res.Removed)
+		require.Empty(t, res.Error)
+	}
+	for i, exists := range []bool{true, false, true, false, true, false, true} {
+		has, err := Has("fs:" + filepath.Join(dirA, pieces[i].String()))
+		require.NoError(t, err)
+		require.Equal(t, exists, has, i)
+	}
+	has, err := Has("fs:" + filepath.Join(dirB, pieces[0].String()))
+	require.NoError(t, err)
+	require.False(t, has)
+
+	// nothing left to collect
+	results, err = gc.Collect(ctx, false)
+	require.NoError(t, err)
+	require.Empty(t, results)
+}
@@ -0,0 +1,121 @@
+package piece
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"golang.org/x/xerrors"
+)
+
+// deals handed out by AssignUnPackedDeals are leased to the sealer, if the sealer doesn't confirm
+// them through UpdateDealOnPacking before the lease expires, they return to the pool of unpacked deals
+var defaultLeaseDuration = time.Hour
+
+// how often the piece store looks for expired leases in the background
+var leaseCheckInterval = time.Minute
+
+func newLeaseID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// ErrLeaseMismatch is returned when a sealer confirms a deal which isn't leased to it anymore
+var ErrLeaseMismatch = xerrors.New("the deal lease expired or the deal was leased again")
+
+// checkLease allows the confirmation of the deal under leaseID. A leased deal is only confirmed with
+// the id of its lease, a deal not leased with an empty id. The caller releases the expired leases
+// first, so a sealer confirming after its lease expired is refused even if the deal wasn't leased
+// again yet.
+func checkLease(deal *DealInfo, leaseID string) error {
+	if deal.LeaseID != leaseID {
+		return xerrors.Errorf("deal %d confirmed with lease %q, leased to %q under %q: %w", deal.DealID, leaseID, deal.Assignee, deal.LeaseID, ErrLeaseMismatch)
+	}
+	return nil
+}
+
+// releaseExpiredLeasesLocked returns the deals whose lease expired before now to the pool,
+// the caller must hold pieceLk
+func (ps *dsPieceStore) releaseExpiredLeasesLocked(now time.Time) error {
+	qres, err := ps.pieces.Query(query.Query{
+		Prefix:   leaseIndexPrefix.String(),
+		KeysOnly: true,
+		Orders:   []query.Order{query.OrderByKey{}},
+	})
+	if err != nil {
+		return xerrors.Errorf("query error: %w", err)
+	}
+
+	var expired []abi.DealID
+	for r := range qres.Next() {
+		if r.Error != nil {
+			_ = qres.Close()
+			return r.Error
+		}
+		// /index/lease/<expire time>/<deal id>
+		ns := datastore.NewKey(r.Key).Namespaces()
+		if len(ns) != 4 {
+			_ = qres.Close()
+			return xerrors.Errorf("unexpected lease index %s", r.Key)
+		}
+		expire, err := strconv.ParseInt(ns[2], 10, 64)
+		if err != nil {
+			_ = qres.Close()
+			return xerrors.Errorf("unable to parse expire time of lease index %s: %w", r.Key, err)
+		}
+		// entries are ordered by expire time
+		if expire > now.UnixNano() {
+			break
+		}
+		dealID, err := dealIDOfIndexKey(r.Key)
+		if err != nil {
+			_ = qres.Close()
+			return err
+		}
+		expired = append(expired, dealID)
+	}
+	_ = qres.Close()
+
+	if len(expired) == 0 {
+		return nil
+	}
+
+	log.Infow("deal leases expired, return deals to the pool", "deals", expired)
+	return ps.mutateDealsLocked(expired, func(deal *DealInfo) error {
+		deal.Status = Undefine
+		deal.Assignee = ""
+		deal.LeaseID = ""
+		deal.LeaseExpire = time.Time{}
+		return nil
+	})
+}
+
+func (ps *dsPieceStore) releaseExpiredLeases() error {
+	ps.pieceLk.Lock()
+	defer ps.pieceLk.Unlock()
+	return ps.releaseExpiredLeasesLocked(time.Now())
+}
+
+func (ps *dsPieceStore) leaseLoop(ctx context.Context) {
+	ticker := time.NewTicker(leaseCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := ps.releaseExpiredLeases(); err != nil {
+				log.Errorf("release expired deal leases: %s", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
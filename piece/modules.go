@@ -2,45 +2,84 @@ package piece
 
 import (
 	"context"
-	"github.com/filecoin-project/go-fil-markets/piecestore"
+	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/venus-market/builder"
 	"github.com/filecoin-project/venus-market/config"
+	"github.com/filecoin-project/venus-market/metrics"
+	"github.com/filecoin-project/venus-market/models"
+	"github.com/filecoin-project/venus-market/types"
 	"github.com/filecoin-project/venus-market/utils"
+	"github.com/filecoin-project/venus/app/client/apiface"
+	types2 "github.com/filecoin-project/venus/pkg/types"
 	"go.uber.org/fx"
+	"golang.org/x/xerrors"
 )
 
-// NewProviderPieceStore creates a statestore for storing metadata about pieces
-// shared by the piecestorage and retrieval providers
-func NewProviderPieceStore(lc fx.Lifecycle, piecestore PieceStore, cidStore CIDStore) (ExtendPieceStore, error) {
-	ps := struct {
-		PieceStore
-		CIDStore
-	}{piecestore, cidStore}
-
-	ps.OnReady(utils.ReadyLogger("piecestore"))
-	lc.Append(fx.Hook{
-		OnStart: func(ctx context.Context) error {
-			return ps.Start(ctx)
-		},
-	})
+// PieceStores holds the piece store of each miner served by the market, deals of a miner are
+// saved under its own namespace while the cid infos are shared by all of them
+type PieceStores map[address.Address]ExtendPieceStore
+
+// Get returns the piece store of mAddr
+func (stores PieceStores) Get(mAddr address.Address) (ExtendPieceStore, error) {
+	ps, ok := stores[mAddr]
+	if !ok {
+		return nil, xerrors.Errorf("get piece store of %s: %w", mAddr, types.ErrMinerNotFound)
+	}
 	return ps, nil
 }
 
-func NewPieceStorage(pieceStrorageCfg *config.PieceStorageString) (IPieceStorage, error) {
-	err := CheckValidate(string(*pieceStrorageCfg))
-	if err != nil {
-		return nil, err
+// NewProviderPieceStores creates a statestore for storing metadata about pieces
+// shared by the piecestorage and retrieval providers of each miner
+func NewProviderPieceStores(mctx metrics.MetricsCtx, lc fx.Lifecycle, full apiface.FullNode, miners types.MinerAddresses, ds models.PieceInfoDS, cidStore CIDStore, pieceStorages *PieceStorageManager) (PieceStores, error) {
+	ctx := metrics.LifecycleCtx(mctx, lc)
+	stores := make(PieceStores, len(miners))
+	for _, mAddr := range miners {
+		minerInfo, err := full.StateMinerInfo(mctx, mAddr, types2.EmptyTSK)
+		if err != nil {
+			return nil, xerrors.Errorf("get sector size of %s: %w", mAddr, err)
+		}
+
+		pieceStore, err := NewDsPieceStore(models.MinerNamespace(ds, mAddr), types.SectorSize(minerInfo.SectorSize), pieceStorages)
+		if err != nil {
+			return nil, err
+		}
+
+		ps := struct {
+			PieceStore
+			CIDStore
+		}{pieceStore, cidStore}
+
+		ps.OnReady(utils.ReadyLogger("piecestore " + mAddr.String()))
+		lc.Append(fx.Hook{
+			OnStart: func(context.Context) error {
+				return ps.Start(ctx)
+			},
+		})
+		stores[mAddr] = ps
+	}
+	return stores, nil
+}
+
+// NewPieceStorageManager opens the piece storages in the config, the single storage of
+// PieceStorage is used if PieceStorages is empty
+func NewPieceStorageManager(mctx metrics.MetricsCtx, cfg *config.MarketConfig) (*PieceStorageManager, error) {
+	cfgs := cfg.PieceStorages
+	if len(cfgs) == 0 {
+		cfgs = []config.PieceStorageConfig{{Path: cfg.PieceStorage, S3: cfg.PieceStorageS3}}
 	}
-	return &PieceStorage{string(*pieceStrorageCfg)}, nil
+	return newPieceStorageManager(mctx, cfgs)
 }
 
 var PieceOpts = func(cfg *config.MarketConfig) builder.Option {
 	return builder.Options(
 		//piece
-		builder.Override(new(IPieceStorage), NewPieceStorage), //save read peiece data
-		builder.Override(new(PieceStore), NewDsPieceStore),
-		builder.Override(new(CIDStore), NewDsCidInfoStore),
-		builder.Override(new(ExtendPieceStore), NewProviderPieceStore),
-		builder.Override(new(piecestore.PieceStore), builder.From(new(ExtendPieceStore))), //save piece metadata(location)   save to metadata /storagemarket
+		builder.Override(new(*PieceStorageManager), NewPieceStorageManager), //save read peiece data
+		builder.Override(new(*TopLevelIndex), NewTopLevelIndex),
+		builder.Override(new(CIDStore), NewDagStoreCidInfoStore),
+		builder.Override(new(PieceStores), NewProviderPieceStores), //save piece metadata(location)   save to metadata /storagemarket
+		builder.Override(new(*PieceGC), NewPieceGC),
+		builder.If(cfg.PieceGC.Interval > 0,
+			builder.Override(RunPieceGCKey, RunPieceGC),
+		),
 	)
 }
@@ -8,7 +8,6 @@ import (
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/go-state-types/big"
 	market2 "github.com/filecoin-project/specs-actors/v2/actors/builtin/market"
-	"github.com/filecoin-project/venus-market/config"
 	"github.com/filecoin-project/venus-market/models"
 	"github.com/filecoin-project/venus-market/types"
 	logging "github.com/ipfs/go-log/v2"
@@ -17,6 +16,7 @@ import (
 	"path"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/filecoin-project/venus/pkg/types/specactors/builtin/market"
 	"github.com/ipfs/go-cid"
@@ -41,8 +41,24 @@ const (
 	Assigned = "Assigned"
 	Packing  = "Packing"
 	Proving  = "Proving"
+
+	// set by the deal watcher once the deal ended on chain
+	Expired = "Expired"
+	Slashed = "Slashed"
+	Failed  = "Failed"
 )
 
+// IsDealEnded reports whether the deal is over on chain, its data isn't needed by it anymore
+func IsDealEnded(status string) bool {
+	return status == Expired || status == Slashed || status == Failed
+}
+
+// DealStatusChange is a status read from the chain and the epoch the deal changed at
+type DealStatusChange struct {
+	Status string
+	Epoch  abi.ChainEpoch
+}
+
 type DealInfo struct {
 	piecestore.DealInfo
 	market.ClientDealProposal
@@ -51,6 +67,15 @@ type DealInfo struct {
 	PublishCid    cid.Cid
 	FastRetrieval bool
 	Status        string
+
+	// Assignee is the sealer the deal is assigned to, the assignment is a lease identified
+	// by LeaseID which expires at LeaseExpire, unless the sealer confirms it
+	Assignee    string
+	LeaseID     string
+	LeaseExpire time.Time
+
+	// StatusChanges are the statuses set from the state of the deal on chain
+	StatusChanges []DealStatusChange
 }
 
 type DealInfoIncludePath struct {
@@ -62,22 +87,31 @@ type DealInfoIncludePath struct {
 	market2.DealProposal
 	FastRetrieval bool
 	PublishCid    cid.Cid
+	LeaseID       string
 }
 
 type GetDealSpec struct {
 	MaxPiece     int
 	MaxPieceSize uint64
+	// Assignee names the sealer deals are assigned to
+	Assignee string
+	// LeaseDuration is how long assigned deals wait for UpdateDealOnPacking before
+	// returning to the pool, default to an hour
+	LeaseDuration time.Duration
 }
 
 type PieceStore interface {
 	UpdateDealOnComplete(pieceCID cid.Cid, proposal market.ClientDealProposal, dataRef *storagemarket.DataRef, publishCid cid.Cid, dealId abi.DealID, fastRetrieval bool) error
-	UpdateDealOnPacking(pieceCID cid.Cid, dealId abi.DealID, sectorid abi.SectorNumber, offset abi.PaddedPieceSize) error
+	UpdateDealOnPacking(pieceCID cid.Cid, dealId abi.DealID, sectorid abi.SectorNumber, offset abi.PaddedPieceSize, leaseID string) error
 	UpdateDealStatus(dealId abi.DealID, status string) error
 	GetDealByPosition(ctx context.Context, sid abi.SectorID, offset abi.PaddedPieceSize, length abi.PaddedPieceSize) (*DealInfo, error)
 	GetDeals(pageIndex, pageSize int) ([]*DealInfo, error)
 	AssignUnPackedDeals(spec *GetDealSpec) ([]*DealInfoIncludePath, error)
 	GetUnPackedDeals(spec *GetDealSpec) ([]*DealInfoIncludePath, error)
-	MarkDealsAsPacking(deals []abi.DealID) error
+	MarkDealsAsPacking(deals []abi.DealID, leaseID string) error
+	GetDealsByStatus(statuses ...string) ([]*DealInfo, error)
+	UpdateDealOnChainStatus(dealId abi.DealID, status string, epoch abi.ChainEpoch) error
+	GetPieceDeals(pieceCID cid.Cid) ([]*DealInfo, error)
 	ListPieceInfoKeys() ([]cid.Cid, error)
 	GetPieceInfo(pieceCID cid.Cid) (piecestore.PieceInfo, error)
 
@@ -97,23 +131,31 @@ type ExtendPieceStore interface {
 var _ piecestore.PieceStore = (ExtendPieceStore)(nil)
 
 type dsPieceStore struct {
-	pieces       datastore.Batching
-	pieceStorage *config.PieceStorageString
-	pieceLk      sync.Mutex
-	ssize        types.SectorSize
+	pieces        datastore.Batching
+	pieceStorages *PieceStorageManager
+	pieceLk       sync.Mutex
+	ssize         types.SectorSize
 }
 
 // NewDsPieceStore returns a new piecestore based on the given datastore
-func NewDsPieceStore(ds models.PieceInfoDS, ssize types.SectorSize, pieceStorage *config.PieceStorageString) (PieceStore, error) {
-	return &dsPieceStore{
-		pieces:       ds,
-		pieceStorage: pieceStorage,
-		ssize:        ssize,
-		pieceLk:      sync.Mutex{},
-	}, nil
+func NewDsPieceStore(ds models.PieceInfoDS, ssize types.SectorSize, pieceStorages *PieceStorageManager) (PieceStore, error) {
+	ps := &dsPieceStore{
+		pieces:        ds,
+		pieceStorages: pieceStorages,
+		ssize:         ssize,
+		pieceLk:       sync.Mutex{},
+	}
+	if err := ps.ensureIndexes(); err != nil {
+		return nil, xerrors.Errorf("build piece store indexes: %w", err)
+	}
+	return ps, nil
 }
 
 func (ps *dsPieceStore) Start(ctx context.Context) error {
+	if err := ps.releaseExpiredLeases(); err != nil {
+		return err
+	}
+	go ps.leaseLoop(ctx)
 	return nil
 }
 
@@ -167,14 +209,26 @@ func (ps *dsPieceStore) UpdateDealOnComplete(pieceCID cid.Cid, proposal market.C
 	})
 }
 
-// Store `dealInfo` in the PieceStore with key `pieceCID`.
-func (ps *dsPieceStore) UpdateDealOnPacking(pieceCID cid.Cid, dealId abi.DealID, sectorid abi.SectorNumber, offset abi.PaddedPieceSize) error {
-	return ps.mutatePieceInfo(pieceCID, func(pi *PieceInfo) error {
+// UpdateDealOnPacking records where the sealer packed the deal, see checkLease for the lease the
+// sealer confirms.
+func (ps *dsPieceStore) UpdateDealOnPacking(pieceCID cid.Cid, dealId abi.DealID, sectorid abi.SectorNumber, offset abi.PaddedPieceSize, leaseID string) error {
+	ps.pieceLk.Lock()
+	defer ps.pieceLk.Unlock()
+
+	if err := ps.releaseExpiredLeasesLocked(time.Now()); err != nil {
+		return err
+	}
+	return ps.mutatePieceInfoLocked(pieceCID, func(pi *PieceInfo) error {
 		for _, di := range pi.Deals {
 			if di.DealID == dealId {
+				if err := checkLease(di, leaseID); err != nil {
+					return err
+				}
 				di.SectorID = sectorid
 				di.Offset = offset
 				di.Status = Assigned
+				// the sealer has packed the deal, the lease is confirmed
+				di.LeaseExpire = time.Time{}
 				return nil
 			}
 		}
@@ -185,19 +239,21 @@ func (ps *dsPieceStore) UpdateDealOnPacking(pieceCID cid.Cid, dealId abi.DealID,
 
 // Store `dealInfo` in the PieceStore with key `pieceCID`.
 func (ps *dsPieceStore) UpdateDealStatus(dealId abi.DealID, status string) error {
-	return ps.mutateDeal(func(info *DealInfo) (bool, error) {
-		if info.DealID == dealId {
-			info.Status = status
-			return false, nil
-		}
-		return true, nil
+	return ps.mutateDealByID(dealId, func(deal *DealInfo) error {
+		deal.Status = status
+		deal.LeaseExpire = time.Time{}
+		return nil
 	})
 }
 
 func (ps *dsPieceStore) GetDealByPosition(ctx context.Context, sid abi.SectorID, offset abi.PaddedPieceSize, length abi.PaddedPieceSize) (*DealInfo, error) {
+	ps.pieceLk.Lock()
+	defer ps.pieceLk.Unlock()
+
 	var dinfo *DealInfo
-	err := ps.eachPackedDeal(func(info *DealInfo) (bool, error) {
-		if info.SectorID == sid.Number && info.Offset <= offset && info.Offset+info.Length >= offset+length {
+	sectorPrefix := sectorIndexPrefix.ChildString(padUint(uint64(sid.Number)))
+	err := ps.eachIndexedDeal(query.Query{Prefix: sectorPrefix.String()}, func(info *DealInfo) (bool, error) {
+		if info.Offset <= offset && info.Offset+info.Length >= offset+length {
 			dinfo = info
 			return false, nil
 		}
@@ -213,19 +269,18 @@ func (ps *dsPieceStore) GetDealByPosition(ctx context.Context, sid abi.SectorID,
 }
 
 func (ps *dsPieceStore) GetDeals(pageIndex, pageSize int) ([]*DealInfo, error) {
+	ps.pieceLk.Lock()
+	defer ps.pieceLk.Unlock()
+
 	var deals []*DealInfo
-	count := 0
-	from := pageIndex * pageSize
-	to := (pageIndex + 1) * pageSize
-	err := ps.eachDeal(func(info *DealInfo) (bool, error) {
-		if count < from {
-			return true, nil
-		} else if count > to {
-			return false, nil
-		} else {
-			deals = append(deals, info)
-			return true, nil
-		}
+	q := query.Query{
+		Prefix: dealIndexPrefix.String(),
+		Offset: pageIndex * pageSize,
+		Limit:  pageSize,
+	}
+	err := ps.eachIndexedDeal(q, func(info *DealInfo) (bool, error) {
+		deals = append(deals, info)
+		return true, nil
 	})
 	if err != nil {
 		return nil, err
@@ -239,8 +294,26 @@ var defaultGetDealSpec = &GetDealSpec{
 	MaxPieceSize: 0,
 }
 
+// AssignUnPackedDeals combines unpacked deals into sectors and leases them to the sealer named
+// in spec, the plan is saved in a single batch. Deals are locked during the whole operation, so
+// sealers calling it at the same time never get the same deals.
 func (ps *dsPieceStore) AssignUnPackedDeals(spec *GetDealSpec) ([]*DealInfoIncludePath, error) {
-	deals, err := ps.GetUnPackedDeals(&GetDealSpec{MaxPiece: math.MaxInt32}) //todo get all pending deals
+	ps.pieceLk.Lock()
+	defer ps.pieceLk.Unlock()
+
+	if spec == nil {
+		spec = defaultGetDealSpec
+	}
+	if spec.MaxPiece == 0 {
+		spec.MaxPiece = defaultMaxPiece
+	}
+
+	now := time.Now()
+	if err := ps.releaseExpiredLeasesLocked(now); err != nil {
+		return nil, err
+	}
+
+	deals, err := ps.unPackedDealsLocked(&GetDealSpec{MaxPiece: math.MaxInt32}) //todo get all pending deals
 	if err != nil {
 		return nil, err
 	}
@@ -249,6 +322,19 @@ func (ps *dsPieceStore) AssignUnPackedDeals(spec *GetDealSpec) ([]*DealInfoInclu
 		return nil, nil
 	}
 
+	leaseID, err := newLeaseID()
+	if err != nil {
+		return nil, xerrors.Errorf("generate lease id: %w", err)
+	}
+	leaseDuration := spec.LeaseDuration
+	if leaseDuration <= 0 {
+		leaseDuration = defaultLeaseDuration
+	}
+	// fillers added below don't belong to any deal and have no lease
+	for _, deal := range deals {
+		deal.LeaseID = leaseID
+	}
+
 	// 按照尺寸, 时间, 价格排序
 	sort.Slice(deals, func(i, j int) bool {
 		left, right := deals[i], deals[j]
@@ -367,26 +453,27 @@ func (ps *dsPieceStore) AssignUnPackedDeals(spec *GetDealSpec) ([]*DealInfoInclu
 	})
 
 	pieces := []*DealInfoIncludePath{}
+	var dealIDs []abi.DealID
 	for _, cp := range combinedAll {
 		pieces = append(pieces, cp.Pieces...)
-
+		dealIDs = append(dealIDs, cp.DealIDs...)
 	}
-	// not atomic opration for deal
-	for _, p := range pieces {
-		err := ps.mutateDeal(func(info *DealInfo) (bool, error) {
-			if info.DealID == p.DealID {
-				info.Status = Assigned
-				return false, nil
-			}
-			return true, nil
-		})
-		if err != nil {
-			return nil, err
-		}
+
+	err = ps.mutateDealsLocked(dealIDs, func(deal *DealInfo) error {
+		deal.Status = Assigned
+		deal.Assignee = spec.Assignee
+		deal.LeaseID = leaseID
+		deal.LeaseExpire = now.Add(leaseDuration)
+		return nil
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("assign deals: %w", err)
 	}
+	log.Infow("assign deals", "assignee", spec.Assignee, "lease", leaseID, "deals", dealIDs)
 	return pieces, nil
 }
 
+// GetUnPackedDeals lists deals waiting to be packed, unlike AssignUnPackedDeals it doesn't assign them
 func (ps *dsPieceStore) GetUnPackedDeals(spec *GetDealSpec) ([]*DealInfoIncludePath, error) {
 	ps.pieceLk.Lock()
 	defer ps.pieceLk.Unlock()
@@ -398,81 +485,119 @@ func (ps *dsPieceStore) GetUnPackedDeals(spec *GetDealSpec) ([]*DealInfoIncludeP
 		spec.MaxPiece = defaultMaxPiece
 	}
 
-	qres, err := ps.pieces.Query(query.Query{})
+	if err := ps.releaseExpiredLeasesLocked(time.Now()); err != nil {
+		return nil, err
+	}
+	return ps.unPackedDealsLocked(spec)
+}
+
+// piecePath returns where the sealer reads the piece from, empty when no piece storage has it
+func (ps *dsPieceStore) piecePath(pieceCID cid.Cid) string {
+	loc, err := ps.pieceStorages.FindStorageForRead(pieceCID.String())
 	if err != nil {
-		return nil, xerrors.Errorf("query error: %w", err)
+		log.Warnf("unable to find piece %s: %s", pieceCID, err)
+		return ""
 	}
-	defer qres.Close() //nolint:errcheck
+	return path.Join(loc.Path, pieceCID.String())
+}
 
+func (ps *dsPieceStore) unPackedDealsLocked(spec *GetDealSpec) ([]*DealInfoIncludePath, error) {
 	var result []*DealInfoIncludePath
-	var curPiece int
 	var curPieceSize uint64
-LOOP:
-	for r := range qres.Next() {
-		var pieceInfo PieceInfo
-		err := json.Unmarshal(r.Value, &pieceInfo)
-		if err != nil {
-			return nil, xerrors.Errorf("unable to parser cid: %w", err)
+	statusPrefix := statusIndexPrefix.ChildString(Undefine)
+	err := ps.eachIndexedDeal(query.Query{Prefix: statusPrefix.String()}, func(deal *DealInfo) (bool, error) {
+		if spec.MaxPieceSize > 0 && curPieceSize+uint64(deal.Length) > spec.MaxPieceSize {
+			return false, nil
 		}
+		result = append(result, &DealInfoIncludePath{
+			DealProposal:    deal.Proposal,
+			Offset:          deal.Offset,
+			Length:          deal.Length,
+			DealID:          deal.DealID,
+			TotalStorageFee: deal.Proposal.TotalStorageFee(),
+			PieceStorage:    ps.piecePath(deal.Proposal.PieceCID),
+			FastRetrieval:   deal.FastRetrieval,
+			PublishCid:      deal.PublishCid,
+		})
 
-		for _, deal := range pieceInfo.Deals {
-			if deal.Status == Undefine {
-				result = append(result, &DealInfoIncludePath{
-					DealProposal:    deal.Proposal,
-					Offset:          deal.Offset,
-					Length:          deal.Length,
-					DealID:          deal.DealID,
-					TotalStorageFee: deal.Proposal.TotalStorageFee(),
-					PieceStorage:    path.Join(string(*ps.pieceStorage), deal.Proposal.PieceCID.String()),
-					FastRetrieval:   deal.FastRetrieval,
-					PublishCid:      deal.PublishCid,
-				})
-				deal.Status = Assigned
-
-				curPiece++
-				curPieceSize += uint64(deal.Length)
-				if spec.MaxPiece > 0 && curPiece > spec.MaxPiece {
-					goto LOOP
-				}
-				if spec.MaxPieceSize > 0 && curPieceSize > spec.MaxPieceSize {
-					goto LOOP
-				}
-			}
-		}
+		curPieceSize += uint64(deal.Length)
+		return spec.MaxPiece <= 0 || len(result) < spec.MaxPiece, nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return result, nil
 }
 
-func (ps *dsPieceStore) MarkDealsAsPacking(deals []abi.DealID) error {
-	pieces, err := ps.ListPieceInfoKeys()
-	if err != nil {
+// MarkDealsAsPacking confirms the deals the sealer packs, see checkLease for the lease the sealer
+// confirms. No deal is confirmed if the lease of one of them doesn't match.
+func (ps *dsPieceStore) MarkDealsAsPacking(deals []abi.DealID, leaseID string) error {
+	ps.pieceLk.Lock()
+	defer ps.pieceLk.Unlock()
+
+	if err := ps.releaseExpiredLeasesLocked(time.Now()); err != nil {
 		return err
 	}
+	return ps.mutateDealsLocked(deals, func(deal *DealInfo) error {
+		if err := checkLease(deal, leaseID); err != nil {
+			return err
+		}
+		deal.Status = Assigned
+		deal.LeaseExpire = time.Time{}
+		return nil
+	})
+}
 
-	for _, piece := range pieces {
-		err = ps.mutatePieceInfo(piece, func(pi *PieceInfo) error {
-			for _, deal := range pi.Deals {
-				for _, inDeal := range deals {
-					if deal.DealID == inDeal {
-						deal.Status = Assigned
-					}
-				}
-			}
-			return nil
+// GetDealsByStatus lists the deals in any of the statuses
+func (ps *dsPieceStore) GetDealsByStatus(statuses ...string) ([]*DealInfo, error) {
+	ps.pieceLk.Lock()
+	defer ps.pieceLk.Unlock()
+
+	var deals []*DealInfo
+	for _, status := range statuses {
+		statusPrefix := statusIndexPrefix.ChildString(status)
+		err := ps.eachIndexedDeal(query.Query{Prefix: statusPrefix.String()}, func(deal *DealInfo) (bool, error) {
+			deals = append(deals, deal)
+			return true, nil
 		})
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
-	return nil
+	return deals, nil
+}
+
+// UpdateDealOnChainStatus sets the status a deal has on chain since epoch
+func (ps *dsPieceStore) UpdateDealOnChainStatus(dealId abi.DealID, status string, epoch abi.ChainEpoch) error {
+	return ps.mutateDealByID(dealId, func(deal *DealInfo) error {
+		deal.Status = status
+		deal.LeaseExpire = time.Time{}
+		deal.StatusChanges = append(deal.StatusChanges, DealStatusChange{Status: status, Epoch: epoch})
+		return nil
+	})
+}
+
+// GetPieceDeals returns the deals of the piece, none if the piece isn't in the piece store
+func (ps *dsPieceStore) GetPieceDeals(pieceCID cid.Cid) ([]*DealInfo, error) {
+	ps.pieceLk.Lock()
+	defer ps.pieceLk.Unlock()
+
+	pi, err := ps.loadPieceInfo(pieceCID)
+	if err == datastore.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pi.Deals, nil
 }
 
 func (ps *dsPieceStore) ListPieceInfoKeys() ([]cid.Cid, error) {
 	ps.pieceLk.Lock()
 	defer ps.pieceLk.Unlock()
 
-	qres, err := ps.pieces.Query(query.Query{})
+	qres, err := ps.pieces.Query(query.Query{KeysOnly: true})
 	if err != nil {
 		return nil, xerrors.Errorf("query error: %w", err)
 	}
@@ -480,6 +605,9 @@ func (ps *dsPieceStore) ListPieceInfoKeys() ([]cid.Cid, error) {
 
 	var out []cid.Cid
 	for r := range qres.Next() {
+		if isIndexKey(r.Key) {
+			continue
+		}
 		id, err := cid.Decode(strings.TrimPrefix(r.Key, "/"))
 		if err != nil {
 			return nil, xerrors.Errorf("unable to parser cid: %w", err)
@@ -511,6 +639,10 @@ func (ps *dsPieceStore) GetPieceInfo(pieceCID cid.Cid) (piecestore.PieceInfo, er
 func (ps *dsPieceStore) mutatePieceInfo(pieceCID cid.Cid, mutator func(pi *PieceInfo) error) error {
 	ps.pieceLk.Lock()
 	defer ps.pieceLk.Unlock()
+	return ps.mutatePieceInfoLocked(pieceCID, mutator)
+}
+
+func (ps *dsPieceStore) mutatePieceInfoLocked(pieceCID cid.Cid, mutator func(pi *PieceInfo) error) error {
 	key := datastore.NewKey(pieceCID.String())
 	pieceBytes, err := ps.pieces.Get(key)
 	if err != nil && datastore.ErrNotFound != err {
@@ -524,131 +656,12 @@ func (ps *dsPieceStore) mutatePieceInfo(pieceCID cid.Cid, mutator func(pi *Piece
 		}
 	}
 
+	// the mutator changes deals in place, so take the index entries before it runs
+	oldKeys := pieceIndexKeys(&piInfo)
 	if err = mutator(&piInfo); err != nil {
 		return err
 	}
-	data, err := json.Marshal(piInfo)
-	if err != nil {
-		return err
-	}
-	return ps.pieces.Put(key, data)
-}
-
-func (ps *dsPieceStore) eachPackedDeal(f func(info *DealInfo) (bool, error)) error {
-	ps.pieceLk.Lock()
-	defer ps.pieceLk.Unlock()
-
-	qres, err := ps.pieces.Query(query.Query{})
-	if err != nil {
-		return xerrors.Errorf("query error: %w", err)
-	}
-	defer qres.Close() //nolint:errcheck
-
-	for r := range qres.Next() {
-		var pieceInfo PieceInfo
-		err := json.Unmarshal(r.Value, &pieceInfo)
-		if err != nil {
-			return xerrors.Errorf("unable to parser cid: %w", err)
-		}
-
-		for _, deal := range pieceInfo.Deals {
-			if deal.Status != Undefine {
-				isContinue, err := f(deal)
-				if err != nil {
-					return err
-				}
-				if !isContinue {
-					break
-				}
-			}
-		}
-	}
-
-	return nil
-}
-
-func (ps *dsPieceStore) eachDeal(f func(info *DealInfo) (bool, error)) error {
-	ps.pieceLk.Lock()
-	defer ps.pieceLk.Unlock()
-
-	qres, err := ps.pieces.Query(query.Query{})
-	if err != nil {
-		return xerrors.Errorf("query error: %w", err)
-	}
-	defer qres.Close() //nolint:errcheck
-
-	for r := range qres.Next() {
-		var pieceInfo PieceInfo
-		err := json.Unmarshal(r.Value, &pieceInfo)
-		if err != nil {
-			return xerrors.Errorf("unable to parser cid: %w", err)
-		}
-
-		for _, deal := range pieceInfo.Deals {
-			isContinue, err := f(deal)
-			if err != nil {
-				return err
-			}
-			if !isContinue {
-				break
-			}
-		}
-	}
-
-	return nil
-}
-
-func (ps *dsPieceStore) mutateDeal(f func(info *DealInfo) (bool, error)) error {
-	ps.pieceLk.Lock()
-	defer ps.pieceLk.Unlock()
-
-	qres, err := ps.pieces.Query(query.Query{})
-	if err != nil {
-		return xerrors.Errorf("query error: %w", err)
-	}
-
-	modify := map[cid.Cid]PieceInfo{}
-	for r := range qres.Next() {
-		id, err := cid.Decode(strings.TrimPrefix(r.Key, "/"))
-		if err != nil {
-			_ = qres.Close()
-			return xerrors.Errorf("unable to parser cid: %w", err)
-		}
-		var pieceInfo PieceInfo
-		err = json.Unmarshal(r.Value, &pieceInfo)
-		if err != nil {
-			_ = qres.Close()
-			return xerrors.Errorf("unable to parser pieceinfo: %w", err)
-		}
-
-		for _, deal := range pieceInfo.Deals {
-			isContinue, err := f(deal)
-			if err != nil {
-				_ = qres.Close()
-				return err
-			}
-			if !isContinue {
-				break
-			}
-		}
-		modify[id] = pieceInfo
-		//todo poor performance
-	}
-
-	_ = qres.Close()
-
-	for pieceCid, pieceInfo := range modify {
-		data, err := json.Marshal(pieceInfo)
-		if err != nil {
-			return err
-		}
-
-		err = ps.pieces.Put(datastore.NewKey(pieceCid.String()), data)
-		if err != nil {
-			return err
-		}
-	}
-	return nil
+	return ps.putPieceInfo(pieceCID, oldKeys, &piInfo)
 }
 
 func fillersFromRem(in abi.UnpaddedPieceSize) ([]abi.UnpaddedPieceSize, error) {
@@ -0,0 +1,358 @@
+package piece
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"golang.org/x/xerrors"
+)
+
+// The piece store keeps secondary indexes of the deals next to the piece records, in the same
+// datastore, so that a lookup doesn't need to decode every piece. Each index entry points to the
+// piece record holding the deal:
+//
+//	/index/deal/<deal id>                            -> piece cid
+//	/index/status/<status>/<deal id>                 -> piece cid
+//	/index/sector/<sector number>/<offset>/<deal id> -> piece cid, deals which are not assigned yet or ended are left out
+//	/index/lease/<expire time>/<deal id>             -> piece cid, deals assigned to a sealer which hasn't confirmed it yet
+//
+// numbers are zero padded, so keys are ordered the same way as the numbers they hold.
+var (
+	indexPrefix       = datastore.NewKey("/index")
+	dealIndexPrefix   = indexPrefix.ChildString("deal")
+	statusIndexPrefix = indexPrefix.ChildString("status")
+	sectorIndexPrefix = indexPrefix.ChildString("sector")
+	leaseIndexPrefix  = indexPrefix.ChildString("lease")
+	indexVersionKey   = indexPrefix.ChildString("version")
+)
+
+// bump it when the layout of the indexes changes, indexes are rebuilt on start
+const indexVersion = "2"
+
+func padUint(v uint64) string {
+	return fmt.Sprintf("%020d", v)
+}
+
+func dealIndexKey(dealID abi.DealID) datastore.Key {
+	return dealIndexPrefix.ChildString(padUint(uint64(dealID)))
+}
+
+func statusIndexKey(status string, dealID abi.DealID) datastore.Key {
+	return statusIndexPrefix.ChildString(status).ChildString(padUint(uint64(dealID)))
+}
+
+func sectorIndexKey(sector abi.SectorNumber, offset abi.PaddedPieceSize, dealID abi.DealID) datastore.Key {
+	return sectorIndexPrefix.ChildString(padUint(uint64(sector))).ChildString(padUint(uint64(offset))).ChildString(padUint(uint64(dealID)))
+}
+
+func leaseIndexKey(expire time.Time, dealID abi.DealID) datastore.Key {
+	return leaseIndexPrefix.ChildString(padUint(uint64(expire.UnixNano()))).ChildString(padUint(uint64(dealID)))
+}
+
+// dealIndexKeys returns the index entries of a deal
+func dealIndexKeys(deal *DealInfo) []datastore.Key {
+	keys := []datastore.Key{dealIndexKey(deal.DealID), statusIndexKey(deal.Status, deal.DealID)}
+	if deal.Status != Undefine && !IsDealEnded(deal.Status) {
+		keys = append(keys, sectorIndexKey(deal.SectorID, deal.Offset, deal.DealID))
+	}
+	if deal.Status == Assigned && !deal.LeaseExpire.IsZero() {
+		keys = append(keys, leaseIndexKey(deal.LeaseExpire, deal.DealID))
+	}
+	return keys
+}
+
+func pieceIndexKeys(pi *PieceInfo) []datastore.Key {
+	var keys []datastore.Key
+	for _, deal := range pi.Deals {
+		keys = append(keys, dealIndexKeys(deal)...)
+	}
+	return keys
+}
+
+func isIndexKey(key string) bool {
+	return strings.HasPrefix(key, indexPrefix.String()+"/")
+}
+
+// dealIDOfIndexKey parses the deal id at the end of an index key
+func dealIDOfIndexKey(key string) (abi.DealID, error) {
+	id, err := strconv.ParseUint(datastore.NewKey(key).BaseNamespace(), 10, 64)
+	if err != nil {
+		return 0, xerrors.Errorf("unable to parse deal id of index %s: %w", key, err)
+	}
+	return abi.DealID(id), nil
+}
+
+// putPieceInfo writes the piece record and updates the indexes of its deals in one batch,
+// oldKeys are the index entries of the record being replaced.
+func (ps *dsPieceStore) putPieceInfo(pieceCID cid.Cid, oldKeys []datastore.Key, pi *PieceInfo) error {
+	batch, err := ps.pieces.Batch()
+	if err != nil {
+		return err
+	}
+	if err := writePieceInfo(batch, pieceCID, oldKeys, pi); err != nil {
+		return err
+	}
+	return batch.Commit()
+}
+
+// writePieceInfo adds the writes of a piece record and its index entries to batch
+func writePieceInfo(batch datastore.Batch, pieceCID cid.Cid, oldKeys []datastore.Key, pi *PieceInfo) error {
+	data, err := json.Marshal(pi)
+	if err != nil {
+		return err
+	}
+
+	newKeys := make(map[datastore.Key]struct{})
+	for _, key := range pieceIndexKeys(pi) {
+		newKeys[key] = struct{}{}
+	}
+	for _, key := range oldKeys {
+		if _, ok := newKeys[key]; ok {
+			continue
+		}
+		if err := batch.Delete(key); err != nil {
+			return err
+		}
+	}
+
+	value := []byte(pieceCID.String())
+	for key := range newKeys {
+		if err := batch.Put(key, value); err != nil {
+			return err
+		}
+	}
+	return batch.Put(datastore.NewKey(pieceCID.String()), data)
+}
+
+// loadPieceInfo reads a piece record, the caller must hold pieceLk
+func (ps *dsPieceStore) loadPieceInfo(pieceCID cid.Cid) (*PieceInfo, error) {
+	pieceBytes, err := ps.pieces.Get(datastore.NewKey(pieceCID.String()))
+	if err != nil {
+		return nil, err
+	}
+	pi := &PieceInfo{}
+	if err = json.Unmarshal(pieceBytes, pi); err != nil {
+		return nil, xerrors.Errorf("unable to parser pieceinfo: %w", err)
+	}
+	pi.PieceCID = pieceCID
+	return pi, nil
+}
+
+// pieceOfDeal looks up the piece holding the deal in the deal index, the caller must hold pieceLk
+func (ps *dsPieceStore) pieceOfDeal(dealID abi.DealID) (cid.Cid, error) {
+	val, err := ps.pieces.Get(dealIndexKey(dealID))
+	if err != nil {
+		if err == datastore.ErrNotFound {
+			return cid.Undef, xerrors.Errorf("deal %d: %w", dealID, err)
+		}
+		return cid.Undef, err
+	}
+	return cid.Decode(string(val))
+}
+
+// mutateDealByID finds the deal through the deal index and updates it along with its piece record
+func (ps *dsPieceStore) mutateDealByID(dealID abi.DealID, mutator func(deal *DealInfo) error) error {
+	ps.pieceLk.Lock()
+	defer ps.pieceLk.Unlock()
+
+	pieceCID, err := ps.pieceOfDeal(dealID)
+	if err != nil {
+		return err
+	}
+	return ps.mutatePieceInfoLocked(pieceCID, func(pi *PieceInfo) error {
+		for _, deal := range pi.Deals {
+			if deal.DealID == dealID {
+				return mutator(deal)
+			}
+		}
+		return xerrors.Errorf("deal %d not found in piece %s, the index is out of date", dealID, pieceCID)
+	})
+}
+
+// mutateDealsLocked updates the deals with mutator, all the piece records and index entries
+// are written in a single batch, so either all the deals are changed or none of them. the caller
+// must hold pieceLk
+func (ps *dsPieceStore) mutateDealsLocked(dealIDs []abi.DealID, mutator func(deal *DealInfo) error) error {
+	type pieceUpdate struct {
+		oldKeys []datastore.Key
+		pi      *PieceInfo
+	}
+	updates := make(map[cid.Cid]*pieceUpdate)
+	var order []cid.Cid
+
+	for _, dealID := range dealIDs {
+		pieceCID, err := ps.pieceOfDeal(dealID)
+		if err != nil {
+			return err
+		}
+		update, ok := updates[pieceCID]
+		if !ok {
+			pi, err := ps.loadPieceInfo(pieceCID)
+			if err != nil {
+				return err
+			}
+			update = &pieceUpdate{oldKeys: pieceIndexKeys(pi), pi: pi}
+			updates[pieceCID] = update
+			order = append(order, pieceCID)
+		}
+
+		found := false
+		for _, deal := range update.pi.Deals {
+			if deal.DealID == dealID {
+				if err := mutator(deal); err != nil {
+					return err
+				}
+				found = true
+				break
+			}
+		}
+		if !found {
+			return xerrors.Errorf("deal %d not found in piece %s, the index is out of date", dealID, pieceCID)
+		}
+	}
+
+	if len(order) == 0 {
+		return nil
+	}
+	batch, err := ps.pieces.Batch()
+	if err != nil {
+		return err
+	}
+	for _, pieceCID := range order {
+		update := updates[pieceCID]
+		if err := writePieceInfo(batch, pieceCID, update.oldKeys, update.pi); err != nil {
+			return err
+		}
+	}
+	return batch.Commit()
+}
+
+// eachIndexedDeal calls f with each deal under the index prefix, in the order of the index keys.
+// f returns false to stop the iteration, the caller must hold pieceLk
+func (ps *dsPieceStore) eachIndexedDeal(q query.Query, f func(deal *DealInfo) (bool, error)) error {
+	q.Orders = []query.Order{query.OrderByKey{}}
+	qres, err := ps.pieces.Query(q)
+	if err != nil {
+		return xerrors.Errorf("query error: %w", err)
+	}
+	defer qres.Close() //nolint:errcheck
+
+	pieces := make(map[cid.Cid]*PieceInfo)
+	for r := range qres.Next() {
+		if r.Error != nil {
+			return r.Error
+		}
+		dealID, err := dealIDOfIndexKey(r.Key)
+		if err != nil {
+			return err
+		}
+		pieceCID, err := cid.Decode(string(r.Value))
+		if err != nil {
+			return xerrors.Errorf("unable to parser cid: %w", err)
+		}
+
+		pi, ok := pieces[pieceCID]
+		if !ok {
+			pi, err = ps.loadPieceInfo(pieceCID)
+			if err != nil {
+				return err
+			}
+			pieces[pieceCID] = pi
+		}
+
+		var deal *DealInfo
+		for _, d := range pi.Deals {
+			if d.DealID == dealID {
+				deal = d
+				break
+			}
+		}
+		if deal == nil {
+			return xerrors.Errorf("deal %d not found in piece %s, the index is out of date", dealID, pieceCID)
+		}
+
+		isContinue, err := f(deal)
+		if err != nil {
+			return err
+		}
+		if !isContinue {
+			break
+		}
+	}
+	return nil
+}
+
+// ensureIndexes builds the indexes of a piece store written before they were introduced,
+// or when their layout has changed
+func (ps *dsPieceStore) ensureIndexes() error {
+	ps.pieceLk.Lock()
+	defer ps.pieceLk.Unlock()
+
+	version, err := ps.pieces.Get(indexVersionKey)
+	if err == nil && string(version) == indexVersion {
+		return nil
+	}
+	if err != nil && err != datastore.ErrNotFound {
+		return err
+	}
+
+	qres, err := ps.pieces.Query(query.Query{})
+	if err != nil {
+		return xerrors.Errorf("query error: %w", err)
+	}
+	defer qres.Close() //nolint:errcheck
+
+	stale := make(map[datastore.Key]struct{})
+	indexes := make(map[datastore.Key][]byte)
+	for r := range qres.Next() {
+		if r.Error != nil {
+			return r.Error
+		}
+		if isIndexKey(r.Key) {
+			stale[datastore.NewKey(r.Key)] = struct{}{}
+			continue
+		}
+
+		pieceCID, err := cid.Decode(strings.TrimPrefix(r.Key, "/"))
+		if err != nil {
+			return xerrors.Errorf("unable to parser cid: %w", err)
+		}
+		var pi PieceInfo
+		if err = json.Unmarshal(r.Value, &pi); err != nil {
+			return xerrors.Errorf("unable to parser pieceinfo: %w", err)
+		}
+		for _, key := range pieceIndexKeys(&pi) {
+			indexes[key] = []byte(pieceCID.String())
+		}
+	}
+
+	log.Infof("build piece store indexes, %d entries", len(indexes))
+	batch, err := ps.pieces.Batch()
+	if err != nil {
+		return err
+	}
+	for key := range stale {
+		if _, ok := indexes[key]; ok || key == indexVersionKey {
+			continue
+		}
+		if err := batch.Delete(key); err != nil {
+			return err
+		}
+	}
+	for key, val := range indexes {
+		if err := batch.Put(key, val); err != nil {
+			return err
+		}
+	}
+	if err := batch.Put(indexVersionKey, []byte(indexVersion)); err != nil {
+		return err
+	}
+	return batch.Commit()
+}
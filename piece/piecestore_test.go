@@ -0,0 +1,291 @@
+package piece
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/venus-market/config"
+	"github.com/filecoin-project/venus/pkg/types/specactors/builtin/market"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	ds_sync "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestPieceStore(t *testing.T, ds datastore.Batching) *dsPieceStore {
+	pieceStorages, err := newPieceStorageManager(context.Background(), []config.PieceStorageConfig{{Path: config.PieceStorageString("fs:" + t.TempDir())}})
+	require.NoError(t, err)
+	ps, err := NewDsPieceStore(ds, 2048, pieceStorages)
+	require.NoError(t, err)
+	return ps.(*dsPieceStore)
+}
+
+func addTestDeal(t *testing.T, ps PieceStore, pieceCID cid.Cid, dealID abi.DealID, size abi.PaddedPieceSize) {
+	proposal := market.ClientDealProposal{
+		Proposal: market.DealProposal{
+			PieceCID:             pieceCID,
+			PieceSize:            size,
+			StoragePricePerEpoch: abi.NewTokenAmount(1),
+			ProviderCollateral:   abi.NewTokenAmount(0),
+			ClientCollateral:     abi.NewTokenAmount(0),
+		},
+	}
+	err := ps.UpdateDealOnComplete(pieceCID, proposal, &storagemarket.DataRef{TransferType: storagemarket.TTGraphsync, Root: pieceCID}, pieceCID, dealID, false)
+	require.NoError(t, err)
+}
+
+func TestPieceStoreIndexes(t *testing.T) {
+	ctx := context.Background()
+	piece1, err := cid.Parse("bafkqaaa")
+	require.NoError(t, err)
+	piece2, err := cid.Parse("bafkqaalb")
+	require.NoError(t, err)
+
+	ds := ds_sync.MutexWrap(datastore.NewMapDatastore())
+	ps := newTestPieceStore(t, ds)
+
+	addTestDeal(t, ps, piece1, 1, 256)
+	addTestDeal(t, ps, piece1, 2, 256)
+	addTestDeal(t, ps, piece2, 3, 512)
+
+	deals, err := ps.GetUnPackedDeals(&GetDealSpec{MaxPiece: 10})
+	require.NoError(t, err)
+	require.Len(t, deals, 3)
+
+	deals, err = ps.GetUnPackedDeals(&GetDealSpec{MaxPiece: 2})
+	require.NoError(t, err)
+	require.Len(t, deals, 2)
+
+	require.NoError(t, ps.UpdateDealOnPacking(piece2, 3, 10, 1024, ""))
+	require.NoError(t, ps.UpdateDealStatus(1, Proving))
+	require.Error(t, ps.UpdateDealStatus(100, Proving))
+
+	deals, err = ps.GetUnPackedDeals(nil)
+	require.NoError(t, err)
+	require.Len(t, deals, 1)
+	require.Equal(t, abi.DealID(2), deals[0].DealID)
+
+	deal, err := ps.GetDealByPosition(ctx, abi.SectorID{Number: 10}, 1024, 512)
+	require.NoError(t, err)
+	require.Equal(t, abi.DealID(3), deal.DealID)
+	require.Equal(t, Assigned, deal.Status)
+
+	_, err = ps.GetDealByPosition(ctx, abi.SectorID{Number: 10}, 0, 512)
+	require.Error(t, err)
+
+	page, err := ps.GetDeals(0, 2)
+	require.NoError(t, err)
+	require.Len(t, page, 2)
+	require.Equal(t, abi.DealID(1), page[0].DealID)
+	require.Equal(t, Proving, page[0].Status)
+	page, err = ps.GetDeals(1, 2)
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+	require.Equal(t, abi.DealID(3), page[0].DealID)
+
+	pieces, err := ps.ListPieceInfoKeys()
+	require.NoError(t, err)
+	require.ElementsMatch(t, []cid.Cid{piece1, piece2}, pieces)
+
+	// the status index doesn't keep the old status around
+	res, err := ds.Query(query.Query{Prefix: statusIndexPrefix.ChildString(Undefine).String(), KeysOnly: true})
+	require.NoError(t, err)
+	entries, err := res.Rest()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+}
+
+func TestPieceStoreRebuildIndexes(t *testing.T) {
+	ctx := context.Background()
+	piece1, err := cid.Parse("bafkqaaa")
+	require.NoError(t, err)
+
+	ds := ds_sync.MutexWrap(datastore.NewMapDatastore())
+	ps := newTestPieceStore(t, ds)
+	addTestDeal(t, ps, piece1, 1, 256)
+	addTestDeal(t, ps, piece1, 2, 256)
+	require.NoError(t, ps.UpdateDealOnPacking(piece1, 2, 5, 0, ""))
+
+	// drop the indexes, as a piece store written before they were introduced
+	res, err := ds.Query(query.Query{Prefix: indexPrefix.String(), KeysOnly: true})
+	require.NoError(t, err)
+	entries, err := res.Rest()
+	require.NoError(t, err)
+	require.NotEmpty(t, entries)
+	for _, e := range entries {
+		require.NoError(t, ds.Delete(datastore.NewKey(e.Key)))
+	}
+
+	ps = newTestPieceStore(t, ds)
+	deals, err := ps.GetUnPackedDeals(nil)
+	require.NoError(t, err)
+	require.Len(t, deals, 1)
+	require.Equal(t, abi.DealID(1), deals[0].DealID)
+
+	deal, err := ps.GetDealByPosition(ctx, abi.SectorID{Number: 5}, 0, 256)
+	require.NoError(t, err)
+	require.Equal(t, abi.DealID(2), deal.DealID)
+}
+
+func TestDealOnChainStatus(t *testing.T) {
+	ctx := context.Background()
+	piece1, err := cid.Parse("bafkqaaa")
+	require.NoError(t, err)
+	piece2, err := cid.Parse("bafkqaalb")
+	require.NoError(t, err)
+
+	ps := newTestPieceStore(t, ds_sync.MutexWrap(datastore.NewMapDatastore()))
+	addTestDeal(t, ps, piece1, 1, 256)
+	addTestDeal(t, ps, piece1, 2, 256)
+	addTestDeal(t, ps, piece2, 3, 512)
+	require.NoError(t, ps.UpdateDealOnPacking(piece1, 1, 5, 0, ""))
+	require.NoError(t, ps.UpdateDealStatus(1, Proving))
+
+	require.NoError(t, ps.UpdateDealOnChainStatus(1, Expired, 1000))
+	require.NoError(t, ps.UpdateDealOnChainStatus(3, Failed, 200))
+	require.Error(t, ps.UpdateDealOnChainStatus(100, Expired, 1000))
+
+	deals, err := ps.GetDealsByStatus(Undefine, Assigned, Packing, Proving)
+	require.NoError(t, err)
+	require.Len(t, deals, 1)
+	require.Equal(t, abi.DealID(2), deals[0].DealID)
+
+	deals, err = ps.GetDealsByStatus(Expired, Failed)
+	require.NoError(t, err)
+	require.Len(t, deals, 2)
+	require.Equal(t, []DealStatusChange{{Status: Expired, Epoch: 1000}}, deals[0].StatusChanges)
+
+	// an ended deal is no longer found in its sector
+	_, err = ps.GetDealByPosition(ctx, abi.SectorID{Number: 5}, 0, 256)
+	require.Error(t, err)
+
+	deals, err = ps.GetPieceDeals(piece1)
+	require.NoError(t, err)
+	require.Len(t, deals, 2)
+	require.True(t, IsDealEnded(deals[0].Status))
+	require.False(t, IsDealEnded(deals[1].Status))
+	unknown, err := cid.Parse("bafkqaatbmi")
+	require.NoError(t, err)
+	deals, err = ps.GetPieceDeals(unknown)
+	require.NoError(t, err)
+	require.Empty(t, deals)
+}
+
+func leasedDeals(deals []*DealInfoIncludePath) []abi.DealID {
+	var out []abi.DealID
+	for _, deal := range deals {
+		if deal.LeaseID != "" {
+			out = append(out, deal.DealID)
+		}
+	}
+	return out
+}
+
+func TestAssignUnPackedDealsLease(t *testing.T) {
+	piece1, err := cid.Parse("bafkqaaa")
+	require.NoError(t, err)
+	piece2, err := cid.Parse("bafkqaalb")
+	require.NoError(t, err)
+
+	ps := newTestPieceStore(t, ds_sync.MutexWrap(datastore.NewMapDatastore()))
+	addTestDeal(t, ps, piece1, 1, 256)
+	addTestDeal(t, ps, piece2, 2, 512)
+
+	deals, err := ps.AssignUnPackedDeals(&GetDealSpec{MaxPiece: 10, Assignee: "sealer-1", LeaseDuration: 100 * time.Millisecond})
+	require.NoError(t, err)
+	require.ElementsMatch(t, []abi.DealID{1, 2}, leasedDeals(deals))
+	lease1 := deals[0].LeaseID
+
+	// nothing left for another sealer while the lease holds
+	deals, err = ps.AssignUnPackedDeals(&GetDealSpec{MaxPiece: 10, Assignee: "sealer-2"})
+	require.NoError(t, err)
+	require.Empty(t, deals)
+
+	// a deal leased is only confirmed with its lease
+	require.ErrorIs(t, ps.UpdateDealOnPacking(piece1, 1, 1, 0, ""), ErrLeaseMismatch)
+
+	// sealer-1 only packs deal 1, deal 2 returns to the pool when the lease expires
+	require.NoError(t, ps.UpdateDealOnPacking(piece1, 1, 1, 0, lease1))
+	time.Sleep(200 * time.Millisecond)
+
+	deals, err = ps.AssignUnPackedDeals(&GetDealSpec{MaxPiece: 10, Assignee: "sealer-2"})
+	require.NoError(t, err)
+	require.Equal(t, []abi.DealID{2}, leasedDeals(deals))
+	var lease2 string
+	for _, deal := range deals {
+		if deal.DealID == 2 {
+			lease2 = deal.LeaseID
+		}
+	}
+	require.NotEqual(t, lease1, lease2)
+
+	// the late confirmations of sealer-1 are refused, sealer-2 keeps the deal
+	require.ErrorIs(t, ps.UpdateDealOnPacking(piece2, 2, 1, 1024, lease1), ErrLeaseMismatch)
+	require.ErrorIs(t, ps.MarkDealsAsPacking([]abi.DealID{2}, lease1), ErrLeaseMismatch)
+	require.NoError(t, ps.MarkDealsAsPacking([]abi.DealID{2}, lease2))
+
+	all, err := ps.GetDeals(0, 10)
+	require.NoError(t, err)
+	require.Len(t, all, 2)
+	require.Equal(t, "sealer-1", all[0].Assignee)
+	require.True(t, all[0].LeaseExpire.IsZero())
+	require.Equal(t, "sealer-2", all[1].Assignee)
+	require.Equal(t, Assigned, all[1].Status)
+	require.True(t, all[1].LeaseExpire.IsZero())
+	require.Equal(t, abi.SectorNumber(0), all[1].SectorID)
+}
+
+func TestConfirmExpiredLease(t *testing.T) {
+	piece1, err := cid.Parse("bafkqaaa")
+	require.NoError(t, err)
+
+	ps := newTestPieceStore(t, ds_sync.MutexWrap(datastore.NewMapDatastore()))
+	addTestDeal(t, ps, piece1, 1, 256)
+
+	deals, err := ps.AssignUnPackedDeals(&GetDealSpec{MaxPiece: 10, Assignee: "sealer-1", LeaseDuration: 50 * time.Millisecond})
+	require.NoError(t, err)
+	require.Equal(t, []abi.DealID{1}, leasedDeals(deals))
+	time.Sleep(100 * time.Millisecond)
+
+	// the lease expired, even though no other sealer took the deal yet
+	require.ErrorIs(t, ps.UpdateDealOnPacking(piece1, 1, 1, 0, deals[0].LeaseID), ErrLeaseMismatch)
+	unpacked, err := ps.GetUnPackedDeals(nil)
+	require.NoError(t, err)
+	require.Len(t, unpacked, 1)
+}
+
+func TestAssignUnPackedDealsConcurrently(t *testing.T) {
+	ps := newTestPieceStore(t, ds_sync.MutexWrap(datastore.NewMapDatastore()))
+	pieceCID, err := cid.Parse("bafkqaaa")
+	require.NoError(t, err)
+	for i := 1; i <= 20; i++ {
+		addTestDeal(t, ps, pieceCID, abi.DealID(i), 256)
+	}
+
+	var lk sync.Mutex
+	seen := make(map[abi.DealID]struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			deals, err := ps.AssignUnPackedDeals(&GetDealSpec{MaxPiece: 10})
+			require.NoError(t, err)
+
+			lk.Lock()
+			defer lk.Unlock()
+			for _, dealID := range leasedDeals(deals) {
+				_, ok := seen[dealID]
+				require.False(t, ok, "deal %d assigned twice", dealID)
+				seen[dealID] = struct{}{}
+			}
+		}()
+	}
+	wg.Wait()
+	require.Len(t, seen, 20)
+}
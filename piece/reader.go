@@ -11,7 +11,7 @@ import (
 func Read(path string) (io.ReadCloser, error) {
 	pieceFile := strings.Split(path, ":")
 	if len(pieceFile) != 2 {
-		return nil, xerrors.Errorf("wrong format for piece storage %w", path)
+		return nil, xerrors.Errorf("wrong format for piece storage %s", path)
 	}
 	switch pieceFile[0] {
 	case "fs":
@@ -24,7 +24,7 @@ func Read(path string) (io.ReadCloser, error) {
 func ReadOffset(path string, offset, size int) (io.ReadCloser, error) {
 	pieceFile := strings.Split(path, ":")
 	if len(pieceFile) != 2 {
-		return nil, xerrors.Errorf("wrong format for piece storage %w", path)
+		return nil, xerrors.Errorf("wrong format for piece storage %s", path)
 	}
 	switch pieceFile[0] {
 	case "fs":
@@ -45,7 +45,7 @@ func ReadOffset(path string, offset, size int) (io.ReadCloser, error) {
 func ReWrite(path string, r io.Reader) (int64, error) {
 	pieceFile := strings.Split(path, ":")
 	if len(pieceFile) != 2 {
-		return -1, xerrors.Errorf("wrong format for piece storage %w", path)
+		return -1, xerrors.Errorf("wrong format for piece storage %s", path)
 	}
 	switch pieceFile[0] {
 	case "fs":
@@ -68,7 +68,7 @@ func ReWrite(path string, r io.Reader) (int64, error) {
 func Has(path string) (bool, error) {
 	pieceFile := strings.Split(path, ":")
 	if len(pieceFile) != 2 {
-		return false, xerrors.Errorf("wrong format for piece storage %w", path)
+		return false, xerrors.Errorf("wrong format for piece storage %s", path)
 	}
 	switch pieceFile[0] {
 	case "fs":
@@ -85,10 +85,90 @@ func Has(path string) (bool, error) {
 	}
 }
 
+func Remove(path string) error {
+	pieceFile := strings.Split(path, ":")
+	if len(pieceFile) != 2 {
+		return xerrors.Errorf("wrong format for piece storage %s", path)
+	}
+	switch pieceFile[0] {
+	case "fs":
+		err := os.Remove(pieceFile[1])
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	default:
+		return xerrors.Errorf("unsupport piece piecestorage type %s", path)
+	}
+}
+
+func Rename(from, to string) error {
+	fromFile, toFile := strings.Split(from, ":"), strings.Split(to, ":")
+	if len(fromFile) != 2 || len(toFile) != 2 || fromFile[0] != toFile[0] {
+		return xerrors.Errorf("wrong format for piece storage %s -> %s", from, to)
+	}
+	switch fromFile[0] {
+	case "fs":
+		return os.Rename(fromFile[1], toFile[1])
+	default:
+		return xerrors.Errorf("unsupport piece piecestorage type %s", from)
+	}
+}
+
+func Usage(path string) (int64, int64, error) {
+	pieceStorage := strings.Split(path, ":")
+	if len(pieceStorage) != 2 {
+		return 0, 0, xerrors.Errorf("wrong format for piece storage %s", path)
+	}
+	switch pieceStorage[0] {
+	case "fs":
+		files, err := ioutil.ReadDir(pieceStorage[1])
+		if err != nil {
+			return 0, 0, err
+		}
+		var used int64
+		for _, f := range files {
+			if f.Mode().IsRegular() {
+				used += f.Size()
+			}
+		}
+		free, err := freeSpace(pieceStorage[1])
+		if err != nil {
+			return 0, 0, err
+		}
+		return used, free, nil
+	default:
+		return 0, 0, xerrors.Errorf("unsupport piece piecestorage type %s", path)
+	}
+}
+
+func List(path string) ([]PieceFileInfo, error) {
+	pieceStorage := strings.Split(path, ":")
+	if len(pieceStorage) != 2 {
+		return nil, xerrors.Errorf("wrong format for piece storage %s", path)
+	}
+	switch pieceStorage[0] {
+	case "fs":
+		files, err := ioutil.ReadDir(pieceStorage[1])
+		if err != nil {
+			return nil, err
+		}
+		out := make([]PieceFileInfo, 0, len(files))
+		for _, f := range files {
+			if f.Mode().IsRegular() {
+				out = append(out, PieceFileInfo{Name: f.Name(), Size: f.Size(), ModTime: f.ModTime()})
+			}
+		}
+		return out, nil
+	default:
+		return nil, xerrors.Errorf("unsupport piece piecestorage type %s", path)
+	}
+}
+
 func CheckValidate(path string) error {
 	pieceStorage := strings.Split(path, ":")
 	if len(pieceStorage) != 2 {
-		return xerrors.Errorf("wrong format for piece storage %w", path)
+		return xerrors.Errorf("wrong format for piece storage %s", path)
 	}
 	switch pieceStorage[0] {
 	case "fs":
@@ -0,0 +1,503 @@
+package piece
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/venus-market/config"
+)
+
+const (
+	defaultS3Region   = "us-east-1"
+	defaultS3PartSize = 64 << 20
+	// s3 rejects parts smaller than 5MiB, except for the last one
+	minS3PartSize = 5 << 20
+
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+var _ IPieceStorage = (*s3PieceStorage)(nil)
+
+// s3PieceStorage keeps pieces as objects of a s3 compatible object store, the objects are
+// named <prefix>/<piece cid> in the bucket, requests use path style addressing so that
+// self-hosted services work without dns setup.
+type s3PieceStorage struct {
+	endpoint  *url.URL
+	bucket    string
+	prefix    string
+	region    string
+	accessKey string
+	secretKey string
+	token     string
+	partSize  int64
+
+	client *http.Client
+	now    func() time.Time
+}
+
+// newS3PieceStorage creates a piece storage for "<bucket>[/<prefix>]" on the service in cfg
+func newS3PieceStorage(bucketPath string, cfg *config.S3PieceStorage) (*s3PieceStorage, error) {
+	if cfg == nil || len(cfg.Endpoint) == 0 {
+		return nil, xerrors.Errorf("s3 piece storage requires an endpoint")
+	}
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, xerrors.Errorf("parse s3 endpoint %s: %w", cfg.Endpoint, err)
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return nil, xerrors.Errorf("s3 endpoint %s must be a http or https url", cfg.Endpoint)
+	}
+
+	bucketPath = strings.Trim(bucketPath, "/")
+	bucket, prefix := bucketPath, ""
+	if idx := strings.Index(bucketPath, "/"); idx >= 0 {
+		bucket, prefix = bucketPath[:idx], bucketPath[idx+1:]
+	}
+	if len(bucket) == 0 {
+		return nil, xerrors.Errorf("s3 piece storage requires a bucket")
+	}
+
+	region := cfg.Region
+	if len(region) == 0 {
+		region = defaultS3Region
+	}
+	partSize := cfg.PartSize
+	if partSize == 0 {
+		partSize = defaultS3PartSize
+	}
+	if partSize < minS3PartSize {
+		return nil, xerrors.Errorf("s3 part size %d is less than the minimum %d", partSize, minS3PartSize)
+	}
+
+	return &s3PieceStorage{
+		endpoint:  endpoint,
+		bucket:    bucket,
+		prefix:    prefix,
+		region:    region,
+		accessKey: cfg.AccessKey,
+		secretKey: cfg.SecretKey,
+		token:     cfg.Token,
+		partSize:  partSize,
+		client:    http.DefaultClient,
+		now:       time.Now,
+	}, nil
+}
+
+func (s *s3PieceStorage) objectURL(name string, query url.Values) *url.URL {
+	u := *s.endpoint
+	u.Path = "/" + path.Join(strings.Trim(u.Path, "/"), s.bucket, s.prefix, name)
+	u.RawQuery = query.Encode()
+	return &u
+}
+
+// do signs and sends a request to the object, the response is returned only for status codes in expect
+func (s *s3PieceStorage) do(ctx context.Context, method, name string, query url.Values, header http.Header, body []byte, expect ...int) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, s.objectURL(name, query).String(), r)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	s.sign(req, body)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, xerrors.Errorf("%s %s: %w", method, name, err)
+	}
+	for _, code := range expect {
+		if resp.StatusCode == code {
+			return resp, nil
+		}
+	}
+	defer resp.Body.Close() //nolint:errcheck
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	return nil, &s3Error{Method: method, Object: name, StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+}
+
+func (s *s3PieceStorage) SaveTo(ctx context.Context, name string, r io.Reader) (int64, error) {
+	buf := make([]byte, s.partSize)
+	n, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		// small enough for a single request
+		resp, err := s.do(ctx, http.MethodPut, name, nil, nil, buf[:n], http.StatusOK)
+		if err != nil {
+			return 0, err
+		}
+		_ = resp.Body.Close()
+		return int64(n), nil
+	}
+	if err != nil {
+		return 0, xerrors.Errorf("read piece data: %w", err)
+	}
+	return s.multipartUpload(ctx, name, buf, r)
+}
+
+type initiateMultipartUploadResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+// multipartUpload uploads the piece in parts of partSize, the first part is already in buf
+func (s *s3PieceStorage) multipartUpload(ctx context.Context, name string, buf []byte, r io.Reader) (int64, error) {
+	resp, err := s.do(ctx, http.MethodPost, name, url.Values{"uploads": {""}}, nil, nil, http.StatusOK)
+	if err != nil {
+		return 0, xerrors.Errorf("create multipart upload: %w", err)
+	}
+	var initResult initiateMultipartUploadResult
+	err = xml.NewDecoder(resp.Body).Decode(&initResult)
+	_ = resp.Body.Close()
+	if err != nil {
+		return 0, xerrors.Errorf("decode multipart upload: %w", err)
+	}
+	uploadID := initResult.UploadID
+
+	var total int64
+	var parts []completedPart
+	upload := func() error {
+		for partNumber := 1; ; partNumber++ {
+			n := len(buf)
+			if partNumber > 1 {
+				var err error
+				n, err = io.ReadFull(r, buf)
+				if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+					return xerrors.Errorf("read piece data: %w", err)
+				}
+				if n == 0 {
+					return nil
+				}
+			}
+
+			query := url.Values{
+				"partNumber": {strconv.Itoa(partNumber)},
+				"uploadId":   {uploadID},
+			}
+			resp, err := s.do(ctx, http.MethodPut, name, query, nil, buf[:n], http.StatusOK)
+			if err != nil {
+				return xerrors.Errorf("upload part %d: %w", partNumber, err)
+			}
+			_ = resp.Body.Close()
+			parts = append(parts, completedPart{PartNumber: partNumber, ETag: resp.Header.Get("ETag")})
+			total += int64(n)
+
+			if n < len(buf) {
+				return nil
+			}
+		}
+	}
+	if err := upload(); err != nil {
+		s.abortMultipartUpload(name, uploadID)
+		return 0, err
+	}
+
+	body, err := xml.Marshal(completeMultipartUpload{Parts: parts})
+	if err != nil {
+		s.abortMultipartUpload(name, uploadID)
+		return 0, err
+	}
+	header := http.Header{"Content-Type": {"application/xml"}}
+	resp, err = s.do(ctx, http.MethodPost, name, url.Values{"uploadId": {uploadID}}, header, body, http.StatusOK)
+	if err != nil {
+		s.abortMultipartUpload(name, uploadID)
+		return 0, xerrors.Errorf("complete multipart upload: %w", err)
+	}
+	defer resp.Body.Close() //nolint:errcheck
+	// the service may still fail after answering 200, the error is in the body then
+	var result struct {
+		XMLName xml.Name
+		Code    string
+		Message string
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err == nil && result.XMLName.Local == "Error" {
+		s.abortMultipartUpload(name, uploadID)
+		return 0, xerrors.Errorf("complete multipart upload: %s %s", result.Code, result.Message)
+	}
+	return total, nil
+}
+
+func (s *s3PieceStorage) abortMultipartUpload(name, uploadID string) {
+	// abort even when the context of the upload has been cancelled, so the parts don't leak
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	resp, err := s.do(ctx, http.MethodDelete, name, url.Values{"uploadId": {uploadID}}, nil, nil, http.StatusNoContent, http.StatusOK)
+	if err != nil {
+		log.Warnf("abort multipart upload %s of %s: %s", uploadID, name, err)
+		return
+	}
+	_ = resp.Body.Close()
+}
+
+func (s *s3PieceStorage) Read(ctx context.Context, name string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, name, nil, nil, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *s3PieceStorage) ReadOffset(ctx context.Context, name string, offset, size abi.UnpaddedPieceSize) (io.ReadCloser, error) {
+	if size == 0 {
+		return ioutil.NopCloser(bytes.NewReader(nil)), nil
+	}
+	header := http.Header{"Range": {fmt.Sprintf("bytes=%d-%d", offset, uint64(offset)+uint64(size)-1)}}
+	resp, err := s.do(ctx, http.MethodGet, name, nil, header, nil, http.StatusPartialContent, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusOK {
+		// the service ignored the range, skip to the offset ourselves
+		if _, err := io.CopyN(ioutil.Discard, resp.Body, int64(offset)); err != nil {
+			_ = resp.Body.Close()
+			return nil, xerrors.Errorf("unable to seek position to %d in object %s: %w", offset, name, err)
+		}
+	}
+	return NewLimitedBufferReader(resp.Body, int(size)), nil
+}
+
+func (s *s3PieceStorage) Has(name string) (bool, error) {
+	resp, err := s.do(context.TODO(), http.MethodHead, name, nil, nil, nil, http.StatusOK)
+	if err != nil {
+		if se, ok := err.(*s3Error); ok && se.StatusCode == http.StatusNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	_ = resp.Body.Close()
+	return true, nil
+}
+
+func (s *s3PieceStorage) Remove(ctx context.Context, name string) error {
+	resp, err := s.do(ctx, http.MethodDelete, name, nil, nil, nil, http.StatusNoContent, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+type listBucketObject struct {
+	Key          string    `xml:"Key"`
+	Size         int64     `xml:"Size"`
+	LastModified time.Time `xml:"LastModified"`
+}
+
+type listBucketResult struct {
+	Contents              []listBucketObject `xml:"Contents"`
+	IsTruncated           bool               `xml:"IsTruncated"`
+	NextContinuationToken string             `xml:"NextContinuationToken"`
+}
+
+// Usage sums the size of the objects under the prefix, an object store has no free space to report
+func (s *s3PieceStorage) Usage(ctx context.Context) (int64, int64, error) {
+	files, err := s.List(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	var used int64
+	for _, f := range files {
+		used += f.Size
+	}
+	return used, -1, nil
+}
+
+// List returns the objects under the prefix
+func (s *s3PieceStorage) List(ctx context.Context) ([]PieceFileInfo, error) {
+	prefix := ""
+	if len(s.prefix) > 0 {
+		prefix = s.prefix + "/"
+	}
+
+	var out []PieceFileInfo
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if len(token) > 0 {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.doBucket(ctx, http.MethodGet, query)
+		if err != nil {
+			return nil, xerrors.Errorf("list objects: %w", err)
+		}
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, xerrors.Errorf("decode object list: %w", err)
+		}
+		for _, obj := range result.Contents {
+			out = append(out, PieceFileInfo{Name: strings.TrimPrefix(obj.Key, prefix), Size: obj.Size, ModTime: obj.LastModified})
+		}
+		if !result.IsTruncated || len(result.NextContinuationToken) == 0 {
+			return out, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// checkBucket makes sure the bucket exists and the credentials are allowed to access it
+func (s *s3PieceStorage) checkBucket(ctx context.Context) error {
+	resp, err := s.doBucket(ctx, http.MethodHead, nil)
+	if err != nil {
+		return xerrors.Errorf("access bucket %s: %w", s.bucket, err)
+	}
+	_ = resp.Body.Close()
+	return nil
+}
+
+// doBucket signs and sends a request to the bucket itself, only 200 responses are returned
+func (s *s3PieceStorage) doBucket(ctx context.Context, method string, query url.Values) (*http.Response, error) {
+	u := *s.endpoint
+	u.Path = "/" + path.Join(strings.Trim(u.Path, "/"), s.bucket)
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, nil)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close() //nolint:errcheck
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, &s3Error{Method: method, Object: s.bucket, StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+	return resp, nil
+}
+
+type s3Error struct {
+	Method     string
+	Object     string
+	StatusCode int
+	Message    string
+}
+
+func (e *s3Error) Error() string {
+	return fmt.Sprintf("%s %s: unexpected status %d: %s", e.Method, e.Object, e.StatusCode, e.Message)
+}
+
+// sign adds an AWS signature version 4 authorization to req,
+// see https://docs.aws.amazon.com/general/latest/gr/sigv4_signing.html
+func (s *s3PieceStorage) sign(req *http.Request, body []byte) {
+	now := s.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	payloadHash := emptyPayloadHash
+	if len(body) > 0 {
+		sum := sha256.Sum256(body)
+		payloadHash = hex.EncodeToString(sum[:])
+	}
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if len(s.token) > 0 {
+		req.Header.Set("X-Amz-Security-Token", s.token)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, false),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	hashedRequest := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashedRequest[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	_, _ = h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var pairs []string
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			pairs = append(pairs, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode escapes every byte but the unreserved characters, slashes are kept unless encodeSlash is set
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
@@ -0,0 +1,276 @@
+package piece
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/go-units"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/venus-market/config"
+)
+
+var ErrPieceNotFound = xerrors.New("piece not found in any piece storage")
+
+// PieceStorageLocation is one of the storages managed by PieceStorageManager
+type PieceStorageLocation struct {
+	IPieceStorage
+
+	Name string
+	// Path is the prefix of the piece paths handed to the sealer, fs:<dir> or s3:<bucket>[/<prefix>]
+	Path        string
+	Weight      uint64
+	ReadOnly    bool
+	MaxCapacity int64
+}
+
+// PieceStorageUsage reports the space of a piece storage, Free and Available are -1 when unknown
+// or unlimited
+type PieceStorageUsage struct {
+	Name        string
+	Path        string
+	Weight      uint64
+	ReadOnly    bool
+	MaxCapacity int64
+	// Used is the space taken by pieces
+	Used int64
+	// Free is the free space of the underlying storage
+	Free int64
+	// Available is the space left for new pieces, limited by both Free and MaxCapacity
+	Available int64
+}
+
+// PieceStorageManager spreads pieces over several piece storages. New pieces are placed by the
+// space left and the weight of each storage, reads search all of them.
+type PieceStorageManager struct {
+	locations []*PieceStorageLocation
+
+	lk sync.Mutex
+	// space of each storage, reloaded once it is older than usageCacheTTL and adjusted by every placement
+	usages map[string]*cachedUsage
+}
+
+type cachedUsage struct {
+	used, free int64
+	at         time.Time
+}
+
+// listing an object store is expensive, the usage of a storage is cached between placements
+var usageCacheTTL = time.Minute
+
+func newPieceStorageManager(ctx context.Context, cfgs []config.PieceStorageConfig) (*PieceStorageManager, error) {
+	if len(cfgs) == 0 {
+		return nil, xerrors.Errorf("no piece storage configured")
+	}
+
+	m := &PieceStorageManager{usages: make(map[string]*cachedUsage)}
+	names := make(map[string]struct{})
+	for _, cfg := range cfgs {
+		loc, err := newPieceStorageLocation(ctx, cfg)
+		if err != nil {
+			return nil, xerrors.Errorf("piece storage %s: %w", cfg.Path, err)
+		}
+		if _, ok := names[loc.Name]; ok {
+			return nil, xerrors.Errorf("duplicate piece storage %s", loc.Name)
+		}
+		names[loc.Name] = struct{}{}
+		m.locations = append(m.locations, loc)
+	}
+	return m, nil
+}
+
+func newPieceStorageLocation(ctx context.Context, cfg config.PieceStorageConfig) (*PieceStorageLocation, error) {
+	loc := &PieceStorageLocation{
+		Name:     cfg.Name,
+		Path:     string(cfg.Path),
+		Weight:   cfg.Weight,
+		ReadOnly: cfg.ReadOnly,
+	}
+	if len(loc.Name) == 0 {
+		loc.Name = loc.Path
+	}
+	if loc.Weight == 0 {
+		loc.Weight = 1
+	}
+	if len(cfg.MaxCapacity) > 0 {
+		capacity, err := units.RAMInBytes(cfg.MaxCapacity)
+		if err != nil {
+			return nil, xerrors.Errorf("parse max capacity %s: %w", cfg.MaxCapacity, err)
+		}
+		loc.MaxCapacity = capacity
+	}
+
+	if bucket := strings.TrimPrefix(loc.Path, "s3:"); bucket != loc.Path {
+		s3Storage, err := newS3PieceStorage(bucket, &cfg.S3)
+		if err != nil {
+			return nil, err
+		}
+		if err := s3Storage.checkBucket(ctx); err != nil {
+			return nil, err
+		}
+		loc.IPieceStorage = s3Storage
+		return loc, nil
+	}
+
+	if err := CheckValidate(loc.Path); err != nil {
+		return nil, err
+	}
+	loc.IPieceStorage = &PieceStorage{loc.Path}
+	return loc, nil
+}
+
+// FindStorageForRead returns the first storage having the piece
+func (m *PieceStorageManager) FindStorageForRead(name string) (*PieceStorageLocation, error) {
+	for _, loc := range m.locations {
+		has, err := loc.Has(name)
+		if err != nil {
+			log.Warnf("check piece %s in piece storage %s: %s", name, loc.Name, err)
+			continue
+		}
+		if has {
+			return loc, nil
+		}
+	}
+	return nil, xerrors.Errorf("%s: %w", name, ErrPieceNotFound)
+}
+
+// FindStoragesForRead returns every storage having the piece, the storages which failed to
+// answer are skipped
+func (m *PieceStorageManager) FindStoragesForRead(name string) []*PieceStorageLocation {
+	var out []*PieceStorageLocation
+	for _, loc := range m.locations {
+		has, err := loc.Has(name)
+		if err != nil {
+			log.Warnf("check piece %s in piece storage %s: %s", name, loc.Name, err)
+			continue
+		}
+		if has {
+			out = append(out, loc)
+		}
+	}
+	return out
+}
+
+// RemovePiece deletes the piece from every writable storage having it
+func (m *PieceStorageManager) RemovePiece(ctx context.Context, name string) error {
+	for _, loc := range m.locations {
+		if loc.ReadOnly {
+			continue
+		}
+		has, err := loc.Has(name)
+		if err != nil {
+			return xerrors.Errorf("check piece %s in piece storage %s: %w", name, loc.Name, err)
+		}
+		if !has {
+			continue
+		}
+		if err := loc.Remove(ctx, name); err != nil {
+			return xerrors.Errorf("remove piece %s from piece storage %s: %w", name, loc.Name, err)
+		}
+	}
+	return nil
+}
+
+// FindStorageForWrite selects the writable storage with the highest weight among those having
+// room for size bytes, the one with the most space left among those of the same weight. A storage
+// whose space is unknown, e.g. an object store, has room for any piece and is neither preferred nor
+// passed over for its space. The space is reserved for the piece.
+func (m *PieceStorageManager) FindStorageForWrite(ctx context.Context, size int64) (*PieceStorageLocation, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	var best *PieceStorageLocation
+	var bestAvailable int64
+	for _, loc := range m.locations {
+		if loc.ReadOnly {
+			continue
+		}
+		usage, err := m.usageLocked(ctx, loc, false)
+		if err != nil {
+			log.Warnf("get usage of piece storage %s: %s", loc.Name, err)
+			continue
+		}
+		available := usage.Available
+		if available >= 0 && available < size {
+			continue
+		}
+
+		switch {
+		case best == nil || loc.Weight > best.Weight:
+		case loc.Weight == best.Weight && available >= 0 && bestAvailable >= 0 && available > bestAvailable:
+		default:
+			continue
+		}
+		best, bestAvailable = loc, available
+	}
+	if best == nil {
+		return nil, xerrors.Errorf("no piece storage has room for %d bytes", size)
+	}
+
+	cached := m.usages[best.Name]
+	cached.used += size
+	if cached.free >= 0 {
+		cached.free -= size
+	}
+	return best, nil
+}
+
+// Locations returns the piece storages, in the order of the config
+func (m *PieceStorageManager) Locations() []*PieceStorageLocation {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	return append([]*PieceStorageLocation(nil), m.locations...)
+}
+
+// Usage reports the space of every storage, the space taken by pieces is computed again
+func (m *PieceStorageManager) Usage(ctx context.Context) ([]PieceStorageUsage, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	out := make([]PieceStorageUsage, 0, len(m.locations))
+	for _, loc := range m.locations {
+		usage, err := m.usageLocked(ctx, loc, true)
+		if err != nil {
+			return nil, xerrors.Errorf("get usage of piece storage %s: %w", loc.Name, err)
+		}
+		out = append(out, usage)
+	}
+	return out, nil
+}
+
+func (m *PieceStorageManager) usageLocked(ctx context.Context, loc *PieceStorageLocation, refresh bool) (PieceStorageUsage, error) {
+	cached, ok := m.usages[loc.Name]
+	if !ok || refresh || time.Since(cached.at) > usageCacheTTL {
+		used, free, err := loc.IPieceStorage.Usage(ctx)
+		if err != nil {
+			return PieceStorageUsage{}, err
+		}
+		cached = &cachedUsage{used: used, free: free, at: time.Now()}
+		m.usages[loc.Name] = cached
+	}
+	used, free := cached.used, cached.free
+
+	available := free
+	if loc.MaxCapacity > 0 {
+		left := loc.MaxCapacity - used
+		if left < 0 {
+			left = 0
+		}
+		if available < 0 || left < available {
+			available = left
+		}
+	}
+
+	return PieceStorageUsage{
+		Name:        loc.Name,
+		Path:        loc.Path,
+		Weight:      loc.Weight,
+		ReadOnly:    loc.ReadOnly,
+		MaxCapacity: loc.MaxCapacity,
+		Used:        used,
+		Free:        free,
+		Available:   available,
+	}, nil
+}
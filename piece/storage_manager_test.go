@@ -0,0 +1,102 @@
+package piece
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/venus-market/config"
+)
+
+func TestPieceStorageManager(t *testing.T) {
+	ctx := context.Background()
+	dirA, dirB, dirC := t.TempDir(), t.TempDir(), t.TempDir()
+	m, err := newPieceStorageManager(ctx, []config.PieceStorageConfig{
+		{Name: "a", Path: config.PieceStorageString("fs:" + dirA), Weight: 1, MaxCapacity: "4KiB"},
+		{Name: "b", Path: config.PieceStorageString("fs:" + dirB), Weight: 10, MaxCapacity: "1KiB"},
+		{Name: "c", Path: config.PieceStorageString("fs:" + dirC), ReadOnly: true},
+	})
+	require.NoError(t, err)
+
+	// b has less room but a higher weight, until it is full
+	for _, expect := range []string{"b", "b", "a"} {
+		loc, err := m.FindStorageForWrite(ctx, 512)
+		require.NoError(t, err)
+		require.Equal(t, expect, loc.Name)
+	}
+	_, err = m.FindStorageForWrite(ctx, 8<<10)
+	require.Error(t, err)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dirC, "piece"), []byte("piece data"), 0644))
+	loc, err := m.FindStorageForRead("piece")
+	require.NoError(t, err)
+	require.Equal(t, "c", loc.Name)
+	_, err = m.FindStorageForRead("missing")
+	require.True(t, xerrors.Is(err, ErrPieceNotFound))
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dirC, "other"), make([]byte, 100), 0644))
+
+	// usage is computed again, the space reserved by placements without a piece is released
+	usages, err := m.Usage(ctx)
+	require.NoError(t, err)
+	require.Len(t, usages, 3)
+	require.Equal(t, int64(0), usages[0].Used)
+	require.Equal(t, int64(4<<10), usages[0].Available)
+	require.Equal(t, int64(1<<10), usages[1].Available)
+	require.Equal(t, int64(110), usages[2].Used)
+	require.True(t, usages[2].ReadOnly)
+	require.Equal(t, usages[2].Free, usages[2].Available)
+
+	// the space left only decides between storages of the same weight
+	m, err = newPieceStorageManager(ctx, []config.PieceStorageConfig{
+		{Name: "a", Path: config.PieceStorageString("fs:" + dirA), Weight: 2, MaxCapacity: "1KiB"},
+		{Name: "b", Path: config.PieceStorageString("fs:" + dirB), Weight: 2, MaxCapacity: "2KiB"},
+		{Name: "c", Path: config.PieceStorageString("fs:" + t.TempDir()), Weight: 1, MaxCapacity: "8KiB"},
+	})
+	require.NoError(t, err)
+	for _, expect := range []string{"b", "b", "a", "b", "a", "b", "c"} {
+		loc, err := m.FindStorageForWrite(ctx, 512)
+		require.NoError(t, err)
+		require.Equal(t, expect, loc.Name)
+	}
+
+	_, err = newPieceStorageManager(ctx, []config.PieceStorageConfig{
+		{Name: "a", Path: config.PieceStorageString("fs:" + dirA)},
+		{Name: "a", Path: config.PieceStorageString("fs:" + dirB)},
+	})
+	require.Error(t, err)
+}
+
+// unknownSpaceStorage is an object store, which doesn't know its free space
+type unknownSpaceStorage struct {
+	IPieceStorage
+}
+
+func (s *unknownSpaceStorage) Usage(context.Context) (int64, int64, error) {
+	return 0, -1, nil
+}
+
+func TestFindStorageForWriteUnknownSpace(t *testing.T) {
+	ctx := context.Background()
+	m, err := newPieceStorageManager(ctx, []config.PieceStorageConfig{
+		{Name: "a", Path: config.PieceStorageString("fs:" + t.TempDir()), Weight: 1, MaxCapacity: "4KiB"},
+		{Name: "b", Path: config.PieceStorageString("fs:" + t.TempDir()), Weight: 2, MaxCapacity: "1KiB"},
+	})
+	require.NoError(t, err)
+	m.locations = append([]*PieceStorageLocation{{IPieceStorage: &unknownSpaceStorage{}, Name: "s3", Weight: 1}}, m.locations...)
+
+	// the weight goes first, then a storage of unknown space keeps its place in the config order
+	for _, expect := range []string{"b", "b", "s3", "s3"} {
+		loc, err := m.FindStorageForWrite(ctx, 512)
+		require.NoError(t, err)
+		require.Equal(t, expect, loc.Name)
+	}
+	// only the storage of unknown space takes a piece larger than the others
+	loc, err := m.FindStorageForWrite(ctx, 8<<10)
+	require.NoError(t, err)
+	require.Equal(t, "s3", loc.Name)
+}
@@ -0,0 +1,180 @@
+package piece
+
+import (
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/multiformats/go-multihash"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/venus-market/models"
+)
+
+var (
+	topIndexMhKey      = datastore.NewKey("/mh")
+	topIndexPieceKey   = datastore.NewKey("/piece")
+	topIndexIndexedKey = datastore.NewKey("/indexed")
+	topIndexQueueKey   = datastore.NewKey("/queue")
+)
+
+// TopLevelIndex maps the payload multihashes to the pieces holding them. The DAG store queues a
+// piece when its shard is registered, fills it from the index of the shard and drops a piece with
+// its shard.
+type TopLevelIndex struct {
+	ds datastore.Batching
+}
+
+func NewTopLevelIndex(ds models.TopIndexDS) *TopLevelIndex {
+	return &TopLevelIndex{ds: ds}
+}
+
+// AddPiece records the payload multihashes of the piece
+func (ti *TopLevelIndex) AddPiece(pieceCid cid.Cid, mhs []multihash.Multihash) error {
+	batch, err := ti.ds.Batch()
+	if err != nil {
+		return err
+	}
+	for _, mh := range mhs {
+		if err := batch.Put(topIndexMhKey.ChildString(mh.B58String()).ChildString(pieceCid.String()), []byte{}); err != nil {
+			return err
+		}
+		if err := batch.Put(topIndexPieceKey.ChildString(pieceCid.String()).ChildString(mh.B58String()), []byte{}); err != nil {
+			return err
+		}
+	}
+	if err := batch.Put(topIndexIndexedKey.ChildString(pieceCid.String()), []byte{}); err != nil {
+		return err
+	}
+	if err := batch.Delete(topIndexQueueKey.ChildString(pieceCid.String())); err != nil {
+		return err
+	}
+	if err := batch.Commit(); err != nil {
+		return xerrors.Errorf("save payload index of piece %s: %w", pieceCid, err)
+	}
+	return nil
+}
+
+// HasPiece reports whether the payload of the piece is indexed
+func (ti *TopLevelIndex) HasPiece(pieceCid cid.Cid) (bool, error) {
+	return ti.ds.Has(topIndexIndexedKey.ChildString(pieceCid.String()))
+}
+
+// DropPiece removes the payload multihashes of the piece
+func (ti *TopLevelIndex) DropPiece(pieceCid cid.Cid) error {
+	prefix := topIndexPieceKey.ChildString(pieceCid.String())
+	mhs, err := ti.childNames(prefix)
+	if err != nil {
+		return err
+	}
+
+	batch, err := ti.ds.Batch()
+	if err != nil {
+		return err
+	}
+	for _, mh := range mhs {
+		if err := batch.Delete(topIndexMhKey.ChildString(mh).ChildString(pieceCid.String())); err != nil {
+			return err
+		}
+		if err := batch.Delete(prefix.ChildString(mh)); err != nil {
+			return err
+		}
+	}
+	if err := batch.Delete(topIndexIndexedKey.ChildString(pieceCid.String())); err != nil {
+		return err
+	}
+	if err := batch.Delete(topIndexQueueKey.ChildString(pieceCid.String())); err != nil {
+		return err
+	}
+	if err := batch.Commit(); err != nil {
+		return xerrors.Errorf("drop payload index of piece %s: %w", pieceCid, err)
+	}
+	return nil
+}
+
+// QueuePiece records a piece to index, it stays queued until AddPiece or DropPiece
+func (ti *TopLevelIndex) QueuePiece(pieceCid cid.Cid) error {
+	if err := ti.ds.Put(topIndexQueueKey.ChildString(pieceCid.String()), []byte{}); err != nil {
+		return xerrors.Errorf("queue piece %s: %w", pieceCid, err)
+	}
+	return nil
+}
+
+// QueuedPieces returns the pieces waiting to be indexed
+func (ti *TopLevelIndex) QueuedPieces() ([]cid.Cid, error) {
+	names, err := ti.childNames(topIndexQueueKey)
+	if err != nil {
+		return nil, err
+	}
+	return decodePieces(names)
+}
+
+// PiecesContainingMultihash returns the pieces holding a payload block
+func (ti *TopLevelIndex) PiecesContainingMultihash(mh multihash.Multihash) ([]cid.Cid, error) {
+	names, err := ti.childNames(topIndexMhKey.ChildString(mh.B58String()))
+	if err != nil {
+		return nil, err
+	}
+	return decodePieces(names)
+}
+
+func decodePieces(names []string) ([]cid.Cid, error) {
+	pieces := make([]cid.Cid, 0, len(names))
+	for _, name := range names {
+		pieceCid, err := cid.Decode(name)
+		if err != nil {
+			return nil, xerrors.Errorf("parse piece cid %s: %w", name, err)
+		}
+		pieces = append(pieces, pieceCid)
+	}
+	return pieces, nil
+}
+
+// ForEachMultihash calls f once for every payload multihash indexed
+func (ti *TopLevelIndex) ForEachMultihash(f func(mh multihash.Multihash) error) error {
+	// the pieces of a multihash are next to each other once sorted
+	res, err := ti.ds.Query(query.Query{Prefix: topIndexMhKey.String() + "/", KeysOnly: true, Orders: []query.Order{query.OrderByKey{}}})
+	if err != nil {
+		return xerrors.Errorf("query payload index: %w", err)
+	}
+	defer res.Close() //nolint:errcheck
+
+	var last string
+	for r := range res.Next() {
+		if r.Error != nil {
+			return r.Error
+		}
+		// /mh/<multihash>/<piece>
+		name := datastore.NewKey(r.Key).Parent().BaseNamespace()
+		if name == last {
+			continue
+		}
+		last = name
+		mh, err := multihash.FromB58String(name)
+		if err != nil {
+			return xerrors.Errorf("parse multihash %s: %w", name, err)
+		}
+		if err := f(mh); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ti *TopLevelIndex) childNames(prefix datastore.Key) ([]string, error) {
+	res, err := ti.ds.Query(query.Query{Prefix: prefix.String() + "/", KeysOnly: true})
+	if err != nil {
+		return nil, xerrors.Errorf("query payload index: %w", err)
+	}
+	defer res.Close() //nolint:errcheck
+
+	var names []string
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		names = append(names, strings.TrimPrefix(r.Key, prefix.String()+"/"))
+	}
+	return names, nil
+}
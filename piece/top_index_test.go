@@ -0,0 +1,98 @@
+package piece
+
+import (
+	"testing"
+
+	"github.com/filecoin-project/go-fil-markets/piecestore"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	ds_sync "github.com/ipfs/go-datastore/sync"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTopLevelIndex(t *testing.T) {
+	newCid := func(data string) cid.Cid {
+		mh, err := multihash.Sum([]byte(data), multihash.SHA2_256, -1)
+		require.NoError(t, err)
+		return cid.NewCidV1(cid.Raw, mh)
+	}
+	piece1, piece2, piece3 := newCid("piece1"), newCid("piece2"), newCid("piece3")
+	a, b, c, d := newCid("a"), newCid("b"), newCid("c"), newCid("d")
+
+	index := NewTopLevelIndex(ds_sync.MutexWrap(datastore.NewMapDatastore()))
+	require.NoError(t, index.AddPiece(piece1, []multihash.Multihash{a.Hash(), b.Hash()}))
+	require.NoError(t, index.AddPiece(piece2, []multihash.Multihash{b.Hash(), c.Hash()}))
+
+	has, err := index.HasPiece(piece1)
+	require.NoError(t, err)
+	require.True(t, has)
+	has, err = index.HasPiece(piece3)
+	require.NoError(t, err)
+	require.False(t, has)
+
+	pieces, err := index.PiecesContainingMultihash(b.Hash())
+	require.NoError(t, err)
+	require.ElementsMatch(t, []cid.Cid{piece1, piece2}, pieces)
+
+	// d and b are only known by the legacy cid infos
+	cidInfoDS := ds_sync.MutexWrap(datastore.NewMapDatastore())
+	legacy, err := NewDsCidInfoStore(cidInfoDS)
+	require.NoError(t, err)
+	require.NoError(t, legacy.AddPieceBlockLocations(piece3, map[cid.Cid]piecestore.BlockLocation{d: {}, b: {}}))
+
+	store, err := NewDagStoreCidInfoStore(cidInfoDS, index)
+	require.NoError(t, err)
+	keys, err := store.ListCidInfoKeys()
+	require.NoError(t, err)
+	require.Len(t, keys, 4)
+
+	// the payload is looked up by its multihash whatever the codec of the cid
+	ci, err := store.GetCIDInfo(cid.NewCidV1(cid.DagProtobuf, a.Hash()))
+	require.NoError(t, err)
+	require.Len(t, ci.PieceBlockLocations, 1)
+	require.Equal(t, piece1, ci.PieceBlockLocations[0].PieceCID)
+	ci, err = store.GetCIDInfo(d)
+	require.NoError(t, err)
+	require.Equal(t, piece3, ci.PieceBlockLocations[0].PieceCID)
+	// both sources are merged while the legacy cid infos exist
+	ci, err = store.GetCIDInfo(b)
+	require.NoError(t, err)
+	var located []cid.Cid
+	for _, pbl := range ci.PieceBlockLocations {
+		located = append(located, pbl.PieceCID)
+	}
+	require.ElementsMatch(t, []cid.Cid{piece1, piece2, piece3}, located)
+
+	// the pieces of the registered shards wait in the queue until they are indexed
+	require.NoError(t, index.QueuePiece(piece3))
+	require.NoError(t, index.QueuePiece(piece1))
+	queued, err := index.QueuedPieces()
+	require.NoError(t, err)
+	require.ElementsMatch(t, []cid.Cid{piece1, piece3}, queued)
+	require.NoError(t, index.AddPiece(piece1, []multihash.Multihash{a.Hash(), b.Hash()}))
+	queued, err = index.QueuedPieces()
+	require.NoError(t, err)
+	require.Equal(t, []cid.Cid{piece3}, queued)
+
+	require.NoError(t, index.DropPiece(piece1))
+	pieces, err = index.PiecesContainingMultihash(a.Hash())
+	require.NoError(t, err)
+	require.Empty(t, pieces)
+	pieces, err = index.PiecesContainingMultihash(b.Hash())
+	require.NoError(t, err)
+	require.Equal(t, []cid.Cid{piece2}, pieces)
+	require.NoError(t, index.DropPiece(piece3))
+	queued, err = index.QueuedPieces()
+	require.NoError(t, err)
+	require.Empty(t, queued)
+
+	dropped, err := DropCidInfos(cidInfoDS)
+	require.NoError(t, err)
+	require.Equal(t, 2, dropped)
+	_, err = store.GetCIDInfo(d)
+	require.ErrorIs(t, err, datastore.ErrNotFound)
+	keys, err = store.ListCidInfoKeys()
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"os/exec"
 	"strings"
+	"syscall"
 
 	"github.com/mitchellh/go-homedir"
 	"golang.org/x/xerrors"
@@ -30,3 +31,12 @@ func move(from, to string) error {
 
 	return nil
 }
+
+// freeSpace returns the bytes available to unprivileged users in the filesystem of path
+func freeSpace(path string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, xerrors.Errorf("statfs %s: %w", path, err)
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil //nolint:unconvert
+}
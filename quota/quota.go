@@ -0,0 +1,430 @@
+package quota
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/venus-market/config"
+	"github.com/filecoin-project/venus-market/models"
+)
+
+var log = logging.Logger("quota")
+
+var (
+	usagePrefix    = datastore.NewKey("/usage")
+	inFlightPrefix = datastore.NewKey("/inflight")
+	acceptedPrefix = datastore.NewKey("/accepted")
+)
+
+// ClientUsage is what a client, given either by its address or by its peer id, takes from the provider
+type ClientUsage struct {
+	// Client is the address or the peer id of the client
+	Client string
+	// padded size of the deals accepted and not failed, expired or slashed
+	AcceptedBytes uint64
+	// deals accepted and not active yet
+	DealsInFlight uint64
+	// proposals received since WindowStart
+	Proposals   uint64
+	WindowStart time.Time
+}
+
+// countedDeal records the clients a deal is counted for, under inFlightPrefix until the deal is
+// active or failed and under acceptedPrefix until it fails, expires or is slashed. A client left out
+// by a reset is undefined.
+type countedDeal struct {
+	Client    address.Address
+	Peer      peer.ID
+	PieceSize abi.PaddedPieceSize
+}
+
+func (d *countedDeal) keys() []datastore.Key {
+	var keys []datastore.Key
+	if d.Client != address.Undef {
+		keys = append(keys, addressKey(d.Client))
+	}
+	if len(d.Peer) > 0 {
+		keys = append(keys, peerKey(d.Peer))
+	}
+	return keys
+}
+
+// ClientQuotas limits the proposals, the deals in flight and the accepted bytes of each client, the
+// counters are kept in the metadata datastore
+type ClientQuotas struct {
+	ds  datastore.Batching
+	cfg config.ClientQuota
+	now func() time.Time
+
+	maxAcceptedBytes uint64
+
+	lk sync.Mutex
+}
+
+func NewClientQuotas(ds models.QuotaDS, cfg *config.MarketConfig) (*ClientQuotas, error) {
+	q := &ClientQuotas{ds: ds, cfg: cfg.ClientQuota, now: time.Now}
+	if len(cfg.ClientQuota.MaxAcceptedBytes) > 0 {
+		v, err := units.RAMInBytes(cfg.ClientQuota.MaxAcceptedBytes)
+		if err != nil {
+			return nil, xerrors.Errorf("parse MaxAcceptedBytes %s: %w", cfg.ClientQuota.MaxAcceptedBytes, err)
+		}
+		q.maxAcceptedBytes = uint64(v)
+	}
+	if q.cfg.ProposalWindow <= 0 {
+		q.cfg.ProposalWindow = config.Duration(time.Hour)
+	}
+	return q, nil
+}
+
+func addressKey(addr address.Address) datastore.Key {
+	return usagePrefix.ChildString("address").ChildString(addr.String())
+}
+
+func peerKey(p peer.ID) datastore.Key {
+	return usagePrefix.ChildString("peer").ChildString(p.String())
+}
+
+// clientKey parses the address or the peer id of a client
+func clientKey(client string) (datastore.Key, error) {
+	if addr, err := address.NewFromString(client); err == nil {
+		return addressKey(addr), nil
+	}
+	if p, err := peer.Decode(client); err == nil {
+		return peerKey(p), nil
+	}
+	return datastore.Key{}, xerrors.Errorf("%s is neither an address nor a peer id", client)
+}
+
+func dealKeys(deal storagemarket.MinerDeal) []datastore.Key {
+	return []datastore.Key{addressKey(deal.Proposal.Client), peerKey(deal.Client)}
+}
+
+func (q *ClientQuotas) getUsage(key datastore.Key) (*ClientUsage, error) {
+	usage := &ClientUsage{Client: key.BaseNamespace()}
+	data, err := q.ds.Get(key)
+	if err == datastore.ErrNotFound {
+		return usage, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, usage); err != nil {
+		return nil, xerrors.Errorf("decode usage of %s: %w", usage.Client, err)
+	}
+	return usage, nil
+}
+
+func putUsage(b datastore.Write, key datastore.Key, usage *ClientUsage) error {
+	data, err := json.Marshal(usage)
+	if err != nil {
+		return err
+	}
+	return b.Put(key, data)
+}
+
+// CheckProposal counts a proposal against the proposals of its clients in the current window
+func (q *ClientQuotas) CheckProposal(deal storagemarket.MinerDeal) (bool, string, error) {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+
+	now := q.now()
+	window := time.Duration(q.cfg.ProposalWindow)
+	var reason string
+	for _, key := range dealKeys(deal) {
+		usage, err := q.getUsage(key)
+		if err != nil {
+			return false, "miner error", err
+		}
+		if now.Sub(usage.WindowStart) >= window {
+			usage.Proposals, usage.WindowStart = 0, now
+		}
+		usage.Proposals++
+		if err := putUsage(q.ds, key, usage); err != nil {
+			return false, "miner error", err
+		}
+		if q.cfg.MaxProposals > 0 && usage.Proposals > q.cfg.MaxProposals && len(reason) == 0 {
+			reason = fmt.Sprintf("client %s made more than %d proposals in %s", usage.Client, q.cfg.MaxProposals, window)
+		}
+	}
+	if len(reason) > 0 {
+		return false, reason, nil
+	}
+	return true, "", nil
+}
+
+// Accept counts an accepted deal against the bytes and the deals in flight of its clients, unless
+// it goes over the quota of one of them
+func (q *ClientQuotas) Accept(deal storagemarket.MinerDeal) (bool, string, error) {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+
+	keys := dealKeys(deal)
+	usages := make([]*ClientUsage, len(keys))
+	size := uint64(deal.Proposal.PieceSize)
+	for i, key := range keys {
+		usage, err := q.getUsage(key)
+		if err != nil {
+			return false, "miner error", err
+		}
+		if q.cfg.MaxDealsInFlight > 0 && usage.DealsInFlight+1 > q.cfg.MaxDealsInFlight {
+			return false, fmt.Sprintf("client %s has %d deals in flight, the limit is %d", usage.Client, usage.DealsInFlight, q.cfg.MaxDealsInFlight), nil
+		}
+		if q.maxAcceptedBytes > 0 && usage.AcceptedBytes+size > q.maxAcceptedBytes {
+			return false, fmt.Sprintf("client %s has %s of deals accepted, the limit is %s", usage.Client,
+				units.BytesSize(float64(usage.AcceptedBytes)), units.BytesSize(float64(q.maxAcceptedBytes))), nil
+		}
+		usages[i] = usage
+	}
+
+	b, err := q.ds.Batch()
+	if err != nil {
+		return false, "miner error", err
+	}
+	for i, usage := range usages {
+		usage.DealsInFlight++
+		usage.AcceptedBytes += size
+		if err := putUsage(b, keys[i], usage); err != nil {
+			return false, "miner error", err
+		}
+	}
+	data, err := json.Marshal(countedDeal{Client: deal.Proposal.Client, Peer: deal.Client, PieceSize: deal.Proposal.PieceSize})
+	if err != nil {
+		return false, "miner error", err
+	}
+	for _, prefix := range []datastore.Key{inFlightPrefix, acceptedPrefix} {
+		if err := b.Put(prefix.ChildString(deal.ProposalCid.String()), data); err != nil {
+			return false, "miner error", err
+		}
+	}
+	if err := b.Commit(); err != nil {
+		return false, "miner error", err
+	}
+	return true, "", nil
+}
+
+// OnDealEvent releases the deals in flight once they are active or failed, the bytes of a deal are
+// released once it failed, expired or was slashed
+func (q *ClientQuotas) OnDealEvent(_ storagemarket.ProviderEvent, deal storagemarket.MinerDeal) {
+	var ended bool
+	switch deal.State {
+	case storagemarket.StorageDealActive:
+	case storagemarket.StorageDealFailing, storagemarket.StorageDealError, storagemarket.StorageDealRejected,
+		storagemarket.StorageDealSlashed, storagemarket.StorageDealExpired:
+		ended = true
+	default:
+		return
+	}
+
+	q.lk.Lock()
+	defer q.lk.Unlock()
+
+	if err := q.release(deal.ProposalCid.String(), ended); err != nil {
+		log.Errorf("release quota of deal %s: %s", deal.ProposalCid, err)
+	}
+}
+
+func (q *ClientQuotas) getCountedDeal(key datastore.Key) (*countedDeal, error) {
+	data, err := q.ds.Get(key)
+	if err == datastore.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var deal countedDeal
+	if err := json.Unmarshal(data, &deal); err != nil {
+		return nil, xerrors.Errorf("decode %s: %w", key, err)
+	}
+	return &deal, nil
+}
+
+func (q *ClientQuotas) release(propCid string, ended bool) error {
+	inFlightKey, acceptedKey := inFlightPrefix.ChildString(propCid), acceptedPrefix.ChildString(propCid)
+	inFlight, err := q.getCountedDeal(inFlightKey)
+	if err != nil {
+		return err
+	}
+	var accepted *countedDeal
+	if ended {
+		if accepted, err = q.getCountedDeal(acceptedKey); err != nil {
+			return err
+		}
+	}
+	if inFlight == nil && accepted == nil {
+		return nil
+	}
+
+	usages := make(map[datastore.Key]*ClientUsage)
+	usageOf := func(key datastore.Key) (*ClientUsage, error) {
+		if usage, ok := usages[key]; ok {
+			return usage, nil
+		}
+		usage, err := q.getUsage(key)
+		if err != nil {
+			return nil, err
+		}
+		usages[key] = usage
+		return usage, nil
+	}
+
+	b, err := q.ds.Batch()
+	if err != nil {
+		return err
+	}
+	if inFlight != nil {
+		for _, key := range inFlight.keys() {
+			usage, err := usageOf(key)
+			if err != nil {
+				return err
+			}
+			if usage.DealsInFlight > 0 {
+				usage.DealsInFlight--
+			}
+		}
+		if err := b.Delete(inFlightKey); err != nil {
+			return err
+		}
+	}
+	if accepted != nil {
+		for _, key := range accepted.keys() {
+			usage, err := usageOf(key)
+			if err != nil {
+				return err
+			}
+			if usage.AcceptedBytes > uint64(accepted.PieceSize) {
+				usage.AcceptedBytes -= uint64(accepted.PieceSize)
+			} else {
+				usage.AcceptedBytes = 0
+			}
+		}
+		if err := b.Delete(acceptedKey); err != nil {
+			return err
+		}
+	}
+	for key, usage := range usages {
+		if err := putUsage(b, key, usage); err != nil {
+			return err
+		}
+	}
+	return b.Commit()
+}
+
+// Usages lists the counters of every client
+func (q *ClientQuotas) Usages() ([]ClientUsage, error) {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+
+	res, err := q.ds.Query(query.Query{Prefix: usagePrefix.String()})
+	if err != nil {
+		return nil, err
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		return nil, err
+	}
+	usages := make([]ClientUsage, 0, len(entries))
+	for _, entry := range entries {
+		var usage ClientUsage
+		if err := json.Unmarshal(entry.Value, &usage); err != nil {
+			return nil, xerrors.Errorf("decode usage %s: %w", entry.Key, err)
+		}
+		usages = append(usages, usage)
+	}
+	return usages, nil
+}
+
+// Reset clears the counters of a client, given by its address or its peer id. The deals in flight
+// of the client are forgotten as well, they are released from the other client they count for as
+// if they were active, so that they don't come back when they end. The bytes of the deals of the
+// client are only released from the other client once the deals end.
+func (q *ClientQuotas) Reset(client string) error {
+	key, err := clientKey(client)
+	if err != nil {
+		return err
+	}
+
+	q.lk.Lock()
+	defer q.lk.Unlock()
+
+	b, err := q.ds.Batch()
+	if err != nil {
+		return err
+	}
+	others := make(map[datastore.Key]*ClientUsage)
+	for _, prefix := range []datastore.Key{inFlightPrefix, acceptedPrefix} {
+		res, err := q.ds.Query(query.Query{Prefix: prefix.String()})
+		if err != nil {
+			return err
+		}
+		entries, err := res.Rest()
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			var deal countedDeal
+			if err := json.Unmarshal(entry.Value, &deal); err != nil {
+				return xerrors.Errorf("decode deal %s: %w", entry.Key, err)
+			}
+			switch key {
+			case addressKey(deal.Client):
+				deal.Client = address.Undef
+			case peerKey(deal.Peer):
+				deal.Peer = ""
+			default:
+				continue
+			}
+
+			if prefix == acceptedPrefix && len(deal.keys()) > 0 {
+				data, err := json.Marshal(deal)
+				if err != nil {
+					return err
+				}
+				if err := b.Put(datastore.NewKey(entry.Key), data); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := b.Delete(datastore.NewKey(entry.Key)); err != nil {
+				return err
+			}
+			if prefix == acceptedPrefix {
+				continue
+			}
+			for _, other := range deal.keys() {
+				usage, ok := others[other]
+				if !ok {
+					if usage, err = q.getUsage(other); err != nil {
+						return err
+					}
+					others[other] = usage
+				}
+				if usage.DealsInFlight > 0 {
+					usage.DealsInFlight--
+				}
+			}
+		}
+	}
+	for other, usage := range others {
+		if err := putUsage(b, other, usage); err != nil {
+			return err
+		}
+	}
+	if err := b.Delete(key); err != nil {
+		return err
+	}
+	return b.Commit()
+}
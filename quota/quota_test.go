@@ -0,0 +1,131 @@
+package quota
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+
+	"github.com/filecoin-project/venus-market/config"
+)
+
+func TestClientQuotas(t *testing.T) {
+	cfg := &config.MarketConfig{ClientQuota: config.ClientQuota{
+		MaxAcceptedBytes: "2KiB",
+		MaxDealsInFlight: 1,
+		MaxProposals:     2,
+		ProposalWindow:   config.Duration(time.Hour),
+	}}
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	quotas, err := NewClientQuotas(ds, cfg)
+	require.NoError(t, err)
+	now := time.Date(2021, 8, 1, 12, 0, 0, 0, time.UTC)
+	quotas.now = func() time.Time { return now }
+
+	client, err := address.NewIDAddress(1001)
+	require.NoError(t, err)
+	clientPeer, err := peer.Decode("QmYyQSo1c1Ym7orWxLYvCrM2EmxFTANf8wXmmE7DWjhx5N")
+	require.NoError(t, err)
+	newDeal := func(propCid string) storagemarket.MinerDeal {
+		deal := storagemarket.MinerDeal{Client: clientPeer}
+		deal.ProposalCid, err = cid.Decode(propCid)
+		require.NoError(t, err)
+		deal.Proposal.Client = client
+		deal.Proposal.PieceSize = 1 << 10
+		return deal
+	}
+	check := func(accept bool) func(bool, string, error) {
+		return func(ok bool, reason string, err error) {
+			require.NoError(t, err)
+			require.Equal(t, accept, ok, reason)
+			if !accept {
+				require.NotEmpty(t, reason)
+			}
+		}
+	}
+	usageOf := func(client string) ClientUsage {
+		usages, err := quotas.Usages()
+		require.NoError(t, err)
+		for _, usage := range usages {
+			if usage.Client == client {
+				return usage
+			}
+		}
+		return ClientUsage{Client: client}
+	}
+
+	deal1 := newDeal("bafy2bzacea3wsdh6y3a36tb3skempjoxqpuyompjbmfeyf34fi3uy6uue42v4")
+	deal2 := newDeal("bafy2bzacedbz6qrgt6fkdl4fguas6ntdimi5uyxmn5sxkugdshxmdcfukjfk2")
+
+	check(true)(quotas.CheckProposal(deal1))
+	check(true)(quotas.Accept(deal1))
+	// one deal in flight at a time
+	check(true)(quotas.CheckProposal(deal2))
+	check(false)(quotas.Accept(deal2))
+	// two proposals in a window
+	check(false)(quotas.CheckProposal(deal2))
+
+	quotas.OnDealEvent(storagemarket.ProviderEventDealActivated, storagemarket.MinerDeal{ProposalCid: deal1.ProposalCid, State: storagemarket.StorageDealActive})
+	require.Equal(t, uint64(0), usageOf(client.String()).DealsInFlight)
+	require.Equal(t, uint64(1<<10), usageOf(clientPeer.String()).AcceptedBytes)
+
+	now = now.Add(time.Hour)
+	check(true)(quotas.CheckProposal(deal2))
+	check(true)(quotas.Accept(deal2))
+	require.Equal(t, uint64(2<<10), usageOf(client.String()).AcceptedBytes)
+
+	// the bytes of failed deals are released
+	quotas.OnDealEvent(storagemarket.ProviderEventFailed, storagemarket.MinerDeal{ProposalCid: deal2.ProposalCid, State: storagemarket.StorageDealFailing})
+	usage := usageOf(client.String())
+	require.Equal(t, uint64(0), usage.DealsInFlight)
+	require.Equal(t, uint64(1<<10), usage.AcceptedBytes)
+	require.Equal(t, uint64(1), usage.Proposals)
+
+	// the counters persist in the datastore
+	quotas, err = NewClientQuotas(ds, cfg)
+	require.NoError(t, err)
+	quotas.now = func() time.Time { return now }
+	require.Equal(t, usage, usageOf(client.String()))
+
+	require.Error(t, quotas.Reset("not a client"))
+	check(true)(quotas.Accept(deal2))
+	require.NoError(t, quotas.Reset(client.String()))
+	require.Equal(t, ClientUsage{Client: client.String()}, usageOf(client.String()))
+	peerUsage := usageOf(clientPeer.String())
+	require.Equal(t, uint64(0), peerUsage.DealsInFlight)
+	require.Equal(t, uint64(2<<10), peerUsage.AcceptedBytes)
+
+	// the deal in flight at the reset is only released from the other client once it ends
+	quotas.OnDealEvent(storagemarket.ProviderEventFailed, storagemarket.MinerDeal{ProposalCid: deal2.ProposalCid, State: storagemarket.StorageDealFailing})
+	require.Equal(t, ClientUsage{Client: client.String()}, usageOf(client.String()))
+	peerUsage.AcceptedBytes = 1 << 10
+	require.Equal(t, peerUsage, usageOf(clientPeer.String()))
+
+	// the bytes of an active deal are released once it expires
+	deal3 := newDeal("bafy2bzaceaxm23epjsmh75yvzcecsrbavlmkcxnva66bkdebdcnyw3bjrc74u")
+	check(true)(quotas.Accept(deal3))
+	quotas.OnDealEvent(storagemarket.ProviderEventDealActivated, storagemarket.MinerDeal{ProposalCid: deal3.ProposalCid, State: storagemarket.StorageDealActive})
+	require.Equal(t, ClientUsage{Client: client.String(), AcceptedBytes: 1 << 10}, usageOf(client.String()))
+	require.Equal(t, uint64(2<<10), usageOf(clientPeer.String()).AcceptedBytes)
+
+	quotas.OnDealEvent(storagemarket.ProviderEventDealExpired, storagemarket.MinerDeal{ProposalCid: deal3.ProposalCid, State: storagemarket.StorageDealExpired})
+	require.Equal(t, ClientUsage{Client: client.String()}, usageOf(client.String()))
+	require.Equal(t, uint64(1<<10), usageOf(clientPeer.String()).AcceptedBytes)
+	// an ended deal is released once
+	quotas.OnDealEvent(storagemarket.ProviderEventDealExpired, storagemarket.MinerDeal{ProposalCid: deal3.ProposalCid, State: storagemarket.StorageDealExpired})
+	require.Equal(t, uint64(1<<10), usageOf(clientPeer.String()).AcceptedBytes)
+
+	// the deal active before the reset still counts for the other client until it is slashed
+	quotas.OnDealEvent(storagemarket.ProviderEventDealSlashed, storagemarket.MinerDeal{ProposalCid: deal1.ProposalCid, State: storagemarket.StorageDealSlashed})
+	require.Equal(t, ClientUsage{Client: client.String()}, usageOf(client.String()))
+	peerUsage = usageOf(clientPeer.String())
+	require.Equal(t, uint64(0), peerUsage.AcceptedBytes)
+	require.Equal(t, uint64(0), peerUsage.DealsInFlight)
+}
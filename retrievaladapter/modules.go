@@ -3,10 +3,8 @@ package retrievaladapter
 import (
 	"context"
 	"github.com/filecoin-project/go-address"
-	"github.com/filecoin-project/go-fil-markets/piecestore"
 	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
 	retrievalimpl "github.com/filecoin-project/go-fil-markets/retrievalmarket/impl"
-	rmnet "github.com/filecoin-project/go-fil-markets/retrievalmarket/network"
 	"github.com/filecoin-project/venus-market/builder"
 	"github.com/filecoin-project/venus-market/config"
 	"github.com/filecoin-project/venus-market/dagstore"
@@ -14,31 +12,65 @@ import (
 	"github.com/filecoin-project/venus-market/journal"
 	"github.com/filecoin-project/venus-market/models"
 	"github.com/filecoin-project/venus-market/network"
+	"github.com/filecoin-project/venus-market/piece"
+	"github.com/filecoin-project/venus-market/sealer"
 	types2 "github.com/filecoin-project/venus-market/types"
 	"github.com/filecoin-project/venus-market/utils"
 	"github.com/libp2p/go-libp2p-core/host"
 	"go.uber.org/fx"
+	"golang.org/x/xerrors"
 )
 
 var (
 	HandleRetrievalKey builder.Invoke = builder.NextInvoke()
 )
 
-// RetrievalProvider creates a new retrieval provider attached to the provider blockstore
-func RetrievalProvider(
-	maddr types2.MinerAddress,
+// RetrievalProviders holds the retrieval provider of each miner served by the market
+type RetrievalProviders map[address.Address]retrievalmarket.RetrievalProvider
+
+// Get returns the retrieval provider of mAddr
+func (providers RetrievalProviders) Get(mAddr address.Address) (retrievalmarket.RetrievalProvider, error) {
+	provider, ok := providers[mAddr]
+	if !ok {
+		return nil, xerrors.Errorf("get retrieval provider of %s: %w", mAddr, types2.ErrMinerNotFound)
+	}
+	return provider, nil
+}
+
+// NewRetrievalProviders creates a retrieval provider for every miner, they are attached to the provider blockstore
+func NewRetrievalProviders(
+	h host.Host,
+	miners types2.MinerAddresses,
 	adapter retrievalmarket.RetrievalProviderNode,
-	netwk rmnet.RetrievalMarketNetwork,
 	retrievalProviderDs models.RetrievalProviderDS,
-	sa retrievalmarket.SectorAccessor,
-	pieceStore piecestore.PieceStore,
+	sas sealer.SectorAccessors,
+	pieceStores piece.PieceStores,
 	dagStore *dagstore.Wrapper,
-	dt network.ProviderDataTransfer,
+	transferMux *network.TransferMux,
 	pricingFnc config.RetrievalPricingFunc,
 	userFilter config.RetrievalDealFilter,
-) (retrievalmarket.RetrievalProvider, error) {
+) (RetrievalProviders, error) {
+	net := newRetrievalNetworkMux(h, miners, pieceStores)
 	opt := retrievalimpl.DealDeciderOpt(retrievalimpl.DealDecider(userFilter))
-	return retrievalimpl.NewProvider(address.Address(maddr), adapter, sa, netwk, pieceStore, dagStore, dt, retrievalProviderDs, retrievalimpl.RetrievalPricingFunc(pricingFnc), opt)
+
+	providers := make(RetrievalProviders, len(miners))
+	for _, mAddr := range miners {
+		sa, err := sas.Get(mAddr)
+		if err != nil {
+			return nil, err
+		}
+		pieceStore, err := pieceStores.Get(mAddr)
+		if err != nil {
+			return nil, err
+		}
+
+		provider, err := retrievalimpl.NewProvider(mAddr, adapter, sa, net.ForMiner(mAddr), pieceStore, dagStore, transferMux.ForMiner(mAddr), models.MinerNamespace(retrievalProviderDs, mAddr), retrievalimpl.RetrievalPricingFunc(pricingFnc), opt)
+		if err != nil {
+			return nil, xerrors.Errorf("create retrieval provider of %s: %w", mAddr, err)
+		}
+		providers[mAddr] = provider
+	}
+	return providers, nil
 }
 
 func RetrievalDealFilter(userFilter config.RetrievalDealFilter) func(onlineOk config.ConsiderOnlineRetrievalDealsConfigFunc,
@@ -74,25 +106,50 @@ func RetrievalDealFilter(userFilter config.RetrievalDealFilter) func(onlineOk co
 	}
 }
 
-func HandleRetrieval(host host.Host,
-	lc fx.Lifecycle,
-	m retrievalmarket.RetrievalProvider,
+// RuleRetrievalDealFilter checks retrieval deals against the rules, after the command filter if set
+func RuleRetrievalDealFilter(cmd string) func(rules *RetrievalDealRuleFilter,
+	onlineOk config.ConsiderOnlineRetrievalDealsConfigFunc,
+	offlineOk config.ConsiderOfflineRetrievalDealsConfigFunc) config.RetrievalDealFilter {
+	return func(rules *RetrievalDealRuleFilter,
+		onlineOk config.ConsiderOnlineRetrievalDealsConfigFunc,
+		offlineOk config.ConsiderOfflineRetrievalDealsConfigFunc) config.RetrievalDealFilter {
+
+		user := rules.Filter
+		if cmd != "" {
+			cli := dealfilter.CliRetrievalDealFilter(cmd)
+			user = func(ctx context.Context, state retrievalmarket.ProviderDealState) (bool, string, error) {
+				ok, reason, err := cli(ctx, state)
+				if err != nil || !ok {
+					return ok, reason, err
+				}
+				return rules.Filter(ctx, state)
+			}
+		}
+		return RetrievalDealFilter(user)(onlineOk, offlineOk)
+	}
+}
+
+func HandleRetrieval(lc fx.Lifecycle,
+	miners types2.MinerAddresses,
+	providers RetrievalProviders,
 	j journal.Journal,
 ) {
-	m.OnReady(utils.ReadyLogger("retrieval provider"))
-	lc.Append(fx.Hook{
-		OnStart: func(ctx context.Context) error {
-			m.SubscribeToEvents(utils.RetrievalProviderLogger)
-
-			evtType := j.RegisterEventType("markets/retrieval/provider", "state_change")
-			m.SubscribeToEvents(utils.RetrievalProviderJournaler(j, evtType))
-
-			return m.Start(ctx)
-		},
-		OnStop: func(context.Context) error {
-			return m.Stop()
-		},
-	})
+	evtType := j.RegisterEventType("markets/retrieval/provider", "state_change")
+	for _, mAddr := range miners {
+		m := providers[mAddr]
+		m.OnReady(utils.ReadyLogger("retrieval provider " + mAddr.String()))
+		lc.Append(fx.Hook{
+			OnStart: func(ctx context.Context) error {
+				m.SubscribeToEvents(utils.RetrievalProviderLogger)
+				m.SubscribeToEvents(utils.RetrievalProviderJournaler(j, evtType))
+
+				return m.Start(ctx)
+			},
+			OnStop: func(context.Context) error {
+				return m.Stop()
+			},
+		})
+	}
 }
 
 // RetrievalPricingFunc configures the pricing function to use for retrieval deals.
@@ -109,24 +166,28 @@ func RetrievalPricingFunc(cfg *config.MarketConfig) func(_ config.ConsiderOnline
 	}
 }
 
-func RetrievalNetwork(h host.Host) rmnet.RetrievalMarketNetwork {
-	return rmnet.NewFromLibp2pHost(h)
-}
-
 var RetrievalProviderOpts = func(cfg *config.MarketConfig) builder.Option {
 	return builder.Options(
-
-		builder.Override(new(rmnet.RetrievalMarketNetwork), RetrievalNetwork),
 		// Markets (retrieval deps)
 		builder.Override(new(config.RetrievalPricingFunc), RetrievalPricingFunc(cfg)),
 		// Markets (retrieval)
 		builder.Override(new(retrievalmarket.RetrievalProviderNode), NewRetrievalProviderNode),
-		builder.Override(new(rmnet.RetrievalMarketNetwork), RetrievalNetwork),
-		builder.Override(new(retrievalmarket.RetrievalProvider), RetrievalProvider), //save to metadata /retrievals/provider
+		builder.Override(new(RetrievalProviders), NewRetrievalProviders), //save to metadata /retrievals/provider/<miner>
 		builder.Override(new(config.RetrievalDealFilter), RetrievalDealFilter(nil)),
 		builder.Override(HandleRetrievalKey, HandleRetrieval),
 		builder.If(cfg.RetrievalFilter != "",
 			builder.Override(new(config.RetrievalDealFilter), RetrievalDealFilter(dealfilter.CliRetrievalDealFilter(cfg.RetrievalFilter))),
 		),
+		builder.If(cfg.RetrievalDealRules != "",
+			builder.Override(new(*RetrievalDealRuleFilter), NewRetrievalDealRuleFilter(cfg.RetrievalDealRules)),
+			builder.Override(new(retrievalmarket.RetrievalProviderNode), RuleRetrievalProviderNode),
+			builder.Override(new(config.RetrievalDealFilter), RuleRetrievalDealFilter(cfg.RetrievalFilter)),
+			builder.Override(TrackRetrievalRulesKey, TrackRetrievalRules),
+		),
+		builder.If(cfg.RetrievalPricing.Strategy == config.RetrievalPricingDynamicMode && cfg.RetrievalPricing.Dynamic != nil,
+			builder.Override(new(*DynamicPricer), NewDynamicPricer(cfg.RetrievalPricing.Dynamic.Path)),
+			builder.Override(new(config.RetrievalPricingFunc), DynamicPricingFunc),
+			builder.Override(TrackRetrievalsKey, TrackRetrievals),
+		),
 	)
 }
@@ -0,0 +1,153 @@
+package retrievaladapter
+
+import (
+	"sync"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	rmnet "github.com/filecoin-project/go-fil-markets/retrievalmarket/network"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/host"
+
+	"github.com/filecoin-project/venus-market/piece"
+	types2 "github.com/filecoin-project/venus-market/types"
+)
+
+// retrievalNetworkMux shares the retrieval query protocol of the libp2p host between the
+// retrieval providers of every miner. A query doesn't name the miner, so it is handed to the
+// first miner which has a deal for the piece holding the payload.
+type retrievalNetworkMux struct {
+	rmnet.RetrievalMarketNetwork
+
+	miners      types2.MinerAddresses
+	pieceStores piece.PieceStores
+
+	lk        sync.RWMutex
+	receivers map[address.Address]rmnet.RetrievalReceiver
+}
+
+func newRetrievalNetworkMux(h host.Host, miners types2.MinerAddresses, pieceStores piece.PieceStores) *retrievalNetworkMux {
+	return &retrievalNetworkMux{
+		RetrievalMarketNetwork: rmnet.NewFromLibp2pHost(h),
+		miners:                 miners,
+		pieceStores:            pieceStores,
+		receivers:              make(map[address.Address]rmnet.RetrievalReceiver),
+	}
+}
+
+// ForMiner returns the network to hand to the retrieval provider of mAddr
+func (mux *retrievalNetworkMux) ForMiner(mAddr address.Address) rmnet.RetrievalMarketNetwork {
+	return &minerRetrievalNetwork{RetrievalMarketNetwork: mux.RetrievalMarketNetwork, mux: mux, miner: mAddr}
+}
+
+func (mux *retrievalNetworkMux) setReceiver(mAddr address.Address, r rmnet.RetrievalReceiver) error {
+	mux.lk.Lock()
+	defer mux.lk.Unlock()
+
+	if len(mux.receivers) == 0 {
+		if err := mux.RetrievalMarketNetwork.SetDelegate(mux); err != nil {
+			return err
+		}
+	}
+	mux.receivers[mAddr] = r
+	return nil
+}
+
+func (mux *retrievalNetworkMux) removeReceiver(mAddr address.Address) error {
+	mux.lk.Lock()
+	defer mux.lk.Unlock()
+
+	if _, ok := mux.receivers[mAddr]; !ok {
+		return nil
+	}
+	delete(mux.receivers, mAddr)
+	if len(mux.receivers) == 0 {
+		return mux.RetrievalMarketNetwork.StopHandlingRequests()
+	}
+	return nil
+}
+
+func (mux *retrievalNetworkMux) HandleQueryStream(s rmnet.RetrievalQueryStream) {
+	query, err := s.ReadQuery()
+	if err != nil {
+		log.Errorf("failed to read query from incoming stream: %s", err)
+		_ = s.Close()
+		return
+	}
+
+	mAddr := mux.minerOfQuery(query)
+
+	mux.lk.RLock()
+	r, ok := mux.receivers[mAddr]
+	mux.lk.RUnlock()
+	if !ok {
+		log.Warnf("no retrieval provider of miner %s to answer query for %s", mAddr, query.PayloadCID)
+		_ = s.Close()
+		return
+	}
+	r.HandleQueryStream(&queryStream{RetrievalQueryStream: s, query: query})
+}
+
+// minerOfQuery returns the first miner which has a deal for the payload, or the first miner
+// if none of them has, its provider will answer the query as unavailable
+func (mux *retrievalNetworkMux) minerOfQuery(query retrievalmarket.Query) address.Address {
+	for _, pieceCid := range mux.piecesOfPayload(query) {
+		for _, mAddr := range mux.miners {
+			ps, err := mux.pieceStores.Get(mAddr)
+			if err != nil {
+				continue
+			}
+			pieceInfo, err := ps.GetPieceInfo(pieceCid)
+			if err == nil && len(pieceInfo.Deals) > 0 {
+				return mAddr
+			}
+		}
+	}
+	return mux.miners[0]
+}
+
+func (mux *retrievalNetworkMux) piecesOfPayload(query retrievalmarket.Query) []cid.Cid {
+	if query.PieceCID != nil {
+		return []cid.Cid{*query.PieceCID}
+	}
+
+	// cid infos are shared by the piece stores of all miners
+	ps, err := mux.pieceStores.Get(mux.miners[0])
+	if err != nil {
+		return nil
+	}
+	cidInfo, err := ps.GetCIDInfo(query.PayloadCID)
+	if err != nil {
+		return nil
+	}
+	pieces := make([]cid.Cid, 0, len(cidInfo.PieceBlockLocations))
+	for _, loc := range cidInfo.PieceBlockLocations {
+		pieces = append(pieces, loc.PieceCID)
+	}
+	return pieces
+}
+
+// minerRetrievalNetwork is the view of the shared network given to the retrieval provider of one miner
+type minerRetrievalNetwork struct {
+	rmnet.RetrievalMarketNetwork
+	mux   *retrievalNetworkMux
+	miner address.Address
+}
+
+func (n *minerRetrievalNetwork) SetDelegate(r rmnet.RetrievalReceiver) error {
+	return n.mux.setReceiver(n.miner, r)
+}
+
+func (n *minerRetrievalNetwork) StopHandlingRequests() error {
+	return n.mux.removeReceiver(n.miner)
+}
+
+// queryStream replays the query read by the mux
+type queryStream struct {
+	rmnet.RetrievalQueryStream
+	query retrievalmarket.Query
+}
+
+func (s *queryStream) ReadQuery() (retrievalmarket.Query, error) {
+	return s.query, nil
+}
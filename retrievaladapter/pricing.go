@@ -0,0 +1,321 @@
+package retrievaladapter
+
+import (
+	"context"
+	"io/ioutil"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/mitchellh/go-homedir"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/venus/pkg/types"
+
+	"github.com/filecoin-project/venus-market/builder"
+	"github.com/filecoin-project/venus-market/config"
+	"github.com/filecoin-project/venus-market/piece"
+	"github.com/filecoin-project/venus-market/sealer"
+)
+
+var TrackRetrievalsKey builder.Invoke = builder.NextInvoke()
+
+// PayloadPrice overrides the prices of the retrievals of some payloads
+type PayloadPrice struct {
+	Payloads     []string
+	PricePerByte string
+	UnsealPrice  string
+}
+
+// ClientDiscount takes Percent off the prices of the retrievals of some clients, by peer id
+type ClientDiscount struct {
+	Clients []string
+	Percent uint64
+}
+
+// DynamicPricingRules is the content of the pricing file of the dynamic retrieval pricing, prices
+// are in FIL or attoFIL, such as "0.0000000001 FIL", empty prices keep the ask of the provider
+type DynamicPricingRules struct {
+	PricePerByte string
+	// charged only if no sector of the piece is unsealed
+	UnsealPrice string
+	// 0 keeps the ask of the provider
+	PaymentInterval         uint64
+	PaymentIntervalIncrease uint64
+	// transfer is free if the payload belongs to a verified deal
+	VerifiedDealsFreeTransfer bool
+
+	// the price per byte is raised by SurgePercent for every SurgeInFlight retrievals in flight, up
+	// to SurgeMaxPercent, 0 for no limit. No surge if SurgeInFlight is 0
+	SurgeInFlight   uint64
+	SurgePercent    uint64
+	SurgeMaxPercent uint64
+
+	Payload []PayloadPrice
+	Client  []ClientDiscount
+}
+
+type payloadPrice struct {
+	pricePerByte, unsealPrice abi.TokenAmount
+}
+
+// compiledPricingRules is DynamicPricingRules with the values parsed
+type compiledPricingRules struct {
+	raw       DynamicPricingRules
+	base      payloadPrice
+	payloads  map[cid.Cid]payloadPrice
+	discounts map[peer.ID]uint64
+}
+
+// DynamicPricer prices retrievals by the rules of a toml file, the file can be reloaded while the
+// market is running
+type DynamicPricer struct {
+	path            string
+	pieceStores     piece.PieceStores
+	sectorAccessors sealer.SectorAccessors
+
+	lk    sync.Mutex
+	rules *compiledPricingRules
+	// retrievals which haven't ended, of every miner
+	inFlight map[retrievalmarket.ProviderDealIdentifier]struct{}
+}
+
+func NewDynamicPricer(path string) func(pieceStores piece.PieceStores, sas sealer.SectorAccessors) (*DynamicPricer, error) {
+	return func(pieceStores piece.PieceStores, sas sealer.SectorAccessors) (*DynamicPricer, error) {
+		path, err := homedir.Expand(path)
+		if err != nil {
+			return nil, err
+		}
+		p := &DynamicPricer{
+			path:            path,
+			pieceStores:     pieceStores,
+			sectorAccessors: sas,
+			inFlight:        make(map[retrievalmarket.ProviderDealIdentifier]struct{}),
+		}
+		if err := p.Reload(); err != nil {
+			return nil, err
+		}
+		return p, nil
+	}
+}
+
+// DynamicPricingFunc is the pricing function of the retrieval providers when the strategy is dynamic
+func DynamicPricingFunc(p *DynamicPricer) config.RetrievalPricingFunc {
+	return p.Price
+}
+
+// TrackRetrievals counts the retrievals in flight of every miner for the surge pricing
+func TrackRetrievals(providers RetrievalProviders, p *DynamicPricer) {
+	for _, provider := range providers {
+		provider.SubscribeToEvents(p.onRetrievalEvent)
+	}
+}
+
+// Reload reads the pricing file again, the current rules are kept if the file is invalid
+func (p *DynamicPricer) Reload() error {
+	data, err := ioutil.ReadFile(p.path)
+	if err != nil {
+		return xerrors.Errorf("read retrieval pricing rules: %w", err)
+	}
+	var raw DynamicPricingRules
+	if _, err := toml.Decode(string(data), &raw); err != nil {
+		return xerrors.Errorf("decode retrieval pricing rules %s: %w", p.path, err)
+	}
+	rules, err := compilePricingRules(raw)
+	if err != nil {
+		return xerrors.Errorf("retrieval pricing rules %s: %w", p.path, err)
+	}
+
+	p.lk.Lock()
+	p.rules = rules
+	p.lk.Unlock()
+	log.Infof("loaded retrieval pricing rules from %s", p.path)
+	return nil
+}
+
+// Rules returns the rules in use
+func (p *DynamicPricer) Rules() DynamicPricingRules {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	return p.rules.raw
+}
+
+// Price is a config.RetrievalPricingFunc
+func (p *DynamicPricer) Price(ctx context.Context, input retrievalmarket.PricingInput) (retrievalmarket.Ask, error) {
+	unsealed := input.Unsealed
+	if !unsealed {
+		unsealed = pieceUnsealed(ctx, p.pieceStores, p.sectorAccessors, input.PieceCID)
+	}
+
+	p.lk.Lock()
+	rules := p.rules
+	inFlight := uint64(len(p.inFlight))
+	p.lk.Unlock()
+
+	ask := input.CurrentAsk
+	price, ok := rules.payloads[input.PayloadCID]
+	if !ok {
+		price = rules.base
+	}
+	if !price.pricePerByte.Nil() {
+		ask.PricePerByte = price.pricePerByte
+	}
+	if !price.unsealPrice.Nil() {
+		ask.UnsealPrice = price.unsealPrice
+	}
+	if rules.raw.PaymentInterval > 0 {
+		ask.PaymentInterval = rules.raw.PaymentInterval
+	}
+	if rules.raw.PaymentIntervalIncrease > 0 {
+		ask.PaymentIntervalIncrease = rules.raw.PaymentIntervalIncrease
+	}
+
+	if unsealed {
+		ask.UnsealPrice = big.Zero()
+	}
+	if input.VerifiedDeal && rules.raw.VerifiedDealsFreeTransfer {
+		ask.PricePerByte = big.Zero()
+	}
+	if surge := rules.surgePercent(inFlight); surge > 0 {
+		ask.PricePerByte = percentOf(ask.PricePerByte, 100+surge)
+	}
+	if discount, ok := rules.discounts[input.Client]; ok {
+		ask.PricePerByte = percentOf(ask.PricePerByte, 100-discount)
+		ask.UnsealPrice = percentOf(ask.UnsealPrice, 100-discount)
+	}
+	return ask, nil
+}
+
+// pieceUnsealed reports whether a sealed deal of the piece, of any miner, has an unsealed copy
+func pieceUnsealed(ctx context.Context, pieceStores piece.PieceStores, sas sealer.SectorAccessors, pieceCid cid.Cid) bool {
+	for mAddr, ps := range pieceStores {
+		deals, err := ps.GetPieceDeals(pieceCid)
+		if err != nil {
+			log.Warnf("get deals of piece %s: %s", pieceCid, err)
+			continue
+		}
+		sa, err := sas.Get(mAddr)
+		if err != nil {
+			continue
+		}
+		for _, deal := range deals {
+			if deal.Status != piece.Proving {
+				continue
+			}
+			unsealed, err := sa.IsUnsealed(ctx, deal.SectorID, deal.Offset.Unpadded(), deal.Length.Unpadded())
+			if err != nil {
+				log.Warnf("check unsealed copy of piece %s in sector %d of %s: %s", pieceCid, deal.SectorID, mAddr, err)
+				continue
+			}
+			if unsealed {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (p *DynamicPricer) onRetrievalEvent(_ retrievalmarket.ProviderEvent, state retrievalmarket.ProviderDealState) {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	if retrievalmarket.IsTerminalStatus(state.Status) {
+		delete(p.inFlight, state.Identifier())
+		return
+	}
+	p.inFlight[state.Identifier()] = struct{}{}
+}
+
+func (rules *compiledPricingRules) surgePercent(inFlight uint64) uint64 {
+	if rules.raw.SurgeInFlight == 0 {
+		return 0
+	}
+	surge := inFlight / rules.raw.SurgeInFlight * rules.raw.SurgePercent
+	if rules.raw.SurgeMaxPercent > 0 && surge > rules.raw.SurgeMaxPercent {
+		surge = rules.raw.SurgeMaxPercent
+	}
+	return surge
+}
+
+func percentOf(amount abi.TokenAmount, percent uint64) abi.TokenAmount {
+	return big.Div(big.Mul(amount, big.NewInt(int64(percent))), big.NewInt(100))
+}
+
+func compilePricingRules(raw DynamicPricingRules) (*compiledPricingRules, error) {
+	rules := &compiledPricingRules{
+		raw:       raw,
+		payloads:  make(map[cid.Cid]payloadPrice),
+		discounts: make(map[peer.ID]uint64),
+	}
+
+	var err error
+	if rules.base, err = parsePayloadPrice(raw.PricePerByte, raw.UnsealPrice); err != nil {
+		return nil, err
+	}
+	for _, rule := range raw.Payload {
+		price, err := parsePayloadPrice(rule.PricePerByte, rule.UnsealPrice)
+		if err != nil {
+			return nil, xerrors.Errorf("price of payloads %v: %w", rule.Payloads, err)
+		}
+		// unset prices of a payload fall back to the base prices
+		if price.pricePerByte.Nil() {
+			price.pricePerByte = rules.base.pricePerByte
+		}
+		if price.unsealPrice.Nil() {
+			price.unsealPrice = rules.base.unsealPrice
+		}
+		for _, payload := range rule.Payloads {
+			c, err := cid.Decode(payload)
+			if err != nil {
+				return nil, xerrors.Errorf("invalid payload %s: %w", payload, err)
+			}
+			if _, ok := rules.payloads[c]; ok {
+				return nil, xerrors.Errorf("payload %s is in several prices", payload)
+			}
+			rules.payloads[c] = price
+		}
+	}
+
+	for _, rule := range raw.Client {
+		if rule.Percent > 100 {
+			return nil, xerrors.Errorf("discount of clients %v is above 100%%", rule.Clients)
+		}
+		for _, client := range rule.Clients {
+			id, err := peer.Decode(client)
+			if err != nil {
+				return nil, xerrors.Errorf("invalid client %s: %w", client, err)
+			}
+			if _, ok := rules.discounts[id]; ok {
+				return nil, xerrors.Errorf("client %s is in several discounts", client)
+			}
+			rules.discounts[id] = rule.Percent
+		}
+	}
+	return rules, nil
+}
+
+func parsePayloadPrice(pricePerByte, unsealPrice string) (payloadPrice, error) {
+	var price payloadPrice
+	for _, p := range []struct {
+		name string
+		raw  string
+		out  *abi.TokenAmount
+	}{
+		{"PricePerByte", pricePerByte, &price.pricePerByte},
+		{"UnsealPrice", unsealPrice, &price.unsealPrice},
+	} {
+		if len(p.raw) == 0 {
+			continue
+		}
+		v, err := types.ParseFIL(p.raw)
+		if err != nil {
+			return price, xerrors.Errorf("invalid %s %s: %w", p.name, p.raw, err)
+		}
+		*p.out = abi.TokenAmount(v)
+	}
+	return price, nil
+}
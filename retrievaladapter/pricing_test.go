@@ -0,0 +1,148 @@
+package retrievaladapter
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/venus/pkg/types/specactors/builtin/market"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	ds_sync "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/venus-market/config"
+	"github.com/filecoin-project/venus-market/piece"
+	"github.com/filecoin-project/venus-market/sealer"
+)
+
+type unsealedSectorAccessor struct {
+	retrievalmarket.SectorAccessor
+	unsealed bool
+}
+
+func (sa *unsealedSectorAccessor) IsUnsealed(ctx context.Context, sectorID abi.SectorNumber, offset abi.UnpaddedPieceSize, length abi.UnpaddedPieceSize) (bool, error) {
+	return sa.unsealed, nil
+}
+
+const testPricingRules = `
+PricePerByte = "10 attofil"
+UnsealPrice = "1000 attofil"
+VerifiedDealsFreeTransfer = true
+SurgeInFlight = 2
+SurgePercent = 50
+SurgeMaxPercent = 100
+
+[[Payload]]
+  Payloads = ["%s"]
+  PricePerByte = "20 attofil"
+
+[[Client]]
+  Clients = ["%s"]
+  Percent = 10
+`
+
+func TestDynamicPricer(t *testing.T) {
+	ctx := context.Background()
+	pieceStorages, err := piece.NewPieceStorageManager(ctx, &config.MarketConfig{
+		PieceStorages: []config.PieceStorageConfig{{Path: config.PieceStorageString("fs:" + t.TempDir())}},
+	})
+	require.NoError(t, err)
+	ps, err := piece.NewDsPieceStore(ds_sync.MutexWrap(datastore.NewMapDatastore()), 2048, pieceStorages)
+	require.NoError(t, err)
+	mAddr, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	sa := &unsealedSectorAccessor{}
+
+	newCid := func(data string) cid.Cid {
+		mh, err := multihash.Sum([]byte(data), multihash.IDENTITY, -1)
+		require.NoError(t, err)
+		return cid.NewCidV1(cid.Raw, mh)
+	}
+	pieceCid, payload1, payload2 := newCid("piece"), newCid("payload1"), newCid("payload2")
+	mh, err := multihash.Sum([]byte("client"), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	client := peer.ID(mh)
+
+	proposal := market.ClientDealProposal{
+		Proposal: market.DealProposal{
+			PieceCID:             pieceCid,
+			PieceSize:            512,
+			StoragePricePerEpoch: abi.NewTokenAmount(1),
+			ProviderCollateral:   abi.NewTokenAmount(0),
+			ClientCollateral:     abi.NewTokenAmount(0),
+		},
+	}
+	require.NoError(t, ps.UpdateDealOnComplete(pieceCid, proposal, &storagemarket.DataRef{Root: payload1}, pieceCid, 1, false))
+	require.NoError(t, ps.UpdateDealStatus(1, piece.Proving))
+
+	path := filepath.Join(t.TempDir(), "pricing.toml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(fmt.Sprintf(testPricingRules, payload2, client)), 0644))
+	p, err := NewDynamicPricer(path)(piece.PieceStores{mAddr: struct {
+		piece.PieceStore
+		piece.CIDStore
+	}{ps, nil}}, sealer.SectorAccessors{mAddr: sa})
+	require.NoError(t, err)
+
+	currentAsk := retrievalmarket.Ask{
+		PricePerByte:            abi.NewTokenAmount(1),
+		UnsealPrice:             abi.NewTokenAmount(1),
+		PaymentInterval:         1 << 20,
+		PaymentIntervalIncrease: 1 << 20,
+	}
+	price := func(payload cid.Cid, client peer.ID, verified bool) retrievalmarket.Ask {
+		ask, err := p.Price(ctx, retrievalmarket.PricingInput{
+			PayloadCID:   payload,
+			PieceCID:     pieceCid,
+			Client:       client,
+			VerifiedDeal: verified,
+			CurrentAsk:   currentAsk,
+		})
+		require.NoError(t, err)
+		return ask
+	}
+	requirePrices := func(ask retrievalmarket.Ask, pricePerByte, unsealPrice int64) {
+		require.Equal(t, abi.NewTokenAmount(pricePerByte), ask.PricePerByte)
+		require.Equal(t, abi.NewTokenAmount(unsealPrice), ask.UnsealPrice)
+	}
+
+	ask := price(payload1, "", false)
+	requirePrices(ask, 10, 1000)
+	require.Equal(t, uint64(1<<20), ask.PaymentInterval)
+	requirePrices(price(payload2, "", false), 20, 1000)
+	requirePrices(price(payload1, client, false), 9, 900)
+	requirePrices(price(payload1, "", true), 0, 1000)
+
+	// no unseal price once the piece has an unsealed copy
+	sa.unsealed = true
+	requirePrices(price(payload1, "", false), 10, 0)
+	sa.unsealed = false
+
+	// 2 retrievals in flight raise the price per byte by 50%
+	retrieval := func(id retrievalmarket.DealID, status retrievalmarket.DealStatus) {
+		state := retrievalmarket.ProviderDealState{Status: status, Receiver: client}
+		state.ID = id
+		p.onRetrievalEvent(retrievalmarket.ProviderEventOpen, state)
+	}
+	retrieval(1, retrievalmarket.DealStatusOngoing)
+	retrieval(2, retrievalmarket.DealStatusOngoing)
+	requirePrices(price(payload1, "", false), 15, 1000)
+	retrieval(2, retrievalmarket.DealStatusCompleted)
+	requirePrices(price(payload1, "", false), 10, 1000)
+
+	// an invalid file keeps the rules in use
+	require.NoError(t, ioutil.WriteFile(path, []byte(`PricePerByte = "5 attofil"`), 0644))
+	require.NoError(t, p.Reload())
+	requirePrices(price(payload2, "", false), 5, 1)
+	require.NoError(t, ioutil.WriteFile(path, []byte(`PricePerByte = "cheap"`), 0644))
+	require.Error(t, p.Reload())
+	require.Equal(t, "5 attofil", p.Rules().PricePerByte)
+}
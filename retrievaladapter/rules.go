@@ -0,0 +1,379 @@
+package retrievaladapter
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/mitchellh/go-homedir"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	"github.com/filecoin-project/go-fil-markets/shared"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/venus/app/client/apiface"
+	paych3 "github.com/filecoin-project/venus/app/submodule/paych"
+	"github.com/filecoin-project/venus/pkg/types"
+	"github.com/filecoin-project/venus/pkg/types/specactors/adt"
+	"github.com/filecoin-project/venus/pkg/types/specactors/builtin/paych"
+
+	"github.com/filecoin-project/venus-market/blockstore"
+	"github.com/filecoin-project/venus-market/builder"
+	"github.com/filecoin-project/venus-market/piece"
+	"github.com/filecoin-project/venus-market/sealer"
+)
+
+var TrackRetrievalRulesKey builder.Invoke = builder.NextInvoke()
+
+// RetrievalDealRules is the content of the rule file of the retrieval deal filter, clients are
+// given by their peer id or by their address
+type RetrievalDealRules struct {
+	// only retrievals of these peers are accepted if set
+	AllowPeers []string
+	DenyPeers  []string
+	// client addresses, checked against the clients of the storage deals of the piece retrieved
+	// and against the wallet paying the retrieval. only these clients are served if set
+	AllowClients []string
+	DenyClients  []string
+	// payloads never served
+	DenyPayloads []string
+	// retrievals in flight of a peer, 0 for no limit
+	MaxRetrievalsPerPeer uint64
+	// refuse the retrievals of pieces without an unsealed copy
+	DenyUnseal bool
+}
+
+// compiledRetrievalRules is RetrievalDealRules with the values parsed
+type compiledRetrievalRules struct {
+	raw          RetrievalDealRules
+	allow        map[peer.ID]struct{}
+	deny         map[peer.ID]struct{}
+	allowClients map[address.Address]struct{}
+	denyClients  map[address.Address]struct{}
+	denyPayloads map[cid.Cid]struct{}
+}
+
+// clientAPI resolves the client addresses and reads the payment channels
+type clientAPI interface {
+	StateGetActor(ctx context.Context, actor address.Address, tsk types.TipSetKey) (*types.Actor, error)
+	StateAccountKey(ctx context.Context, addr address.Address, tsk types.TipSetKey) (address.Address, error)
+	ChainReadObj(context.Context, cid.Cid) ([]byte, error)
+	ChainHasObj(context.Context, cid.Cid) (bool, error)
+}
+
+// RetrievalDealRuleFilter checks retrieval deals against the rules of a toml file, the file can be
+// reloaded while the market is running
+type RetrievalDealRuleFilter struct {
+	path            string
+	pieceStores     piece.PieceStores
+	sectorAccessors sealer.SectorAccessors
+	api             clientAPI
+	// payerOf returns the wallet paying into a payment channel
+	payerOf func(ctx context.Context, paymentChannel address.Address) (address.Address, error)
+
+	lk    sync.Mutex
+	rules *compiledRetrievalRules
+	// retrievals which haven't ended of each peer, of every miner
+	inFlight map[peer.ID]map[retrievalmarket.ProviderDealIdentifier]struct{}
+}
+
+func NewRetrievalDealRuleFilter(path string) func(pieceStores piece.PieceStores, sas sealer.SectorAccessors, full apiface.FullNode) (*RetrievalDealRuleFilter, error) {
+	return func(pieceStores piece.PieceStores, sas sealer.SectorAccessors, full apiface.FullNode) (*RetrievalDealRuleFilter, error) {
+		path, err := homedir.Expand(path)
+		if err != nil {
+			return nil, err
+		}
+		f := &RetrievalDealRuleFilter{
+			path:            path,
+			pieceStores:     pieceStores,
+			sectorAccessors: sas,
+			api:             full,
+			inFlight:        make(map[peer.ID]map[retrievalmarket.ProviderDealIdentifier]struct{}),
+		}
+		f.payerOf = f.paymentChannelFrom
+		if err := f.Reload(); err != nil {
+			return nil, err
+		}
+		return f, nil
+	}
+}
+
+// TrackRetrievalRules counts the retrievals in flight of every peer for MaxRetrievalsPerPeer
+func TrackRetrievalRules(providers RetrievalProviders, f *RetrievalDealRuleFilter) {
+	for _, provider := range providers {
+		provider.SubscribeToEvents(f.onRetrievalEvent)
+	}
+}
+
+// Reload reads the rule file again, the current rules are kept if the file is invalid
+func (f *RetrievalDealRuleFilter) Reload() error {
+	data, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return xerrors.Errorf("read retrieval deal rules: %w", err)
+	}
+	var raw RetrievalDealRules
+	if _, err := toml.Decode(string(data), &raw); err != nil {
+		return xerrors.Errorf("decode retrieval deal rules %s: %w", f.path, err)
+	}
+	rules, err := compileRetrievalRules(raw)
+	if err != nil {
+		return xerrors.Errorf("retrieval deal rules %s: %w", f.path, err)
+	}
+
+	f.lk.Lock()
+	f.rules = rules
+	f.lk.Unlock()
+	log.Infof("loaded retrieval deal rules from %s", f.path)
+	return nil
+}
+
+// Rules returns the rules in use
+func (f *RetrievalDealRuleFilter) Rules() RetrievalDealRules {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+	return f.rules.raw
+}
+
+// Filter is a config.RetrievalDealFilter
+func (f *RetrievalDealRuleFilter) Filter(ctx context.Context, state retrievalmarket.ProviderDealState) (bool, string, error) {
+	f.lk.Lock()
+	rules := f.rules
+	// the deal decided may already be recorded as open, it doesn't count against itself
+	var inFlight uint64
+	for id := range f.inFlight[state.Receiver] {
+		if id != state.Identifier() {
+			inFlight++
+		}
+	}
+	f.lk.Unlock()
+
+	client := state.Receiver
+	if _, ok := rules.deny[client]; ok {
+		return false, fmt.Sprintf("peer %s is denied", client), nil
+	}
+	if len(rules.allow) > 0 {
+		if _, ok := rules.allow[client]; !ok {
+			return false, fmt.Sprintf("peer %s is not in the allowed peers", client), nil
+		}
+	}
+	if _, ok := rules.denyPayloads[state.PayloadCID]; ok {
+		return false, fmt.Sprintf("payload %s is not served", state.PayloadCID), nil
+	}
+	if limit := rules.raw.MaxRetrievalsPerPeer; limit > 0 && inFlight >= limit {
+		return false, fmt.Sprintf("peer %s reached the limit of %d retrievals in flight", client, limit), nil
+	}
+
+	if !rules.checkClients() && !rules.raw.DenyUnseal {
+		return true, "", nil
+	}
+	var pieceCid cid.Cid
+	switch {
+	case state.PieceInfo != nil:
+		pieceCid = state.PieceInfo.PieceCID
+	case state.PieceCID != nil:
+		pieceCid = *state.PieceCID
+	default:
+		return false, fmt.Sprintf("piece of payload %s is unknown", state.PayloadCID), nil
+	}
+
+	if rules.checkClients() {
+		reason, err := f.checkDealClients(ctx, rules, pieceCid)
+		if err != nil || reason != "" {
+			return false, reason, err
+		}
+	}
+	if rules.raw.DenyUnseal {
+		if !pieceUnsealed(ctx, f.pieceStores, f.sectorAccessors, pieceCid) {
+			return false, fmt.Sprintf("piece %s has no unsealed copy and unsealing is refused", pieceCid), nil
+		}
+	}
+	return true, "", nil
+}
+
+// checkDealClients refuses the pieces stored by a denied client, or by none of the allowed clients
+func (f *RetrievalDealRuleFilter) checkDealClients(ctx context.Context, rules *compiledRetrievalRules, pieceCid cid.Cid) (string, error) {
+	allowed := false
+	for mAddr, ps := range f.pieceStores {
+		deals, err := ps.GetPieceDeals(pieceCid)
+		if err != nil {
+			return "", xerrors.Errorf("get deals of piece %s of %s: %w", pieceCid, mAddr, err)
+		}
+		for _, deal := range deals {
+			client := deal.Proposal.Client
+			denied, err := f.clientIn(ctx, client, rules.denyClients)
+			if err != nil {
+				return "", err
+			}
+			if denied {
+				return fmt.Sprintf("client %s is denied", client), nil
+			}
+			ok, err := f.clientIn(ctx, client, rules.allowClients)
+			if err != nil {
+				return "", err
+			}
+			allowed = allowed || ok
+		}
+	}
+	if len(rules.allowClients) > 0 && !allowed {
+		return fmt.Sprintf("no client of piece %s is in the allowed clients", pieceCid), nil
+	}
+	return "", nil
+}
+
+// CheckPayer refuses the payments of a wallet denied by the rules, or not in the allowed clients
+func (f *RetrievalDealRuleFilter) CheckPayer(ctx context.Context, paymentChannel address.Address) error {
+	f.lk.Lock()
+	rules := f.rules
+	f.lk.Unlock()
+	if !rules.checkClients() {
+		return nil
+	}
+
+	payer, err := f.payerOf(ctx, paymentChannel)
+	if err != nil {
+		return xerrors.Errorf("get payer of payment channel %s: %w", paymentChannel, err)
+	}
+	denied, err := f.clientIn(ctx, payer, rules.denyClients)
+	if err != nil {
+		return err
+	}
+	if denied {
+		return xerrors.Errorf("client %s is denied", payer)
+	}
+	if len(rules.allowClients) > 0 {
+		ok, err := f.clientIn(ctx, payer, rules.allowClients)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return xerrors.Errorf("client %s is not in the allowed clients", payer)
+		}
+	}
+	return nil
+}
+
+// clientIn reports whether the address, or the key address of an id address, is in the set
+func (f *RetrievalDealRuleFilter) clientIn(ctx context.Context, addr address.Address, set map[address.Address]struct{}) (bool, error) {
+	if len(set) == 0 {
+		return false, nil
+	}
+	if _, ok := set[addr]; ok {
+		return true, nil
+	}
+	if addr.Protocol() != address.ID {
+		return false, nil
+	}
+	key, err := f.api.StateAccountKey(ctx, addr, types.EmptyTSK)
+	if err != nil {
+		return false, xerrors.Errorf("resolve client %s: %w", addr, err)
+	}
+	_, ok := set[key]
+	return ok, nil
+}
+
+func (f *RetrievalDealRuleFilter) paymentChannelFrom(ctx context.Context, paymentChannel address.Address) (address.Address, error) {
+	act, err := f.api.StateGetActor(ctx, paymentChannel, types.EmptyTSK)
+	if err != nil {
+		return address.Undef, err
+	}
+	st, err := paych.Load(adt.WrapStore(ctx, cbor.NewCborStore(blockstore.NewAPIBlockstore(f.api))), act)
+	if err != nil {
+		return address.Undef, err
+	}
+	return st.From()
+}
+
+func (f *RetrievalDealRuleFilter) onRetrievalEvent(_ retrievalmarket.ProviderEvent, state retrievalmarket.ProviderDealState) {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+
+	deals, ok := f.inFlight[state.Receiver]
+	if retrievalmarket.IsTerminalStatus(state.Status) {
+		if ok {
+			delete(deals, state.Identifier())
+			if len(deals) == 0 {
+				delete(f.inFlight, state.Receiver)
+			}
+		}
+		return
+	}
+	if !ok {
+		deals = make(map[retrievalmarket.ProviderDealIdentifier]struct{})
+		f.inFlight[state.Receiver] = deals
+	}
+	deals[state.Identifier()] = struct{}{}
+}
+
+func compileRetrievalRules(raw RetrievalDealRules) (*compiledRetrievalRules, error) {
+	rules := &compiledRetrievalRules{
+		raw:          raw,
+		allow:        make(map[peer.ID]struct{}),
+		deny:         make(map[peer.ID]struct{}),
+		allowClients: make(map[address.Address]struct{}),
+		denyClients:  make(map[address.Address]struct{}),
+		denyPayloads: make(map[cid.Cid]struct{}),
+	}
+
+	for _, list := range []struct {
+		peers []string
+		set   map[peer.ID]struct{}
+	}{{raw.AllowPeers, rules.allow}, {raw.DenyPeers, rules.deny}} {
+		for _, p := range list.peers {
+			id, err := peer.Decode(p)
+			if err != nil {
+				return nil, xerrors.Errorf("invalid peer %s: %w", p, err)
+			}
+			list.set[id] = struct{}{}
+		}
+	}
+	for _, list := range []struct {
+		clients []string
+		set     map[address.Address]struct{}
+	}{{raw.AllowClients, rules.allowClients}, {raw.DenyClients, rules.denyClients}} {
+		for _, c := range list.clients {
+			addr, err := address.NewFromString(c)
+			if err != nil {
+				return nil, xerrors.Errorf("invalid client %s: %w", c, err)
+			}
+			list.set[addr] = struct{}{}
+		}
+	}
+	for _, payload := range raw.DenyPayloads {
+		c, err := cid.Decode(payload)
+		if err != nil {
+			return nil, xerrors.Errorf("invalid payload %s: %w", payload, err)
+		}
+		rules.denyPayloads[c] = struct{}{}
+	}
+	return rules, nil
+}
+
+func (rules *compiledRetrievalRules) checkClients() bool {
+	return len(rules.allowClients) > 0 || len(rules.denyClients) > 0
+}
+
+// ruleProviderNode refuses the vouchers of the clients the rules don't serve
+type ruleProviderNode struct {
+	retrievalmarket.RetrievalProviderNode
+	rules *RetrievalDealRuleFilter
+}
+
+// RuleRetrievalProviderNode is the retrieval provider node checking the wallet paying a retrieval
+// against the client rules
+func RuleRetrievalProviderNode(full apiface.FullNode, payAPI *paych3.PaychAPI, rules *RetrievalDealRuleFilter) retrievalmarket.RetrievalProviderNode {
+	return &ruleProviderNode{RetrievalProviderNode: NewRetrievalProviderNode(full, payAPI), rules: rules}
+}
+
+func (n *ruleProviderNode) SavePaymentVoucher(ctx context.Context, paymentChannel address.Address, voucher *paych.SignedVoucher, proof []byte, expectedAmount abi.TokenAmount, tok shared.TipSetToken) (abi.TokenAmount, error) {
+	if err := n.rules.CheckPayer(ctx, paymentChannel); err != nil {
+		return abi.NewTokenAmount(0), err
+	}
+	return n.RetrievalProviderNode.SavePaymentVoucher(ctx, paymentChannel, voucher, proof, expectedAmount, tok)
+}
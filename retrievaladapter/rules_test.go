@@ -0,0 +1,234 @@
+package retrievaladapter
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-fil-markets/piecestore"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/venus/pkg/types"
+	"github.com/filecoin-project/venus/pkg/types/specactors/builtin/market"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	ds_sync "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/venus-market/config"
+	"github.com/filecoin-project/venus-market/piece"
+	"github.com/filecoin-project/venus-market/sealer"
+)
+
+const testRetrievalRules = `
+AllowPeers = ["%s", "%s"]
+DenyPeers = ["%s"]
+DenyPayloads = ["%s"]
+MaxRetrievalsPerPeer = 2
+DenyUnseal = true
+`
+
+func TestRetrievalDealRuleFilter(t *testing.T) {
+	ctx := context.Background()
+	pieceStorages, err := piece.NewPieceStorageManager(ctx, &config.MarketConfig{
+		PieceStorages: []config.PieceStorageConfig{{Path: config.PieceStorageString("fs:" + t.TempDir())}},
+	})
+	require.NoError(t, err)
+	ps, err := piece.NewDsPieceStore(ds_sync.MutexWrap(datastore.NewMapDatastore()), 2048, pieceStorages)
+	require.NoError(t, err)
+	mAddr, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	sa := &unsealedSectorAccessor{unsealed: true}
+
+	newCid := func(data string) cid.Cid {
+		mh, err := multihash.Sum([]byte(data), multihash.IDENTITY, -1)
+		require.NoError(t, err)
+		return cid.NewCidV1(cid.Raw, mh)
+	}
+	newPeer := func(data string) peer.ID {
+		mh, err := multihash.Sum([]byte(data), multihash.SHA2_256, -1)
+		require.NoError(t, err)
+		return peer.ID(mh)
+	}
+	pieceCid, payload, blocked := newCid("piece"), newCid("payload"), newCid("blocked")
+	peer1, peer2, peer3 := newPeer("peer1"), newPeer("peer2"), newPeer("peer3")
+
+	proposal := market.ClientDealProposal{
+		Proposal: market.DealProposal{
+			PieceCID:             pieceCid,
+			PieceSize:            512,
+			StoragePricePerEpoch: abi.NewTokenAmount(1),
+			ProviderCollateral:   abi.NewTokenAmount(0),
+			ClientCollateral:     abi.NewTokenAmount(0),
+		},
+	}
+	require.NoError(t, ps.UpdateDealOnComplete(pieceCid, proposal, &storagemarket.DataRef{Root: payload}, pieceCid, 1, false))
+	require.NoError(t, ps.UpdateDealStatus(1, piece.Proving))
+
+	path := filepath.Join(t.TempDir(), "rules.toml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(fmt.Sprintf(testRetrievalRules, peer1, peer2, peer2, blocked)), 0644))
+	f, err := NewRetrievalDealRuleFilter(path)(piece.PieceStores{mAddr: struct {
+		piece.PieceStore
+		piece.CIDStore
+	}{ps, nil}}, sealer.SectorAccessors{mAddr: sa}, nil)
+	require.NoError(t, err)
+
+	newState := func(id retrievalmarket.DealID, receiver peer.ID, payload cid.Cid) retrievalmarket.ProviderDealState {
+		state := retrievalmarket.ProviderDealState{
+			Receiver:  receiver,
+			PieceInfo: &piecestore.PieceInfo{PieceCID: pieceCid},
+		}
+		state.ID = id
+		state.PayloadCID = payload
+		return state
+	}
+	check := func(state retrievalmarket.ProviderDealState, reason string) {
+		ok, msg, err := f.Filter(ctx, state)
+		require.NoError(t, err)
+		require.Equal(t, reason == "", ok, msg)
+		require.Equal(t, reason, msg)
+	}
+
+	check(newState(1, peer1, payload), "")
+	check(newState(1, peer2, payload), fmt.Sprintf("peer %s is denied", peer2))
+	check(newState(1, peer3, payload), fmt.Sprintf("peer %s is not in the allowed peers", peer3))
+	check(newState(1, peer1, blocked), fmt.Sprintf("payload %s is not served", blocked))
+
+	sa.unsealed = false
+	check(newState(1, peer1, payload), fmt.Sprintf("piece %s has no unsealed copy and unsealing is refused", pieceCid))
+	sa.unsealed = true
+
+	// peer1 has 2 retrievals in flight, the next one waits for one of them to end
+	for id, status := range []retrievalmarket.DealStatus{retrievalmarket.DealStatusOngoing, retrievalmarket.DealStatusOngoing} {
+		state := newState(retrievalmarket.DealID(id+1), peer1, payload)
+		state.Status = status
+		f.onRetrievalEvent(retrievalmarket.ProviderEventOpen, state)
+	}
+	check(newState(3, peer1, payload), fmt.Sprintf("peer %s reached the limit of 2 retrievals in flight", peer1))
+	state := newState(1, peer1, payload)
+	state.Status = retrievalmarket.DealStatusCompleted
+	f.onRetrievalEvent(retrievalmarket.ProviderEventComplete, state)
+	check(newState(3, peer1, payload), "")
+
+	// the deal decided is already open when the filter runs, it doesn't count against itself
+	state = newState(3, peer1, payload)
+	state.Status = retrievalmarket.DealStatusNew
+	f.onRetrievalEvent(retrievalmarket.ProviderEventOpen, state)
+	check(state, "")
+	state = newState(4, peer1, payload)
+	state.Status = retrievalmarket.DealStatusNew
+	f.onRetrievalEvent(retrievalmarket.ProviderEventOpen, state)
+	check(state, fmt.Sprintf("peer %s reached the limit of 2 retrievals in flight", peer1))
+
+	// an invalid file keeps the rules in use
+	require.NoError(t, ioutil.WriteFile(path, []byte(`DenyPeers = ["nobody"]`), 0644))
+	require.Error(t, f.Reload())
+	require.Equal(t, uint64(2), f.Rules().MaxRetrievalsPerPeer)
+	require.NoError(t, ioutil.WriteFile(path, []byte(`DenyUnseal = true`), 0644))
+	require.NoError(t, f.Reload())
+	check(newState(1, peer3, payload), "")
+}
+
+const testClientRules = `
+AllowClients = ["%s", "%s"]
+DenyClients = ["%s"]
+`
+
+func TestRetrievalClientRules(t *testing.T) {
+	ctx := context.Background()
+	pieceStorages, err := piece.NewPieceStorageManager(ctx, &config.MarketConfig{
+		PieceStorages: []config.PieceStorageConfig{{Path: config.PieceStorageString("fs:" + t.TempDir())}},
+	})
+	require.NoError(t, err)
+	ps, err := piece.NewDsPieceStore(ds_sync.MutexWrap(datastore.NewMapDatastore()), 2048, pieceStorages)
+	require.NoError(t, err)
+
+	newAddr := func(id uint64) address.Address {
+		addr, err := address.NewIDAddress(id)
+		require.NoError(t, err)
+		return addr
+	}
+	newCid := func(data string) cid.Cid {
+		mh, err := multihash.Sum([]byte(data), multihash.IDENTITY, -1)
+		require.NoError(t, err)
+		return cid.NewCidV1(cid.Raw, mh)
+	}
+	mAddr := newAddr(1000)
+	allowed, keyAllowed, denied, other := newAddr(1001), newAddr(1002), newAddr(1003), newAddr(1004)
+	key, err := address.NewSecp256k1Address([]byte("client key"))
+	require.NoError(t, err)
+	api := &clientKeyAPI{keys: map[address.Address]address.Address{keyAllowed: key}}
+
+	// a piece of each client
+	pieces := make(map[address.Address]cid.Cid)
+	for i, client := range []address.Address{allowed, keyAllowed, denied, other} {
+		pieceCid := newCid(client.String())
+		proposal := market.ClientDealProposal{
+			Proposal: market.DealProposal{
+				PieceCID:             pieceCid,
+				PieceSize:            512,
+				Client:               client,
+				StoragePricePerEpoch: abi.NewTokenAmount(1),
+				ProviderCollateral:   abi.NewTokenAmount(0),
+				ClientCollateral:     abi.NewTokenAmount(0),
+			},
+		}
+		dealID := abi.DealID(i + 1)
+		require.NoError(t, ps.UpdateDealOnComplete(pieceCid, proposal, &storagemarket.DataRef{Root: pieceCid}, pieceCid, dealID, false))
+		require.NoError(t, ps.UpdateDealStatus(dealID, piece.Proving))
+		pieces[client] = pieceCid
+	}
+
+	path := filepath.Join(t.TempDir(), "rules.toml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(fmt.Sprintf(testClientRules, allowed, key, denied)), 0644))
+	f, err := NewRetrievalDealRuleFilter(path)(piece.PieceStores{mAddr: struct {
+		piece.PieceStore
+		piece.CIDStore
+	}{ps, nil}}, sealer.SectorAccessors{}, nil)
+	require.NoError(t, err)
+	f.api = api
+	f.payerOf = func(_ context.Context, paymentChannel address.Address) (address.Address, error) {
+		// the channels of the test are paid by the client of the same id plus 100
+		id, err := address.IDFromAddress(paymentChannel)
+		require.NoError(t, err)
+		return newAddr(id - 100), nil
+	}
+
+	check := func(client address.Address, reason string) {
+		state := retrievalmarket.ProviderDealState{PieceInfo: &piecestore.PieceInfo{PieceCID: pieces[client]}}
+		ok, msg, err := f.Filter(ctx, state)
+		require.NoError(t, err)
+		require.Equal(t, reason == "", ok, msg)
+		require.Equal(t, reason, msg)
+	}
+	// the clients of the storage deals
+	check(allowed, "")
+	check(keyAllowed, "")
+	check(denied, fmt.Sprintf("client %s is denied", denied))
+	check(other, fmt.Sprintf("no client of piece %s is in the allowed clients", pieces[other]))
+
+	// the wallets paying the retrievals
+	require.NoError(t, f.CheckPayer(ctx, newAddr(1101)))
+	require.NoError(t, f.CheckPayer(ctx, newAddr(1102)))
+	require.EqualError(t, f.CheckPayer(ctx, newAddr(1103)), fmt.Sprintf("client %s is denied", denied))
+	require.EqualError(t, f.CheckPayer(ctx, newAddr(1104)), fmt.Sprintf("client %s is not in the allowed clients", other))
+}
+
+// clientKeyAPI resolves the id addresses of the clients to their key
+type clientKeyAPI struct {
+	clientAPI
+	keys map[address.Address]address.Address
+}
+
+func (a *clientKeyAPI) StateAccountKey(_ context.Context, addr address.Address, _ types.TipSetKey) (address.Address, error) {
+	if key, ok := a.keys[addr]; ok {
+		return key, nil
+	}
+	return addr, nil
+}
@@ -22,12 +22,42 @@ import (
 
 var log = logging.Logger("modules")
 
-func ServeRPC(ctx context.Context, home config.IHome, cfg *config.API, api interface{}, shutdownCh <-chan struct{}, maxRequestSize int64, authUrl string) error {
+// ServeRPC serves the api and the handlers behind the token check, the public handlers are served
+// without a token on the same listener
+func ServeRPC(ctx context.Context, home config.IHome, cfg *config.API, api interface{}, handlers, publicHandlers map[string]http.Handler, shutdownCh <-chan struct{}, maxRequestSize int64, authUrl string) error {
 	seckey, err := makeSecet(home, cfg)
 	if err != nil {
 		return err
 
 	}
+	srv := &http.Server{Handler: newHandler(seckey, api, handlers, publicHandlers, maxRequestSize, authUrl)}
+
+	go func() {
+		select {
+		case <-shutdownCh:
+		case <-ctx.Done():
+		}
+		log.Warn("Shutting down...")
+		if err := srv.Shutdown(context.TODO()); err != nil {
+			log.Errorf("shutting down RPC server failed: %s", err)
+		}
+		log.Warn("Graceful shutdown successful")
+	}()
+
+	addr, err := multiaddr.NewMultiaddr(cfg.ListenAddress)
+	if err != nil {
+		return err
+	}
+
+	nl, err := manet.Listen(addr)
+	if err != nil {
+		return err
+	}
+	log.Infof("start rpc listen %s", addr)
+	return srv.Serve(manet.NetListener(nl))
+}
+
+func newHandler(seckey []byte, api interface{}, handlers, publicHandlers map[string]http.Handler, maxRequestSize int64, authUrl string) http.Handler {
 	serverOptions := make([]jsonrpc.ServerOption, 0)
 	if maxRequestSize != 0 { // config set
 		serverOptions = append(serverOptions, jsonrpc.WithMaxRequestSize(maxRequestSize))
@@ -38,6 +68,9 @@ func ServeRPC(ctx context.Context, home config.IHome, cfg *config.API, api inter
 
 	mux := mux.NewRouter()
 	mux.Handle("/rpc/v0", rpcServer)
+	for prefix, handler := range handlers {
+		mux.PathPrefix(prefix).Handler(handler)
+	}
 	mux.PathPrefix("/").Handler(http.DefaultServeMux)
 
 	var handler http.Handler
@@ -51,31 +84,17 @@ func ServeRPC(ctx context.Context, home config.IHome, cfg *config.API, api inter
 			&localJwtClient{seckey: seckey}, nil,
 			mux, logging.Logger("auth"))
 	}
-	srv := &http.Server{Handler: handler}
-
-	go func() {
-		select {
-		case <-shutdownCh:
-		case <-ctx.Done():
-		}
-		log.Warn("Shutting down...")
-		if err := srv.Shutdown(context.TODO()); err != nil {
-			log.Errorf("shutting down RPC server failed: %s", err)
-		}
-		log.Warn("Graceful shutdown successful")
-	}()
-
-	addr, err := multiaddr.NewMultiaddr(cfg.ListenAddress)
-	if err != nil {
-		return err
+	if len(publicHandlers) == 0 {
+		return handler
 	}
 
-	nl, err := manet.Listen(addr)
-	if err != nil {
-		return err
+	// the public routes are matched before the token check
+	public := http.NewServeMux()
+	for prefix, h := range publicHandlers {
+		public.Handle(prefix, h)
 	}
-	log.Infof("start rpc listen %s", addr)
-	return srv.Serve(manet.NetListener(nl))
+	public.Handle("/", handler)
+	return public
 }
 
 func makeSecet(cfg config.IHome, api *config.API) ([]byte, error) {
@@ -0,0 +1,37 @@
+package rpc
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type emptyAPI struct{}
+
+func TestPublicHandlers(t *testing.T) {
+	seckey, _, err := MakeToken()
+	require.NoError(t, err)
+
+	reply := func(body string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(body))
+		})
+	}
+	srv := httptest.NewServer(newHandler(seckey, &emptyAPI{},
+		map[string]http.Handler{"/private/": reply("private")},
+		map[string]http.Handler{"/public/v0/": reply("public")},
+		0, ""))
+	defer srv.Close()
+
+	// the public routes are served without a token
+	resp, err := http.Get(srv.URL + "/public/v0/head")
+	require.NoError(t, err)
+	defer resp.Body.Close() //nolint:errcheck
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "public", string(body))
+}
@@ -5,12 +5,14 @@ import (
 	"github.com/filecoin-project/dagstore"
 	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	clients2 "github.com/filecoin-project/venus-market/api/clients"
 	"github.com/filecoin-project/venus-market/builder"
 	"github.com/filecoin-project/venus-market/config"
 	dagstore2 "github.com/filecoin-project/venus-market/dagstore"
+	"github.com/filecoin-project/venus-market/journal"
+	"github.com/filecoin-project/venus-market/piece"
 	"github.com/filecoin-project/venus-market/types"
 	"github.com/filecoin-project/venus/app/client/apiface"
-	types2 "github.com/filecoin-project/venus/pkg/types"
 	"go.uber.org/fx"
 	"golang.org/x/xerrors"
 	"os"
@@ -22,20 +24,54 @@ var (
 	DAGStoreKey = builder.Special{ID: 1}
 )
 
-func MinerAddress(cfg *config.MarketConfig) (types.MinerAddress, error) {
-	addr, err := address.NewFromString(cfg.MinerAddress)
-	if err != nil {
-		return types.MinerAddress{}, err
+// MinerAddresses returns the miners served by the market, MinerAddress of old config files comes first
+func MinerAddresses(cfg *config.MarketConfig) (types.MinerAddresses, error) {
+	var addrs types.MinerAddresses
+	for _, str := range append([]string{cfg.MinerAddress}, cfg.Miners...) {
+		if len(str) == 0 {
+			continue
+		}
+		addr, err := address.NewFromString(str)
+		if err != nil {
+			return nil, xerrors.Errorf("parse miner address %s: %w", str, err)
+		}
+		if !addrs.Has(addr) {
+			addrs = append(addrs, addr)
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, xerrors.Errorf("no miner configured")
 	}
-	return types.MinerAddress(addr), nil
+	return addrs, nil
 }
 
-func MinerSectorSize(minerAddr types.MinerAddress, node apiface.FullNode) (types.SectorSize, error) {
-	minerInfo, err := node.StateMinerInfo(context.TODO(), address.Address(minerAddr), types2.EmptyTSK)
-	if err != nil {
-		return types.SectorSize(0), err
+// SectorAccessors holds the sector accessor of each miner served by the market
+type SectorAccessors map[address.Address]retrievalmarket.SectorAccessor
+
+// Get returns the sector accessor of mAddr
+func (sas SectorAccessors) Get(mAddr address.Address) (retrievalmarket.SectorAccessor, error) {
+	sa, ok := sas[mAddr]
+	if !ok {
+		return nil, xerrors.Errorf("get sector accessor of %s: %w", mAddr, types.ErrMinerNotFound)
+	}
+	return sa, nil
+}
+
+func NewSectorAccessors(miners types.MinerAddresses,
+	minerapi clients2.MarketRequestEvent,
+	pieceStores piece.PieceStores,
+	planner *LocationPlanner,
+	full apiface.FullNode) (SectorAccessors, error) {
+	sas := make(SectorAccessors, len(miners))
+	for _, mAddr := range miners {
+		ps, err := pieceStores.Get(mAddr)
+		if err != nil {
+			return nil, err
+		}
+		pp := NewPieceProvider(ps, planner)
+		sas[mAddr] = NewSectorAccessor(types.MinerAddress(mAddr), minerapi, pp, full)
 	}
-	return types.SectorSize(minerInfo.SectorSize), nil
+	return sas, nil
 }
 
 func NewAddressSelector(cfg *config.MarketConfig) (*AddressSelector, error) {
@@ -44,10 +80,10 @@ func NewAddressSelector(cfg *config.MarketConfig) (*AddressSelector, error) {
 	}, nil
 }
 
-// DAGStore constructs a DAG store using the supplied minerAPI, and the
-// user configuration. It returns both the DAGStore and the Wrapper suitable for
-// passing to markets.
-func NewDAGStore(lc fx.Lifecycle, homeDir *config.HomeDir, cfg *config.DAGStoreConfig, minerAPI dagstore2.MinerAPI) (*dagstore.DAGStore, *dagstore2.Wrapper, error) {
+// DAGStore constructs a DAG store using the supplied minerAPI, the piece
+// storages and the user configuration. It returns the DAGStore, the Wrapper suitable for
+// passing to markets and the health checker of its shards.
+func NewDAGStore(lc fx.Lifecycle, homeDir *config.HomeDir, cfg *config.DAGStoreConfig, minerAPI dagstore2.MinerAPI, pieceStorages *piece.PieceStorageManager, topIndex *piece.TopLevelIndex, j journal.Journal) (*dagstore.DAGStore, *dagstore2.Wrapper, *dagstore2.HealthChecker, error) {
 	// fall back to default root directory if not explicitly set in the config.
 	if cfg.RootDir == "" {
 		cfg.RootDir = filepath.Join(string(*homeDir), DefaultDAGStoreDir)
@@ -61,10 +97,11 @@ func NewDAGStore(lc fx.Lifecycle, homeDir *config.HomeDir, cfg *config.DAGStoreC
 		}
 	}
 
-	dagst, w, err := dagstore2.NewDAGStore(cfg, minerAPI)
+	dagst, w, err := dagstore2.NewDAGStore(cfg, minerAPI, pieceStorages, topIndex)
 	if err != nil {
-		return nil, nil, xerrors.Errorf("failed to create DAG store: %w", err)
+		return nil, nil, nil, xerrors.Errorf("failed to create DAG store: %w", err)
 	}
+	health := dagstore2.NewHealthChecker(cfg.Health, w, j)
 
 	lc.Append(fx.Hook{
 		OnStart: func(ctx context.Context) error {
@@ -75,16 +112,16 @@ func NewDAGStore(lc fx.Lifecycle, homeDir *config.HomeDir, cfg *config.DAGStoreC
 		},
 	})
 
-	return dagst, w, nil
+	return dagst, w, health, nil
 }
 
 var SealerOpts = builder.Options(
 	//sealer service
-	builder.Override(new(types.MinerAddress), MinerAddress), //todo miner single miner todo change to support multiple miner
-	builder.Override(new(types.SectorSize), MinerSectorSize),
-	builder.Override(new(PieceProvider), NewPieceProvider),
+	builder.Override(new(types.MinerAddresses), MinerAddresses),
 	builder.Override(new(*AddressSelector), NewAddressSelector),
 	builder.Override(new(dagstore2.MinerAPI), NewMinerAPI),
-	builder.Override(new(retrievalmarket.SectorAccessor), NewSectorAccessor),
+	builder.Override(new(*UnsealManager), NewUnsealManager),
+	builder.Override(new(*LocationPlanner), NewLocationPlanner),
+	builder.Override(new(SectorAccessors), NewSectorAccessors),
 	builder.Override(DAGStoreKey, NewDAGStore),
 )
@@ -0,0 +1,236 @@
+package sealer
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+	"github.com/filecoin-project/venus/app/client/apiface"
+	types2 "github.com/ipfs-force-community/venus-common-utils/types"
+
+	clients2 "github.com/filecoin-project/venus-market/api/clients"
+	"github.com/filecoin-project/venus-market/config"
+	"github.com/filecoin-project/venus-market/piece"
+)
+
+// kinds of the copies of a piece
+const (
+	PieceCopyStorage   = "piece-storage"
+	PieceCopyTransient = "dagstore-transient"
+	PieceCopyUnsealed  = "unsealed-sector"
+	PieceCopySealed    = "sealed-sector"
+)
+
+// cost of reading a piece from each kind of copy, an unsealed sector is copied to a piece storage
+// by the sealer and a sealed one is unsealed first
+var pieceCopyCosts = map[string]int{
+	PieceCopyStorage:   0,
+	PieceCopyTransient: 1,
+	PieceCopyUnsealed:  10,
+	PieceCopySealed:    100,
+}
+
+// a piece storage on an object store costs more than a local one
+const remoteStorageCost = 2
+
+// PieceCopy is a place a piece can be read from
+type PieceCopy struct {
+	Kind string
+	Cost int
+	// piece storage name or transient file path
+	Path string
+	// miner and sector of the sector copies
+	Miner  address.Address
+	Sector abi.SectorNumber
+
+	loc  *piece.PieceStorageLocation
+	deal *piece.DealInfo
+}
+
+// LocationPlanner finds every copy of a piece: the piece storages, the transients of the dagstore
+// and the sectors of every miner, and reads the piece from the cheapest one. A sector is only
+// unsealed when no unsealed copy can be read.
+type LocationPlanner struct {
+	pieceStores   piece.PieceStores
+	pieceStorages *piece.PieceStorageManager
+	minerAPI      clients2.MarketRequestEvent
+	unseals       *UnsealManager
+	full          apiface.FullNode
+	transientsDir string
+}
+
+func NewLocationPlanner(homeDir *config.HomeDir,
+	cfg *config.DAGStoreConfig,
+	pieceStores piece.PieceStores,
+	pieceStorages *piece.PieceStorageManager,
+	minerAPI clients2.MarketRequestEvent,
+	unseals *UnsealManager,
+	full apiface.FullNode) *LocationPlanner {
+	// same fall back as the DAG store
+	rootDir := cfg.RootDir
+	if rootDir == "" {
+		rootDir = filepath.Join(string(*homeDir), DefaultDAGStoreDir)
+	}
+	return &LocationPlanner{
+		pieceStores:   pieceStores,
+		pieceStorages: pieceStorages,
+		minerAPI:      minerAPI,
+		unseals:       unseals,
+		full:          full,
+		transientsDir: filepath.Join(rootDir, "transients"),
+	}
+}
+
+// Plan returns the copies of the piece, cheapest first
+func (p *LocationPlanner) Plan(ctx context.Context, pieceCid cid.Cid) ([]PieceCopy, error) {
+	var copies []PieceCopy
+	for _, loc := range p.pieceStorages.FindStoragesForRead(pieceCid.String()) {
+		cost := pieceCopyCosts[PieceCopyStorage]
+		if strings.HasPrefix(loc.Path, "s3:") {
+			cost += remoteStorageCost
+		}
+		copies = append(copies, PieceCopy{Kind: PieceCopyStorage, Cost: cost, Path: loc.Name, loc: loc})
+	}
+	// a piece storage copy is always cheaper than a sector, asking the sealer for the unsealed
+	// copies of the sectors is a waste then
+	checkSectors := len(copies) == 0
+
+	// unpadded size of the piece, a transient of another size is incomplete or broken
+	var pieceSize abi.UnpaddedPieceSize
+	for mAddr, ps := range p.pieceStores {
+		deals, err := ps.GetPieceDeals(pieceCid)
+		if err != nil {
+			log.Warnf("get deals of piece %s of %s: %s", pieceCid, mAddr, err)
+			continue
+		}
+		for _, deal := range deals {
+			pieceSize = deal.Proposal.PieceSize.Unpadded()
+			if deal.Status != piece.Proving {
+				continue
+			}
+			kind := PieceCopySealed
+			if checkSectors {
+				unsealed, err := p.isUnsealed(ctx, mAddr, deal)
+				if err != nil {
+					log.Warnf("check unsealed copy of piece %s in sector %d of %s: %s", pieceCid, deal.SectorID, mAddr, err)
+				} else if unsealed {
+					kind = PieceCopyUnsealed
+				}
+			}
+			copies = append(copies, PieceCopy{Kind: kind, Cost: pieceCopyCosts[kind], Miner: mAddr, Sector: deal.SectorID, deal: deal})
+		}
+	}
+
+	transients, err := filepath.Glob(filepath.Join(p.transientsDir, "*"+pieceCid.String()+"*"))
+	if err != nil {
+		return nil, xerrors.Errorf("find dagstore transients: %w", err)
+	}
+	for _, path := range transients {
+		// the dagstore may still be writing it, or left it behind from a failed shard
+		st, err := os.Stat(path)
+		if err != nil || pieceSize == 0 || st.Size() != int64(pieceSize) {
+			log.Debugf("skip dagstore transient %s of piece %s with %d bytes", path, pieceCid, pieceSize)
+			continue
+		}
+		copies = append(copies, PieceCopy{Kind: PieceCopyTransient, Cost: pieceCopyCosts[PieceCopyTransient], Path: path})
+	}
+
+	sort.SliceStable(copies, func(i, j int) bool {
+		return copies[i].Cost < copies[j].Cost
+	})
+	return copies, nil
+}
+
+// IsUnsealed reports whether the piece can be read without unsealing a sector
+func (p *LocationPlanner) IsUnsealed(ctx context.Context, pieceCid cid.Cid) (bool, error) {
+	copies, err := p.Plan(ctx, pieceCid)
+	if err != nil {
+		return false, err
+	}
+	return len(copies) > 0 && copies[0].Kind != PieceCopySealed, nil
+}
+
+// ReadPiece reads size bytes at offset of the piece from its cheapest copy which can be read, it
+// reports whether a sector was unsealed for it
+func (p *LocationPlanner) ReadPiece(ctx context.Context, pieceCid cid.Cid, offset, size abi.UnpaddedPieceSize) (io.ReadCloser, bool, error) {
+	copies, err := p.Plan(ctx, pieceCid)
+	if err != nil {
+		return nil, false, err
+	}
+
+	lastErr := xerrors.Errorf("no copy found for piece %s", pieceCid)
+	for _, c := range copies {
+		r, err := p.read(ctx, pieceCid, c, offset, size)
+		if err != nil {
+			lastErr = xerrors.Errorf("read piece %s from %s copy: %w", pieceCid, c.Kind, err)
+			log.Warn(lastErr.Error())
+			continue
+		}
+		log.Debugw("read piece", "piece", pieceCid, "copy", c.Kind, "path", c.Path, "miner", c.Miner, "sector", c.Sector)
+		return r, c.Kind == PieceCopySealed, nil
+	}
+	return nil, false, lastErr
+}
+
+func (p *LocationPlanner) read(ctx context.Context, pieceCid cid.Cid, c PieceCopy, offset, size abi.UnpaddedPieceSize) (io.ReadCloser, error) {
+	switch c.Kind {
+	case PieceCopyStorage:
+		return c.loc.ReadOffset(ctx, pieceCid.String(), offset, size)
+	case PieceCopyTransient:
+		f, err := os.Open(c.Path)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := f.Seek(int64(offset), io.SeekStart); err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+		return piece.NewLimitedBufferReader(f, int(size)), nil
+	default:
+		sector, err := p.sectorRef(ctx, c.Miner, c.Sector)
+		if err != nil {
+			return nil, err
+		}
+		// the sealer copies the piece to a piece storage, unsealing it first if needed
+		loc, err := p.unseals.Unseal(ctx, c.Miner, sector, c.deal)
+		if err != nil {
+			return nil, err
+		}
+		return loc.ReadOffset(ctx, pieceCid.String(), offset, size)
+	}
+}
+
+func (p *LocationPlanner) isUnsealed(ctx context.Context, mAddr address.Address, deal *piece.DealInfo) (bool, error) {
+	sector, err := p.sectorRef(ctx, mAddr, deal.SectorID)
+	if err != nil {
+		return false, err
+	}
+	return p.minerAPI.IsUnsealed(ctx, mAddr, deal.Proposal.PieceCID, sector, types2.PaddedByteIndex(deal.Offset), deal.Proposal.PieceSize)
+}
+
+func (p *LocationPlanner) sectorRef(ctx context.Context, mAddr address.Address, number abi.SectorNumber) (storage.SectorRef, error) {
+	mid, err := address.IDFromAddress(mAddr)
+	if err != nil {
+		return storage.SectorRef{}, err
+	}
+	spt, err := sealProofType(ctx, p.full, mAddr)
+	if err != nil {
+		return storage.SectorRef{}, err
+	}
+	return storage.SectorRef{
+		ID: abi.SectorID{
+			Miner:  abi.ActorID(mid),
+			Number: number,
+		},
+		ProofType: spt,
+	}, nil
+}
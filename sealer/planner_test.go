@@ -0,0 +1,130 @@
+package sealer
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/network"
+	"github.com/filecoin-project/venus/app/client/apiface"
+	vTypes "github.com/filecoin-project/venus/pkg/types"
+	"github.com/filecoin-project/venus/pkg/types/specactors/builtin/market"
+	"github.com/filecoin-project/venus/pkg/types/specactors/builtin/miner"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	ds_sync "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/venus-market/config"
+	"github.com/filecoin-project/venus-market/piece"
+)
+
+type proofTypeNode struct {
+	apiface.FullNode
+}
+
+func (n *proofTypeNode) StateMinerInfo(ctx context.Context, maddr address.Address, tsk vTypes.TipSetKey) (miner.MinerInfo, error) {
+	return miner.MinerInfo{WindowPoStProofType: abi.RegisteredPoStProof_StackedDrgWindow2KiBV1}, nil
+}
+
+func (n *proofTypeNode) StateNetworkVersion(ctx context.Context, tsk vTypes.TipSetKey) (network.Version, error) {
+	return network.Version13, nil
+}
+
+func TestLocationPlanner(t *testing.T) {
+	ctx := context.Background()
+	pieceStorages, err := piece.NewPieceStorageManager(ctx, &config.MarketConfig{
+		PieceStorages: []config.PieceStorageConfig{{Path: config.PieceStorageString("fs:" + t.TempDir())}},
+	})
+	require.NoError(t, err)
+	ps, err := piece.NewDsPieceStore(ds_sync.MutexWrap(datastore.NewMapDatastore()), 2048, pieceStorages)
+	require.NoError(t, err)
+	mAddr, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	pieceCid, err := cid.Parse("bafkqaaa")
+	require.NoError(t, err)
+
+	proposal := market.ClientDealProposal{
+		Proposal: market.DealProposal{
+			PieceCID:             pieceCid,
+			PieceSize:            256,
+			StoragePricePerEpoch: abi.NewTokenAmount(1),
+			ProviderCollateral:   abi.NewTokenAmount(0),
+			ClientCollateral:     abi.NewTokenAmount(0),
+		},
+	}
+	require.NoError(t, ps.UpdateDealOnComplete(pieceCid, proposal, &storagemarket.DataRef{Root: pieceCid}, pieceCid, 1, false))
+	require.NoError(t, ps.UpdateDealStatus(1, piece.Proving))
+
+	api := &mockUnsealAPI{release: make(chan struct{})}
+	close(api.release)
+	cfg := config.UnsealConfig{Timeout: config.Duration(time.Minute)}
+	unseals := newUnsealManager(ctx, cfg, ds_sync.MutexWrap(datastore.NewMapDatastore()), api, pieceStorages)
+	homeDir := config.HomeDir(t.TempDir())
+	planner := NewLocationPlanner(&homeDir, &config.DAGStoreConfig{}, piece.PieceStores{mAddr: struct {
+		piece.PieceStore
+		piece.CIDStore
+	}{ps, nil}}, pieceStorages, api, unseals, &proofTypeNode{})
+
+	kinds := func() []string {
+		copies, err := planner.Plan(ctx, pieceCid)
+		require.NoError(t, err)
+		var out []string
+		for _, c := range copies {
+			out = append(out, c.Kind)
+		}
+		return out
+	}
+
+	require.Equal(t, []string{PieceCopySealed}, kinds())
+	unsealed, err := planner.IsUnsealed(ctx, pieceCid)
+	require.NoError(t, err)
+	require.False(t, unsealed)
+
+	api.unsealed = true
+	require.Equal(t, []string{PieceCopyUnsealed}, kinds())
+	unsealed, err = planner.IsUnsealed(ctx, pieceCid)
+	require.NoError(t, err)
+	require.True(t, unsealed)
+
+	// a transient of the dagstore is cheaper than the sector
+	transients := filepath.Join(string(homeDir), DefaultDAGStoreDir, "transients")
+	require.NoError(t, os.MkdirAll(transients, 0755))
+	transient := filepath.Join(transients, "transient-"+pieceCid.String()+"-1.data")
+	// a transient still written or left by a failed shard isn't read
+	require.NoError(t, ioutil.WriteFile(transient, []byte("0123456789"), 0644))
+	require.Equal(t, []string{PieceCopyUnsealed}, kinds())
+	require.NoError(t, ioutil.WriteFile(transient, append([]byte("0123456789"), make([]byte, 244)...), 0644))
+	require.Equal(t, []string{PieceCopyTransient, PieceCopyUnsealed}, kinds())
+	r, needUnseal, err := planner.ReadPiece(ctx, pieceCid, 2, 4)
+	require.NoError(t, err)
+	require.False(t, needUnseal)
+	data, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	require.Equal(t, "2345", string(data))
+	require.Equal(t, 0, api.calls)
+
+	// the sector is only unsealed without another copy, the piece storage has it afterwards
+	require.NoError(t, os.Remove(transient))
+	api.unsealed = false
+	r, needUnseal, err = planner.ReadPiece(ctx, pieceCid, 0, 4)
+	require.NoError(t, err)
+	require.True(t, needUnseal)
+	data, err = ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	require.Equal(t, make([]byte, 4), data)
+	require.Equal(t, 1, api.calls)
+	require.Equal(t, []string{PieceCopyStorage, PieceCopySealed}, kinds())
+
+	// the sectors aren't checked while a piece storage has the piece
+	api.unsealed = true
+	require.Equal(t, []string{PieceCopyStorage, PieceCopySealed}, kinds())
+}
@@ -85,12 +85,17 @@ func (sa *sectorAccessor) IsUnsealed(ctx context.Context, sectorID abi.SectorNum
 }
 
 func (sa *sectorAccessor) getSealProofType(ctx context.Context) (abi.RegisteredSealProof, error) {
-	mi, err := sa.full.StateMinerInfo(ctx, sa.maddr, types.EmptyTSK)
+	return sealProofType(ctx, sa.full, sa.maddr)
+}
+
+// sealProofType returns the seal proof type of the sectors of maddr
+func sealProofType(ctx context.Context, full apiface.FullNode, maddr address.Address) (abi.RegisteredSealProof, error) {
+	mi, err := full.StateMinerInfo(ctx, maddr, types.EmptyTSK)
 	if err != nil {
 		return 0, err
 	}
 
-	ver, err := sa.full.StateNetworkVersion(ctx, types.EmptyTSK)
+	ver, err := full.StateNetworkVersion(ctx, types.EmptyTSK)
 	if err != nil {
 		return 0, err
 	}
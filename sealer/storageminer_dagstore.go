@@ -3,9 +3,9 @@ package sealer
 import (
 	"context"
 	"fmt"
-	"github.com/filecoin-project/go-fil-markets/piecestore"
-	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
 	"github.com/filecoin-project/venus-market/dagstore"
+	"github.com/filecoin-project/venus-market/piece"
+	"github.com/filecoin-project/venus-market/types"
 	"go.uber.org/fx"
 
 	"github.com/filecoin-project/venus-market/config"
@@ -16,17 +16,36 @@ const (
 	DefaultDAGStoreDir         = "dagstore"
 )
 
-// NewMinerAPI creates a new MinerAPI adaptor for the dagstore mounts.
-func NewMinerAPI(lc fx.Lifecycle, r *config.DAGStoreConfig, pieceStore piecestore.PieceStore, sa retrievalmarket.SectorAccessor) (dagstore.MinerAPI, error) {
-	mountApi := dagstore.NewMinerAPI(pieceStore, sa, r.MaxConcurrencyStorageCalls)
-	ready := make(chan error, 1)
-	pieceStore.OnReady(func(err error) {
-		ready <- err
-	})
+// NewMinerAPI creates a new MinerAPI adaptor for the dagstore mounts, pieces are looked up
+// in the piece store of each served miner in turn.
+func NewMinerAPI(lc fx.Lifecycle, r *config.DAGStoreConfig, miners types.MinerAddresses, pieceStores piece.PieceStores, sas SectorAccessors) (dagstore.MinerAPI, error) {
+	mountApis := make([]dagstore.MinerAPI, 0, len(miners))
+	readys := make([]chan error, 0, len(miners))
+	for _, mAddr := range miners {
+		pieceStore, err := pieceStores.Get(mAddr)
+		if err != nil {
+			return nil, err
+		}
+		sa, err := sas.Get(mAddr)
+		if err != nil {
+			return nil, err
+		}
+		mountApis = append(mountApis, dagstore.NewMinerAPI(pieceStore, sa, r.MaxConcurrencyStorageCalls))
+
+		ready := make(chan error, 1)
+		pieceStore.OnReady(func(err error) {
+			ready <- err
+		})
+		readys = append(readys, ready)
+	}
+
+	mountApi := dagstore.NewMultiMinerAPI(mountApis...)
 	lc.Append(fx.Hook{
 		OnStart: func(ctx context.Context) error {
-			if err := <-ready; err != nil {
-				return fmt.Errorf("aborting dagstore start; piecestore failed to start: %s", err)
+			for _, ready := range readys {
+				if err := <-ready; err != nil {
+					return fmt.Errorf("aborting dagstore start; piecestore failed to start: %s", err)
+				}
 			}
 			return mountApi.Start(ctx)
 		},
@@ -0,0 +1,287 @@
+package sealer
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"go.uber.org/fx"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/specs-storage/storage"
+	types2 "github.com/ipfs-force-community/venus-common-utils/types"
+
+	clients2 "github.com/filecoin-project/venus-market/api/clients"
+	"github.com/filecoin-project/venus-market/config"
+	"github.com/filecoin-project/venus-market/metrics"
+	"github.com/filecoin-project/venus-market/models"
+	"github.com/filecoin-project/venus-market/piece"
+	"github.com/filecoin-project/venus-market/types"
+)
+
+// unsealingSuffix is appended to the name of a piece while it is unsealed
+const unsealingSuffix = ".unsealing"
+
+// unsealJob is a job running for the retrievals of a piece, done is closed once it completed
+type unsealJob struct {
+	types.UnsealJob
+
+	done chan struct{}
+	loc  *piece.PieceStorageLocation
+	err  error
+}
+
+// UnsealManager unseals the pieces retrievals ask for into the piece storages. The retrievals of a
+// piece share a single job, the last job of each piece is kept in the datastore.
+type UnsealManager struct {
+	ctx           context.Context
+	cfg           config.UnsealConfig
+	ds            datastore.Batching
+	minerAPI      clients2.MarketRequestEvent
+	pieceStorages *piece.PieceStorageManager
+	now           func() time.Time
+
+	// running jobs hold a slot, nil if not limited
+	slots chan struct{}
+
+	lk   sync.Mutex
+	jobs map[cid.Cid]*unsealJob
+}
+
+func NewUnsealManager(mctx metrics.MetricsCtx, lc fx.Lifecycle, cfg *config.MarketConfig, ds models.UnsealJobDS, minerAPI clients2.MarketRequestEvent, pieceStorages *piece.PieceStorageManager) (*UnsealManager, error) {
+	m := newUnsealManager(metrics.LifecycleCtx(mctx, lc), cfg.Unseal, ds, minerAPI, pieceStorages)
+	if err := m.failInterrupted(); err != nil {
+		return nil, xerrors.Errorf("fail interrupted unseal jobs: %w", err)
+	}
+	return m, nil
+}
+
+func newUnsealManager(ctx context.Context, cfg config.UnsealConfig, ds datastore.Batching, minerAPI clients2.MarketRequestEvent, pieceStorages *piece.PieceStorageManager) *UnsealManager {
+	m := &UnsealManager{
+		ctx:           ctx,
+		cfg:           cfg,
+		ds:            ds,
+		minerAPI:      minerAPI,
+		pieceStorages: pieceStorages,
+		now:           time.Now,
+		jobs:          make(map[cid.Cid]*unsealJob),
+	}
+	if cfg.MaxConcurrent > 0 {
+		m.slots = make(chan struct{}, cfg.MaxConcurrent)
+	}
+	return m
+}
+
+// Unseal unseals the piece of the deal and returns the storage it was unsealed to, a retrieval
+// of a piece already unsealing waits for the running job
+func (m *UnsealManager) Unseal(ctx context.Context, miner address.Address, sector storage.SectorRef, deal *piece.DealInfo) (*piece.PieceStorageLocation, error) {
+	pieceCid := deal.Proposal.PieceCID
+
+	m.lk.Lock()
+	job, ok := m.jobs[pieceCid]
+	if !ok {
+		now := m.now()
+		job = &unsealJob{
+			UnsealJob: types.UnsealJob{
+				PieceCid:  pieceCid,
+				Miner:     miner,
+				Sector:    sector.ID,
+				Offset:    deal.Offset,
+				Size:      deal.Proposal.PieceSize,
+				State:     types.UnsealJobQueued,
+				CreatedAt: now,
+				UpdatedAt: now,
+			},
+			done: make(chan struct{}),
+		}
+		m.jobs[pieceCid] = job
+		m.saveLocked(job)
+		go m.run(job, sector)
+	} else {
+		log.Infow("wait for the running unseal job", "piece", pieceCid, "state", job.State)
+	}
+	job.Waiters++
+	m.lk.Unlock()
+
+	defer func() {
+		m.lk.Lock()
+		job.Waiters--
+		m.lk.Unlock()
+	}()
+
+	select {
+	case <-job.done:
+		if job.err != nil {
+			return nil, job.err
+		}
+		return job.loc, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (m *UnsealManager) run(job *unsealJob, sector storage.SectorRef) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if m.cfg.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(m.ctx, time.Duration(m.cfg.Timeout))
+	} else {
+		ctx, cancel = context.WithCancel(m.ctx)
+	}
+	defer cancel()
+
+	if m.slots != nil {
+		select {
+		case m.slots <- struct{}{}:
+			defer func() { <-m.slots }()
+		case <-ctx.Done():
+			m.complete(job, nil, xerrors.Errorf("wait for an unseal slot: %w", ctx.Err()))
+			return
+		}
+	}
+
+	loc, err := m.unseal(ctx, job, sector)
+	m.complete(job, loc, err)
+}
+
+// unseal has the piece unsealed under a temporary name when the storage shows files while they are
+// written, the piece is renamed once the unsealer returned so that no reader sees a partial piece
+func (m *UnsealManager) unseal(ctx context.Context, job *unsealJob, sector storage.SectorRef) (*piece.PieceStorageLocation, error) {
+	name := job.PieceCid.String()
+	loc, err := m.pieceStorages.FindStorageForWrite(ctx, int64(job.Size.Unpadded()))
+	if err != nil {
+		return nil, xerrors.Errorf("find storage for unsealed piece: %w", err)
+	}
+	tmpName := name
+	renamer, rename := loc.IPieceStorage.(piece.PieceRenamer)
+	if rename {
+		tmpName = name + unsealingSuffix
+	}
+
+	m.lk.Lock()
+	job.State = types.UnsealJobUnsealing
+	job.Dest = path.Join(loc.Path, tmpName)
+	job.UpdatedAt = m.now()
+	m.saveLocked(job)
+	m.lk.Unlock()
+
+	log.Infow("unseal piece", "piece", job.PieceCid, "miner", job.Miner, "sector", job.Sector, "dest", job.Dest)
+	if err := m.minerAPI.SectorsUnsealPiece(ctx, job.Miner, job.PieceCid, sector, types2.PaddedByteIndex(job.Offset), job.Size, job.Dest); err != nil {
+		m.removeUnsealing(loc, tmpName)
+		return nil, xerrors.Errorf("unsealing piece: %w", err)
+	}
+
+	has, err := loc.Has(tmpName)
+	if err != nil {
+		return nil, xerrors.Errorf("check piece in piece storage %s: %w", loc.Name, err)
+	}
+	if !has {
+		return nil, xerrors.Errorf("unsealed piece not found at %s", job.Dest)
+	}
+	if rename {
+		if err := renamer.Rename(ctx, tmpName, name); err != nil {
+			m.removeUnsealing(loc, tmpName)
+			return nil, xerrors.Errorf("rename unsealed piece %s: %w", job.Dest, err)
+		}
+	}
+	return loc, nil
+}
+
+// removeUnsealing removes what the unsealer left under the temporary name of a failed job
+func (m *UnsealManager) removeUnsealing(loc *piece.PieceStorageLocation, tmpName string) {
+	if err := loc.Remove(m.ctx, tmpName); err != nil {
+		log.Errorf("remove partially unsealed piece %s from piece storage %s: %s", tmpName, loc.Name, err)
+	}
+}
+
+// complete records the outcome of the job and wakes up its waiters
+func (m *UnsealManager) complete(job *unsealJob, loc *piece.PieceStorageLocation, err error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	job.loc, job.err = loc, err
+	job.State = types.UnsealJobDone
+	if err != nil {
+		job.State = types.UnsealJobFailed
+		job.Error = err.Error()
+		log.Errorw("unseal piece failed", "piece", job.PieceCid, "sector", job.Sector, "error", err)
+	} else {
+		log.Infow("piece unsealed", "piece", job.PieceCid, "sector", job.Sector, "took", m.now().Sub(job.CreatedAt))
+	}
+	job.UpdatedAt = m.now()
+	m.saveLocked(job)
+	delete(m.jobs, job.PieceCid)
+	close(job.done)
+}
+
+func (m *UnsealManager) saveLocked(job *unsealJob) {
+	data, err := json.Marshal(job.UnsealJob)
+	if err == nil {
+		err = m.ds.Put(datastore.NewKey(job.PieceCid.String()), data)
+	}
+	if err != nil {
+		log.Errorf("save unseal job of piece %s: %s", job.PieceCid, err)
+	}
+}
+
+// Jobs returns the running jobs and the last job of every other piece, sorted by creation time
+func (m *UnsealManager) Jobs() ([]types.UnsealJob, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	res, err := m.ds.Query(query.Query{})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close() //nolint:errcheck
+
+	var out []types.UnsealJob
+	for entry := range res.Next() {
+		if entry.Error != nil {
+			return nil, entry.Error
+		}
+		var job types.UnsealJob
+		if err := json.Unmarshal(entry.Value, &job); err != nil {
+			return nil, xerrors.Errorf("unmarshal unseal job %s: %w", entry.Key, err)
+		}
+		if running, ok := m.jobs[job.PieceCid]; ok {
+			job = running.UnsealJob
+		}
+		out = append(out, job)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].CreatedAt.Before(out[j].CreatedAt)
+	})
+	return out, nil
+}
+
+// failInterrupted marks the jobs left running by the previous run as failed, their retrievals are gone
+func (m *UnsealManager) failInterrupted() error {
+	jobs, err := m.Jobs()
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		if job.State != types.UnsealJobQueued && job.State != types.UnsealJobUnsealing {
+			continue
+		}
+		job.State = types.UnsealJobFailed
+		job.Error = "interrupted by a restart"
+		job.UpdatedAt = m.now()
+		data, err := json.Marshal(job)
+		if err != nil {
+			return err
+		}
+		if err := m.ds.Put(datastore.NewKey(job.PieceCid.String()), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
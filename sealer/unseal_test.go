@@ -0,0 +1,146 @@
+package sealer
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+	types2 "github.com/ipfs-force-community/venus-common-utils/types"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	ds_sync "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/venus-market/config"
+	"github.com/filecoin-project/venus-market/piece"
+	"github.com/filecoin-project/venus-market/types"
+)
+
+func TestUnsealManager(t *testing.T) {
+	ctx := context.Background()
+	pieceStorages, err := piece.NewPieceStorageManager(ctx, &config.MarketConfig{
+		PieceStorages: []config.PieceStorageConfig{{Path: config.PieceStorageString("fs:" + t.TempDir())}},
+	})
+	require.NoError(t, err)
+	ds := ds_sync.MutexWrap(datastore.NewMapDatastore())
+	api := &mockUnsealAPI{release: make(chan struct{})}
+	cfg := config.UnsealConfig{Timeout: config.Duration(time.Minute)}
+	m := newUnsealManager(ctx, cfg, ds, api, pieceStorages)
+
+	miner, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	piece1, err := cid.Parse("bafkqaaa")
+	require.NoError(t, err)
+	piece2, err := cid.Parse("bafkqaalb")
+	require.NoError(t, err)
+	newDeal := func(pieceCid cid.Cid) *piece.DealInfo {
+		deal := &piece.DealInfo{}
+		deal.Proposal.PieceCID = pieceCid
+		deal.Proposal.PieceSize = 256
+		deal.Offset = 1024
+		return deal
+	}
+	sector := storage.SectorRef{ID: abi.SectorID{Miner: 1000, Number: 5}}
+
+	// the retrievals of a piece share a job
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			loc, err := m.Unseal(ctx, miner, sector, newDeal(piece1))
+			require.NoError(t, err)
+			has, err := loc.Has(piece1.String())
+			require.NoError(t, err)
+			require.True(t, has)
+		}()
+	}
+	require.Eventually(t, func() bool {
+		jobs, err := m.Jobs()
+		require.NoError(t, err)
+		return len(jobs) == 1 && jobs[0].Waiters == 2 && jobs[0].State == types.UnsealJobUnsealing && len(api.unsealDest()) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	// the piece is unsealed under a temporary name, no reader sees it before it is complete
+	require.True(t, strings.HasSuffix(api.unsealDest(), piece1.String()+unsealingSuffix))
+	_, err = pieceStorages.FindStorageForRead(piece1.String())
+	require.True(t, xerrors.Is(err, piece.ErrPieceNotFound))
+	close(api.release)
+	wg.Wait()
+
+	require.Equal(t, 1, api.calls)
+	require.Equal(t, types2.PaddedByteIndex(1024), api.offset)
+	jobs, err := m.Jobs()
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	require.Equal(t, types.UnsealJobDone, jobs[0].State)
+	require.Equal(t, abi.SectorID{Miner: 1000, Number: 5}, jobs[0].Sector)
+
+	// a failed unseal is reported to the retrieval and kept
+	api.fail = true
+	_, err = m.Unseal(ctx, miner, sector, newDeal(piece2))
+	require.Error(t, err)
+	jobs, err = m.Jobs()
+	require.NoError(t, err)
+	require.Len(t, jobs, 2)
+	require.Equal(t, types.UnsealJobFailed, jobs[1].State)
+	require.Contains(t, jobs[1].Error, "sector not found")
+	_, err = pieceStorages.FindStorageForRead(piece2.String() + unsealingSuffix)
+	require.True(t, xerrors.Is(err, piece.ErrPieceNotFound))
+
+	// the jobs running when the market stopped are failed at start
+	job := &unsealJob{UnsealJob: types.UnsealJob{PieceCid: piece2, State: types.UnsealJobUnsealing, CreatedAt: time.Now()}}
+	m.saveLocked(job)
+	m = newUnsealManager(ctx, cfg, ds, api, pieceStorages)
+	require.NoError(t, m.failInterrupted())
+	jobs, err = m.Jobs()
+	require.NoError(t, err)
+	require.Equal(t, types.UnsealJobFailed, jobs[1].State)
+	require.Equal(t, "interrupted by a restart", jobs[1].Error)
+}
+
+type mockUnsealAPI struct {
+	release  chan struct{}
+	fail     bool
+	unsealed bool
+
+	lk     sync.Mutex
+	calls  int
+	offset types2.PaddedByteIndex
+	dest   string
+}
+
+func (m *mockUnsealAPI) unsealDest() string {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	return m.dest
+}
+
+func (m *mockUnsealAPI) IsUnsealed(ctx context.Context, miner address.Address, pieceCid cid.Cid, sector storage.SectorRef, offset types2.PaddedByteIndex, size abi.PaddedPieceSize) (bool, error) {
+	return m.unsealed, nil
+}
+
+func (m *mockUnsealAPI) SectorsUnsealPiece(ctx context.Context, miner address.Address, pieceCid cid.Cid, sector storage.SectorRef, offset types2.PaddedByteIndex, size abi.PaddedPieceSize, dest string) error {
+	m.lk.Lock()
+	m.calls++
+	m.offset = offset
+	m.dest = dest
+	m.lk.Unlock()
+
+	// the file shows up while it is written
+	dest = strings.TrimPrefix(dest, "fs:")
+	if err := ioutil.WriteFile(dest, nil, 0644); err != nil {
+		return err
+	}
+	if m.fail {
+		return xerrors.New("sector not found")
+	}
+	<-m.release
+	return ioutil.WriteFile(dest, make([]byte, size.Unpadded()), 0644)
+}
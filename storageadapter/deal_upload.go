@@ -0,0 +1,351 @@
+package storageadapter
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-jsonrpc/auth"
+	"github.com/filecoin-project/venus-auth/core"
+	"github.com/ipfs/go-cid"
+	"github.com/mitchellh/go-homedir"
+	"golang.org/x/xerrors"
+)
+
+// DealUploadPath is the prefix of the deal data upload endpoint on the rpc server, followed by the
+// proposal cid of the deal. GET reports the progress of the upload, PUT appends the body placed by
+// the `Content-Range: bytes <start>-<end>/<size>` header, POST checks the data against the hex
+// sha256 of the `sha256` query and imports it for the deal, DELETE drops the data received.
+const DealUploadPath = "/deal-data/v0/"
+
+// ChunkSha256Header carries the sha256 of an uploaded chunk
+const ChunkSha256Header = "X-Chunk-Sha256"
+
+var (
+	ErrUploadOffsetMismatch = xerrors.New("chunk doesn't start at the end of the data received")
+	ErrUploadBusy           = xerrors.New("deal data is being uploaded by another request")
+)
+
+// DealUploadStatus reports the progress of the data upload of an offline deal
+type DealUploadStatus struct {
+	ProposalCid cid.Cid
+	// Size is the size of the whole data, set by the first chunk
+	Size     int64
+	Received int64
+}
+
+type dealUploadMeta struct {
+	Size int64
+}
+
+// DealUploads receives the data of offline deals by chunks. The chunks are appended to a file under
+// the transfer path, an interrupted upload resumes from the data received so far.
+type DealUploads struct {
+	dir       string
+	providers StorageProviders
+
+	lk     sync.Mutex
+	active map[cid.Cid]struct{}
+}
+
+func NewDealUploads(transferPath string) func(providers StorageProviders) (*DealUploads, error) {
+	return func(providers StorageProviders) (*DealUploads, error) {
+		dir, err := homedir.Expand(transferPath)
+		if err != nil {
+			return nil, err
+		}
+		dir = filepath.Join(dir, "deal-uploads")
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, xerrors.Errorf("create deal upload dir: %w", err)
+		}
+		return &DealUploads{dir: dir, providers: providers, active: make(map[cid.Cid]struct{})}, nil
+	}
+}
+
+func (u *DealUploads) dataPath(propCid cid.Cid) string {
+	return filepath.Join(u.dir, propCid.String())
+}
+
+func (u *DealUploads) metaPath(propCid cid.Cid) string {
+	return filepath.Join(u.dir, propCid.String()+".json")
+}
+
+// acquire makes sure one request at a time works on the data of a deal
+func (u *DealUploads) acquire(propCid cid.Cid) (func(), error) {
+	u.lk.Lock()
+	defer u.lk.Unlock()
+
+	if _, ok := u.active[propCid]; ok {
+		return nil, xerrors.Errorf("%s: %w", propCid, ErrUploadBusy)
+	}
+	u.active[propCid] = struct{}{}
+	return func() {
+		u.lk.Lock()
+		delete(u.active, propCid)
+		u.lk.Unlock()
+	}, nil
+}
+
+// providerOfDeal finds the provider of a deal waiting for its data to be imported
+func (u *DealUploads) providerOfDeal(propCid cid.Cid) (storagemarket.StorageProvider, error) {
+	for _, provider := range u.providers {
+		deal, err := provider.GetLocalDeal(propCid)
+		if err != nil {
+			continue
+		}
+		if deal.Ref == nil || deal.Ref.TransferType != storagemarket.TTManual {
+			return nil, xerrors.Errorf("deal %s is not an offline deal", propCid)
+		}
+		if deal.State != storagemarket.StorageDealWaitingForData {
+			return nil, xerrors.Errorf("deal %s is not waiting for data, state: %s", propCid, storagemarket.DealStates[deal.State])
+		}
+		return provider, nil
+	}
+	return nil, xerrors.Errorf("deal %s not found in any miner", propCid)
+}
+
+// Status returns the progress of the upload of a deal, Size is 0 if no chunk was received
+func (u *DealUploads) Status(propCid cid.Cid) (*DealUploadStatus, error) {
+	status := &DealUploadStatus{ProposalCid: propCid}
+
+	metaBytes, err := ioutil.ReadFile(u.metaPath(propCid))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return status, nil
+		}
+		return nil, err
+	}
+	var meta dealUploadMeta
+	if err := json.Unmarshal(metaBytes, &meta); err != nil {
+		return nil, xerrors.Errorf("decode upload meta of %s: %w", propCid, err)
+	}
+	status.Size = meta.Size
+
+	fi, err := os.Stat(u.dataPath(propCid))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		status.Received = fi.Size()
+	}
+	return status, nil
+}
+
+// WriteChunk appends a chunk of the data of a deal, it must start at the end of the data received
+// and belong to an upload of the same size. The chunk is dropped if it doesn't match chunkSum.
+func (u *DealUploads) WriteChunk(propCid cid.Cid, offset, size int64, chunk io.Reader, chunkSum []byte) (*DealUploadStatus, error) {
+	release, err := u.acquire(propCid)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if _, err := u.providerOfDeal(propCid); err != nil {
+		return nil, err
+	}
+
+	status, err := u.Status(propCid)
+	if err != nil {
+		return nil, err
+	}
+	if size <= 0 {
+		return status, xerrors.Errorf("invalid data size %d", size)
+	}
+	if status.Size == 0 {
+		metaBytes, err := json.Marshal(dealUploadMeta{Size: size})
+		if err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(u.metaPath(propCid), metaBytes, 0644); err != nil {
+			return nil, xerrors.Errorf("write upload meta of %s: %w", propCid, err)
+		}
+		status.Size = size
+	}
+	if status.Size != size {
+		return status, xerrors.Errorf("data size %d doesn't match the size %d of the upload", size, status.Size)
+	}
+	if offset != status.Received {
+		return status, xerrors.Errorf("chunk at %d, %d bytes received: %w", offset, status.Received, ErrUploadOffsetMismatch)
+	}
+
+	f, err := os.OpenFile(u.dataPath(propCid), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() //nolint:errcheck
+
+	hasher := sha256.New()
+	// read one more byte to find out chunks going over the size
+	written, err := io.Copy(f, io.TeeReader(io.LimitReader(chunk, size-offset+1), hasher))
+	if err == nil && offset+written > size {
+		err = xerrors.Errorf("chunk goes over the data size %d", size)
+	}
+	if err == nil && len(chunkSum) > 0 && !bytes.Equal(hasher.Sum(nil), chunkSum) {
+		err = xerrors.Errorf("chunk doesn't match its checksum")
+	}
+	if err != nil {
+		if terr := f.Truncate(offset); terr != nil {
+			log.Errorf("drop bad chunk of %s: %s", propCid, terr)
+		}
+		return status, err
+	}
+
+	status.Received += written
+	return status, nil
+}
+
+// Complete checks the whole data against checksum and imports it for the deal, the data is removed
+// once imported. Data not matching the checksum is dropped.
+func (u *DealUploads) Complete(ctx context.Context, propCid cid.Cid, checksum []byte) error {
+	release, err := u.acquire(propCid)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	provider, err := u.providerOfDeal(propCid)
+	if err != nil {
+		return err
+	}
+	status, err := u.Status(propCid)
+	if err != nil {
+		return err
+	}
+	if status.Size == 0 || status.Received != status.Size {
+		return xerrors.Errorf("upload of %s is not finished, %d of %d bytes received", propCid, status.Received, status.Size)
+	}
+
+	f, err := os.Open(u.dataPath(propCid))
+	if err != nil {
+		return err
+	}
+	defer f.Close() //nolint:errcheck
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return xerrors.Errorf("hash data of %s: %w", propCid, err)
+	}
+	if !bytes.Equal(hasher.Sum(nil), checksum) {
+		if err := u.remove(propCid); err != nil {
+			log.Errorf("remove data of %s: %s", propCid, err)
+		}
+		return xerrors.Errorf("data of %s doesn't match the checksum, upload it again", propCid)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	if err := provider.ImportDataForDeal(ctx, propCid, f); err != nil {
+		return xerrors.Errorf("import data for deal %s: %w", propCid, err)
+	}
+	return u.remove(propCid)
+}
+
+// Abort drops the data received for a deal
+func (u *DealUploads) Abort(propCid cid.Cid) error {
+	release, err := u.acquire(propCid)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return u.remove(propCid)
+}
+
+func (u *DealUploads) remove(propCid cid.Cid) error {
+	for _, p := range []string{u.dataPath(propCid), u.metaPath(propCid)} {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (u *DealUploads) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	propCid, err := cid.Decode(strings.TrimPrefix(r.URL.Path, DealUploadPath))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid proposal cid: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	perm := core.PermWrite
+	if r.Method == http.MethodGet {
+		perm = core.PermRead
+	}
+	if !auth.HasPerm(r.Context(), nil, auth.Permission(perm)) {
+		http.Error(w, fmt.Sprintf("missing permission: %s", perm), http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		status, err := u.Status(propCid)
+		writeUploadStatus(w, status, err)
+	case http.MethodPut:
+		var start, end, size int64
+		if _, err := fmt.Sscanf(r.Header.Get("Content-Range"), "bytes %d-%d/%d", &start, &end, &size); err != nil {
+			http.Error(w, fmt.Sprintf("invalid Content-Range: %s", err), http.StatusBadRequest)
+			return
+		}
+		chunkSum, err := hex.DecodeString(r.Header.Get(ChunkSha256Header))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid %s: %s", ChunkSha256Header, err), http.StatusBadRequest)
+			return
+		}
+		status, err := u.WriteChunk(propCid, start, size, io.LimitReader(r.Body, end-start+1), chunkSum)
+		if err == nil && status.Received != end+1 {
+			err = xerrors.Errorf("chunk is shorter than its Content-Range")
+		}
+		writeUploadStatus(w, status, err)
+	case http.MethodPost:
+		checksum, err := hex.DecodeString(r.URL.Query().Get("sha256"))
+		if err != nil || len(checksum) != sha256.Size {
+			http.Error(w, "missing or invalid sha256 of the data", http.StatusBadRequest)
+			return
+		}
+		if err := u.Complete(r.Context(), propCid, checksum); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case http.MethodDelete:
+		if err := u.Abort(propCid); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// writeUploadStatus replies with the status in json, along with the error if any so that the
+// client can resume from the data received
+func writeUploadStatus(w http.ResponseWriter, status *DealUploadStatus, err error) {
+	code := http.StatusOK
+	if err != nil {
+		code = http.StatusBadRequest
+		if xerrors.Is(err, ErrUploadOffsetMismatch) || xerrors.Is(err, ErrUploadBusy) {
+			code = http.StatusConflict
+		}
+	}
+	if status == nil {
+		http.Error(w, err.Error(), code)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		w.Header().Set("X-Upload-Error", err.Error())
+	}
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(status)
+}
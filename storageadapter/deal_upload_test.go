@@ -0,0 +1,169 @@
+package storageadapter
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-jsonrpc/auth"
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+)
+
+type uploadTestProvider struct {
+	storagemarket.StorageProvider
+
+	deals    map[cid.Cid]storagemarket.MinerDeal
+	imported map[cid.Cid][]byte
+}
+
+func (p *uploadTestProvider) GetLocalDeal(propCid cid.Cid) (storagemarket.MinerDeal, error) {
+	deal, ok := p.deals[propCid]
+	if !ok {
+		return storagemarket.MinerDeal{}, fmt.Errorf("deal %s not found", propCid)
+	}
+	return deal, nil
+}
+
+func (p *uploadTestProvider) ImportDataForDeal(_ context.Context, propCid cid.Cid, data io.Reader) error {
+	buf, err := ioutil.ReadAll(data)
+	if err != nil {
+		return err
+	}
+	p.imported[propCid] = buf
+	return nil
+}
+
+func TestDealUploads(t *testing.T) {
+	propCid, err := cid.Decode("bafy2bzacea3wsdh6y3a36tb3skempjoxqpuyompjbmfeyf34fi3uy6uue42v4")
+	require.NoError(t, err)
+	maddr, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+
+	deal := storagemarket.MinerDeal{
+		Ref:   &storagemarket.DataRef{TransferType: storagemarket.TTManual},
+		State: storagemarket.StorageDealWaitingForData,
+	}
+	provider := &uploadTestProvider{
+		deals:    map[cid.Cid]storagemarket.MinerDeal{propCid: deal},
+		imported: make(map[cid.Cid][]byte),
+	}
+	uploads, err := NewDealUploads(t.TempDir())(StorageProviders{maddr: provider})
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("Authorization") {
+		case "write":
+			r = r.WithContext(auth.WithPerm(r.Context(), []auth.Permission{"read", "write"}))
+		case "read":
+			r = r.WithContext(auth.WithPerm(r.Context(), []auth.Permission{"read"}))
+		}
+		uploads.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	endpoint := srv.URL + DealUploadPath + propCid.String()
+
+	send := func(method, url string, header http.Header, body []byte) (*http.Response, *DealUploadStatus) {
+		req, err := http.NewRequest(method, url, bytes.NewReader(body))
+		require.NoError(t, err)
+		req.Header = header
+		req.Header.Set("Authorization", "write")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close() //nolint:errcheck
+		var status *DealUploadStatus
+		if resp.Header.Get("Content-Type") == "application/json" {
+			status = &DealUploadStatus{}
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(status))
+		}
+		return resp, status
+	}
+	putChunk := func(data []byte, offset int64, size int, chunkSum []byte) (*http.Response, *DealUploadStatus) {
+		header := http.Header{}
+		header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+int64(len(data))-1, size))
+		if chunkSum != nil {
+			header.Set(ChunkSha256Header, hex.EncodeToString(chunkSum))
+		}
+		return send(http.MethodPut, endpoint, header, data)
+	}
+
+	data := make([]byte, 3000)
+	_, err = rand.Read(data)
+	require.NoError(t, err)
+	dataSum := sha256.Sum256(data)
+
+	// writing needs the write permission
+	resp, err := http.Post(endpoint+"?sha256="+hex.EncodeToString(dataSum[:]), "", nil)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	req, err := http.NewRequest(http.MethodDelete, endpoint, nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "read")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	chunkSum := sha256.Sum256(data[:1000])
+	resp, status := putChunk(data[:1000], 0, len(data), chunkSum[:])
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, DealUploadStatus{ProposalCid: propCid, Size: 3000, Received: 1000}, *status)
+
+	// a corrupted chunk is dropped
+	resp, status = putChunk(data[1000:2000], 1000, len(data), chunkSum[:])
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	require.Equal(t, int64(1000), status.Received)
+
+	// chunks must follow the data received
+	resp, status = putChunk(data[2000:], 2000, len(data), nil)
+	require.Equal(t, http.StatusConflict, resp.StatusCode)
+	require.Equal(t, int64(1000), status.Received)
+
+	// the data is not complete yet
+	resp, _ = send(http.MethodPost, endpoint+"?sha256="+hex.EncodeToString(dataSum[:]), http.Header{}, nil)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, status = putChunk(data[1000:], 1000, len(data), nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, int64(3000), status.Received)
+
+	resp, status = send(http.MethodGet, endpoint, http.Header{}, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, DealUploadStatus{ProposalCid: propCid, Size: 3000, Received: 3000}, *status)
+
+	// a wrong checksum drops the whole data
+	otherSum := sha256.Sum256(data[1:])
+	resp, _ = send(http.MethodPost, endpoint+"?sha256="+hex.EncodeToString(otherSum[:]), http.Header{}, nil)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	status, err = uploads.Status(propCid)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), status.Size)
+	require.Empty(t, provider.imported)
+
+	resp, _ = putChunk(data, 0, len(data), nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, _ = send(http.MethodPost, endpoint+"?sha256="+hex.EncodeToString(dataSum[:]), http.Header{}, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, data, provider.imported[propCid])
+	files, err := ioutil.ReadDir(uploads.dir)
+	require.NoError(t, err)
+	require.Empty(t, files)
+
+	// deals not waiting for data are refused
+	deal.State = storagemarket.StorageDealAwaitingPreCommit
+	provider.deals[propCid] = deal
+	resp, _ = putChunk(data, 0, len(data), nil)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
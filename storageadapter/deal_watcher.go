@@ -0,0 +1,226 @@
+package storageadapter
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"go.uber.org/fx"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/venus/app/client/apiface"
+	"github.com/filecoin-project/venus/app/submodule/apitypes"
+	"github.com/filecoin-project/venus/pkg/types"
+
+	"github.com/filecoin-project/venus-market/builder"
+	"github.com/filecoin-project/venus-market/dagstore"
+	"github.com/filecoin-project/venus-market/indexprovider"
+	"github.com/filecoin-project/venus-market/metrics"
+	"github.com/filecoin-project/venus-market/piece"
+)
+
+var WatchDealsKey builder.Invoke = builder.NextInvoke()
+
+// how often the deal watcher reads the deals of the piece stores on chain
+var dealWatchInterval = 30 * time.Minute
+
+// the statuses of the deals which haven't ended yet
+var watchedDealStatuses = []string{piece.Undefine, piece.Assigned, piece.Packing, piece.Proving}
+
+// dealWatcherAPI is the specific methods called by the DealWatcher
+type dealWatcherAPI interface {
+	ChainHead(context.Context) (*types.TipSet, error)
+	StateMarketStorageDeal(context.Context, abi.DealID, types.TipSetKey) (*apitypes.MarketDeal, error)
+}
+
+type shardDestroyer interface {
+	DestroyShard(ctx context.Context, pieceCid cid.Cid) error
+}
+
+type pieceAnnouncer interface {
+	Enabled() bool
+	NotifyRemove(ctx context.Context, pieceCid cid.Cid) (cid.Cid, error)
+}
+
+// DealWatcher follows the deals of the piece stores on chain. A deal is moved to Expired, Slashed
+// or Failed once it ended, and the piece file and the dagstore shard of a piece are removed once
+// all its deals, of every miner, ended, the removal of the piece is announced to the indexers.
+type DealWatcher struct {
+	api           dealWatcherAPI
+	pieceStores   piece.PieceStores
+	pieceStorages *piece.PieceStorageManager
+	dagStore      shardDestroyer
+	announcer     pieceAnnouncer
+
+	// pieces with a deal which ended, kept until they are cleaned up or have a running deal, the
+	// pieces of every ended deal on start as a cleanup may not have run before a restart
+	ended map[cid.Cid]struct{}
+}
+
+func NewDealWatcher(full apiface.FullNode, pieceStores piece.PieceStores, pieceStorages *piece.PieceStorageManager, dagStore *dagstore.Wrapper, indexProvider *indexprovider.Provider) *DealWatcher {
+	return &DealWatcher{
+		api:           full,
+		pieceStores:   pieceStores,
+		pieceStorages: pieceStorages,
+		dagStore:      dagStore,
+		announcer:     indexProvider,
+		ended:         make(map[cid.Cid]struct{}),
+	}
+}
+
+func WatchDeals(mctx metrics.MetricsCtx, lc fx.Lifecycle, w *DealWatcher) {
+	ctx := metrics.LifecycleCtx(mctx, lc)
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			go w.run(ctx)
+			return nil
+		},
+	})
+}
+
+func (w *DealWatcher) run(ctx context.Context) {
+	ticker := time.NewTicker(dealWatchInterval)
+	defer ticker.Stop()
+
+	w.loadEnded()
+
+	for {
+		w.check(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *DealWatcher) check(ctx context.Context) {
+	head, err := w.api.ChainHead(ctx)
+	if err != nil {
+		log.Errorf("deal watcher get chain head: %s", err)
+		return
+	}
+
+	for mAddr, ps := range w.pieceStores {
+		deals, err := ps.GetDealsByStatus(watchedDealStatuses...)
+		if err != nil {
+			log.Errorf("list deals of miner %s: %s", mAddr, err)
+			continue
+		}
+
+		for _, deal := range deals {
+			onChain, err := w.api.StateMarketStorageDeal(ctx, deal.DealID, head.Key())
+			if err != nil {
+				// the market actor drops the deals which ended
+				if !strings.Contains(err.Error(), "not found") {
+					log.Warnf("look up deal %d on chain: %s", deal.DealID, err)
+					continue
+				}
+			}
+
+			status, epoch := endedDealStatus(head.Height(), deal, onChain)
+			if status == "" {
+				continue
+			}
+			if err := ps.UpdateDealOnChainStatus(deal.DealID, status, epoch); err != nil {
+				log.Errorf("update status of deal %d: %s", deal.DealID, err)
+				continue
+			}
+			log.Infow("deal ended on chain", "miner", mAddr, "deal", deal.DealID, "status", status, "epoch", epoch)
+			w.ended[deal.Proposal.PieceCID] = struct{}{}
+		}
+	}
+
+	for pieceCID := range w.ended {
+		ended, err := w.pieceEnded(pieceCID)
+		if err != nil {
+			log.Errorf("check deals of piece %s: %s", pieceCID, err)
+			continue
+		}
+		if ended {
+			if err := w.cleanupPiece(ctx, pieceCID); err != nil {
+				log.Errorf("clean up piece %s: %s", pieceCID, err)
+				continue
+			}
+		}
+		delete(w.ended, pieceCID)
+	}
+}
+
+// loadEnded adds the pieces of the deals which ended to those to clean up, cleaning up a piece
+// again is harmless
+func (w *DealWatcher) loadEnded() {
+	for mAddr, ps := range w.pieceStores {
+		deals, err := ps.GetDealsByStatus(piece.Expired, piece.Slashed, piece.Failed)
+		if err != nil {
+			log.Errorf("list ended deals of miner %s: %s", mAddr, err)
+			continue
+		}
+		for _, deal := range deals {
+			w.ended[deal.Proposal.PieceCID] = struct{}{}
+		}
+	}
+}
+
+// endedDealStatus returns the status a deal ended with and the epoch it ended at, or an empty
+// status while the deal is running. onChain is nil if the market actor doesn't have the deal.
+func endedDealStatus(height abi.ChainEpoch, deal *piece.DealInfo, onChain *apitypes.MarketDeal) (string, abi.ChainEpoch) {
+	proposal := deal.Proposal
+	switch {
+	case onChain != nil && onChain.State.SlashEpoch > -1:
+		return piece.Slashed, onChain.State.SlashEpoch
+	case onChain != nil && onChain.State.SectorStartEpoch < 0:
+		// a deal not activated by its start epoch is dropped by the market actor
+		if height > proposal.StartEpoch {
+			return piece.Failed, proposal.StartEpoch
+		}
+		return "", 0
+	case height >= proposal.EndEpoch:
+		return piece.Expired, proposal.EndEpoch
+	case onChain == nil && height > proposal.StartEpoch:
+		// the deal left the market actor before its end
+		if deal.Status == piece.Proving {
+			return piece.Slashed, height
+		}
+		return piece.Failed, proposal.StartEpoch
+	}
+	return "", 0
+}
+
+// pieceEnded reports whether all the deals of the piece ended
+func (w *DealWatcher) pieceEnded(pieceCID cid.Cid) (bool, error) {
+	var found bool
+	for _, ps := range w.pieceStores {
+		deals, err := ps.GetPieceDeals(pieceCID)
+		if err != nil {
+			return false, err
+		}
+		for _, deal := range deals {
+			if !piece.IsDealEnded(deal.Status) {
+				return false, nil
+			}
+			found = true
+		}
+	}
+	return found, nil
+}
+
+func (w *DealWatcher) cleanupPiece(ctx context.Context, pieceCID cid.Cid) error {
+	if w.announcer != nil && w.announcer.Enabled() {
+		// the indexers still pointing at the piece aren't a reason to keep its file
+		if _, err := w.announcer.NotifyRemove(ctx, pieceCID); err != nil {
+			log.Errorw("announce piece removal", "piece", pieceCID, "err", err)
+		}
+	}
+	if err := w.dagStore.DestroyShard(ctx, pieceCID); err != nil {
+		return err
+	}
+	if err := w.pieceStorages.RemovePiece(ctx, pieceCID.String()); err != nil {
+		return xerrors.Errorf("remove piece file: %w", err)
+	}
+	log.Infow("all deals of the piece ended, piece file and shard removed", "piece", pieceCID)
+	return nil
+}
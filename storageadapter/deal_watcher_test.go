@@ -0,0 +1,104 @@
+package storageadapter
+
+import (
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/venus/app/submodule/apitypes"
+
+	"github.com/filecoin-project/venus-market/piece"
+)
+
+func TestEndedDealStatus(t *testing.T) {
+	newDeal := func(status string) *piece.DealInfo {
+		deal := &piece.DealInfo{Status: status}
+		deal.Proposal.StartEpoch = 100
+		deal.Proposal.EndEpoch = 1000
+		return deal
+	}
+	onChain := func(sectorStart, slash abi.ChainEpoch) *apitypes.MarketDeal {
+		md := &apitypes.MarketDeal{}
+		md.State.SectorStartEpoch = sectorStart
+		md.State.SlashEpoch = slash
+		return md
+	}
+
+	cases := []struct {
+		name    string
+		height  abi.ChainEpoch
+		deal    *piece.DealInfo
+		onChain *apitypes.MarketDeal
+		status  string
+		epoch   abi.ChainEpoch
+	}{
+		{"waiting for activation", 50, newDeal(piece.Assigned), onChain(-1, -1), "", 0},
+		{"active", 500, newDeal(piece.Proving), onChain(90, -1), "", 0},
+		{"not activated", 150, newDeal(piece.Assigned), onChain(-1, -1), piece.Failed, 100},
+		{"slashed", 500, newDeal(piece.Proving), onChain(90, 400), piece.Slashed, 400},
+		{"expired", 1000, newDeal(piece.Proving), onChain(90, -1), piece.Expired, 1000},
+		{"dropped after its end", 1200, newDeal(piece.Proving), nil, piece.Expired, 1000},
+		{"dropped while proving", 500, newDeal(piece.Proving), nil, piece.Slashed, 500},
+		{"dropped before activation", 500, newDeal(piece.Undefine), nil, piece.Failed, 100},
+		{"not found before its start", 50, newDeal(piece.Undefine), nil, "", 0},
+	}
+	for _, c := range cases {
+		status, epoch := endedDealStatus(c.height, c.deal, c.onChain)
+		require.Equal(t, c.status, status, c.name)
+		require.Equal(t, c.epoch, epoch, c.name)
+	}
+}
+
+type endedTestPieceStore struct {
+	piece.ExtendPieceStore
+
+	deals []*piece.DealInfo
+}
+
+func (ps *endedTestPieceStore) GetDealsByStatus(statuses ...string) ([]*piece.DealInfo, error) {
+	var out []*piece.DealInfo
+	for _, deal := range ps.deals {
+		for _, status := range statuses {
+			if deal.Status == status {
+				out = append(out, deal)
+				break
+			}
+		}
+	}
+	return out, nil
+}
+
+func TestDealWatcherLoadEnded(t *testing.T) {
+	newDeal := func(pieceCid string, status string) *piece.DealInfo {
+		c, err := cid.Decode(pieceCid)
+		require.NoError(t, err)
+		deal := &piece.DealInfo{Status: status}
+		deal.Proposal.PieceCID = c
+		return deal
+	}
+	expired := newDeal("baga6ea4seaqjtovkwk4myyzj56eztkh5pzsk5upksan6f5outesy62bsvl4dsha", piece.Expired)
+	proving := newDeal("bafy2bzacea3wsdh6y3a36tb3skempjoxqpuyompjbmfeyf34fi3uy6uue42v4", piece.Proving)
+	slashed := newDeal("bafy2bzaceaxm23epjsmh75yvzcecsrbavlmkcxnva66bkdebdcnyw3bjrc74u", piece.Slashed)
+
+	maddr1, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	maddr2, err := address.NewIDAddress(1001)
+	require.NoError(t, err)
+	w := &DealWatcher{
+		pieceStores: piece.PieceStores{
+			maddr1: &endedTestPieceStore{deals: []*piece.DealInfo{expired, proving}},
+			maddr2: &endedTestPieceStore{deals: []*piece.DealInfo{slashed}},
+		},
+		ended: make(map[cid.Cid]struct{}),
+	}
+
+	// the cleanups left by a restart are run again
+	w.loadEnded()
+	require.Equal(t, map[cid.Cid]struct{}{
+		expired.Proposal.PieceCID: {},
+		slashed.Proposal.PieceCID: {},
+	}, w.ended)
+}
@@ -3,24 +3,30 @@ package storageadapter
 import (
 	"context"
 	"fmt"
+	"github.com/filecoin-project/venus-market/models"
 	"github.com/filecoin-project/venus-market/sealer"
 	marketTypes "github.com/filecoin-project/venus-market/types"
 	"github.com/filecoin-project/venus/app/client/apiface"
+	"github.com/filecoin-project/venus/app/submodule/apitypes"
+	"github.com/filecoin-project/venus/pkg/constants"
 	"github.com/filecoin-project/venus/pkg/types/specactors"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
 	"go.uber.org/fx"
 	"golang.org/x/xerrors"
 
 	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/exitcode"
 	market2 "github.com/filecoin-project/specs-actors/v2/actors/builtin/market"
 
 	"github.com/filecoin-project/venus-market/config"
+	"github.com/filecoin-project/venus-market/journal"
 	"github.com/filecoin-project/venus/pkg/types"
 	"github.com/filecoin-project/venus/pkg/types/specactors/builtin/market"
 	"github.com/filecoin-project/venus/pkg/types/specactors/builtin/miner"
@@ -35,6 +41,8 @@ type dealPublisherAPI interface {
 	WalletHas(context.Context, address.Address) (bool, error)
 	StateAccountKey(context.Context, address.Address, types.TipSetKey) (address.Address, error)
 	StateLookupID(context.Context, address.Address, types.TipSetKey) (address.Address, error)
+	StateSearchMsg(ctx context.Context, from types.TipSetKey, msg cid.Cid, limit abi.ChainEpoch, allowReplaced bool) (*apitypes.MsgLookup, error)
+	StateCall(ctx context.Context, msg *types.Message, tsk types.TipSetKey) (*types.InvocResult, error)
 }
 
 // DealPublisher batches deal publishing so that many deals can be included in
@@ -45,9 +53,20 @@ type dealPublisherAPI interface {
 // There is a configurable maximum number of deals that can be included in one
 // message. When the limit is reached the DealPublisher immediately submits a
 // publish message with all deals in the queue.
+// Before sending the message it is simulated against the chain state, the
+// deals which would make it fail are excluded with their own error.
+// The queue is published before the period ends when a deal starts soon, and
+// it can be held after the period while the base fee is high.
+// The queue, the start of the publish period and the publish messages are
+// kept in the metadata datastore, so that the queue is restored after a
+// restart and deals published before it are not published again.
 type DealPublisher struct {
-	api dealPublisherAPI
-	as  *sealer.AddressSelector
+	api   dealPublisherAPI
+	as    *sealer.AddressSelector
+	store *publishStore
+
+	journal         journal.Journal
+	excludedEvtType journal.EventType
 
 	ctx      context.Context
 	Shutdown context.CancelFunc
@@ -55,18 +74,44 @@ type DealPublisher struct {
 	maxDealsPerPublishMsg uint64
 	publishPeriod         time.Duration
 	publishSpec           *types.MessageSendSpec
+	policy                publishPolicy
 
 	lk                     sync.Mutex
 	pending                []*pendingDeal
 	cancelWaitForMoreDeals context.CancelFunc
 	publishPeriodStart     time.Time
+	// deals handed to a publish message which is not sent yet
+	publishing map[cid.Cid]*pendingDeal
+	// latest decisions, the oldest first
+	decisions []marketTypes.PublishDecision
 }
 
+// maxPublishDecisions is the number of decisions kept for PendingDeals
+const maxPublishDecisions = 16
+
+// publishMsgTimeout is how long a publish message may stay out of the chain before it's considered
+// dropped or replaced, and its deals are queued again
+var publishMsgTimeout = 4 * time.Hour
+
 // A deal that is queued to be published
 type pendingDeal struct {
-	ctx    context.Context
-	deal   market2.ClientDealProposal
-	Result chan publishResult
+	ctx     context.Context
+	propCid cid.Cid
+	deal    market2.ClientDealProposal
+	queued  time.Time
+	Result  chan publishResult
+	// the same deal submitted again while it was queued, they get the same result
+	followers []*pendingDeal
+}
+
+// DealPublishExcludedEvt is recorded when a deal is excluded from a publish message
+type DealPublishExcludedEvt struct {
+	ProposalCid cid.Cid
+	Client      address.Address
+	Provider    address.Address
+	PieceCID    cid.Cid
+	StartEpoch  abi.ChainEpoch
+	Error       string
 }
 
 // The result of publishing a deal
@@ -75,14 +120,21 @@ type publishResult struct {
 	err    error
 }
 
-func newPendingDeal(ctx context.Context, deal market2.ClientDealProposal) *pendingDeal {
+func newPendingDeal(ctx context.Context, propCid cid.Cid, deal market2.ClientDealProposal) *pendingDeal {
 	return &pendingDeal{
-		ctx:    ctx,
-		deal:   deal,
-		Result: make(chan publishResult),
+		ctx:     ctx,
+		propCid: propCid,
+		deal:    deal,
+		queued:  marketTypes.Clock.Now(),
+		// buffered so that restored deals nobody waits for complete as well
+		Result: make(chan publishResult, 1),
 	}
 }
 
+func (pd *pendingDeal) record() *publishRecord {
+	return &publishRecord{ProposalCid: pd.propCid, Deal: pd.deal, Queued: pd.queued}
+}
+
 type PublishMsgConfig struct {
 	// The amount of time to wait for more deals to arrive before
 	// publishing
@@ -90,21 +142,30 @@ type PublishMsgConfig struct {
 	// The maximum number of deals to include in a single PublishStorageDeals
 	// message
 	MaxDealsPerMsg uint64
+	// Publish before the period ends when a deal starts within this margin
+	StartMargin time.Duration
+	// Hold the deals after the period ends while the base fee is above it
+	MaxBaseFee abi.TokenAmount
 }
 
 func NewDealPublisher(
 	cfg *config.MarketConfig,
-) func(lc fx.Lifecycle, full apiface.FullNode, as *sealer.AddressSelector) *DealPublisher {
-	return func(lc fx.Lifecycle, full apiface.FullNode, as *sealer.AddressSelector) *DealPublisher {
+) func(lc fx.Lifecycle, full apiface.FullNode, as *sealer.AddressSelector, ds models.DealPublishDS, j journal.Journal) *DealPublisher {
+	return func(lc fx.Lifecycle, full apiface.FullNode, as *sealer.AddressSelector, ds models.DealPublishDS, j journal.Journal) *DealPublisher {
 		maxFee := abi.TokenAmount(cfg.MaxPublishDealsFee)
 		publishMsgConfig := PublishMsgConfig{
 			Period:         time.Duration(cfg.PublishMsgPeriod),
 			MaxDealsPerMsg: cfg.MaxDealsPerPublishMsg,
+			StartMargin:    time.Duration(cfg.PublishStartMargin),
+			MaxBaseFee:     abi.TokenAmount(cfg.PublishMaxBaseFee),
 		}
 
 		publishSpec := &types.MessageSendSpec{MaxFee: maxFee}
-		dp := newDealPublisher(full, as, publishMsgConfig, publishSpec)
+		dp := newDealPublisher(full, as, ds, j, publishMsgConfig, publishSpec)
 		lc.Append(fx.Hook{
+			OnStart: func(ctx context.Context) error {
+				return dp.restore(ctx)
+			},
 			OnStop: func(ctx context.Context) error {
 				dp.Shutdown()
 				return nil
@@ -117,6 +178,8 @@ func NewDealPublisher(
 func newDealPublisher(
 	dpapi dealPublisherAPI,
 	as *sealer.AddressSelector,
+	ds datastore.Batching,
+	j journal.Journal,
 	publishMsgCfg PublishMsgConfig,
 	publishSpec *types.MessageSendSpec,
 ) *DealPublisher {
@@ -124,12 +187,143 @@ func newDealPublisher(
 	return &DealPublisher{
 		api:                   dpapi,
 		as:                    as,
+		store:                 &publishStore{ds: ds},
+		journal:               j,
+		excludedEvtType:       j.RegisterEventType("markets/piecestorage/provider", "publish_excluded"),
 		ctx:                   ctx,
 		Shutdown:              cancel,
 		maxDealsPerPublishMsg: publishMsgCfg.MaxDealsPerMsg,
 		publishPeriod:         publishMsgCfg.Period,
 		publishSpec:           publishSpec,
+		policy: publishPolicy{
+			startEpochMargin: durationEpochs(publishMsgCfg.StartMargin),
+			maxBaseFee:       publishMsgCfg.MaxBaseFee,
+		},
+		publishing: make(map[cid.Cid]*pendingDeal),
+	}
+}
+
+// restore reloads the queue saved before a restart. Deals whose publish message landed or is still
+// on its way are not queued again, deals which can't be published any more are dropped.
+func (p *DealPublisher) restore(ctx context.Context) error {
+	head, err := p.api.ChainHead(ctx)
+	if err != nil {
+		return xerrors.Errorf("restore publish queue: %w", err)
+	}
+
+	published, err := p.store.list(publishedPrefix)
+	if err != nil {
+		return xerrors.Errorf("restore publish queue: %w", err)
+	}
+	inFlight := make(map[cid.Cid]struct{})
+	var dropped []*publishRecord
+	for _, rec := range published {
+		if rec.Deal.Proposal.StartEpoch < head.Height() {
+			if err := p.store.remove(publishedKey(rec.ProposalCid)); err != nil {
+				return err
+			}
+			continue
+		}
+		_, ok, drop := p.publishedMessage(ctx, rec.ProposalCid)
+		if ok {
+			inFlight[rec.ProposalCid] = struct{}{}
+		}
+		if drop != nil {
+			dropped = append(dropped, drop)
+		}
+	}
+
+	// the deals of a message dropped are queued again
+	for _, rec := range dropped {
+		rec.MsgCid, rec.Sent = cid.Undef, time.Time{}
+		if err := p.store.queue(rec); err != nil {
+			return xerrors.Errorf("queue deal %s again: %w", rec.ProposalCid, err)
+		}
+	}
+	pending, err := p.store.list(pendingPublishPrefix)
+	if err != nil {
+		return xerrors.Errorf("restore publish queue: %w", err)
+	}
+	start, err := p.store.periodStart()
+	if err != nil {
+		return xerrors.Errorf("restore publish period: %w", err)
+	}
+
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	for _, rec := range pending {
+		_, ok := inFlight[rec.ProposalCid]
+		if ok || rec.Deal.Proposal.StartEpoch < head.Height() {
+			log.Infof("drop deal %s from publish queue, published: %t", rec.ProposalCid, ok)
+			if err := p.store.remove(pendingPublishKey(rec.ProposalCid)); err != nil {
+				return err
+			}
+			continue
+		}
+		pd := newPendingDeal(p.ctx, rec.ProposalCid, rec.Deal)
+		pd.queued = rec.Queued
+		p.pending = append(p.pending, pd)
+	}
+	if len(p.pending) == 0 {
+		return p.store.setPeriodStart(time.Time{})
+	}
+
+	log.Infof("restored %d deals to publish deals queue", len(p.pending))
+	if uint64(len(p.pending)) >= p.maxDealsPerPublishMsg || p.publishPeriod == 0 {
+		p.recordDecision(newPublishDecision(len(p.pending), "restored queue is full"))
+		p.publishAllDeals()
+		return nil
+	}
+	if start.IsZero() {
+		start = marketTypes.Clock.Now()
+	}
+	p.startPublishPeriod(start)
+	return nil
+}
+
+// publishedMessage returns the message which published the deal, ok is false when the deal has no
+// message or its message failed. A message not found publishMsgTimeout after it was sent is
+// considered dropped or replaced, the deal is returned as dropped so that it's queued again.
+func (p *DealPublisher) publishedMessage(ctx context.Context, propCid cid.Cid) (msgCid cid.Cid, ok bool, dropped *publishRecord) {
+	rec, err := p.store.getPublished(propCid)
+	if err != nil {
+		log.Errorf("get publish message of deal %s: %s", propCid, err)
+		return cid.Undef, false, nil
+	}
+	if rec == nil {
+		return cid.Undef, false, nil
+	}
+
+	lookup, err := p.api.StateSearchMsg(ctx, types.EmptyTSK, rec.MsgCid, constants.LookbackNoLimit, true)
+	if err == nil && lookup != nil && lookup.Receipt.ExitCode == exitcode.Ok {
+		return rec.MsgCid, true, nil
+	}
+	if err == nil && lookup == nil {
+		now := marketTypes.Clock.Now()
+		if rec.Sent.IsZero() {
+			// saved before the send time was kept, the timeout starts now
+			rec.Sent = now
+			if err := p.store.updatePublished(rec); err != nil {
+				log.Errorf("save publish message of deal %s: %s", propCid, err)
+			}
+		}
+		// a message not found yet is still on its way
+		if now.Sub(rec.Sent) < publishMsgTimeout {
+			return rec.MsgCid, true, nil
+		}
+		log.Warnf("publish message %s of deal %s not found %s after it was sent, the deal is published again", rec.MsgCid, propCid, now.Sub(rec.Sent))
+		dropped = rec
+	} else {
+		if err == nil {
+			err = xerrors.Errorf("exit code: %s", lookup.Receipt.ExitCode)
+		}
+		log.Warnf("publish message %s of deal %s failed, the deal can be published again: %s", rec.MsgCid, propCid, err)
+	}
+	if err := p.store.remove(publishedKey(propCid)); err != nil {
+		log.Errorf("remove publish message of deal %s: %s", propCid, err)
 	}
+	return cid.Undef, false, dropped
 }
 
 // PendingDeals returns the list of deals that are queued up to be published
@@ -150,10 +344,32 @@ func (p *DealPublisher) PendingDeals() marketTypes.PendingDealInfo {
 		pending[i] = deal.deal
 	}
 
+	decisions := make([]marketTypes.PublishDecision, 0, len(p.decisions))
+	for i := len(p.decisions) - 1; i >= 0; i-- {
+		decisions = append(decisions, p.decisions[i])
+	}
+
 	return marketTypes.PendingDealInfo{
 		Deals:              pending,
 		PublishPeriodStart: p.publishPeriodStart,
 		PublishPeriod:      p.publishPeriod,
+		Decisions:          decisions,
+	}
+}
+
+// recordDecision keeps the latest decisions, a hold repeated every epoch is recorded once
+func (p *DealPublisher) recordDecision(decision marketTypes.PublishDecision) {
+	if decision.Publish {
+		log.Infof("publishing %d deals: %s", decision.Deals, decision.Reason)
+	} else {
+		if len(p.decisions) > 0 && !p.decisions[len(p.decisions)-1].Publish {
+			return
+		}
+		log.Infof("holding %d deals: %s", decision.Deals, decision.Reason)
+	}
+	p.decisions = append(p.decisions, decision)
+	if len(p.decisions) > maxPublishDecisions {
+		p.decisions = p.decisions[len(p.decisions)-maxPublishDecisions:]
 	}
 }
 
@@ -164,11 +380,22 @@ func (p *DealPublisher) ForcePublishPendingDeals() {
 	defer p.lk.Unlock()
 
 	log.Infof("force publishing deals")
+	p.recordDecision(newPublishDecision(len(p.pending), "forced"))
 	p.publishAllDeals()
 }
 
 func (p *DealPublisher) Publish(ctx context.Context, deal market2.ClientDealProposal) (cid.Cid, error) {
-	pdeal := newPendingDeal(ctx, deal)
+	propCid, err := deal.Proposal.Cid()
+	if err != nil {
+		return cid.Undef, xerrors.Errorf("get proposal cid: %w", err)
+	}
+	// the deal may have been published before a restart
+	if msgCid, ok, _ := p.publishedMessage(ctx, propCid); ok {
+		log.Infof("deal %s is already published by message %s", propCid, msgCid)
+		return msgCid, nil
+	}
+
+	pdeal := newPendingDeal(ctx, propCid, deal)
 
 	// Add the deal to the queue
 	p.processNewDeal(pdeal)
@@ -200,7 +427,23 @@ func (p *DealPublisher) processNewDeal(pdeal *pendingDeal) {
 		return
 	}
 
+	// The deal may be queued already, when it was restored after a restart
+	queued, ok := p.publishing[pdeal.propCid]
+	for _, pd := range p.pending {
+		if pd.propCid == pdeal.propCid {
+			queued, ok = pd, true
+		}
+	}
+	if ok {
+		log.Infof("deal %s is already in publish deals queue", pdeal.propCid)
+		queued.followers = append(queued.followers, pdeal)
+		return
+	}
+
 	// Add the new deal to the queue
+	if err := p.store.queue(pdeal.record()); err != nil {
+		log.Errorf("save deal %s to publish deals queue: %s", pdeal.propCid, err)
+	}
 	p.pending = append(p.pending, pdeal)
 	log.Infof("add deal with piece CID %s to publish deals queue - %d deals in queue (max queue size %d)",
 		pdeal.deal.Proposal.PieceCID, len(p.pending), p.maxDealsPerPublishMsg)
@@ -209,6 +452,11 @@ func (p *DealPublisher) processNewDeal(pdeal *pendingDeal) {
 	// publish message
 	if uint64(len(p.pending)) >= p.maxDealsPerPublishMsg || p.publishPeriod == 0 {
 		log.Infof("publish deals queue has reached max size of %d, publishing deals", p.maxDealsPerPublishMsg)
+		reason := fmt.Sprintf("queue reached %d deals", p.maxDealsPerPublishMsg)
+		if p.publishPeriod == 0 {
+			reason = "publish period is 0"
+		}
+		p.recordDecision(newPublishDecision(len(p.pending), reason))
 		p.publishAllDeals()
 		return
 	}
@@ -227,39 +475,99 @@ func (p *DealPublisher) waitForMoreDeals() {
 	}
 
 	// Set a timeout to wait for more deals to arrive
+	p.startPublishPeriod(marketTypes.Clock.Now())
+}
+
+// startPublishPeriod publishes the queue once the publish period started at start has elapsed, the
+// policy is checked every epoch in the meantime
+func (p *DealPublisher) startPublishPeriod(start time.Time) {
 	log.Infof("waiting publish deals queue period of %s before publishing", p.publishPeriod)
 	ctx, cancel := context.WithCancel(p.ctx)
-	p.publishPeriodStart = marketTypes.Clock.Now()
+	p.publishPeriodStart = start
 	p.cancelWaitForMoreDeals = cancel
+	if err := p.store.setPeriodStart(start); err != nil {
+		log.Errorf("save start of publish period: %s", err)
+	}
 
 	go func() {
-		timer := marketTypes.Clock.NewTimer(p.publishPeriod)
-		select {
-		case <-ctx.Done():
-			timer.Stop()
-		case <-timer.Chan():
-			p.lk.Lock()
-			defer p.lk.Unlock()
-
-			// The timeout has expired so publish all pending deals
-			log.Infof("publish deals queue period of %s has expired, publishing deals", p.publishPeriod)
-			p.publishAllDeals()
+		timer := marketTypes.Clock.NewTimer(p.publishPeriod - marketTypes.Clock.Since(start))
+		defer timer.Stop()
+		ticker := time.NewTicker(time.Duration(constants.MainNetBlockDelaySecs) * time.Second)
+		defer ticker.Stop()
+
+		var periodEnded bool
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.Chan():
+				log.Infof("publish deals queue period of %s has expired", p.publishPeriod)
+				periodEnded = true
+			case <-ticker.C:
+			}
+			if p.checkPublish(ctx, periodEnded) {
+				return
+			}
 		}
 	}()
 }
 
+// checkPublish publishes the queue if the policy decides to, it returns true once the queue is
+// published
+func (p *DealPublisher) checkPublish(ctx context.Context, periodEnded bool) bool {
+	height, baseFee := abi.ChainEpoch(0), abi.NewTokenAmount(0)
+	head, err := p.api.ChainHead(ctx)
+	if err == nil {
+		height, baseFee = head.Height(), head.Blocks()[0].ParentBaseFee
+	} else if !periodEnded {
+		log.Warnf("get chain head to check publish deals queue: %s", err)
+		return false
+	}
+
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	// the queue was published in the meantime
+	if ctx.Err() != nil {
+		return true
+	}
+
+	var decision *marketTypes.PublishDecision
+	if err != nil {
+		d := newPublishDecision(len(p.pending), fmt.Sprintf("publish period ended, chain head unknown: %s", err))
+		decision = &d
+	} else {
+		decision = p.policy.decide(height, baseFee, periodEnded, p.pending)
+	}
+	if decision == nil {
+		return false
+	}
+	p.recordDecision(*decision)
+	if !decision.Publish {
+		return false
+	}
+	p.publishAllDeals()
+	return true
+}
+
 func (p *DealPublisher) publishAllDeals() {
 	// If the timeout hasn't yet been cancelled, cancel it
 	if p.cancelWaitForMoreDeals != nil {
 		p.cancelWaitForMoreDeals()
 		p.cancelWaitForMoreDeals = nil
 		p.publishPeriodStart = time.Time{}
+		if err := p.store.setPeriodStart(time.Time{}); err != nil {
+			log.Errorf("clear start of publish period: %s", err)
+		}
 	}
 
 	// Filter out any deals that have been cancelled
 	p.filterCancelledDeals()
 	deals := p.pending[:]
 	p.pending = nil
+	for _, pd := range deals {
+		p.publishing[pd.propCid] = pd
+	}
 
 	// Send the publish message
 	go p.publishReady(deals)
@@ -285,28 +593,160 @@ func (p *DealPublisher) publishReady(ready []*pendingDeal) {
 		}
 	}
 
-	// Validate each deal to make sure it can be published
-	validated := make([]*pendingDeal, 0, len(ready))
-	deals := make([]market2.ClientDealProposal, 0, len(ready))
+	// complete records the result and sends it to the deals and to their followers
+	complete := func(pds []*pendingDeal, msgCid cid.Cid, err error) {
+		for _, pd := range p.finishPublishing(pds, msgCid, err) {
+			go onComplete(pd, msgCid, err)
+		}
+	}
+
+	// exclude completes a deal which can't be published with its own error
+	exclude := func(pd *pendingDeal, err error) {
+		p.recordExcluded(pd, err)
+		complete([]*pendingDeal{pd}, cid.Undef, err)
+	}
+
+	// Validate each deal to make sure it can be published, one message is
+	// sent for the deals of each provider
+	var providers []address.Address
+	byProvider := make(map[address.Address][]*pendingDeal)
 	for _, pd := range ready {
 		// Validate the deal
 		if err := p.validateDeal(pd.deal); err != nil {
 			// Validation failed, complete immediately with an error
-			go onComplete(pd, cid.Undef, err)
+			exclude(pd, err)
 			continue
 		}
 
-		validated = append(validated, pd)
+		provider := pd.deal.Proposal.Provider
+		if _, ok := byProvider[provider]; !ok {
+			providers = append(providers, provider)
+		}
+		byProvider[provider] = append(byProvider[provider], pd)
+	}
+
+	for _, provider := range providers {
+		validated := p.preflight(provider, byProvider[provider], exclude)
+		deals := make([]market2.ClientDealProposal, 0, len(validated))
+		for _, pd := range validated {
+			deals = append(deals, pd.deal)
+		}
+
+		// Send the publish message
+		msgCid, err := p.publishDealProposals(deals)
+
+		// Signal that each deal has been published
+		complete(validated, msgCid, err)
+	}
+}
+
+// preflight simulates the publish message of the deals of a provider. If it fails, each deal is
+// simulated alone and the deals failing are excluded, so that the others are still published.
+// Only a failing receipt excludes a deal, the deals which can't be simulated because the node
+// doesn't answer are published unsimulated.
+func (p *DealPublisher) preflight(provider address.Address, pds []*pendingDeal, exclude func(*pendingDeal, error)) []*pendingDeal {
+	deals := make([]market2.ClientDealProposal, 0, len(pds))
+	for _, pd := range pds {
 		deals = append(deals, pd.deal)
 	}
+	head, err := p.api.ChainHead(p.ctx)
+	if err != nil {
+		log.Warnf("get chain head to simulate publish message: %s", err)
+		return pds
+	}
+	msg, err := p.publishMsg(provider, deals)
+	if err != nil {
+		// not caused by a deal, publishing fails the same way
+		log.Warnf("build publish message to simulate: %s", err)
+		return pds
+	}
+	err = p.simulate(msg, head.Key())
+	if err == nil {
+		return pds
+	}
+	if !xerrors.Is(err, errSimulationFailed) {
+		log.Warnf("publish message of %d deals not simulated: %s", len(pds), err)
+		return pds
+	}
+	log.Warnf("simulated publish message of %d deals failed, checking the deals one by one: %s", len(pds), err)
 
-	// Send the publish message
-	msgCid, err := p.publishDealProposals(deals)
+	valid := make([]*pendingDeal, 0, len(pds))
+	for _, pd := range pds {
+		single := *msg
+		single.Params, err = specactors.SerializeParams(&market2.PublishStorageDealsParams{
+			Deals: []market2.ClientDealProposal{pd.deal},
+		})
+		if err == nil {
+			err = p.simulate(&single, head.Key())
+		}
+		if err != nil && xerrors.Is(err, errSimulationFailed) {
+			exclude(pd, err)
+			continue
+		}
+		if err != nil {
+			log.Warnw("deal not simulated, publish it anyway", "proposal", pd.propCid, "err", err)
+		}
+		valid = append(valid, pd)
+	}
+	return valid
+}
 
-	// Signal that each deal has been published
-	for _, pd := range validated {
-		go onComplete(pd, msgCid, err)
+// errSimulationFailed is returned by simulate when the message fails against the chain state
+var errSimulationFailed = xerrors.New("simulated deal publish message failed")
+
+// simulate runs the publish message against the state of the tipset, the failing receipts are
+// reported with errSimulationFailed, other errors mean that the message couldn't be run
+func (p *DealPublisher) simulate(msg *types.Message, tsk types.TipSetKey) error {
+	res, err := p.api.StateCall(p.ctx, msg, tsk)
+	if err != nil {
+		return xerrors.Errorf("simulating deal publish message: %w", err)
+	}
+	if res.MsgRct.ExitCode != exitcode.Ok {
+		return xerrors.Errorf("%w: exit code %s: %s", errSimulationFailed, res.MsgRct.ExitCode, res.Error)
 	}
+	return nil
+}
+
+func (p *DealPublisher) recordExcluded(pd *pendingDeal, err error) {
+	log.Warnw("exclude deal from publish message", "proposal", pd.propCid, "piece", pd.deal.Proposal.PieceCID, "err", err)
+	p.journal.RecordEvent(p.excludedEvtType, func() interface{} {
+		return DealPublishExcludedEvt{
+			ProposalCid: pd.propCid,
+			Client:      pd.deal.Proposal.Client,
+			Provider:    pd.deal.Proposal.Provider,
+			PieceCID:    pd.deal.Proposal.PieceCID,
+			StartEpoch:  pd.deal.Proposal.StartEpoch,
+			Error:       err.Error(),
+		}
+	})
+}
+
+// finishPublishing saves the publish message of deals, or removes them from the queue if they
+// failed, and returns the deals waiting for the result
+func (p *DealPublisher) finishPublishing(pds []*pendingDeal, msgCid cid.Cid, err error) []*pendingDeal {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	recs := make([]*publishRecord, 0, len(pds))
+	keys := make([]datastore.Key, 0, len(pds))
+	waiting := make([]*pendingDeal, 0, len(pds))
+	for _, pd := range pds {
+		recs = append(recs, pd.record())
+		keys = append(keys, pendingPublishKey(pd.propCid))
+		waiting = append(waiting, pd)
+		waiting = append(waiting, pd.followers...)
+		delete(p.publishing, pd.propCid)
+	}
+
+	if err == nil {
+		err = p.store.published(recs, msgCid, marketTypes.Clock.Now())
+	} else {
+		err = p.store.remove(keys...)
+	}
+	if err != nil {
+		log.Errorf("save result of publishing %d deals: %s", len(pds), err)
+	}
+	return waiting
 }
 
 // validateDeal checks that the deal proposal start epoch hasn't already
@@ -343,36 +783,46 @@ func (p *DealPublisher) publishDealProposals(deals []market2.ClientDealProposal)
 		}
 	}
 
-	mi, err := p.api.StateMinerInfo(p.ctx, provider, types.EmptyTSK)
+	msg, err := p.publishMsg(provider, deals)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	smsg, err := p.api.MpoolPushMessage(p.ctx, msg, p.publishSpec)
+
 	if err != nil {
 		return cid.Undef, err
 	}
+	return smsg.Cid(), nil
+}
+
+// publishMsg builds the PublishStorageDeals message of deals of the same provider
+func (p *DealPublisher) publishMsg(provider address.Address, deals []market2.ClientDealProposal) (*types.Message, error) {
+	mi, err := p.api.StateMinerInfo(p.ctx, provider, types.EmptyTSK)
+	if err != nil {
+		return nil, err
+	}
 
 	params, err := specactors.SerializeParams(&market2.PublishStorageDealsParams{
 		Deals: deals,
 	})
 
 	if err != nil {
-		return cid.Undef, xerrors.Errorf("serializing PublishStorageDeals params failed: %w", err)
+		return nil, xerrors.Errorf("serializing PublishStorageDeals params failed: %w", err)
 	}
 
 	addr, _, err := p.as.AddressFor(p.ctx, p.api, mi, marketTypes.DealPublishAddr, big.Zero(), big.Zero())
 	if err != nil {
-		return cid.Undef, xerrors.Errorf("selecting address for publishing deals: %w", err)
+		return nil, xerrors.Errorf("selecting address for publishing deals: %w", err)
 	}
 
-	smsg, err := p.api.MpoolPushMessage(p.ctx, &types.Message{
+	return &types.Message{
 		To:     market.Address,
 		From:   addr,
 		Value:  types.NewInt(0),
 		Method: market.Methods.PublishStorageDeals,
 		Params: params,
-	}, p.publishSpec)
-
-	if err != nil {
-		return cid.Undef, err
-	}
-	return smsg.Cid(), nil
+	}, nil
 }
 
 func pieceCids(deals []market2.ClientDealProposal) string {
@@ -383,14 +833,43 @@ func pieceCids(deals []market2.ClientDealProposal) string {
 	return strings.Join(cids, ", ")
 }
 
-// filter out deals that have been cancelled
+// filter out deals that have been cancelled, their records are removed so that they are not
+// restored after a restart. A deal submitted again while it was queued stays as long as one of
+// its submissions isn't cancelled.
 func (p *DealPublisher) filterCancelledDeals() {
 	i := 0
+	var cancelled []datastore.Key
 	for _, pd := range p.pending {
-		if pd.ctx.Err() == nil {
-			p.pending[i] = pd
+		if live := pd.live(); live != nil {
+			p.pending[i] = live
 			i++
+			continue
 		}
+		cancelled = append(cancelled, pendingPublishKey(pd.propCid))
 	}
 	p.pending = p.pending[:i]
+
+	// the restored deals are cancelled with the publisher, they are kept for the next start
+	if len(cancelled) == 0 || p.ctx.Err() != nil {
+		return
+	}
+	if err := p.store.remove(cancelled...); err != nil {
+		log.Errorf("remove %d cancelled deals from publish deals queue: %s", len(cancelled), err)
+	}
+}
+
+// live returns the first submission of the deal which isn't cancelled, it takes over the
+// submissions after it
+func (pd *pendingDeal) live() *pendingDeal {
+	if pd.ctx.Err() == nil {
+		return pd
+	}
+	for i, follower := range pd.followers {
+		if follower.ctx.Err() == nil {
+			follower.queued = pd.queued
+			follower.followers = pd.followers[i+1:]
+			return follower
+		}
+	}
+	return nil
 }
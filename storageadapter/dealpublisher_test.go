@@ -0,0 +1,309 @@
+package storageadapter
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/exitcode"
+	market2 "github.com/filecoin-project/specs-actors/v2/actors/builtin/market"
+
+	"github.com/filecoin-project/venus/app/submodule/apitypes"
+	"github.com/filecoin-project/venus/pkg/types"
+	"github.com/filecoin-project/venus/pkg/types/specactors/builtin/miner"
+
+	"github.com/filecoin-project/venus-market/journal"
+	marketTypes "github.com/filecoin-project/venus-market/types"
+)
+
+func TestDealPublisherRestore(t *testing.T) {
+	ctx := context.Background()
+	api := newPublishAPI(t, 10)
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	store := &publishStore{ds: ds}
+	now := marketTypes.Clock.Now()
+
+	queued := newPublishRecord(t, "queued", 1000)
+	expired := newPublishRecord(t, "expired", 5)
+	landed := newPublishRecord(t, "landed", 1000)
+	failed := newPublishRecord(t, "failed", 1000)
+	missing := newPublishRecord(t, "missing", 1000)
+	sending := newPublishRecord(t, "sending", 1000)
+	require.NoError(t, store.queue(queued))
+	require.NoError(t, store.queue(expired))
+
+	landedMsg, failedMsg, missingMsg, sendingMsg := testCid(t, "landed-msg"), testCid(t, "failed-msg"),
+		testCid(t, "missing-msg"), testCid(t, "sending-msg")
+	require.NoError(t, store.published([]*publishRecord{landed}, landedMsg, now))
+	require.NoError(t, store.published([]*publishRecord{failed}, failedMsg, now))
+	require.NoError(t, store.published([]*publishRecord{missing}, missingMsg, now.Add(-2*publishMsgTimeout)))
+	require.NoError(t, store.published([]*publishRecord{sending}, sendingMsg, now.Add(-time.Minute)))
+	api.lookups[landedMsg] = &apitypes.MsgLookup{Receipt: types.MessageReceipt{ExitCode: exitcode.Ok}}
+	api.lookups[failedMsg] = &apitypes.MsgLookup{Receipt: types.MessageReceipt{ExitCode: exitcode.ErrIllegalArgument}}
+
+	dp := newDealPublisher(api, nil, ds, journal.NilJournal(), PublishMsgConfig{Period: time.Hour, MaxDealsPerMsg: 10}, &types.MessageSendSpec{})
+	defer dp.Shutdown()
+	require.NoError(t, dp.restore(ctx))
+
+	// the deal of the message not found in time is queued again, the one still sending is not
+	require.ElementsMatch(t, []cid.Cid{queued.ProposalCid, missing.ProposalCid}, pendingCids(dp))
+	requireRecords(t, store, pendingPublishPrefix, queued, missing)
+	requireRecords(t, store, publishedPrefix, landed, sending)
+
+	// the deals published once are answered with their message
+	msgCid, err := dp.Publish(ctx, landed.Deal)
+	require.NoError(t, err)
+	require.Equal(t, landedMsg, msgCid)
+
+	dp.ForcePublishPendingDeals()
+	msg := api.waitPush(t)
+	require.Len(t, publishedDeals(t, msg), 2)
+	require.Eventually(t, func() bool {
+		pending, err := store.list(pendingPublishPrefix)
+		require.NoError(t, err)
+		return len(pending) == 0
+	}, time.Second, 10*time.Millisecond)
+	requireRecords(t, store, publishedPrefix, landed, sending, queued, missing)
+}
+
+func TestDealPublisherCancelledDeals(t *testing.T) {
+	api := newPublishAPI(t, 10)
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	store := &publishStore{ds: ds}
+	dp := newDealPublisher(api, nil, ds, journal.NilJournal(), PublishMsgConfig{Period: time.Hour, MaxDealsPerMsg: 10}, &types.MessageSendSpec{})
+	defer dp.Shutdown()
+
+	cancelled := newPublishRecord(t, "cancelled", 1000)
+	kept := newPublishRecord(t, "kept", 1000)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go dp.Publish(ctx, cancelled.Deal) //nolint:errcheck
+	require.Eventually(t, func() bool { return len(pendingCids(dp)) == 1 }, time.Second, 10*time.Millisecond)
+	requireRecords(t, store, pendingPublishPrefix, cancelled)
+
+	// the record of the cancelled deal goes when the queue changes
+	cancel()
+	go dp.Publish(context.Background(), kept.Deal) //nolint:errcheck
+	require.Eventually(t, func() bool {
+		pending := pendingCids(dp)
+		return len(pending) == 1 && pending[0] == kept.ProposalCid
+	}, time.Second, 10*time.Millisecond)
+	requireRecords(t, store, pendingPublishPrefix, kept)
+}
+
+func TestDealPublisherPreflight(t *testing.T) {
+	good1 := newPublishRecord(t, "good1", 1000)
+	good2 := newPublishRecord(t, "good2", 1000)
+	bad := newPublishRecord(t, "bad", 1000)
+
+	t.Run("single bad deal", func(t *testing.T) {
+		api := newPublishAPI(t, 10)
+		api.call = func(msg *types.Message) (*types.InvocResult, error) {
+			for _, deal := range publishedDeals(t, msg) {
+				if deal.Proposal.PieceCID == bad.Deal.Proposal.PieceCID {
+					return &types.InvocResult{MsgRct: &types.MessageReceipt{ExitCode: exitcode.ErrIllegalArgument}}, nil
+				}
+			}
+			return &types.InvocResult{MsgRct: &types.MessageReceipt{ExitCode: exitcode.Ok}}, nil
+		}
+		results := publishAll(t, api, good1, bad, good2)
+
+		// the bad deal is left out, the others are published together
+		msg := api.waitPush(t)
+		require.Len(t, publishedDeals(t, msg), 2)
+		require.NoError(t, <-results[good1.ProposalCid])
+		require.NoError(t, <-results[good2.ProposalCid])
+		require.Error(t, <-results[bad.ProposalCid])
+		// the batch, then each deal alone
+		require.Equal(t, 4, api.calls)
+	})
+
+	t.Run("rpc error", func(t *testing.T) {
+		api := newPublishAPI(t, 10)
+		api.call = func(*types.Message) (*types.InvocResult, error) {
+			return nil, xerrors.New("connection refused")
+		}
+		results := publishAll(t, api, good1, bad, good2)
+
+		// the deals aren't excluded when the node can't simulate the message
+		msg := api.waitPush(t)
+		require.Len(t, publishedDeals(t, msg), 3)
+		for _, res := range results {
+			require.NoError(t, <-res)
+		}
+		require.Equal(t, 1, api.calls)
+	})
+}
+
+// publishAll queues the deals and publishes them in one go, the result of each deal is sent on its channel
+func publishAll(t *testing.T, api *publishAPI, recs ...*publishRecord) map[cid.Cid]chan error {
+	dp := newDealPublisher(api, nil, dssync.MutexWrap(datastore.NewMapDatastore()), journal.NilJournal(),
+		PublishMsgConfig{Period: time.Hour, MaxDealsPerMsg: 10}, &types.MessageSendSpec{})
+	t.Cleanup(dp.Shutdown)
+
+	results := make(map[cid.Cid]chan error)
+	for _, rec := range recs {
+		res := make(chan error, 1)
+		results[rec.ProposalCid] = res
+		go func(rec *publishRecord) {
+			_, err := dp.Publish(context.Background(), rec.Deal)
+			res <- err
+		}(rec)
+	}
+	require.Eventually(t, func() bool { return len(pendingCids(dp)) == len(recs) }, time.Second, 10*time.Millisecond)
+	dp.ForcePublishPendingDeals()
+	return results
+}
+
+func newPublishRecord(t *testing.T, name string, start abi.ChainEpoch) *publishRecord {
+	deal := market2.ClientDealProposal{}
+	deal.Proposal.PieceCID = testCid(t, name)
+	deal.Proposal.Provider = testIDAddr(t, 1000)
+	deal.Proposal.Client = testIDAddr(t, 1001)
+	deal.Proposal.StartEpoch = start
+	deal.Proposal.EndEpoch = start + 1000
+	propCid, err := deal.Proposal.Cid()
+	require.NoError(t, err)
+	return &publishRecord{ProposalCid: propCid, Deal: deal, Queued: marketTypes.Clock.Now()}
+}
+
+func testCid(t *testing.T, data string) cid.Cid {
+	c, err := cid.Prefix{Version: 1, Codec: cid.Raw, MhType: multihash.SHA2_256, MhLength: -1}.Sum([]byte(data))
+	require.NoError(t, err)
+	return c
+}
+
+func testIDAddr(t *testing.T, id uint64) address.Address {
+	addr, err := address.NewIDAddress(id)
+	require.NoError(t, err)
+	return addr
+}
+
+func pendingCids(dp *DealPublisher) []cid.Cid {
+	dp.lk.Lock()
+	defer dp.lk.Unlock()
+	var out []cid.Cid
+	for _, pd := range dp.pending {
+		out = append(out, pd.propCid)
+	}
+	return out
+}
+
+func requireRecords(t *testing.T, store *publishStore, prefix datastore.Key, expected ...*publishRecord) {
+	recs, err := store.list(prefix)
+	require.NoError(t, err)
+	var want, got []cid.Cid
+	for _, rec := range expected {
+		want = append(want, rec.ProposalCid)
+	}
+	for _, rec := range recs {
+		got = append(got, rec.ProposalCid)
+	}
+	require.ElementsMatch(t, want, got)
+}
+
+func publishedDeals(t *testing.T, msg *types.Message) []market2.ClientDealProposal {
+	var params market2.PublishStorageDealsParams
+	require.NoError(t, params.UnmarshalCBOR(bytes.NewReader(msg.Params)))
+	return params.Deals
+}
+
+// publishAPI answers the calls of the DealPublisher from a chain at a fixed height
+type publishAPI struct {
+	t      *testing.T
+	head   *types.TipSet
+	worker address.Address
+
+	lk      sync.Mutex
+	lookups map[cid.Cid]*apitypes.MsgLookup
+	// call simulates a publish message, every message succeeds if nil
+	call   func(msg *types.Message) (*types.InvocResult, error)
+	calls  int
+	pushCh chan *types.Message
+}
+
+func newPublishAPI(t *testing.T, height abi.ChainEpoch) *publishAPI {
+	dummyCid := testCid(t, "dummy")
+	head, err := types.NewTipSet([]*types.BlockHeader{{
+		Miner:                 testIDAddr(t, 1000),
+		Height:                height,
+		ParentStateRoot:       dummyCid,
+		Messages:              dummyCid,
+		ParentMessageReceipts: dummyCid,
+		ParentBaseFee:         abi.NewTokenAmount(100),
+	}})
+	require.NoError(t, err)
+	return &publishAPI{
+		t:       t,
+		head:    head,
+		worker:  testIDAddr(t, 1002),
+		lookups: make(map[cid.Cid]*apitypes.MsgLookup),
+		pushCh:  make(chan *types.Message, 8),
+	}
+}
+
+func (a *publishAPI) waitPush(t *testing.T) *types.Message {
+	select {
+	case msg := <-a.pushCh:
+		return msg
+	case <-time.After(time.Second):
+		require.Fail(t, "no publish message pushed")
+		return nil
+	}
+}
+
+func (a *publishAPI) ChainHead(context.Context) (*types.TipSet, error) {
+	return a.head, nil
+}
+
+func (a *publishAPI) MpoolPushMessage(_ context.Context, msg *types.Message, _ *types.MessageSendSpec) (*types.SignedMessage, error) {
+	a.pushCh <- msg
+	return &types.SignedMessage{Message: *msg}, nil
+}
+
+func (a *publishAPI) StateMinerInfo(context.Context, address.Address, types.TipSetKey) (miner.MinerInfo, error) {
+	return miner.MinerInfo{Worker: a.worker}, nil
+}
+
+func (a *publishAPI) WalletBalance(context.Context, address.Address) (types.BigInt, error) {
+	return abi.NewTokenAmount(0), nil
+}
+
+func (a *publishAPI) WalletHas(context.Context, address.Address) (bool, error) {
+	return true, nil
+}
+
+func (a *publishAPI) StateAccountKey(_ context.Context, addr address.Address, _ types.TipSetKey) (address.Address, error) {
+	return addr, nil
+}
+
+func (a *publishAPI) StateLookupID(_ context.Context, addr address.Address, _ types.TipSetKey) (address.Address, error) {
+	return addr, nil
+}
+
+func (a *publishAPI) StateSearchMsg(_ context.Context, _ types.TipSetKey, msg cid.Cid, _ abi.ChainEpoch, _ bool) (*apitypes.MsgLookup, error) {
+	a.lk.Lock()
+	defer a.lk.Unlock()
+	return a.lookups[msg], nil
+}
+
+func (a *publishAPI) StateCall(_ context.Context, msg *types.Message, _ types.TipSetKey) (*types.InvocResult, error) {
+	a.lk.Lock()
+	defer a.lk.Unlock()
+	a.calls++
+	if a.call != nil {
+		return a.call(msg)
+	}
+	return &types.InvocResult{MsgRct: &types.MessageReceipt{ExitCode: exitcode.Ok}}, nil
+}
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"go.uber.org/fx"
+	"golang.org/x/xerrors"
 
 	"github.com/filecoin-project/go-address"
 	dtimpl "github.com/filecoin-project/go-data-transfer/impl"
@@ -15,11 +16,9 @@ import (
 	dtgstransport "github.com/filecoin-project/go-data-transfer/transport/graphsync"
 	"github.com/filecoin-project/go-fil-markets/filestore"
 	piecefilestore "github.com/filecoin-project/go-fil-markets/filestore"
-	"github.com/filecoin-project/go-fil-markets/piecestore"
 	"github.com/filecoin-project/go-fil-markets/storagemarket"
 	storageimpl "github.com/filecoin-project/go-fil-markets/storagemarket/impl"
 	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/storedask"
-	smnet "github.com/filecoin-project/go-fil-markets/storagemarket/network"
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/ipfs/go-datastore"
 	"github.com/libp2p/go-libp2p-core/host"
@@ -32,10 +31,14 @@ import (
 	"github.com/filecoin-project/venus-market/config"
 	"github.com/filecoin-project/venus-market/dagstore"
 	"github.com/filecoin-project/venus-market/dealfilter"
+	"github.com/filecoin-project/venus-market/fundmgr"
+	"github.com/filecoin-project/venus-market/indexprovider"
 	"github.com/filecoin-project/venus-market/journal"
 	"github.com/filecoin-project/venus-market/metrics"
 	"github.com/filecoin-project/venus-market/models"
 	"github.com/filecoin-project/venus-market/network"
+	"github.com/filecoin-project/venus-market/piece"
+	"github.com/filecoin-project/venus-market/quota"
 	types2 "github.com/filecoin-project/venus-market/types"
 	"github.com/filecoin-project/venus-market/utils"
 )
@@ -44,19 +47,18 @@ var (
 	HandleDealsKey builder.Invoke = builder.NextInvoke()
 )
 
-func NewStorageAsk(ctx metrics.MetricsCtx,
+func NewStorageAsk(ctx context.Context,
 	fapi apiface.FullNode,
-	askDs models.StorageAskDS,
-	minerAddress types2.MinerAddress,
+	askDs datastore.Batching,
+	minerAddress address.Address,
 	spn storagemarket.StorageProviderNode) (*storedask.StoredAsk, error) {
 
-	fmt.Println(address.Address(minerAddress).String())
-	mi, err := fapi.StateMinerInfo(ctx, address.Address(minerAddress), types.EmptyTSK)
+	mi, err := fapi.StateMinerInfo(ctx, minerAddress, types.EmptyTSK)
 	if err != nil {
 		return nil, err
 	}
 
-	return storedask.NewStoredAsk(askDs, datastore.NewKey("latest"), spn, address.Address(minerAddress),
+	return storedask.NewStoredAsk(askDs, datastore.NewKey("latest"), spn, minerAddress,
 		storagemarket.MaxPieceSize(abi.PaddedPieceSize(mi.SectorSize)))
 }
 
@@ -66,41 +68,78 @@ func NewTransferStore(transferPath string) func() (filestore.FileStore, error) {
 	}
 }
 
-func StorageProvider(
+// StorageProviders holds the storage provider of each miner served by the market
+type StorageProviders map[address.Address]storagemarket.StorageProvider
+
+// Get returns the storage provider of mAddr
+func (providers StorageProviders) Get(mAddr address.Address) (storagemarket.StorageProvider, error) {
+	provider, ok := providers[mAddr]
+	if !ok {
+		return nil, xerrors.Errorf("get storage provider of %s: %w", mAddr, types2.ErrMinerNotFound)
+	}
+	return provider, nil
+}
+
+// NewStorageProviders creates a storage provider for every miner, they share the libp2p host and
+// the data transfer manager, while the deals and the stored ask of each miner are kept apart
+func NewStorageProviders(
+	mctx metrics.MetricsCtx,
 	h host.Host,
-	minerAddress types2.MinerAddress,
-	storedAsk *storedask.StoredAsk,
+	fapi apiface.FullNode,
+	miners types2.MinerAddresses,
 	transferStore filestore.FileStore,
 	providerDealsDs models.ProviderDealDS,
+	askDs models.StorageAskDS,
 	dagStore *dagstore.Wrapper,
-	pieceStore piecestore.PieceStore,
-	dataTransfer network.ProviderDataTransfer,
+	pieceStores piece.PieceStores,
+	transferMux *network.TransferMux,
 	spn storagemarket.StorageProviderNode,
 	df config.StorageDealFilter,
-) (storagemarket.StorageProvider, error) {
-	net := smnet.NewFromLibp2pHost(h)
-
+) (StorageProviders, error) {
+	net := newStorageNetworkMux(h)
 	opt := storageimpl.CustomDealDecisionLogic(storageimpl.DealDeciderFunc(df))
 
-	return storageimpl.NewProvider(net, providerDealsDs, transferStore, dagStore, pieceStore, dataTransfer, spn, address.Address(minerAddress), storedAsk, opt)
-}
+	providers := make(StorageProviders, len(miners))
+	for _, mAddr := range miners {
+		storedAsk, err := NewStorageAsk(mctx, fapi, models.MinerNamespace(askDs, mAddr), mAddr, spn)
+		if err != nil {
+			return nil, xerrors.Errorf("create stored ask of %s: %w", mAddr, err)
+		}
 
-func HandleDeals(mctx metrics.MetricsCtx, lc fx.Lifecycle, host host.Host, h storagemarket.StorageProvider, j journal.Journal) {
-	ctx := metrics.LifecycleCtx(mctx, lc)
-	h.OnReady(utils.ReadyLogger("piecestorage provider"))
-	lc.Append(fx.Hook{
-		OnStart: func(context.Context) error {
-			h.SubscribeToEvents(utils.StorageProviderLogger)
+		pieceStore, err := pieceStores.Get(mAddr)
+		if err != nil {
+			return nil, err
+		}
 
-			evtType := j.RegisterEventType("markets/piecestorage/provider", "state_change")
-			h.SubscribeToEvents(utils.StorageProviderJournaler(j, evtType))
+		provider, err := storageimpl.NewProvider(net.ForMiner(mAddr), models.MinerNamespace(providerDealsDs, mAddr), transferStore, dagStore, pieceStore, transferMux.ForMiner(mAddr), spn, mAddr, storedAsk, opt)
+		if err != nil {
+			return nil, xerrors.Errorf("create storage provider of %s: %w", mAddr, err)
+		}
+		providers[mAddr] = provider
+	}
+	return providers, nil
+}
 
-			return h.Start(ctx)
-		},
-		OnStop: func(context.Context) error {
-			return h.Stop()
-		},
-	})
+func HandleDeals(mctx metrics.MetricsCtx, lc fx.Lifecycle, miners types2.MinerAddresses, providers StorageProviders, quotas *quota.ClientQuotas, indexProvider *indexprovider.Provider, j journal.Journal) {
+	ctx := metrics.LifecycleCtx(mctx, lc)
+	evtType := j.RegisterEventType("markets/piecestorage/provider", "state_change")
+	for _, mAddr := range miners {
+		h := providers[mAddr]
+		h.OnReady(utils.ReadyLogger("piecestorage provider " + mAddr.String()))
+		lc.Append(fx.Hook{
+			OnStart: func(context.Context) error {
+				h.SubscribeToEvents(utils.StorageProviderLogger)
+				h.SubscribeToEvents(utils.StorageProviderJournaler(j, evtType))
+				h.SubscribeToEvents(quotas.OnDealEvent)
+				h.SubscribeToEvents(indexProvider.OnDealEvent)
+
+				return h.Start(ctx)
+			},
+			OnStop: func(context.Context) error {
+				return h.Stop()
+			},
+		})
+	}
 }
 
 // NewProviderDAGServiceDataTransfer returns a data transfer manager that just
@@ -139,7 +178,8 @@ func BasicDealFilter(user config.StorageDealFilter) func(onlineOk config.Conside
 	blocklistFunc config.StorageDealPieceCidBlocklistConfigFunc,
 	expectedSealTimeFunc config.GetExpectedSealDurationFunc,
 	startDelay config.GetMaxDealStartDelayFunc,
-	spn storagemarket.StorageProviderNode) config.StorageDealFilter {
+	spn storagemarket.StorageProviderNode,
+	quotas *quota.ClientQuotas) config.StorageDealFilter {
 	return func(onlineOk config.ConsiderOnlineStorageDealsConfigFunc,
 		offlineOk config.ConsiderOfflineStorageDealsConfigFunc,
 		verifiedOk config.ConsiderVerifiedStorageDealsConfigFunc,
@@ -147,9 +187,16 @@ func BasicDealFilter(user config.StorageDealFilter) func(onlineOk config.Conside
 		blocklistFunc config.StorageDealPieceCidBlocklistConfigFunc,
 		expectedSealTimeFunc config.GetExpectedSealDurationFunc,
 		startDelay config.GetMaxDealStartDelayFunc,
-		spn storagemarket.StorageProviderNode) config.StorageDealFilter {
+		spn storagemarket.StorageProviderNode,
+		quotas *quota.ClientQuotas) config.StorageDealFilter {
 
 		return func(ctx context.Context, deal storagemarket.MinerDeal) (bool, string, error) {
+			// every proposal counts against the proposal quota of the client
+			ok, reason, err := quotas.CheckProposal(deal)
+			if err != nil || !ok {
+				return ok, reason, err
+			}
+
 			b, err := onlineOk()
 			if err != nil {
 				return false, "miner error", err
@@ -231,28 +278,88 @@ func BasicDealFilter(user config.StorageDealFilter) func(onlineOk config.Conside
 			}
 
 			if user != nil {
-				return user(ctx, deal)
+				if ok, reason, err := user(ctx, deal); err != nil || !ok {
+					return ok, reason, err
+				}
 			}
 
-			return true, "", nil
+			return quotas.Accept(deal)
+		}
+	}
+}
+
+// RuleDealFilter checks the deals passing the basic checks and the filter command, if any, against
+// the rules of the rule filter
+func RuleDealFilter(cmd string) func(rules *dealfilter.StorageDealRuleFilter,
+	onlineOk config.ConsiderOnlineStorageDealsConfigFunc,
+	offlineOk config.ConsiderOfflineStorageDealsConfigFunc,
+	verifiedOk config.ConsiderVerifiedStorageDealsConfigFunc,
+	unverifiedOk config.ConsiderUnverifiedStorageDealsConfigFunc,
+	blocklistFunc config.StorageDealPieceCidBlocklistConfigFunc,
+	expectedSealTimeFunc config.GetExpectedSealDurationFunc,
+	startDelay config.GetMaxDealStartDelayFunc,
+	spn storagemarket.StorageProviderNode,
+	quotas *quota.ClientQuotas) config.StorageDealFilter {
+	return func(rules *dealfilter.StorageDealRuleFilter,
+		onlineOk config.ConsiderOnlineStorageDealsConfigFunc,
+		offlineOk config.ConsiderOfflineStorageDealsConfigFunc,
+		verifiedOk config.ConsiderVerifiedStorageDealsConfigFunc,
+		unverifiedOk config.ConsiderUnverifiedStorageDealsConfigFunc,
+		blocklistFunc config.StorageDealPieceCidBlocklistConfigFunc,
+		expectedSealTimeFunc config.GetExpectedSealDurationFunc,
+		startDelay config.GetMaxDealStartDelayFunc,
+		spn storagemarket.StorageProviderNode,
+		quotas *quota.ClientQuotas) config.StorageDealFilter {
+
+		user := rules.Filter
+		if cmd != "" {
+			cli := dealfilter.CliStorageDealFilter(cmd)
+			user = func(ctx context.Context, deal storagemarket.MinerDeal) (bool, string, error) {
+				ok, reason, err := cli(ctx, deal)
+				if err != nil || !ok {
+					return ok, reason, err
+				}
+				return rules.Filter(ctx, deal)
+			}
+		}
+		filter := BasicDealFilter(user)(onlineOk, offlineOk, verifiedOk, unverifiedOk, blocklistFunc, expectedSealTimeFunc, startDelay, spn, quotas)
+		return func(ctx context.Context, deal storagemarket.MinerDeal) (bool, string, error) {
+			ok, reason, err := filter(ctx, deal)
+			// only the deals accepted by every check count against the daily quotas
+			if serr := rules.Settle(deal, err == nil && ok); serr != nil {
+				log.Errorf("settle deal %s on the daily quotas of client %s: %s", deal.ProposalCid, deal.Proposal.Client, serr)
+			}
+			return ok, reason, err
 		}
 	}
 }
 
 var StorageProviderOpts = func(cfg *config.MarketConfig) builder.Option {
 	return builder.Options(
-		builder.Override(new(*storedask.StoredAsk), NewStorageAsk),
 		builder.Override(new(network.ProviderDataTransfer), NewProviderDAGServiceDataTransfer), //save to metadata /datatransfer/provider/transfers
-		//   save to metadata /deals/provider/piecestorage-ask/latest
+		builder.Override(new(*network.TransferMux), network.NewTransferMux),
 		builder.Override(new(config.StorageDealFilter), BasicDealFilter(nil)),
 		builder.Override(new(filestore.FileStore), NewTransferStore(cfg.TransferPath)),
-		builder.Override(new(storagemarket.StorageProvider), StorageProvider),
+		builder.Override(new(*DealUploads), NewDealUploads(cfg.TransferPath)),
+		//   save to metadata /deals/provider/<miner> and /deals/provider/storage-ask/<miner>/latest
+		builder.Override(new(StorageProviders), NewStorageProviders),
+		builder.Override(new(*quota.ClientQuotas), quota.NewClientQuotas),
 		builder.Override(new(*DealPublisher), NewDealPublisher(cfg)),
 		builder.Override(HandleDealsKey, HandleDeals),
+		builder.Override(new(*DealWatcher), NewDealWatcher),
+		builder.Override(WatchDealsKey, WatchDeals),
 		builder.Override(new(network.ProviderDataTransfer), NewProviderDAGServiceDataTransfer),
 		builder.If(cfg.Filter != "",
 			builder.Override(new(config.StorageDealFilter), BasicDealFilter(dealfilter.CliStorageDealFilter(cfg.Filter))),
 		),
+		builder.If(cfg.StorageDealRules != "",
+			builder.Override(new(*dealfilter.StorageDealRuleFilter), dealfilter.NewStorageDealRuleFilter(cfg.StorageDealRules)),
+			builder.Override(new(config.StorageDealFilter), RuleDealFilter(cfg.Filter)),
+		),
+		builder.If(cfg.EscrowKeeper.Wallet != "",
+			builder.Override(new(*fundmgr.EscrowKeeper), fundmgr.NewEscrowKeeper(cfg)),
+			builder.Override(fundmgr.RunEscrowKeeperKey, fundmgr.RunEscrowKeeper),
+		),
 		builder.Override(new(*DealPublisher), NewDealPublisher(cfg)),
 		builder.Override(new(storagemarket.StorageProviderNode), NewProviderNodeAdapter(cfg)),
 	)
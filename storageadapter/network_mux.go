@@ -0,0 +1,200 @@
+package storageadapter
+
+import (
+	"sync"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	smnet "github.com/filecoin-project/go-fil-markets/storagemarket/network"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/host"
+	"golang.org/x/xerrors"
+)
+
+// storageNetworkMux shares the storage market protocols of the libp2p host between the
+// storage providers of every miner. It reads the first message of each incoming stream to
+// find the miner the stream is for and hands the stream to the provider of that miner.
+type storageNetworkMux struct {
+	smnet.StorageMarketNetwork
+
+	lk        sync.RWMutex
+	receivers map[address.Address]smnet.StorageReceiver
+	miners    []address.Address
+}
+
+func newStorageNetworkMux(h host.Host) *storageNetworkMux {
+	return &storageNetworkMux{
+		StorageMarketNetwork: smnet.NewFromLibp2pHost(h),
+		receivers:            make(map[address.Address]smnet.StorageReceiver),
+	}
+}
+
+// ForMiner returns the network to hand to the storage provider of mAddr
+func (mux *storageNetworkMux) ForMiner(mAddr address.Address) smnet.StorageMarketNetwork {
+	return &minerStorageNetwork{StorageMarketNetwork: mux.StorageMarketNetwork, mux: mux, miner: mAddr}
+}
+
+func (mux *storageNetworkMux) setReceiver(mAddr address.Address, r smnet.StorageReceiver) error {
+	mux.lk.Lock()
+	defer mux.lk.Unlock()
+
+	if len(mux.receivers) == 0 {
+		if err := mux.StorageMarketNetwork.SetDelegate(mux); err != nil {
+			return err
+		}
+	}
+	if _, ok := mux.receivers[mAddr]; !ok {
+		mux.miners = append(mux.miners, mAddr)
+	}
+	mux.receivers[mAddr] = r
+	return nil
+}
+
+func (mux *storageNetworkMux) removeReceiver(mAddr address.Address) error {
+	mux.lk.Lock()
+	defer mux.lk.Unlock()
+
+	if _, ok := mux.receivers[mAddr]; !ok {
+		return nil
+	}
+	delete(mux.receivers, mAddr)
+	for idx, addr := range mux.miners {
+		if addr == mAddr {
+			mux.miners = append(mux.miners[:idx], mux.miners[idx+1:]...)
+			break
+		}
+	}
+	if len(mux.receivers) == 0 {
+		return mux.StorageMarketNetwork.StopHandlingRequests()
+	}
+	return nil
+}
+
+func (mux *storageNetworkMux) receiver(mAddr address.Address) (smnet.StorageReceiver, bool) {
+	mux.lk.RLock()
+	defer mux.lk.RUnlock()
+	r, ok := mux.receivers[mAddr]
+	return r, ok
+}
+
+func (mux *storageNetworkMux) HandleAskStream(s smnet.StorageAskStream) {
+	req, err := s.ReadAskRequest()
+	if err != nil {
+		log.Errorf("failed to read AskRequest from incoming stream: %s", err)
+		_ = s.Close()
+		return
+	}
+
+	r, ok := mux.receiver(req.Miner)
+	if !ok {
+		log.Warnf("receive ask request for miner %s which is not served by this market", req.Miner)
+		_ = s.Close()
+		return
+	}
+	r.HandleAskStream(&askStream{StorageAskStream: s, req: req})
+}
+
+func (mux *storageNetworkMux) HandleDealStream(s smnet.StorageDealStream) {
+	proposal, err := s.ReadDealProposal()
+	if err != nil {
+		log.Errorf("failed to read proposal message: %s", err)
+		_ = s.Close()
+		return
+	}
+	if proposal.DealProposal == nil {
+		log.Errorf("receive empty deal proposal from %s", s.RemotePeer())
+		_ = s.Close()
+		return
+	}
+
+	mAddr := proposal.DealProposal.Proposal.Provider
+	r, ok := mux.receiver(mAddr)
+	if !ok {
+		log.Warnf("receive deal proposal for miner %s which is not served by this market", mAddr)
+		_ = s.Close()
+		return
+	}
+	r.HandleDealStream(&dealStream{StorageDealStream: s, proposal: proposal})
+}
+
+func (mux *storageNetworkMux) HandleDealStatusStream(s smnet.DealStatusStream) {
+	req, err := s.ReadDealStatusRequest()
+	if err != nil {
+		log.Errorf("failed to read DealStatusRequest from incoming stream: %s", err)
+		_ = s.Close()
+		return
+	}
+
+	r, err := mux.receiverOfDeal(req.Proposal)
+	if err != nil {
+		log.Warnf("receive deal status request for %s: %s", req.Proposal, err)
+		_ = s.Close()
+		return
+	}
+	r.HandleDealStatusStream(&dealStatusStream{DealStatusStream: s, req: req})
+}
+
+// receiverOfDeal finds the provider which has the deal, a status request doesn't carry the miner address
+func (mux *storageNetworkMux) receiverOfDeal(proposalCid cid.Cid) (smnet.StorageReceiver, error) {
+	mux.lk.RLock()
+	defer mux.lk.RUnlock()
+
+	for _, mAddr := range mux.miners {
+		r := mux.receivers[mAddr]
+		provider, ok := r.(interface {
+			GetLocalDeal(propCid cid.Cid) (storagemarket.MinerDeal, error)
+		})
+		if !ok {
+			continue
+		}
+		if _, err := provider.GetLocalDeal(proposalCid); err == nil {
+			return r, nil
+		}
+	}
+	return nil, xerrors.Errorf("deal not found")
+}
+
+// minerStorageNetwork is the view of the shared network given to the storage provider of one miner
+type minerStorageNetwork struct {
+	smnet.StorageMarketNetwork
+	mux   *storageNetworkMux
+	miner address.Address
+}
+
+func (n *minerStorageNetwork) SetDelegate(r smnet.StorageReceiver) error {
+	return n.mux.setReceiver(n.miner, r)
+}
+
+func (n *minerStorageNetwork) StopHandlingRequests() error {
+	return n.mux.removeReceiver(n.miner)
+}
+
+// askStream replays the request read by the mux
+type askStream struct {
+	smnet.StorageAskStream
+	req smnet.AskRequest
+}
+
+func (s *askStream) ReadAskRequest() (smnet.AskRequest, error) {
+	return s.req, nil
+}
+
+// dealStream replays the proposal read by the mux
+type dealStream struct {
+	smnet.StorageDealStream
+	proposal smnet.Proposal
+}
+
+func (s *dealStream) ReadDealProposal() (smnet.Proposal, error) {
+	return s.proposal, nil
+}
+
+// dealStatusStream replays the request read by the mux
+type dealStatusStream struct {
+	smnet.DealStatusStream
+	req smnet.DealStatusRequest
+}
+
+func (s *dealStatusStream) ReadDealStatusRequest() (smnet.DealStatusRequest, error) {
+	return s.req, nil
+}
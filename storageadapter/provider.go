@@ -17,6 +17,7 @@ import (
 	"io"
 
 	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-commp-utils/writer"
 	"github.com/filecoin-project/go-fil-markets/shared"
 	"github.com/filecoin-project/go-fil-markets/storagemarket"
 	"github.com/filecoin-project/go-state-types/abi"
@@ -32,6 +33,7 @@ import (
 	"github.com/filecoin-project/venus/pkg/types/specactors/builtin/miner"
 
 	"github.com/filecoin-project/venus-market/config"
+	"github.com/filecoin-project/venus-market/journal"
 	"github.com/filecoin-project/venus-market/metrics"
 	"github.com/filecoin-project/venus-market/utils"
 )
@@ -47,16 +49,19 @@ type ProviderNodeAdapter struct {
 
 	dealPublisher *DealPublisher
 
-	storage                     piece.IPieceStorage
-	extendPieceMeta             piece.ExtendPieceStore
+	storage                     *piece.PieceStorageManager
+	pieceStores                 piece.PieceStores
 	addBalanceSpec              *types.MessageSendSpec
 	maxDealCollateralMultiplier uint64
 	dsMatcher                   *dealStateMatcher
 	scMgr                       *SectorCommittedManager
+
+	journal              journal.Journal
+	pieceMismatchEvtType journal.EventType
 }
 
-func NewProviderNodeAdapter(fc *config.MarketConfig) func(mctx metrics.MetricsCtx, lc fx.Lifecycle, node apiface.FullNode, dealPublisher *DealPublisher, fundMgr *fundmgr.FundManager, storage piece.IPieceStorage, extendPieceMeta piece.ExtendPieceStore) storagemarket.StorageProviderNode {
-	return func(mctx metrics.MetricsCtx, lc fx.Lifecycle, full apiface.FullNode, dealPublisher *DealPublisher, fundMgr *fundmgr.FundManager, storage piece.IPieceStorage, extendPieceMeta piece.ExtendPieceStore) storagemarket.StorageProviderNode {
+func NewProviderNodeAdapter(fc *config.MarketConfig) func(mctx metrics.MetricsCtx, lc fx.Lifecycle, node apiface.FullNode, dealPublisher *DealPublisher, fundMgr *fundmgr.FundManager, storage *piece.PieceStorageManager, pieceStores piece.PieceStores, j journal.Journal) storagemarket.StorageProviderNode {
+	return func(mctx metrics.MetricsCtx, lc fx.Lifecycle, full apiface.FullNode, dealPublisher *DealPublisher, fundMgr *fundmgr.FundManager, storage *piece.PieceStorageManager, pieceStores piece.PieceStores, j journal.Journal) storagemarket.StorageProviderNode {
 		ctx := metrics.LifecycleCtx(mctx, lc)
 
 		ev, err := events.NewEvents(ctx, full)
@@ -65,13 +70,16 @@ func NewProviderNodeAdapter(fc *config.MarketConfig) func(mctx metrics.MetricsCt
 			log.Warn(err)
 		}
 		na := &ProviderNodeAdapter{
-			FullNode:        full,
-			ev:              ev,
-			dealPublisher:   dealPublisher,
-			dsMatcher:       newDealStateMatcher(state.NewStatePredicates(state.WrapFastAPI(full))),
-			storage:         storage,
-			extendPieceMeta: extendPieceMeta,
-			fundMgr:         fundMgr,
+			FullNode:      full,
+			ev:            ev,
+			dealPublisher: dealPublisher,
+			dsMatcher:     newDealStateMatcher(state.NewStatePredicates(state.WrapFastAPI(full))),
+			storage:       storage,
+			pieceStores:   pieceStores,
+			fundMgr:       fundMgr,
+			journal:       j,
+
+			pieceMismatchEvtType: j.RegisterEventType("markets/piecestorage/provider", "piece_mismatch"),
 		}
 		if fc != nil {
 			na.addBalanceSpec = &types.MessageSendSpec{MaxFee: abi.TokenAmount(fc.MaxMarketBalanceAddFee)}
@@ -89,20 +97,20 @@ func (n *ProviderNodeAdapter) PublishDeals(ctx context.Context, deal storagemark
 
 func (n *ProviderNodeAdapter) OnDealComplete(ctx context.Context, deal storagemarket.MinerDeal, pieceSize abi.UnpaddedPieceSize, pieceData io.Reader) (*storagemarket.PackingResult, error) {
 	pieceCid := deal.ClientDealProposal.Proposal.PieceCID
-	has, err := n.storage.Has(pieceCid.String())
-	if err != nil {
+	_, err := n.storage.FindStorageForRead(pieceCid.String())
+	if err != nil && !xerrors.Is(err, piece.ErrPieceNotFound) {
 		return nil, xerrors.Errorf("failed to get piece cid data %w", err)
 	}
 
-	if !has {
-		wLen, err := n.storage.SaveTo(ctx, pieceCid.String(), pieceData)
+	if err != nil {
+		st, err := n.storage.FindStorageForWrite(ctx, int64(pieceSize))
 		if err != nil {
-			return nil, err
+			return nil, xerrors.Errorf("failed to find storage for piece %s: %w", pieceCid, err)
 		}
-		if wLen != int64(pieceSize) {
-			return nil, xerrors.Errorf("save piece expect len %d but got %d", pieceSize, wLen)
+		if err := n.savePiece(ctx, st, deal, pieceSize, pieceData); err != nil {
+			return nil, err
 		}
-		log.Infof("success to write file %s to piece storage", pieceCid)
+		log.Infof("success to write file %s to piece storage %s", pieceCid, st.Name)
 	}
 
 	/*	storagemarket.MinerDeal{
@@ -118,7 +126,11 @@ func (n *ProviderNodeAdapter) OnDealComplete(ctx context.Context, deal storagema
 			deal.Proposal.PieceSize.Unpadded(),
 			paddedReader,*/
 
-	err = n.extendPieceMeta.UpdateDealOnComplete(pieceCid, deal.ClientDealProposal, deal.Ref, *deal.PublishCid, deal.DealID, deal.FastRetrieval)
+	pieceStore, err := n.pieceStores.Get(deal.Proposal.Provider)
+	if err != nil {
+		return nil, err
+	}
+	err = pieceStore.UpdateDealOnComplete(pieceCid, deal.ClientDealProposal, deal.Ref, *deal.PublishCid, deal.DealID, deal.FastRetrieval)
 	if err != nil {
 		return nil, err
 	}
@@ -174,6 +186,69 @@ func (n *ProviderNodeAdapter) OnDealComplete(ctx context.Context, deal storagema
 	}, nil*/
 }
 
+// PieceMismatchEvt is recorded when the data received for a deal doesn't match the piece of the deal
+type PieceMismatchEvt struct {
+	ProposalCid cid.Cid
+	Client      address.Address
+	PieceCID    cid.Cid
+	// ReceivedCID is the piece commitment of the received data, undefined if it wasn't computed
+	ReceivedCID cid.Cid
+	Storage     string
+	Error       string
+}
+
+// savePiece writes the piece data to st while computing its piece commitment, the piece is
+// removed again if it can't be saved completely or the data doesn't match the piece cid of the deal
+func (n *ProviderNodeAdapter) savePiece(ctx context.Context, st *piece.PieceStorageLocation, deal storagemarket.MinerDeal, pieceSize abi.UnpaddedPieceSize, pieceData io.Reader) (err error) {
+	pieceCid := deal.Proposal.PieceCID
+	defer func() {
+		if err == nil {
+			return
+		}
+		if rmErr := st.Remove(ctx, pieceCid.String()); rmErr != nil {
+			log.Errorf("remove piece %s from piece storage %s: %s", pieceCid, st.Name, rmErr)
+		}
+	}()
+
+	w := &writer.Writer{}
+	wLen, err := st.SaveTo(ctx, pieceCid.String(), io.TeeReader(pieceData, w))
+	if err != nil {
+		return xerrors.Errorf("save piece %s to piece storage %s: %w", pieceCid, st.Name, err)
+	}
+
+	receivedCid := cid.Undef
+	verifyErr := func() error {
+		if wLen != int64(pieceSize) {
+			return xerrors.Errorf("save piece expect len %d but got %d", pieceSize, wLen)
+		}
+		sum, err := w.Sum()
+		if err != nil {
+			return xerrors.Errorf("compute piece commitment of %s: %w", pieceCid, err)
+		}
+		receivedCid = sum.PieceCID
+		if !receivedCid.Equals(pieceCid) {
+			return xerrors.Errorf("piece commitment of received data %s doesn't match piece cid %s of the deal", receivedCid, pieceCid)
+		}
+		return nil
+	}()
+	if verifyErr == nil {
+		return nil
+	}
+
+	log.Errorw("reject piece data", "proposal", deal.ProposalCid, "piece", pieceCid, "err", verifyErr)
+	n.journal.RecordEvent(n.pieceMismatchEvtType, func() interface{} {
+		return PieceMismatchEvt{
+			ProposalCid: deal.ProposalCid,
+			Client:      deal.Client,
+			PieceCID:    pieceCid,
+			ReceivedCID: receivedCid,
+			Storage:     st.Name,
+			Error:       verifyErr.Error(),
+		}
+	})
+	return verifyErr
+}
+
 func (n *ProviderNodeAdapter) VerifySignature(ctx context.Context, sig crypto.Signature, addr address.Address, input []byte, encodedTs shared.TipSetToken) (bool, error) {
 	addr, err := n.StateAccountKey(ctx, addr, types.EmptyTSK)
 	if err != nil {
@@ -329,7 +404,10 @@ func (n *ProviderNodeAdapter) OnDealSectorPreCommitted(ctx context.Context, prov
 func (n *ProviderNodeAdapter) OnDealSectorCommitted(ctx context.Context, provider address.Address, dealID abi.DealID, sectorNumber abi.SectorNumber, proposal market2.DealProposal, publishCid *cid.Cid, cb storagemarket.DealSectorCommittedCallback) error {
 	return n.scMgr.OnDealSectorCommitted(ctx, provider, sectorNumber, market.DealProposal(proposal), *publishCid, func(err error) {
 		cb(err)
-		_Err := n.extendPieceMeta.UpdateDealStatus(dealID, "Proving")
+		pieceStore, _Err := n.pieceStores.Get(provider)
+		if _Err == nil {
+			_Err = pieceStore.UpdateDealStatus(dealID, "Proving")
+		}
 		if _Err != nil {
 			log.Errorw("update deal status %w", _Err)
 		}
@@ -0,0 +1,97 @@
+package storageadapter
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/filecoin-project/go-commp-utils/writer"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/venus-market/config"
+	"github.com/filecoin-project/venus-market/journal"
+	"github.com/filecoin-project/venus-market/piece"
+)
+
+type recordJournal struct {
+	events []interface{}
+}
+
+func (j *recordJournal) RegisterEventType(system, event string) journal.EventType {
+	return journal.EventType{System: system, Event: event}
+}
+
+func (j *recordJournal) RecordEvent(_ journal.EventType, supplier func() interface{}) {
+	j.events = append(j.events, supplier())
+}
+
+func (j *recordJournal) Close() error {
+	return nil
+}
+
+func testPieceData(t *testing.T, size abi.UnpaddedPieceSize) ([]byte, cid.Cid) {
+	data := make([]byte, size)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+
+	w := &writer.Writer{}
+	_, err = w.Write(data)
+	require.NoError(t, err)
+	sum, err := w.Sum()
+	require.NoError(t, err)
+	return data, sum.PieceCID
+}
+
+func TestSavePieceVerifiesCommP(t *testing.T) {
+	ctx := context.Background()
+	storages, err := piece.NewPieceStorageManager(ctx, &config.MarketConfig{PieceStorage: config.PieceStorageString("fs:" + t.TempDir())})
+	require.NoError(t, err)
+	j := &recordJournal{}
+	n := &ProviderNodeAdapter{storage: storages, journal: j}
+
+	pieceSize := abi.UnpaddedPieceSize(1016)
+	data, pieceCid := testPieceData(t, pieceSize)
+	_, otherCid := testPieceData(t, pieceSize)
+
+	st, err := storages.FindStorageForWrite(ctx, int64(pieceSize))
+	require.NoError(t, err)
+
+	// the data doesn't match the piece of the deal
+	deal := storagemarket.MinerDeal{}
+	deal.Proposal.PieceCID = otherCid
+	err = n.savePiece(ctx, st, deal, pieceSize, bytes.NewReader(data))
+	require.Error(t, err)
+	_, err = storages.FindStorageForRead(otherCid.String())
+	require.True(t, xerrors.Is(err, piece.ErrPieceNotFound))
+	require.Len(t, j.events, 1)
+	evt := j.events[0].(PieceMismatchEvt)
+	require.Equal(t, otherCid, evt.PieceCID)
+	require.Equal(t, pieceCid, evt.ReceivedCID)
+
+	// truncated data
+	deal.Proposal.PieceCID = pieceCid
+	err = n.savePiece(ctx, st, deal, pieceSize, bytes.NewReader(data[:500]))
+	require.Error(t, err)
+	_, err = storages.FindStorageForRead(pieceCid.String())
+	require.True(t, xerrors.Is(err, piece.ErrPieceNotFound))
+	require.Len(t, j.events, 2)
+
+	// the transfer fails halfway
+	err = n.savePiece(ctx, st, deal, pieceSize, io.MultiReader(bytes.NewReader(data[:500]), iotest.ErrReader(xerrors.New("connection reset"))))
+	require.Error(t, err)
+	_, err = storages.FindStorageForRead(pieceCid.String())
+	require.True(t, xerrors.Is(err, piece.ErrPieceNotFound))
+	require.Len(t, j.events, 2)
+
+	require.NoError(t, n.savePiece(ctx, st, deal, pieceSize, bytes.NewReader(data)))
+	_, err = storages.FindStorageForRead(pieceCid.String())
+	require.NoError(t, err)
+	require.Len(t, j.events, 2)
+}
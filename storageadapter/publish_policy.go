@@ -0,0 +1,73 @@
+package storageadapter
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/venus/pkg/constants"
+	"github.com/filecoin-project/venus/pkg/types"
+
+	marketTypes "github.com/filecoin-project/venus-market/types"
+)
+
+// publishPolicy decides when the queue is published before the publish period ends, or held
+// after it, based on the start epochs of the deals and on the base fee
+type publishPolicy struct {
+	// publish when a deal starts within this number of epochs, 0 to disable
+	startEpochMargin abi.ChainEpoch
+	// hold the queue while the base fee is above it, zero to disable
+	maxBaseFee abi.TokenAmount
+}
+
+// decide returns nil to keep waiting for more deals
+func (pp publishPolicy) decide(height abi.ChainEpoch, baseFee abi.TokenAmount, periodEnded bool, deals []*pendingDeal) *marketTypes.PublishDecision {
+	decision := &marketTypes.PublishDecision{
+		Time:    marketTypes.Clock.Now(),
+		Height:  height,
+		BaseFee: baseFee,
+		Deals:   len(deals),
+		Publish: true,
+	}
+
+	if pp.startEpochMargin > 0 {
+		var first *pendingDeal
+		for _, pd := range deals {
+			if first == nil || pd.deal.Proposal.StartEpoch < first.deal.Proposal.StartEpoch {
+				first = pd
+			}
+		}
+		if first != nil && first.deal.Proposal.StartEpoch-pp.startEpochMargin <= height {
+			decision.Reason = fmt.Sprintf("deal %s starts at epoch %d, within %d epochs of the chain head",
+				first.propCid, first.deal.Proposal.StartEpoch, pp.startEpochMargin)
+			return decision
+		}
+	}
+
+	if !periodEnded {
+		return nil
+	}
+	if !pp.maxBaseFee.Nil() && !pp.maxBaseFee.IsZero() && !baseFee.Nil() && big.Cmp(baseFee, pp.maxBaseFee) > 0 {
+		decision.Publish = false
+		decision.Reason = fmt.Sprintf("base fee %s is above %s", types.FIL(baseFee), types.FIL(pp.maxBaseFee))
+		return decision
+	}
+	decision.Reason = "publish period ended"
+	return decision
+}
+
+// newPublishDecision records a decision made without looking at the chain
+func newPublishDecision(deals int, reason string) marketTypes.PublishDecision {
+	return marketTypes.PublishDecision{
+		Time:    marketTypes.Clock.Now(),
+		Deals:   deals,
+		Publish: true,
+		Reason:  reason,
+	}
+}
+
+// durationEpochs converts a duration to a number of epochs
+func durationEpochs(d time.Duration) abi.ChainEpoch {
+	return abi.ChainEpoch(d / (time.Duration(constants.MainNetBlockDelaySecs) * time.Second))
+}
@@ -0,0 +1,52 @@
+package storageadapter
+
+import (
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	market2 "github.com/filecoin-project/specs-actors/v2/actors/builtin/market"
+)
+
+func TestPublishPolicy(t *testing.T) {
+	newDeal := func(propCid string, start abi.ChainEpoch) *pendingDeal {
+		c, err := cid.Decode(propCid)
+		require.NoError(t, err)
+		deal := market2.ClientDealProposal{}
+		deal.Proposal.StartEpoch = start
+		return &pendingDeal{propCid: c, deal: deal}
+	}
+	deals := []*pendingDeal{
+		newDeal("bafy2bzacedbz6qrgt6fkdl4fguas6ntdimi5uyxmn5sxkugdshxmdcfukjfk2", 500),
+		newDeal("bafy2bzacea3wsdh6y3a36tb3skempjoxqpuyompjbmfeyf34fi3uy6uue42v4", 300),
+	}
+	lowFee, highFee := big.NewInt(50), big.NewInt(200)
+
+	policy := publishPolicy{startEpochMargin: 100, maxBaseFee: big.NewInt(100)}
+	require.Nil(t, policy.decide(150, lowFee, false, deals))
+
+	// the second deal starts within the margin
+	decision := policy.decide(200, highFee, false, deals)
+	require.NotNil(t, decision)
+	require.True(t, decision.Publish)
+	require.Equal(t, 2, decision.Deals)
+	require.Contains(t, decision.Reason, deals[1].propCid.String())
+
+	// the base fee holds the queue once the period ended
+	decision = policy.decide(150, highFee, true, deals)
+	require.NotNil(t, decision)
+	require.False(t, decision.Publish)
+	decision = policy.decide(150, lowFee, true, deals)
+	require.NotNil(t, decision)
+	require.True(t, decision.Publish)
+
+	// disabled margin and base fee
+	policy = publishPolicy{maxBaseFee: big.Zero()}
+	require.Nil(t, policy.decide(400, highFee, false, deals))
+	decision = policy.decide(400, highFee, true, deals)
+	require.NotNil(t, decision)
+	require.True(t, decision.Publish)
+}
@@ -0,0 +1,159 @@
+package storageadapter
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"golang.org/x/xerrors"
+
+	market2 "github.com/filecoin-project/specs-actors/v2/actors/builtin/market"
+)
+
+var (
+	pendingPublishPrefix  = datastore.NewKey("/pending")
+	publishedPrefix       = datastore.NewKey("/published")
+	publishPeriodStartKey = datastore.NewKey("/period-start")
+)
+
+// publishRecord is a deal of the publish queue, MsgCid and Sent are set once its publish message
+// is sent
+type publishRecord struct {
+	ProposalCid cid.Cid
+	Deal        market2.ClientDealProposal
+	Queued      time.Time
+	MsgCid      cid.Cid
+	Sent        time.Time
+}
+
+// publishStore keeps the queue of the DealPublisher in the metadata datastore
+type publishStore struct {
+	ds datastore.Batching
+}
+
+func pendingPublishKey(propCid cid.Cid) datastore.Key {
+	return pendingPublishPrefix.ChildString(propCid.String())
+}
+
+func publishedKey(propCid cid.Cid) datastore.Key {
+	return publishedPrefix.ChildString(propCid.String())
+}
+
+func (s *publishStore) put(b datastore.Write, key datastore.Key, rec *publishRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return b.Put(key, data)
+}
+
+// queue saves a deal added to the queue
+func (s *publishStore) queue(rec *publishRecord) error {
+	return s.put(s.ds, pendingPublishKey(rec.ProposalCid), rec)
+}
+
+// published moves deals from the queue to the deals published by msgCid, sent at sent
+func (s *publishStore) published(recs []*publishRecord, msgCid cid.Cid, sent time.Time) error {
+	b, err := s.ds.Batch()
+	if err != nil {
+		return err
+	}
+	for _, rec := range recs {
+		rec.MsgCid = msgCid
+		rec.Sent = sent
+		if err := s.put(b, publishedKey(rec.ProposalCid), rec); err != nil {
+			return err
+		}
+		if err := b.Delete(pendingPublishKey(rec.ProposalCid)); err != nil {
+			return err
+		}
+	}
+	return b.Commit()
+}
+
+// updatePublished saves a deal published
+func (s *publishStore) updatePublished(rec *publishRecord) error {
+	return s.put(s.ds, publishedKey(rec.ProposalCid), rec)
+}
+
+// remove deletes the given records
+func (s *publishStore) remove(keys ...datastore.Key) error {
+	b, err := s.ds.Batch()
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := b.Delete(key); err != nil {
+			return err
+		}
+	}
+	return b.Commit()
+}
+
+// getPublished returns nil if the deal has no publish message
+func (s *publishStore) getPublished(propCid cid.Cid) (*publishRecord, error) {
+	data, err := s.ds.Get(publishedKey(propCid))
+	if err == datastore.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rec publishRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, xerrors.Errorf("decode published deal %s: %w", propCid, err)
+	}
+	return &rec, nil
+}
+
+// list returns the records under prefix in the order they were queued
+func (s *publishStore) list(prefix datastore.Key) ([]*publishRecord, error) {
+	res, err := s.ds.Query(query.Query{Prefix: prefix.String()})
+	if err != nil {
+		return nil, err
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		return nil, err
+	}
+	recs := make([]*publishRecord, 0, len(entries))
+	for _, entry := range entries {
+		var rec publishRecord
+		if err := json.Unmarshal(entry.Value, &rec); err != nil {
+			return nil, xerrors.Errorf("decode publish record %s: %w", entry.Key, err)
+		}
+		recs = append(recs, &rec)
+	}
+	sort.Slice(recs, func(i, j int) bool {
+		return recs[i].Queued.Before(recs[j].Queued)
+	})
+	return recs, nil
+}
+
+// periodStart returns the zero time if no publish period is running
+func (s *publishStore) periodStart() (time.Time, error) {
+	var start time.Time
+	data, err := s.ds.Get(publishPeriodStartKey)
+	if err == datastore.ErrNotFound {
+		return start, nil
+	}
+	if err != nil {
+		return start, err
+	}
+	return start, start.UnmarshalText(data)
+}
+
+// setPeriodStart saves the start of the publish period, the zero time clears it
+func (s *publishStore) setPeriodStart(start time.Time) error {
+	if start.IsZero() {
+		return s.ds.Delete(publishPeriodStartKey)
+	}
+	data, err := start.MarshalText()
+	if err != nil {
+		return err
+	}
+	return s.ds.Put(publishPeriodStartKey, data)
+}
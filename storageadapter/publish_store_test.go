@@ -0,0 +1,75 @@
+package storageadapter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	market2 "github.com/filecoin-project/specs-actors/v2/actors/builtin/market"
+)
+
+func TestPublishStore(t *testing.T) {
+	store := &publishStore{ds: dssync.MutexWrap(datastore.NewMapDatastore())}
+
+	newRecord := func(propCid string, queued time.Time) *publishRecord {
+		c, err := cid.Decode(propCid)
+		require.NoError(t, err)
+		deal := market2.ClientDealProposal{}
+		deal.Proposal.StartEpoch = abi.ChainEpoch(100)
+		return &publishRecord{ProposalCid: c, Deal: deal, Queued: queued}
+	}
+	start := time.Date(2021, 8, 1, 12, 0, 0, 0, time.UTC)
+	rec1 := newRecord("bafy2bzacedbz6qrgt6fkdl4fguas6ntdimi5uyxmn5sxkugdshxmdcfukjfk2", start)
+	rec2 := newRecord("bafy2bzacea3wsdh6y3a36tb3skempjoxqpuyompjbmfeyf34fi3uy6uue42v4", start.Add(time.Second))
+	msgCid, err := cid.Decode("bafy2bzacecnamqgqmifpluoeldx7zzglxcljo6oja4vrmtj7432rphldpdmm2")
+	require.NoError(t, err)
+
+	require.NoError(t, store.queue(rec1))
+	require.NoError(t, store.queue(rec2))
+	pending, err := store.list(pendingPublishPrefix)
+	require.NoError(t, err)
+	require.Len(t, pending, 2)
+	require.Equal(t, rec1.ProposalCid, pending[0].ProposalCid)
+	require.Equal(t, rec2.ProposalCid, pending[1].ProposalCid)
+	require.Equal(t, abi.ChainEpoch(100), pending[0].Deal.Proposal.StartEpoch)
+
+	// published deals leave the queue
+	require.NoError(t, store.published([]*publishRecord{rec1}, msgCid, start.Add(time.Minute)))
+	pending, err = store.list(pendingPublishPrefix)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	require.Equal(t, rec2.ProposalCid, pending[0].ProposalCid)
+
+	published, err := store.getPublished(rec1.ProposalCid)
+	require.NoError(t, err)
+	require.Equal(t, msgCid, published.MsgCid)
+	require.True(t, start.Add(time.Minute).Equal(published.Sent))
+	published, err = store.getPublished(rec2.ProposalCid)
+	require.NoError(t, err)
+	require.Nil(t, published)
+
+	require.NoError(t, store.remove(publishedKey(rec1.ProposalCid), pendingPublishKey(rec2.ProposalCid)))
+	published, err = store.getPublished(rec1.ProposalCid)
+	require.NoError(t, err)
+	require.Nil(t, published)
+	pending, err = store.list(pendingPublishPrefix)
+	require.NoError(t, err)
+	require.Empty(t, pending)
+
+	periodStart, err := store.periodStart()
+	require.NoError(t, err)
+	require.True(t, periodStart.IsZero())
+	require.NoError(t, store.setPeriodStart(start))
+	periodStart, err = store.periodStart()
+	require.NoError(t, err)
+	require.True(t, start.Equal(periodStart))
+	require.NoError(t, store.setPeriodStart(time.Time{}))
+	periodStart, err = store.periodStart()
+	require.NoError(t, err)
+	require.True(t, periodStart.IsZero())
+}
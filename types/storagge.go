@@ -1,6 +1,7 @@
 package types
 
 import (
+	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/specs-actors/v2/actors/builtin/market"
 	"github.com/ipfs/go-cid"
@@ -15,6 +16,20 @@ type PendingDealInfo struct {
 	Deals              []market.ClientDealProposal
 	PublishPeriodStart time.Time
 	PublishPeriod      time.Duration
+	// Decisions are the latest decisions to publish or hold the queue, the latest first
+	Decisions []PublishDecision
+}
+
+// PublishDecision records why the publish deals queue was published or held
+type PublishDecision struct {
+	Time time.Time
+	// Height and BaseFee are set when the decision is made against the chain head
+	Height  abi.ChainEpoch
+	BaseFee abi.TokenAmount
+	Deals   int
+	// Publish is false when the queue is held
+	Publish bool
+	Reason  string
 }
 
 type SectorOffset struct {
@@ -111,3 +126,55 @@ const (
 
 	TerminateSectorsAddr
 )
+
+// ImportDataRef points the deals of a proposal or of a piece to the file of their data, a piece
+// imports the data for each of its offline deals waiting for data
+type ImportDataRef struct {
+	ProposalCid *cid.Cid
+	PieceCid    *cid.Cid
+	File        string
+}
+
+type ImportDataStatus string
+
+const (
+	ImportDataImported ImportDataStatus = "imported"
+	ImportDataSkipped  ImportDataStatus = "skipped"
+	ImportDataFailed   ImportDataStatus = "failed"
+)
+
+// ImportDataResult is the outcome of an ImportDataRef
+type ImportDataResult struct {
+	ImportDataRef
+	// Deals are the proposals the data was imported for
+	Deals   []cid.Cid
+	Status  ImportDataStatus
+	Message string
+}
+
+type UnsealJobState string
+
+const (
+	UnsealJobQueued    UnsealJobState = "queued"
+	UnsealJobUnsealing UnsealJobState = "unsealing"
+	UnsealJobDone      UnsealJobState = "done"
+	UnsealJobFailed    UnsealJobState = "failed"
+)
+
+// UnsealJob unseals a piece from a sector of a miner into a piece storage, the retrievals of the
+// piece wait for the same job
+type UnsealJob struct {
+	PieceCid cid.Cid
+	Miner    address.Address
+	Sector   abi.SectorID
+	Offset   abi.PaddedPieceSize
+	Size     abi.PaddedPieceSize
+	// Dest is the path the piece is unsealed to, set once the job is unsealing
+	Dest  string
+	State UnsealJobState
+	Error string
+	// Waiters are the retrievals waiting for the job
+	Waiters   int
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
@@ -11,6 +11,8 @@ import (
 	vTypes "github.com/filecoin-project/venus/pkg/types"
 	"github.com/ipfs/go-cid"
 	"github.com/libp2p/go-libp2p-core/peer"
+	"golang.org/x/xerrors"
+	"time"
 )
 
 // Clock is the global clock for the system. In standard builds,
@@ -24,6 +26,22 @@ type SectorSize abi.SectorSize
 type MinerAddress address.Address
 type MinerID abi.ActorID
 
+// MinerAddresses is the list of miner actors served by the market, in the order they were configured
+type MinerAddresses []address.Address
+
+// ErrMinerNotFound is returned when a request targets a miner this market doesn't serve
+var ErrMinerNotFound = xerrors.New("miner not served by this market")
+
+// Has reports whether mAddr is one of the served miners
+func (addrs MinerAddresses) Has(mAddr address.Address) bool {
+	for _, addr := range addrs {
+		if addr == mAddr {
+			return true
+		}
+	}
+	return false
+}
+
 // ShutdownChan is a channel to which you send a value if you intend to shut
 // down the daemon (or miner), including the node and RPC server.
 type ShutdownChan chan struct{}
@@ -96,6 +114,16 @@ type DagstoreShardResult struct {
 	Error   string
 }
 
+// DagstoreShardHealth is a shard the health checker failed to repair so far.
+type DagstoreShardHealth struct {
+	Key          string
+	State        string
+	LastError    string
+	Attempts     int
+	FirstFailure time.Time
+	NextAttempt  time.Time
+}
+
 type DagstoreInitializeAllParams struct {
 	MaxConcurrency int
 	IncludeSealed  bool